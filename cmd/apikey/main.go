@@ -0,0 +1,57 @@
+// Command apikey issues an API key straight in the database, it is how the
+// first administrator key is created before anyone can call POST /api-keys
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/services"
+	"users-microservice/pkg/storage"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	name := flag.String("name", "", "name telling what the key is used for")
+	scopes := flag.String("scopes", string(models.ScopeUsersAdmin), "comma separated scopes of the key")
+	ttl := flag.Duration("ttl", 0, "how long the key is valid, 0 until revoked")
+	flag.Parse()
+
+	godotenv.Load()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("FATAL: could not load config: %v", err)
+	}
+	storageImpl, err := storage.NewPostgresStorage(cfg)
+	if err != nil {
+		log.Fatalf("FATAL: failed to create a storage: %s", err)
+	}
+	apiKeyService, err := services.NewAPIKeyService(storageImpl, nil)
+	if err != nil {
+		log.Fatalf("FATAL: failed to create an APIKeyService: %s", err)
+	}
+
+	req := services.APIKeyRequest{Name: *name}
+	for _, scope := range strings.Split(*scopes, ",") {
+		req.Scopes = append(req.Scopes, models.Scope(strings.TrimSpace(scope)))
+	}
+	if *ttl > 0 {
+		expiresAt := time.Now().Add(*ttl)
+		req.ExpiresAt = &expiresAt
+	}
+
+	// the tool runs next to the database, there is no caller to authorize
+	ctx := models.ContextWithPrincipal(context.Background(), models.SystemPrincipal("cmd/apikey"))
+	issue, err := apiKeyService.IssueAPIKey(ctx, req)
+	if err != nil {
+		log.Fatalf("FATAL: failed to issue API key: %s", err)
+	}
+	fmt.Println(issue.Secret)
+}
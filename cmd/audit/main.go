@@ -0,0 +1,65 @@
+// Command audit verifies the hash chains of the audit log against their
+// recorded heads and fails when an entry has been altered, removed or
+// inserted. It checks every user unless one is given
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/storage"
+
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	user := flag.String("user", "", "ID of the only user to verify")
+	flag.Parse()
+
+	godotenv.Load()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("FATAL: could not load config: %v", err)
+	}
+	storageImpl, err := storage.NewPostgresStorage(cfg)
+	if err != nil {
+		log.Fatalf("FATAL: failed to create a storage: %s", err)
+	}
+
+	var userIDs []uuid.UUID
+	if *user != "" {
+		userID, err := uuid.Parse(*user)
+		if err != nil {
+			log.Fatalf("FATAL: '%s' is not a user ID", *user)
+		}
+		userIDs = []uuid.UUID{userID}
+	} else if userIDs, err = storageImpl.RetrieveAuditedUserIDs(); err != nil {
+		log.Fatalf("FATAL: %s", err)
+	}
+
+	broken := 0
+	for _, userID := range userIDs {
+		entries, err := storageImpl.RetrieveAuditEntries(userID)
+		if err != nil {
+			log.Fatalf("FATAL: %s", err)
+		}
+		head, err := storageImpl.RetrieveAuditHead(userID)
+		if err != nil && models.ErrorContext(err) != models.ContextNotFound {
+			log.Fatalf("FATAL: %s", err)
+		}
+		if err := models.VerifyAuditChain(entries, head); err != nil {
+			fmt.Printf("BROKEN %s: %s\n", userID, err)
+			broken++
+		}
+	}
+
+	fmt.Printf("%d of %d chains intact\n", len(userIDs)-broken, len(userIDs))
+	if broken > 0 {
+		os.Exit(1)
+	}
+}
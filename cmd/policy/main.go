@@ -0,0 +1,129 @@
+// Command policy evaluates a policy file against sample inputs, so policies
+// can be tried before the service loads them. Samples are a JSON list like
+//
+//	[{"name": "support reads active users",
+//	  "principal": {"kind": "service", "claims": {"role": "support"}},
+//	  "action": "users.get",
+//	  "resource": {"status": "active", "region": "DE"},
+//	  "expect": "allow", "expect_mask": ["date_of_birth"]}]
+//
+// and the command fails when a decision or the fields it masks differ from
+// the expected ones
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"users-microservice/pkg/authz"
+)
+
+type sample struct {
+	Name string `json:"name"`
+	authz.Input
+	// allow or deny, the decision is only printed when empty
+	Expect authz.Effect `json:"expect"`
+	// fields an allowed decision masks, in any order, not checked when
+	// missing
+	ExpectMask []string `json:"expect_mask"`
+}
+
+// timestampAttributes of users are timestamps for conditions but strings in
+// JSON
+var timestampAttributes = []string{"date_of_birth", "created_at"}
+
+func main() {
+	policyFile := flag.String("policy", os.Getenv("POLICY_FILE"), "policy file to evaluate")
+	samplesFile := flag.String("samples", "", "JSON file of sample inputs")
+	flag.Parse()
+
+	engine, err := authz.Load(*policyFile)
+	if err != nil {
+		log.Fatalf("FATAL: %s", err)
+	}
+	data, err := os.ReadFile(*samplesFile)
+	if err != nil {
+		log.Fatalf("FATAL: failed to read samples: %s", err)
+	}
+	var samples []sample
+	if err := json.Unmarshal(data, &samples); err != nil {
+		log.Fatalf("FATAL: failed to parse samples: %s", err)
+	}
+
+	failed := 0
+	for i, sample := range samples {
+		if sample.Name == "" {
+			sample.Name = fmt.Sprintf("sample %d", i+1)
+		}
+		input, err := prepare(sample.Input)
+		if err != nil {
+			log.Fatalf("FATAL: %s: %s", sample.Name, err)
+		}
+
+		decision := engine.Evaluate(input)
+		effect := authz.EffectDeny
+		if decision.Allowed {
+			effect = authz.EffectAllow
+		}
+		rule := decision.Rule
+		if rule == "" {
+			rule = "default"
+		}
+
+		status := "    "
+		switch {
+		case sample.Expect == "":
+		case sample.Expect == effect && (sample.ExpectMask == nil || sameFields(sample.ExpectMask, decision.Mask)):
+			status = "PASS"
+		default:
+			status = "FAIL"
+			failed++
+		}
+		fmt.Printf("%s %s: %s by %s", status, sample.Name, effect, rule)
+		if len(decision.Mask) > 0 {
+			fmt.Printf(" masking %s", strings.Join(decision.Mask, ", "))
+		}
+		if decision.Err != nil {
+			fmt.Printf(" (%v)", decision.Err)
+		}
+		fmt.Println()
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d samples failed\n", failed, len(samples))
+		os.Exit(1)
+	}
+}
+
+func sameFields(expected []string, actual []string) bool {
+	return len(expected) == len(actual) && !slices.ContainsFunc(expected, func(field string) bool { return !slices.Contains(actual, field) })
+}
+
+// prepare fills in what the service always sets on principals and turns
+// timestamps into times
+func prepare(input authz.Input) (authz.Input, error) {
+	principal := map[string]any{"kind": "service", "subject": "", "user_id": "", "scopes": []string{}, "claims": map[string]any{}}
+	maps.Copy(principal, input.Principal)
+	input.Principal = principal
+
+	resource := maps.Clone(input.Resource)
+	for _, name := range timestampAttributes {
+		value, ok := resource[name].(string)
+		if !ok {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return input, fmt.Errorf("%s is not an RFC 3339 timestamp: %w", name, err)
+		}
+		resource[name] = parsed
+	}
+	input.Resource = resource
+	return input, nil
+}
@@ -1,10 +1,33 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"users-microservice/pkg/accesslog"
 	"users-microservice/pkg/api"
+	"users-microservice/pkg/auth"
+	"users-microservice/pkg/authz"
 	"users-microservice/pkg/config"
+	"users-microservice/pkg/encryption"
+	"users-microservice/pkg/events"
+	"users-microservice/pkg/export"
+	"users-microservice/pkg/jwtauth"
+	"users-microservice/pkg/lockout"
+	"users-microservice/pkg/mailer"
+	"users-microservice/pkg/oidc"
+	"users-microservice/pkg/password"
+	"users-microservice/pkg/servertls"
 	"users-microservice/pkg/services"
+	"users-microservice/pkg/signing"
+	"users-microservice/pkg/sms"
 	"users-microservice/pkg/storage"
 
 	"github.com/joho/godotenv"
@@ -22,12 +45,180 @@ func main() {
 	if err != nil {
 		log.Fatalf("FATAL: failed to create a storage: %s", err)
 	}
-	service, err := services.NewUserService(storageImpl)
+	mailerImpl, err := mailer.New(cfg)
+	if err != nil {
+		log.Fatalf("FATAL: failed to create a mailer: %s", err)
+	}
+	smsSender, err := sms.New(cfg)
+	if err != nil {
+		log.Fatalf("FATAL: failed to create an SMS sender: %s", err)
+	}
+	publisher, err := events.New(cfg)
+	if err != nil {
+		log.Fatalf("FATAL: failed to create an events publisher: %s", err)
+	}
+	policy, err := password.NewPolicy(cfg.PasswordMinLength, cfg.PasswordMaxLength, cfg.BreachedPasswordsFile)
+	if err != nil {
+		log.Fatalf("FATAL: failed to create a password policy: %s", err)
+	}
+	hasher := password.NewHasher(password.Params{
+		Memory:      uint32(cfg.Argon2Memory),
+		Iterations:  uint32(cfg.Argon2Iterations),
+		Parallelism: uint8(cfg.Argon2Parallelism),
+		SaltLength:  16,
+		KeyLength:   32,
+	})
+	signingKey := []byte(cfg.SessionSigningKey)
+	if len(signingKey) == 0 {
+		signingKey = make([]byte, 32)
+		if _, err := rand.Read(signingKey); err != nil {
+			log.Fatalf("FATAL: failed to generate a session signing key: %s", err)
+		}
+		log.Print("WARNING: SESSION_SIGNING_KEY is not set, access tokens will not survive a restart")
+	}
+	signer := auth.NewAccessTokenSigner(signingKey, cfg.TokenIssuer, cfg.AccessTokenTTL)
+
+	mfaKey := cfg.MFAEncryptionKey
+	if mfaKey == "" {
+		randomKey := make([]byte, 32)
+		if _, err := rand.Read(randomKey); err != nil {
+			log.Fatalf("FATAL: failed to generate an MFA encryption key: %s", err)
+		}
+		mfaKey = base64.StdEncoding.EncodeToString(randomKey)
+		log.Print("WARNING: MFA_ENCRYPTION_KEY is not set, enrolled authenticators, OIDC signing keys and personal data exports will not survive a restart")
+	}
+	mfaCipher, err := encryption.NewCipherFromBase64(mfaKey)
+	if err != nil {
+		log.Fatalf("FATAL: failed to create an MFA cipher: %s", err)
+	}
+
+	var failureCounters storage.FailureCounterStorage
+	switch cfg.LockoutStore {
+	case "postgres":
+		failureCounters = storageImpl
+	case "memory":
+		failureCounters = storage.NewMemoryFailureCounterStorage()
+	default:
+		log.Fatalf("FATAL: unknown lockout store %q", cfg.LockoutStore)
+	}
+	accountGuard := lockout.NewGuard(failureCounters, lockout.AccountPolicy(cfg))
+	sourceGuard := lockout.NewGuard(failureCounters, lockout.SourcePolicy(cfg))
+
+	var policies *authz.Engine
+	if cfg.PolicyFile != "" {
+		policies, err = authz.Load(cfg.PolicyFile)
+		if err != nil {
+			log.Fatalf("FATAL: failed to load policies: %s", err)
+		}
+		if policies.Audit() {
+			log.Print("WARNING: policies are in audit mode, their decisions are only logged")
+		}
+		go reloadPolicies(policies)
+	}
+
+	// the server stops on SIGINT and SIGTERM, background work after it
+	stopping, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	background, cancelBackground := context.WithCancel(context.Background())
+	defer cancelBackground()
+
+	accessLog := accesslog.NewWriter(storageImpl, cfg)
+	// events stored with changes are published in the background
+	go events.NewRelay(storageImpl, publisher, cfg).Run(background)
+	// every service registers the personal data it holds
+	exporter := export.NewExporter()
+
+	service, err := services.NewUserService(storageImpl, mailerImpl, smsSender, accountGuard, accessLog, exporter, policies, cfg)
 	if err != nil {
 		log.Fatalf("FATAL: failed to create a UserService: %s", err)
 	}
-	apiServer := api.NewAPIServer(":8080", service, cfg)
-	if err := apiServer.Run(); err != nil {
+	authService, err := services.NewAuthService(services.AuthDependencies{
+		Storage:  storageImpl,
+		Hasher:   hasher,
+		Policy:   policy,
+		Notifier: services.NewMailAuthNotifier(mailerImpl, cfg.AppBaseURL),
+		Signer:   signer,
+		Cipher:   mfaCipher,
+		Guard:    accountGuard,
+		Exporter: exporter,
+		Policies: policies,
+	}, cfg)
+	if err != nil {
+		log.Fatalf("FATAL: failed to create an AuthService: %s", err)
+	}
+	// keys stay published for as long as the tokens they signed are valid
+	oidcKeys := oidc.NewKeySet(storageImpl, mfaCipher, cfg.OIDCKeyRotation, cfg.OIDCTokenTTL)
+	oidcService, err := services.NewOIDCService(storageImpl, oidcKeys, policies, cfg)
+	if err != nil {
+		log.Fatalf("FATAL: failed to create an OIDCService: %s", err)
+	}
+	scimService, err := services.NewSCIMService(service, storageImpl, accessLog, policies, cfg)
+	if err != nil {
+		log.Fatalf("FATAL: failed to create a SCIMService: %s", err)
+	}
+	apiKeyService, err := services.NewAPIKeyService(storageImpl, policies)
+	if err != nil {
+		log.Fatalf("FATAL: failed to create an APIKeyService: %s", err)
+	}
+	privacyService, err := services.NewPrivacyService(background, storageImpl, exporter, mfaCipher, accessLog, policies, cfg)
+	if err != nil {
+		log.Fatalf("FATAL: failed to create a PrivacyService: %s", err)
+	}
+	var tokenVerifier *jwtauth.Verifier
+	if cfg.JWT != nil {
+		tokenVerifier = jwtauth.NewVerifier(cfg.JWT, &http.Client{Timeout: 10 * time.Second})
+	}
+	var serverTLS *servertls.Settings
+	if cfg.TLS != nil {
+		serverTLS, err = servertls.Load(cfg.TLS)
+		if err != nil {
+			log.Fatalf("FATAL: failed to load TLS settings: %s", err)
+		}
+	}
+	var signatures *signing.Verifier
+	if cfg.Signing != nil {
+		var nonces storage.NonceStorage = storageImpl
+		if cfg.Signing.NonceStore == "memory" {
+			nonces = storage.NewMemoryNonceStorage()
+		}
+		signatures, err = signing.Load(cfg.Signing, nonces)
+		if err != nil {
+			log.Fatalf("FATAL: failed to load signing clients: %s", err)
+		}
+	}
+	apiServer := api.NewAPIServer(":8080", api.Dependencies{
+		Users:         service,
+		Auth:          authService,
+		OIDC:          oidcService,
+		SCIM:          scimService,
+		APIKeys:       apiKeyService,
+		Privacy:       privacyService,
+		TokenVerifier: tokenVerifier,
+		TLS:           serverTLS,
+		Signatures:    signatures,
+		SourceGuard:   sourceGuard,
+	}, cfg)
+	if err := apiServer.Run(stopping); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("FATAL: could not start server: %v", err)
 	}
+
+	// requests are done, the access records they queued are written before
+	// the relay and exports stop
+	accessLog.Close()
+	cancelBackground()
+	log.Print("Server stopped")
+}
+
+// reloadPolicies reads the policy file again on SIGHUP so policies change
+// without a restart
+func reloadPolicies(policies *authz.Engine) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		if err := policies.Reload(); err != nil {
+			log.Printf("ERROR: failed to reload policies, keeping the current ones: %v", err)
+			continue
+		}
+		log.Print("Policies reloaded")
+	}
 }
@@ -0,0 +1,162 @@
+// Package accesslog persists who read the data of which user. Records are
+// queued and written in batches in the background so reads do not wait on
+// the database
+package accesslog
+
+import (
+	"log"
+	"sync"
+	"time"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/storage"
+)
+
+// records written in one insert at most
+const maxBatchSize = 500
+
+// how often records past the retention are deleted
+const pruneInterval = time.Hour
+
+// Writer queues access records and writes them in the background. Records
+// are only written synchronously when the queue is full or the writer is
+// closed, so none are dropped
+type Writer struct {
+	storage   storage.AccessLogStorage
+	records   chan models.AccessRecord
+	flushes   chan chan struct{}
+	interval  time.Duration
+	retention time.Duration
+
+	closeOnce sync.Once
+	closing   chan struct{}
+	closed    chan struct{}
+}
+
+func NewWriter(storage storage.AccessLogStorage, cfg *config.Config) *Writer {
+	w := &Writer{
+		storage:   storage,
+		records:   make(chan models.AccessRecord, max(cfg.AccessLogBufferSize, 1)),
+		flushes:   make(chan chan struct{}),
+		interval:  cfg.AccessLogFlushInterval,
+		retention: cfg.AccessLogRetention,
+		closing:   make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+	if w.interval <= 0 {
+		w.interval = time.Second
+	}
+	go w.run()
+	return w
+}
+
+// Record queues the record
+func (w *Writer) Record(record models.AccessRecord) {
+	select {
+	case <-w.closing:
+		w.write([]models.AccessRecord{record})
+		return
+	default:
+	}
+
+	select {
+	case w.records <- record:
+	default:
+		w.write([]models.AccessRecord{record})
+	}
+}
+
+// Flush returns once every record queued before the call is written
+func (w *Writer) Flush() {
+	done := make(chan struct{})
+	select {
+	case w.flushes <- done:
+		<-done
+	case <-w.closed:
+	}
+}
+
+// Close writes the queued records and stops the writer, records made
+// afterwards are written synchronously
+func (w *Writer) Close() {
+	w.closeOnce.Do(func() {
+		close(w.closing)
+	})
+	<-w.closed
+}
+
+func (w *Writer) run() {
+	defer close(w.closed)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	var prune <-chan time.Time
+	if w.retention > 0 {
+		w.prune()
+		pruneTicker := time.NewTicker(pruneInterval)
+		defer pruneTicker.Stop()
+		prune = pruneTicker.C
+	}
+
+	batch := make([]models.AccessRecord, 0, maxBatchSize)
+	for {
+		select {
+		case record := <-w.records:
+			batch = append(batch, record)
+			if len(batch) >= maxBatchSize {
+				batch = w.writeBatch(batch)
+			}
+		case <-ticker.C:
+			batch = w.writeBatch(batch)
+		case <-prune:
+			w.prune()
+		case done := <-w.flushes:
+			batch = w.writeBatch(w.drain(batch))
+			close(done)
+		case <-w.closing:
+			w.writeBatch(w.drain(batch))
+			return
+		}
+	}
+}
+
+// drain moves the queued records to the batch
+func (w *Writer) drain(batch []models.AccessRecord) []models.AccessRecord {
+	for {
+		select {
+		case record := <-w.records:
+			batch = append(batch, record)
+		default:
+			return batch
+		}
+	}
+}
+
+// writeBatch writes the batch in inserts of at most maxBatchSize records and
+// returns it emptied
+func (w *Writer) writeBatch(batch []models.AccessRecord) []models.AccessRecord {
+	for start := 0; start < len(batch); start += maxBatchSize {
+		w.write(batch[start:min(start+maxBatchSize, len(batch))])
+	}
+	return batch[:0]
+}
+
+func (w *Writer) write(records []models.AccessRecord) {
+	if len(records) == 0 {
+		return
+	}
+	if err := w.storage.AppendAccessRecords(records); err != nil {
+		log.Printf("ERROR: failed to write %d access records: %v", len(records), err)
+	}
+}
+
+func (w *Writer) prune() {
+	deleted, err := w.storage.DeleteAccessRecordsBefore(time.Now().Add(-w.retention))
+	if err != nil {
+		log.Printf("ERROR: failed to delete expired access records: %v", err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d access records past the retention", deleted)
+	}
+}
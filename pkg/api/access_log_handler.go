@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+	"users-microservice/pkg/models"
+)
+
+type AccessRecordAPI struct {
+	Accessor   string    `json:"accessor"`
+	Fields     []string  `json:"fields"`
+	Purpose    string    `json:"purpose,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	AccessedAt time.Time `json:"accessed_at"`
+}
+
+func NewAccessLogResponse(records []models.AccessRecord) []AccessRecordAPI {
+	response := make([]AccessRecordAPI, 0, len(records))
+	for _, record := range records {
+		response = append(response, AccessRecordAPI{
+			Accessor:   record.Accessor,
+			Fields:     record.Fields,
+			Purpose:    record.Purpose,
+			RequestID:  record.RequestID,
+			AccessedAt: record.AccessedAt,
+		})
+	}
+	return response
+}
+
+// HandleGetUserAccessLog lists who read the user, newest first. "since" is an
+// RFC 3339 time and "limit" caps the number of records
+func (s *APIServer) HandleGetUserAccessLog(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	query := r.URL.Query()
+	var since time.Time
+	if value := query.Get("since"); value != "" {
+		if since, err = time.Parse(time.RFC3339, value); err != nil {
+			return models.NewInternalError(models.ContextBadRequest, "since has to be an RFC 3339 time")
+		}
+	}
+	var limit int
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			return models.NewInternalError(models.ContextBadRequest, "limit has to be a positive integer")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	records, err := s.service.GetUserAccessLog(ctx, userUUID, since, limit)
+	if err != nil {
+		return err
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, NewAccessLogResponse(records))
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/services"
+
+	"github.com/google/uuid"
+)
+
+type APIKeyCreateAPI struct {
+	Name      string         `json:"name"`
+	Scopes    []models.Scope `json:"scopes"`
+	ExpiresAt *time.Time     `json:"expires_at"`
+}
+
+type APIKeyAPI struct {
+	ID         uuid.UUID      `json:"id"`
+	Name       string         `json:"name"`
+	Prefix     string         `json:"prefix"`
+	Scopes     []models.Scope `json:"scopes"`
+	CreatedAt  time.Time      `json:"created_at"`
+	ExpiresAt  *time.Time     `json:"expires_at"`
+	LastUsedAt *time.Time     `json:"last_used_at"`
+	// only returned when the key is issued
+	Key string `json:"key,omitempty"`
+}
+
+func NewAPIKeyResponse(key *models.APIKey) APIKeyAPI {
+	return APIKeyAPI{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+	}
+}
+
+func (s *APIServer) HandleIssueAPIKey(w http.ResponseWriter, r *http.Request) error {
+	var keyRequest APIKeyCreateAPI
+	if err := json.NewDecoder(r.Body).Decode(&keyRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	issue, err := s.apiKeyService.IssueAPIKey(ctx, services.APIKeyRequest(keyRequest))
+	if err != nil {
+		return err
+	}
+
+	response := NewAPIKeyResponse(issue.Key)
+	response.Key = issue.Secret
+	return ConstructSuccessResponse(w, http.StatusCreated, response)
+}
+
+func (s *APIServer) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	keys, err := s.apiKeyService.ListAPIKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	response := make([]APIKeyAPI, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, NewAPIKeyResponse(&key))
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+func (s *APIServer) HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) error {
+	id := r.PathValue("keyID")
+	keyUUID, err := uuid.Parse(id)
+	if err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, fmt.Sprintf("UUID '%s' is not formatted correctly.", id))
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := s.apiKeyService.RevokeAPIKey(ctx, keyUUID); err != nil {
+		return err
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, nil)
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+	"users-microservice/pkg/models"
+)
+
+type AuditChangeAPI struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+	// personal data is an HMAC of the value rather than the value
+	Hashed bool `json:"hashed,omitempty"`
+}
+
+type AuditEntryAPI struct {
+	Sequence  int64            `json:"sequence"`
+	Action    string           `json:"action"`
+	Actor     string           `json:"actor"`
+	RequestID string           `json:"request_id,omitempty"`
+	Changes   []AuditChangeAPI `json:"changes"`
+	CreatedAt time.Time        `json:"created_at"`
+	PrevHash  string           `json:"prev_hash,omitempty"`
+	Hash      string           `json:"hash"`
+}
+
+func NewAuditResponse(entries []models.AuditEntry) []AuditEntryAPI {
+	response := make([]AuditEntryAPI, 0, len(entries))
+	for _, entry := range entries {
+		changes := make([]AuditChangeAPI, 0, len(entry.Changes))
+		for _, change := range entry.Changes {
+			changes = append(changes, AuditChangeAPI{Field: change.Field, Before: change.Before, After: change.After, Hashed: change.Hashed})
+		}
+		response = append(response, AuditEntryAPI{
+			Sequence:  entry.Sequence,
+			Action:    entry.Action,
+			Actor:     entry.Actor,
+			RequestID: entry.RequestID,
+			Changes:   changes,
+			CreatedAt: entry.CreatedAt,
+			PrevHash:  entry.PrevHash,
+			Hash:      entry.Hash,
+		})
+	}
+	return response
+}
+
+func (s *APIServer) HandleGetUserAudit(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	entries, err := s.service.GetUserAudit(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, NewAuditResponse(entries))
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/services"
+)
+
+type PasswordAPI struct {
+	Password string `json:"password"`
+}
+
+type PasswordChangeAPI struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type LoginAPI struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (s *APIServer) HandleSetPassword(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var passwordRequest PasswordAPI
+	if err := json.NewDecoder(r.Body).Decode(&passwordRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := s.authService.SetPassword(ctx, userUUID, passwordRequest.Password); err != nil {
+		return err
+	}
+
+	return ConstructSuccessResponse(w, http.StatusOK, nil)
+}
+
+func (s *APIServer) HandleChangePassword(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var changeRequest PasswordChangeAPI
+	if err := json.NewDecoder(r.Body).Decode(&changeRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	serviceReq := services.PasswordChangeRequest{
+		CurrentPassword: changeRequest.CurrentPassword,
+		NewPassword:     changeRequest.NewPassword,
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := s.authService.ChangePassword(ctx, userUUID, serviceReq); err != nil {
+		return err
+	}
+
+	return ConstructSuccessResponse(w, http.StatusOK, nil)
+}
+
+func (s *APIServer) HandleLogin(w http.ResponseWriter, r *http.Request) error {
+	var loginRequest LoginAPI
+	if err := json.NewDecoder(r.Body).Decode(&loginRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	serviceReq := services.LoginRequest{
+		Email:    loginRequest.Email,
+		Password: loginRequest.Password,
+		Metadata: sessionMetadata(r),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	result, err := s.authService.Login(ctx, serviceReq)
+	if err != nil {
+		return err
+	}
+
+	response := NewLoginResponse(result)
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+type PasswordResetAPI struct {
+	Email string `json:"email"`
+}
+
+type PasswordResetConfirmAPI struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// HandleRequestPasswordReset answers 202 no matter what happened, failures are
+// only logged so the response cannot reveal whether the account exists
+func (s *APIServer) HandleRequestPasswordReset(w http.ResponseWriter, r *http.Request) error {
+	var resetRequest PasswordResetAPI
+	if err := json.NewDecoder(r.Body).Decode(&resetRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if err := s.authService.RequestPasswordReset(ctx, resetRequest.Email); err != nil {
+		logError(r, err, time.Since(start))
+	}
+
+	return ConstructSuccessResponse(w, http.StatusAccepted, nil)
+}
+
+func (s *APIServer) HandleConfirmPasswordReset(w http.ResponseWriter, r *http.Request) error {
+	var confirmRequest PasswordResetConfirmAPI
+	if err := json.NewDecoder(r.Body).Decode(&confirmRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := s.authService.ConfirmPasswordReset(ctx, confirmRequest.Token, confirmRequest.NewPassword); err != nil {
+		return err
+	}
+
+	return ConstructSuccessResponse(w, http.StatusOK, nil)
+}
+
+func (s *APIServer) HandleUnlockAccount(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var unlockRequest StatusChangeAPI
+	if err := json.NewDecoder(r.Body).Decode(&unlockRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := s.authService.UnlockAccount(ctx, userUUID, unlockRequest.Reason); err != nil {
+		return err
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, nil)
+}
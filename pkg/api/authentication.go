@@ -0,0 +1,110 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"users-microservice/pkg/jwtauth"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/signing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// apiKeyMarker starts every API key, other bearer tokens are JWTs
+const apiKeyMarker = "uk_"
+
+// sessionScopes are granted to users signed in here, they act on their own
+// account but do not administer it
+var sessionScopes = []models.Scope{models.ScopeUsersWrite}
+
+// requireScope only lets requests through whose caller holds the scope, the
+// caller is handed on in the request context
+func (s *APIServer) requireScope(scope models.Scope, next apiHandler) apiHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		principal, err := s.authenticate(r)
+		if err != nil {
+			if models.ErrorContext(err) == models.ContextUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			}
+			return err
+		}
+		if !principal.HasScope(scope) {
+			return models.NewInternalError(models.ContextForbidden, fmt.Sprintf("caller is not granted the '%s' scope", scope))
+		}
+		ctx := models.ContextWithFieldMasks(models.ContextWithPrincipal(r.Context(), principal))
+		return next(w, r.WithContext(ctx))
+	}
+}
+
+// public serves routes where users authenticate with their own credentials,
+// the service acts for them as the system principal
+func public(next apiHandler) apiHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		principal := models.SystemPrincipal(models.SystemSubjectPublic)
+		ctx := models.ContextWithFieldMasks(models.ContextWithPrincipal(r.Context(), principal))
+		return next(w, r.WithContext(ctx))
+	}
+}
+
+// authenticate finds the caller by the API key, session access token or
+// gateway token sent as bearer token, by the request signature, or else by
+// the client certificate.
+// Tokens come first so a gateway connecting with a certificate can still act
+// for its users
+func (s *APIServer) authenticate(r *http.Request) (*models.Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		if signing.IsSigned(r) {
+			if s.signatures == nil {
+				return nil, models.NewInternalError(models.ContextUnauthorized, "signed requests are not accepted")
+			}
+			return s.signatures.Verify(r)
+		}
+		if s.tls != nil {
+			if principal, ok := s.tls.Principal(r.TLS); ok {
+				return principal, nil
+			}
+		}
+		return nil, models.NewInternalError(models.ContextUnauthorized, "API key or bearer token is required")
+	}
+
+	if strings.HasPrefix(token, apiKeyMarker) {
+		key, err := s.apiKeyService.Authenticate(r.Context(), token)
+		if err != nil {
+			return nil, err
+		}
+		return &models.Principal{Kind: models.PrincipalService, Subject: key.ID.String(), Scopes: key.Scopes}, nil
+	}
+
+	// sessions started here sign their access tokens with HS256, the gateway
+	// only with key pairs
+	if isSessionToken(token) {
+		session, err := s.authService.VerifyAccessToken(r.Context(), token)
+		if err != nil {
+			return nil, err
+		}
+		return &models.Principal{Kind: models.PrincipalUser, Subject: session.UserID.String(), UserID: session.UserID, Scopes: sessionScopes}, nil
+	}
+
+	if s.tokenVerifier == nil {
+		return nil, models.NewInternalError(models.ContextUnauthorized, "API key is invalid")
+	}
+	identity, err := s.tokenVerifier.Verify(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, jwtauth.ErrKeySetUnavailable) {
+			log.Printf("ERROR: failed to verify bearer token: %v", err)
+			return nil, models.NewWrappedError(err, models.ContextInternalServer, "bearer tokens cannot be verified right now")
+		}
+		return nil, models.NewWrappedError(err, models.ContextUnauthorized, "bearer token is invalid")
+	}
+
+	return &models.Principal{Kind: identity.Kind, Subject: identity.Subject, UserID: identity.UserID, Scopes: identity.Scopes, Claims: identity.Claims}, nil
+}
+
+func isSessionToken(token string) bool {
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	return err == nil && parsed.Method.Alg() == jwt.SigningMethodHS256.Alg()
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+type ErasureCertificateAPI struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	ErasedBy  string    `json:"erased_by"`
+	RequestID string    `json:"request_id,omitempty"`
+	Erased    []string  `json:"erased"`
+	ErasedAt  time.Time `json:"erased_at"`
+	Hash      string    `json:"hash"`
+}
+
+func NewErasureCertificateResponse(certificate *models.ErasureCertificate) ErasureCertificateAPI {
+	return ErasureCertificateAPI{
+		ID:        certificate.ID,
+		UserID:    certificate.UserID,
+		ErasedBy:  certificate.ErasedBy,
+		RequestID: certificate.RequestID,
+		Erased:    certificate.Erased,
+		ErasedAt:  certificate.ErasedAt,
+		Hash:      certificate.Hash,
+	}
+}
+
+// HandleErasePersonalData erases the user for good, repeating the request
+// returns the certificate of the first erasure
+func (s *APIServer) HandleErasePersonalData(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	certificate, err := s.privacyService.ErasePersonalData(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, NewErasureCertificateResponse(certificate))
+}
+
+func (s *APIServer) HandleGetErasureCertificate(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	certificate, err := s.privacyService.GetErasureCertificate(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, NewErasureCertificateResponse(certificate))
+}
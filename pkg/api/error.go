@@ -41,6 +41,12 @@ func translateByContext(context string, message string) APIError {
 		return *NewAPIError(http.StatusNotFound, message)
 	case models.ContextBadRequest:
 		return *NewAPIError(http.StatusBadRequest, message)
+	case models.ContextUnauthorized:
+		return *NewAPIError(http.StatusUnauthorized, message)
+	case models.ContextForbidden:
+		return *NewAPIError(http.StatusForbidden, message)
+	case models.ContextTooManyRequests:
+		return *NewAPIError(http.StatusTooManyRequests, message)
 	default:
 		return *NewAPIError(http.StatusInternalServerError, message)
 	}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/services"
+
+	"github.com/google/uuid"
+)
+
+const (
+	federatedLoginNonceCookie = "federated_login_nonce"
+	// the cookie is only sent back to the federated sign-in endpoints
+	federatedLoginCookiePath = "/auth/federated"
+)
+
+type FederationProvidersAPI struct {
+	Providers []string `json:"providers"`
+}
+
+type AuthorizationURLAPI struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+// FederatedCallbackAPI holds the parameters the provider redirected back with
+type FederatedCallbackAPI struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+type FederatedSignupAPI struct {
+	SignupToken string    `json:"signup_token"`
+	Name        string    `json:"name"`
+	DateOfBirth time.Time `json:"date_of_birth"`
+}
+
+// FederatedSignupRequiredAPI is returned instead of a session when nobody is
+// linked to the identity, name and email are what the provider asserted
+type FederatedSignupRequiredAPI struct {
+	SignupToken string    `json:"signup_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Provider    string    `json:"provider"`
+	Email       string    `json:"email"`
+	Name        string    `json:"name,omitempty"`
+}
+
+type FederatedIdentityAPI struct {
+	ID         uuid.UUID  `json:"id"`
+	Provider   string     `json:"provider"`
+	Issuer     string     `json:"issuer"`
+	Subject    string     `json:"subject"`
+	Email      string     `json:"email,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func NewFederatedIdentityResponse(identity *models.FederatedIdentity) FederatedIdentityAPI {
+	return FederatedIdentityAPI{
+		ID:         identity.ID,
+		Provider:   identity.Provider,
+		Issuer:     identity.Issuer,
+		Subject:    identity.Subject,
+		Email:      identity.Email,
+		CreatedAt:  identity.CreatedAt,
+		LastUsedAt: identity.LastUsedAt,
+	}
+}
+
+func (s *APIServer) HandleListFederationProviders(w http.ResponseWriter, r *http.Request) error {
+	return ConstructSuccessResponse(w, http.StatusOK, FederationProvidersAPI{Providers: s.authService.FederationProviders()})
+}
+
+func (s *APIServer) HandleBeginFederatedLogin(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	start, err := s.authService.BeginFederatedLogin(ctx, r.PathValue("provider"))
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     federatedLoginNonceCookie,
+		Value:    start.Nonce,
+		Path:     federatedLoginCookiePath,
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return ConstructSuccessResponse(w, http.StatusOK, AuthorizationURLAPI{AuthorizationURL: start.AuthorizationURL})
+}
+
+func (s *APIServer) HandleFederatedLogin(w http.ResponseWriter, r *http.Request) error {
+	var callback FederatedCallbackAPI
+	if err := json.NewDecoder(r.Body).Decode(&callback); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	// a missing cookie fails like a wrong one, the state still gets used up
+	var nonce string
+	if cookie, err := r.Cookie(federatedLoginNonceCookie); err == nil {
+		nonce = cookie.Value
+	}
+
+	serviceReq := services.FederatedCallbackRequest{
+		Provider: r.PathValue("provider"),
+		Code:     callback.Code,
+		State:    callback.State,
+		Nonce:    nonce,
+		Metadata: sessionMetadata(r),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	result, err := s.authService.FederatedLogin(ctx, serviceReq)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     federatedLoginNonceCookie,
+		Path:     federatedLoginCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	response := NewLoginResponse(result)
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+func (s *APIServer) HandleFederatedSignup(w http.ResponseWriter, r *http.Request) error {
+	var signupRequest FederatedSignupAPI
+	if err := json.NewDecoder(r.Body).Decode(&signupRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	serviceReq := services.FederatedSignupRequest{
+		Token:       signupRequest.SignupToken,
+		Name:        signupRequest.Name,
+		DateOfBirth: signupRequest.DateOfBirth,
+		Metadata:    sessionMetadata(r),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	result, err := s.authService.CompleteFederatedSignup(ctx, serviceReq)
+	if err != nil {
+		return err
+	}
+
+	response := NewLoginResponse(result)
+	return ConstructSuccessResponse(w, http.StatusCreated, response)
+}
+
+func (s *APIServer) HandleBeginIdentityLink(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	authorizationURL, err := s.authService.BeginIdentityLink(ctx, userUUID, r.PathValue("provider"))
+	if err != nil {
+		return err
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, AuthorizationURLAPI{AuthorizationURL: authorizationURL})
+}
+
+func (s *APIServer) HandleLinkIdentity(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var callback FederatedCallbackAPI
+	if err := json.NewDecoder(r.Body).Decode(&callback); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	serviceReq := services.FederatedCallbackRequest{
+		Provider: r.PathValue("provider"),
+		Code:     callback.Code,
+		State:    callback.State,
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	identity, err := s.authService.LinkIdentity(ctx, userUUID, serviceReq)
+	if err != nil {
+		return err
+	}
+
+	response := NewFederatedIdentityResponse(identity)
+	return ConstructSuccessResponse(w, http.StatusCreated, response)
+}
+
+func (s *APIServer) HandleListIdentities(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	identities, err := s.authService.ListIdentities(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+
+	response := make([]FederatedIdentityAPI, 0, len(identities))
+	for _, identity := range identities {
+		response = append(response, NewFederatedIdentityResponse(&identity))
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+func (s *APIServer) HandleUnlinkIdentity(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+	id := r.PathValue("identityID")
+	identityUUID, err := uuid.Parse(id)
+	if err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, fmt.Sprintf("UUID '%s' is not formatted correctly.", id))
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := s.authService.UnlinkIdentity(ctx, userUUID, identityUUID); err != nil {
+		return err
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, nil)
+}
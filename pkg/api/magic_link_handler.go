@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/services"
+)
+
+const (
+	magicLinkNonceCookie = "magic_link_nonce"
+	// the cookie is only sent back to the magic link endpoints
+	magicLinkCookiePath = "/auth/magic-link"
+)
+
+type MagicLinkAPI struct {
+	Email string `json:"email"`
+}
+
+func (s *APIServer) HandleRequestMagicLink(w http.ResponseWriter, r *http.Request) error {
+	var linkRequest MagicLinkAPI
+	if err := json.NewDecoder(r.Body).Decode(&linkRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	// a device asking again keeps its nonce, the link already sent stays
+	// bound to it
+	var nonce string
+	if cookie, err := r.Cookie(magicLinkNonceCookie); err == nil {
+		nonce = cookie.Value
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	nonce, err := s.authService.RequestMagicLink(ctx, linkRequest.Email, nonce)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkNonceCookie,
+		Value:    nonce,
+		Path:     magicLinkCookiePath,
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return ConstructSuccessResponse(w, http.StatusAccepted, nil)
+}
+
+func (s *APIServer) HandleConsumeMagicLink(w http.ResponseWriter, r *http.Request) error {
+	var tokenRequest TokenAPI
+	if err := json.NewDecoder(r.Body).Decode(&tokenRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	// a missing cookie fails like a wrong one, the token still gets used up
+	var nonce string
+	if cookie, err := r.Cookie(magicLinkNonceCookie); err == nil {
+		nonce = cookie.Value
+	}
+
+	serviceReq := services.MagicLinkLoginRequest{
+		Token:    tokenRequest.Token,
+		Nonce:    nonce,
+		Metadata: sessionMetadata(r),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	result, err := s.authService.ConsumeMagicLink(ctx, serviceReq)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkNonceCookie,
+		Path:     magicLinkCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	response := NewLoginResponse(result)
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
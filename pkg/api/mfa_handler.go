@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/services"
+)
+
+type TOTPEnrollmentAPI struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type TOTPCodeAPI struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodesAPI struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MFAStatusAPI struct {
+	Enabled                bool  `json:"enabled"`
+	RecoveryCodesRemaining int64 `json:"recovery_codes_remaining"`
+}
+
+type MFALoginAPI struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func (s *APIServer) HandleEnrollTOTP(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	enrollment, err := s.authService.EnrollTOTP(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+
+	response := TOTPEnrollmentAPI{Secret: enrollment.Secret, OTPAuthURI: enrollment.URI}
+	return ConstructSuccessResponse(w, http.StatusCreated, response)
+}
+
+func (s *APIServer) HandleConfirmTOTP(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var codeRequest TOTPCodeAPI
+	if err := json.NewDecoder(r.Body).Decode(&codeRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	recoveryCodes, err := s.authService.ConfirmTOTP(ctx, userUUID, codeRequest.Code)
+	if err != nil {
+		return err
+	}
+
+	response := RecoveryCodesAPI{RecoveryCodes: recoveryCodes}
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+func (s *APIServer) HandleGetMFAStatus(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	status, err := s.authService.GetMFAStatus(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+
+	response := MFAStatusAPI{Enabled: status.Enabled, RecoveryCodesRemaining: status.RecoveryCodesRemaining}
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+func (s *APIServer) HandleResetMFA(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var resetRequest StatusChangeAPI
+	if err := json.NewDecoder(r.Body).Decode(&resetRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := s.authService.ResetMFA(ctx, userUUID, resetRequest.Reason); err != nil {
+		return err
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, nil)
+}
+
+func (s *APIServer) HandleMFALogin(w http.ResponseWriter, r *http.Request) error {
+	var loginRequest MFALoginAPI
+	if err := json.NewDecoder(r.Body).Decode(&loginRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	serviceReq := services.MFALoginRequest{
+		MFAToken:     loginRequest.MFAToken,
+		Code:         loginRequest.Code,
+		RecoveryCode: loginRequest.RecoveryCode,
+		Metadata:     sessionMetadata(r),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	result, err := s.authService.CompleteMFALogin(ctx, serviceReq)
+	if err != nil {
+		return err
+	}
+
+	response := NewLoginResponse(result)
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
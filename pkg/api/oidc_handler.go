@@ -0,0 +1,303 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/oidc"
+	"users-microservice/pkg/services"
+)
+
+// AuthorizationRequestAPI uses the parameter names of the authorization
+// endpoint so the login page can pass the query through unchanged
+type AuthorizationRequestAPI struct {
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	ResponseType        string `json:"response_type"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	Nonce               string `json:"nonce"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+type AuthorizationRedirectAPI struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+type TokenResponseAPI struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+type OIDCClientCreateAPI struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Public       bool     `json:"public"`
+}
+
+type OIDCClientAPI struct {
+	ID           string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Public       bool      `json:"public"`
+	CreatedAt    time.Time `json:"created_at"`
+	// only returned on registration
+	Secret string `json:"client_secret,omitempty"`
+}
+
+type SigningKeyAPI struct {
+	KeyID string `json:"kid"`
+}
+
+func NewOIDCClientResponse(client *models.OIDCClient) OIDCClientAPI {
+	return OIDCClientAPI{
+		ID:           client.ID,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIs,
+		Public:       client.IsPublic(),
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// MakeOAuthHandleFunc is MakeHTTPHandleFunc for endpoints whose errors have to
+// follow the protocol instead of the API envelope
+func MakeOAuthHandleFunc(f apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r = withRequestContext(w, r)
+		if err := f(w, r); err != nil {
+			logError(r, err, time.Since(start))
+			oidcErr := oidc.AsError(err)
+			if oidcErr == nil {
+				oidcErr = oidc.NewError(oidc.ErrorServerError, "unexpected error")
+			}
+
+			status := http.StatusBadRequest
+			switch oidcErr.Code {
+			case oidc.ErrorInvalidClient:
+				status = http.StatusUnauthorized
+				w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+			case oidc.ErrorInvalidToken:
+				status = http.StatusUnauthorized
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			case oidc.ErrorServerError:
+				status = http.StatusInternalServerError
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			ConstructResponse(w, status, oidcErr)
+		} else {
+			logSuccess(r, time.Since(start))
+		}
+	}
+}
+
+func (s *APIServer) HandleOpenIDConfiguration(w http.ResponseWriter, r *http.Request) error {
+	return ConstructResponse(w, http.StatusOK, s.oidcService.ProviderMetadata())
+}
+
+func (s *APIServer) HandleJWKS(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	set, err := s.oidcService.JWKS(ctx)
+	if err != nil {
+		return err
+	}
+
+	// short enough for clients to pick up a rotated key before it signs much
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	return ConstructResponse(w, http.StatusOK, set)
+}
+
+// HandleAuthorize checks the request of the client and sends the browser on
+// to the login page, which completes it with HandleCompleteAuthorization
+func (s *APIServer) HandleAuthorize(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	authRequest := services.AuthorizationRequest{
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		ResponseType:        query.Get("response_type"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		Nonce:               query.Get("nonce"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := s.oidcService.ValidateAuthorization(ctx, authRequest); err != nil {
+		oidcErr := oidc.AsError(err)
+		if oidcErr == nil {
+			// the redirect URI is not trusted, the error stays here
+			return err
+		}
+		http.Redirect(w, r, oidc.ErrorRedirectURL(authRequest.RedirectURI, authRequest.State, s.oidcIssuer, oidcErr), http.StatusFound)
+		return nil
+	}
+
+	http.Redirect(w, r, oidc.RedirectURL(s.oidcLoginURL, query), http.StatusFound)
+	return nil
+}
+
+// HandleCompleteAuthorization is called by the login page with the access
+// token of the signed-in user and returns where to send the browser
+func (s *APIServer) HandleCompleteAuthorization(w http.ResponseWriter, r *http.Request) error {
+	accessToken, ok := bearerToken(r)
+	if !ok {
+		return models.NewInternalError(models.ContextUnauthorized, "access token is required")
+	}
+
+	var authRequest AuthorizationRequestAPI
+	if err := json.NewDecoder(r.Body).Decode(&authRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	session, err := s.authService.VerifyAccessToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	serviceReq := services.AuthorizationRequest(authRequest)
+	redirectTo, err := s.oidcService.Authorize(ctx, serviceReq, session)
+	if err != nil {
+		oidcErr := oidc.AsError(err)
+		if oidcErr == nil {
+			return err
+		}
+		redirectTo = oidc.ErrorRedirectURL(serviceReq.RedirectURI, serviceReq.State, s.oidcIssuer, oidcErr)
+	}
+
+	return ConstructSuccessResponse(w, http.StatusOK, AuthorizationRedirectAPI{RedirectTo: redirectTo})
+}
+
+func (s *APIServer) HandleToken(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return oidc.NewError(oidc.ErrorInvalidRequest, "request body must be form encoded")
+	}
+
+	clientID, clientSecret, basic := r.BasicAuth()
+	if basic {
+		// credentials are form encoded before they go into the header
+		var err error
+		if clientID, err = url.QueryUnescape(clientID); err != nil {
+			return oidc.NewError(oidc.ErrorInvalidClient, "client credentials are malformed")
+		}
+		if clientSecret, err = url.QueryUnescape(clientSecret); err != nil {
+			return oidc.NewError(oidc.ErrorInvalidClient, "client credentials are malformed")
+		}
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	serviceReq := services.TokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	issued, err := s.oidcService.ExchangeCode(ctx, serviceReq)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return ConstructResponse(w, http.StatusOK, TokenResponseAPI{
+		AccessToken: issued.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(issued.ExpiresIn.Seconds()),
+		IDToken:     issued.IDToken,
+		Scope:       issued.Scope,
+	})
+}
+
+func (s *APIServer) HandleUserInfo(w http.ResponseWriter, r *http.Request) error {
+	accessToken, ok := bearerToken(r)
+	if !ok {
+		return oidc.NewError(oidc.ErrorInvalidToken, "access token is required")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	info, err := s.oidcService.UserInfo(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return ConstructResponse(w, http.StatusOK, info)
+}
+
+func (s *APIServer) HandleRegisterOIDCClient(w http.ResponseWriter, r *http.Request) error {
+	var clientRequest OIDCClientCreateAPI
+	if err := json.NewDecoder(r.Body).Decode(&clientRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	registration, err := s.oidcService.RegisterClient(ctx, services.OIDCClientRequest(clientRequest))
+	if err != nil {
+		return err
+	}
+
+	response := NewOIDCClientResponse(registration.Client)
+	response.Secret = registration.Secret
+	return ConstructSuccessResponse(w, http.StatusCreated, response)
+}
+
+func (s *APIServer) HandleGetOIDCClient(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	client, err := s.oidcService.GetClient(ctx, r.PathValue("clientID"))
+	if err != nil {
+		return err
+	}
+
+	response := NewOIDCClientResponse(client)
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+func (s *APIServer) HandleDeleteOIDCClient(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := s.oidcService.DeleteClient(ctx, r.PathValue("clientID")); err != nil {
+		return err
+	}
+
+	return ConstructSuccessResponse(w, http.StatusOK, nil)
+}
+
+func (s *APIServer) HandleRotateSigningKey(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	kid, err := s.oidcService.RotateSigningKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ConstructSuccessResponse(w, http.StatusOK, SigningKeyAPI{KeyID: kid})
+}
@@ -0,0 +1,310 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/services"
+
+	"github.com/google/uuid"
+)
+
+// Base64URL is binary WebAuthn data, encoded the way browsers serialize it
+type Base64URL []byte
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// The options and credentials below follow the JSON serialization of the
+// WebAuthn specification rather than the snake case used elsewhere, so they
+// can be handed to the browser API as they are
+
+type PublicKeyCredentialDescriptorAPI struct {
+	Type string    `json:"type"`
+	ID   Base64URL `json:"id"`
+}
+
+type PublicKeyCredentialParametersAPI struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type PasskeyCreationOptionsAPI struct {
+	Challenge Base64URL `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          Base64URL `json:"id"`
+		Name        string    `json:"name"`
+		DisplayName string    `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams       []PublicKeyCredentialParametersAPI `json:"pubKeyCredParams"`
+	Timeout                int64                              `json:"timeout"`
+	ExcludeCredentials     []PublicKeyCredentialDescriptorAPI `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+type PasskeyRequestOptionsAPI struct {
+	Challenge        Base64URL                          `json:"challenge"`
+	RPID             string                             `json:"rpId"`
+	Timeout          int64                              `json:"timeout"`
+	AllowCredentials []PublicKeyCredentialDescriptorAPI `json:"allowCredentials"`
+	UserVerification string                             `json:"userVerification"`
+}
+
+type RegistrationCredentialAPI struct {
+	ID       string    `json:"id"`
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AttestationObject Base64URL `json:"attestationObject"`
+	} `json:"response"`
+}
+
+type AuthenticationCredentialAPI struct {
+	ID       string    `json:"id"`
+	RawID    Base64URL `json:"rawId"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AuthenticatorData Base64URL `json:"authenticatorData"`
+		Signature         Base64URL `json:"signature"`
+		UserHandle        Base64URL `json:"userHandle"`
+	} `json:"response"`
+}
+
+type PasskeyRegistrationAPI struct {
+	Name       string                    `json:"name"`
+	Credential RegistrationCredentialAPI `json:"credential"`
+}
+
+type PasskeyNameAPI struct {
+	Name string `json:"name"`
+}
+
+type PasskeyAPI struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func NewPasskeyResponse(passkey *models.Passkey) PasskeyAPI {
+	return PasskeyAPI{
+		ID:         passkey.ID,
+		Name:       passkey.Name,
+		CreatedAt:  passkey.CreatedAt,
+		LastUsedAt: passkey.LastUsedAt,
+	}
+}
+
+func NewPasskeyCreationOptionsResponse(options *services.PasskeyRegistrationOptions) PasskeyCreationOptionsAPI {
+	response := PasskeyCreationOptionsAPI{
+		Challenge:          options.Challenge,
+		Timeout:            options.Timeout.Milliseconds(),
+		ExcludeCredentials: make([]PublicKeyCredentialDescriptorAPI, 0, len(options.ExcludeCredentials)),
+		Attestation:        "none",
+	}
+	response.RP.ID = options.RPID
+	response.RP.Name = options.RPName
+	response.User.ID = options.UserHandle
+	response.User.Name = options.UserName
+	response.User.DisplayName = options.UserDisplayName
+	for _, alg := range options.Algorithms {
+		response.PubKeyCredParams = append(response.PubKeyCredParams, PublicKeyCredentialParametersAPI{Type: "public-key", Alg: alg})
+	}
+	for _, id := range options.ExcludeCredentials {
+		response.ExcludeCredentials = append(response.ExcludeCredentials, PublicKeyCredentialDescriptorAPI{Type: "public-key", ID: id})
+	}
+	response.AuthenticatorSelection.ResidentKey = "required"
+	response.AuthenticatorSelection.UserVerification = "required"
+	return response
+}
+
+func (s *APIServer) HandleBeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	options, err := s.authService.BeginPasskeyRegistration(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+
+	response := NewPasskeyCreationOptionsResponse(options)
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+func (s *APIServer) HandleFinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var registrationRequest PasskeyRegistrationAPI
+	if err := json.NewDecoder(r.Body).Decode(&registrationRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	serviceReq := services.PasskeyRegistrationRequest{
+		Name:              registrationRequest.Name,
+		ClientDataJSON:    registrationRequest.Credential.Response.ClientDataJSON,
+		AttestationObject: registrationRequest.Credential.Response.AttestationObject,
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	passkey, err := s.authService.FinishPasskeyRegistration(ctx, userUUID, serviceReq)
+	if err != nil {
+		return err
+	}
+
+	response := NewPasskeyResponse(passkey)
+	return ConstructSuccessResponse(w, http.StatusCreated, response)
+}
+
+func (s *APIServer) HandleListPasskeys(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	passkeys, err := s.authService.ListPasskeys(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+
+	response := make([]PasskeyAPI, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		response = append(response, NewPasskeyResponse(&passkey))
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+func (s *APIServer) HandleRenamePasskey(w http.ResponseWriter, r *http.Request) error {
+	userUUID, passkeyUUID, err := parsePasskeyID(r)
+	if err != nil {
+		return err
+	}
+
+	var nameRequest PasskeyNameAPI
+	if err := json.NewDecoder(r.Body).Decode(&nameRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := s.authService.RenamePasskey(ctx, userUUID, passkeyUUID, nameRequest.Name); err != nil {
+		return err
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, nil)
+}
+
+func (s *APIServer) HandleDeletePasskey(w http.ResponseWriter, r *http.Request) error {
+	userUUID, passkeyUUID, err := parsePasskeyID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := s.authService.DeletePasskey(ctx, userUUID, passkeyUUID); err != nil {
+		return err
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, nil)
+}
+
+func (s *APIServer) HandleBeginPasskeyLogin(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	options, err := s.authService.BeginPasskeyLogin(ctx)
+	if err != nil {
+		return err
+	}
+
+	response := PasskeyRequestOptionsAPI{
+		Challenge:        options.Challenge,
+		RPID:             options.RPID,
+		Timeout:          options.Timeout.Milliseconds(),
+		AllowCredentials: []PublicKeyCredentialDescriptorAPI{},
+		UserVerification: "required",
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+func (s *APIServer) HandlePasskeyLogin(w http.ResponseWriter, r *http.Request) error {
+	var credential AuthenticationCredentialAPI
+	if err := json.NewDecoder(r.Body).Decode(&credential); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	serviceReq := services.PasskeyLoginRequest{
+		CredentialID:      credential.RawID,
+		ClientDataJSON:    credential.Response.ClientDataJSON,
+		AuthenticatorData: credential.Response.AuthenticatorData,
+		Signature:         credential.Response.Signature,
+		UserHandle:        credential.Response.UserHandle,
+		Metadata:          sessionMetadata(r),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	result, err := s.authService.PasskeyLogin(ctx, serviceReq)
+	if err != nil {
+		return err
+	}
+
+	response := NewLoginResponse(result)
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+func parsePasskeyID(r *http.Request) (uuid.UUID, uuid.UUID, error) {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	id := r.PathValue("passkeyID")
+	passkeyUUID, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, models.NewWrappedError(err, models.ContextBadRequest, fmt.Sprintf("UUID '%s' is not formatted correctly.", id))
+	}
+	return userUUID, passkeyUUID, nil
+}
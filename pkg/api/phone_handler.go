@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"users-microservice/pkg/models"
+)
+
+type PhoneCodeAPI struct {
+	Code string `json:"code"`
+}
+
+func (s *APIServer) HandleSendPhoneVerification(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := s.service.SendPhoneVerification(ctx, userUUID); err != nil {
+		return err
+	}
+
+	return ConstructSuccessResponse(w, http.StatusAccepted, nil)
+}
+
+func (s *APIServer) HandleVerifyPhone(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var codeRequest PhoneCodeAPI
+	if err := json.NewDecoder(r.Body).Decode(&codeRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, err := s.service.VerifyPhone(ctx, userUUID, codeRequest.Code)
+	if err != nil {
+		return err
+	}
+
+	response := NewUserResponse(user)
+	redactUserResponse(w, r, &response)
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
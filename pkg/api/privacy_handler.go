@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+type PersonalDataExportAPI struct {
+	ID          uuid.UUID  `json:"id"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	// single-use link to the archive, only for completed exports
+	DownloadURL       string    `json:"download_url,omitempty"`
+	DownloadExpiresAt time.Time `json:"download_expires_at,omitzero"`
+}
+
+func NewPersonalDataExportResponse(export *models.PersonalDataExport) PersonalDataExportAPI {
+	return PersonalDataExportAPI{
+		ID:          export.ID,
+		Status:      string(export.Status),
+		Error:       export.Error,
+		CreatedAt:   export.CreatedAt,
+		CompletedAt: export.CompletedAt,
+		ExpiresAt:   export.ExpiresAt,
+	}
+}
+
+// customMethods serves "POST /users/{id}:<method>", the router cannot match
+// a wildcard followed by more in the same segment. The handlers find the ID
+// in the "id" path value as usual
+func customMethods(methods map[string]http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, method, ok := strings.Cut(r.PathValue("id"), ":")
+		handler, found := methods[method]
+		if !ok || !found {
+			ConstructResponseWithError(w, *NewAPIError(http.StatusNotFound, fmt.Sprintf("method '%s' does not exist", method)))
+			return
+		}
+		r.SetPathValue("id", id)
+		handler.ServeHTTP(w, r)
+	}
+}
+
+func (s *APIServer) exportURL(userID uuid.UUID, exportID uuid.UUID) string {
+	return fmt.Sprintf("/users/%s/personal-data-exports/%s", userID, exportID)
+}
+
+// HandleExportPersonalData starts the export, the caller follows the
+// Location header until it is completed
+func (s *APIServer) HandleExportPersonalData(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	export, err := s.privacyService.ExportPersonalData(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Location", s.exportURL(userUUID, export.ID))
+	return ConstructSuccessResponse(w, http.StatusAccepted, NewPersonalDataExportResponse(export))
+}
+
+func (s *APIServer) HandleGetPersonalDataExport(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+	exportID, err := uuid.Parse(r.PathValue("exportID"))
+	if err != nil {
+		return models.NewWrappedError(err, models.ContextNotFound, fmt.Sprintf("export with '%s' ID does not exist", r.PathValue("exportID")))
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	status, err := s.privacyService.GetPersonalDataExport(ctx, userUUID, exportID)
+	if err != nil {
+		return err
+	}
+	response := NewPersonalDataExportResponse(status.Export)
+	response.DownloadURL = status.DownloadURL
+	response.DownloadExpiresAt = status.DownloadExpiresAt
+	// links are single-use, a cached response would hand out a spent one
+	w.Header().Set("Cache-Control", "no-store")
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+// HandleDownloadPersonalDataExport serves the archive itself rather than an
+// API response, the token of the link authenticates the download
+func (s *APIServer) HandleDownloadPersonalDataExport(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	export, archive, err := s.privacyService.DownloadPersonalDataExport(ctx, r.PathValue("token"))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="personal-data-%s.json"`, export.UserID))
+	w.Header().Set("Cache-Control", "no-store")
+	WriteResponse(w, http.StatusOK, archive)
+	return nil
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+)
+
+// redactedFieldsHeader names the fields of a response that were masked or
+// left out because the caller may not see personal data or a policy masked
+// them
+const redactedFieldsHeader = "X-Redacted-Fields"
+
+// redactUserResponse masks personal data of the user the caller of r may not
+// see, only the birth year of the date of birth is kept
+func redactUserResponse(w http.ResponseWriter, r *http.Request, response *UserAPI) {
+	masked := models.MaskedFields(r.Context(), response.ID)
+
+	var fields []string
+	if response.Email != "" && slices.Contains(masked, models.FieldEmail) {
+		response.Email = models.MaskEmail(response.Email)
+		fields = append(fields, models.FieldEmail)
+	}
+	if response.PendingEmail != "" && slices.Contains(masked, models.FieldPendingEmail) {
+		response.PendingEmail = ""
+		fields = append(fields, models.FieldPendingEmail)
+	}
+	if !response.DateOfBirth.IsZero() && slices.Contains(masked, models.FieldDateOfBirth) {
+		response.BirthYear = response.DateOfBirth.Year()
+		response.DateOfBirth = time.Time{}
+		fields = append(fields, models.FieldDateOfBirth)
+	}
+	if response.PhoneNumber != "" && slices.Contains(masked, models.FieldPhoneNumber) {
+		response.PhoneNumber = models.MaskPhoneNumber(response.PhoneNumber)
+		fields = append(fields, models.FieldPhoneNumber)
+	}
+	addRedactedFields(w, fields)
+}
+
+// addRedactedFields adds the fields to the header, responses with several
+// users list each field once
+func addRedactedFields(w http.ResponseWriter, fields []string) {
+	var current []string
+	if value := w.Header().Get(redactedFieldsHeader); value != "" {
+		current = strings.Split(value, ", ")
+	}
+	for _, field := range fields {
+		if !slices.Contains(current, field) {
+			current = append(current, field)
+		}
+	}
+	if len(current) > 0 {
+		w.Header().Set(redactedFieldsHeader, strings.Join(current, ", "))
+	}
+}
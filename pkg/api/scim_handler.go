@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/scim"
+	"users-microservice/pkg/services"
+
+	"github.com/google/uuid"
+)
+
+// MakeSCIMHandleFunc is MakeHTTPHandleFunc for the SCIM endpoints, errors are
+// reported as SCIM error responses
+func MakeSCIMHandleFunc(f apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r = withRequestContext(w, r)
+		if err := f(w, r); err != nil {
+			logError(r, err, time.Since(start))
+			scimErr := scim.AsError(err)
+			if scimErr == nil {
+				apiError := TranslateToAPIError(err)
+				var errorType string
+				switch apiError.Status {
+				case http.StatusConflict:
+					errorType = scim.ErrorUniqueness
+				case http.StatusBadRequest:
+					errorType = scim.ErrorInvalidValue
+				}
+				scimErr = scim.NewError(apiError.Status, errorType, apiError.Description)
+			}
+			ConstructSCIMResponse(w, scimErr.StatusCode(), scimErr)
+		} else {
+			logSuccess(r, time.Since(start))
+		}
+	}
+}
+
+// ConstructSCIMResponse is ConstructResponse with the SCIM media type
+func ConstructSCIMResponse(w http.ResponseWriter, status int, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Print("ERROR: failed to serialize the response")
+		w.WriteHeader(http.StatusInternalServerError)
+		return NewAPIError(http.StatusInternalServerError, "failed to serialize the response")
+	}
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(status)
+	if _, err := w.Write(payload); err != nil {
+		log.Printf("ERROR: Failed to write payload to client: %v", err)
+	}
+	return nil
+}
+
+// scimUserID parses the ID in the path, IDs that cannot exist are not found
+func scimUserID(r *http.Request) (uuid.UUID, error) {
+	id := r.PathValue("id")
+	userUUID, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, scim.NewError(http.StatusNotFound, "", fmt.Sprintf("user with '%s' ID does not exist", id))
+	}
+	return userUUID, nil
+}
+
+// newSCIMUser represents the user as far as the caller of r may see it
+func (s *APIServer) newSCIMUser(w http.ResponseWriter, r *http.Request, user *models.User) *scim.User {
+	resource := scim.NewUser(user, s.scimBaseURL)
+	addRedactedFields(w, resource.Redact(models.MaskedFields(r.Context(), user.ID)))
+	return resource
+}
+
+func decodeSCIMBody(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return scim.NewBadRequest(scim.ErrorInvalidSyntax, "request body contains malformed data")
+	}
+	return nil
+}
+
+func (s *APIServer) constructSCIMUser(w http.ResponseWriter, r *http.Request, status int, user *models.User) error {
+	resource := s.newSCIMUser(w, r, user)
+	if status == http.StatusCreated {
+		w.Header().Set("Location", resource.Meta.Location)
+	}
+	return ConstructSCIMResponse(w, status, resource)
+}
+
+func (s *APIServer) HandleSCIMCreateUser(w http.ResponseWriter, r *http.Request) error {
+	var resource scim.User
+	if err := decodeSCIMBody(r, &resource); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, err := s.scimService.CreateUser(ctx, &resource)
+	if err != nil {
+		return err
+	}
+	return s.constructSCIMUser(w, r, http.StatusCreated, user)
+}
+
+func (s *APIServer) HandleSCIMGetUser(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := scimUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, err := s.scimService.GetUser(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+	return s.constructSCIMUser(w, r, http.StatusOK, user)
+}
+
+func (s *APIServer) HandleSCIMReplaceUser(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := scimUserID(r)
+	if err != nil {
+		return err
+	}
+	var resource scim.User
+	if err := decodeSCIMBody(r, &resource); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, err := s.scimService.ReplaceUser(ctx, userUUID, &resource)
+	if err != nil {
+		return err
+	}
+	return s.constructSCIMUser(w, r, http.StatusOK, user)
+}
+
+func (s *APIServer) HandleSCIMPatchUser(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := scimUserID(r)
+	if err != nil {
+		return err
+	}
+	var patch scim.PatchRequest
+	if err := decodeSCIMBody(r, &patch); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, err := s.scimService.PatchUser(ctx, userUUID, &patch)
+	if err != nil {
+		return err
+	}
+	return s.constructSCIMUser(w, r, http.StatusOK, user)
+}
+
+func (s *APIServer) HandleSCIMDeleteUser(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := scimUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := s.scimService.DeleteUser(ctx, userUUID); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *APIServer) HandleSCIMListUsers(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	listReq := services.SCIMListRequest{Filter: query.Get("filter")}
+	if value := query.Get("startIndex"); value != "" {
+		startIndex, err := strconv.Atoi(value)
+		if err != nil {
+			return scim.NewBadRequest(scim.ErrorInvalidValue, "startIndex has to be an integer")
+		}
+		listReq.StartIndex = startIndex
+	}
+	if value := query.Get("count"); value != "" {
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return scim.NewBadRequest(scim.ErrorInvalidValue, "count has to be an integer")
+		}
+		listReq.Count = &count
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	list, err := s.scimService.ListUsers(ctx, listReq)
+	if err != nil {
+		return err
+	}
+
+	resources := make([]*scim.User, 0, len(list.Users))
+	for _, user := range list.Users {
+		resources = append(resources, s.newSCIMUser(w, r, &user))
+	}
+	return ConstructSCIMResponse(w, http.StatusOK, scim.NewListResponse(resources, list.TotalResults, list.StartIndex))
+}
+
+func (s *APIServer) HandleSCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request) error {
+	return ConstructSCIMResponse(w, http.StatusOK, scim.NewServiceProviderConfig(s.scimBaseURL, s.scimMaxResults))
+}
+
+func (s *APIServer) HandleSCIMSchemas(w http.ResponseWriter, r *http.Request) error {
+	schemas := scim.NewSchemas(s.scimBaseURL)
+	return ConstructSCIMResponse(w, http.StatusOK, scim.NewListResponse(schemas, len(schemas), 1))
+}
+
+func (s *APIServer) HandleSCIMSchema(w http.ResponseWriter, r *http.Request) error {
+	id := r.PathValue("id")
+	for _, schema := range scim.NewSchemas(s.scimBaseURL) {
+		if schema.ID == id {
+			return ConstructSCIMResponse(w, http.StatusOK, schema)
+		}
+	}
+	return scim.NewError(http.StatusNotFound, "", fmt.Sprintf("schema '%s' does not exist", id))
+}
+
+func (s *APIServer) HandleSCIMResourceTypes(w http.ResponseWriter, r *http.Request) error {
+	resourceTypes := scim.NewResourceTypes(s.scimBaseURL)
+	return ConstructSCIMResponse(w, http.StatusOK, scim.NewListResponse(resourceTypes, len(resourceTypes), 1))
+}
+
+func (s *APIServer) HandleSCIMResourceType(w http.ResponseWriter, r *http.Request) error {
+	id := r.PathValue("id")
+	for _, resourceType := range scim.NewResourceTypes(s.scimBaseURL) {
+		if resourceType.ID == id {
+			return ConstructSCIMResponse(w, http.StatusOK, resourceType)
+		}
+	}
+	return scim.NewError(http.StatusNotFound, "", fmt.Sprintf("resource type '%s' does not exist", id))
+}
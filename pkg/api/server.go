@@ -1,13 +1,25 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 	"users-microservice/pkg/config"
+	"users-microservice/pkg/jwtauth"
+	"users-microservice/pkg/lockout"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/servertls"
 	"users-microservice/pkg/services"
+	"users-microservice/pkg/signing"
 )
 
+// requests still running after are cut off on shutdown
+const shutdownTimeout = 30 * time.Second
+
 type APIResponse struct {
 	Success   bool        `json:"success"`
 	Data      interface{} `json:"data,omitempty"`
@@ -16,24 +28,89 @@ type APIResponse struct {
 }
 
 type APIServer struct {
-	listenAddr   string
-	service      services.UserService
-	ReadTimeout  time.Duration
-	WriteTimeout time.Duration
-	IdleTimeout  time.Duration
+	listenAddr  string
+	service     services.UserService
+	authService services.AuthService
+	oidcService services.OIDCService
+	scimService services.SCIMService
+	// API keys authenticate the callers of protected routes
+	apiKeyService  services.APIKeyService
+	privacyService services.PrivacyService
+	// verifies bearer tokens of the gateway, nil when they are not accepted
+	tokenVerifier *jwtauth.Verifier
+	// HTTPS and client certificate identities, nil when serving plain HTTP
+	tls *servertls.Settings
+	// verifies requests signed with shared secrets, nil when not accepted
+	signatures  *signing.Verifier
+	sourceGuard *lockout.Guard
+	// the login page authorization requests are forwarded to
+	oidcLoginURL string
+	oidcIssuer   string
+	// where SCIM clients find the resources
+	scimBaseURL    string
+	scimMaxResults int
+	// whether cookies set by the API are restricted to HTTPS
+	secureCookies bool
+	ReadTimeout   time.Duration
+	WriteTimeout  time.Duration
+	IdleTimeout   time.Duration
 }
 
 type apiHandler func(w http.ResponseWriter, r *http.Request) error
 
-func NewAPIServer(listenAddr string, service services.UserService, cfg *config.Config) *APIServer {
-	return &APIServer{listenAddr: listenAddr, service: service, ReadTimeout: cfg.ReadTimeout, WriteTimeout: cfg.WriteTimeout, IdleTimeout: cfg.IdleTimeout}
+// Dependencies are the services the server exposes and what it
+// authenticates callers with. Anything not configured is left nil
+type Dependencies struct {
+	Users   services.UserService
+	Auth    services.AuthService
+	OIDC    services.OIDCService
+	SCIM    services.SCIMService
+	APIKeys services.APIKeyService
+	Privacy services.PrivacyService
+	// verifies bearer tokens of the gateway
+	TokenVerifier *jwtauth.Verifier
+	// HTTPS and client certificate identities
+	TLS *servertls.Settings
+	// verifies requests signed with shared secrets
+	Signatures  *signing.Verifier
+	SourceGuard *lockout.Guard
+}
+
+func NewAPIServer(listenAddr string, deps Dependencies, cfg *config.Config) *APIServer {
+	return &APIServer{
+		listenAddr:     listenAddr,
+		service:        deps.Users,
+		authService:    deps.Auth,
+		oidcService:    deps.OIDC,
+		scimService:    deps.SCIM,
+		apiKeyService:  deps.APIKeys,
+		privacyService: deps.Privacy,
+		tokenVerifier:  deps.TokenVerifier,
+		tls:            deps.TLS,
+		signatures:     deps.Signatures,
+		sourceGuard:    deps.SourceGuard,
+		oidcLoginURL:   cfg.OIDCLoginURL,
+		oidcIssuer:     cfg.OIDCIssuer,
+		scimBaseURL:    cfg.SCIMBaseURL,
+		scimMaxResults: cfg.SCIMMaxResults,
+		secureCookies:  cfg.CookieSecure,
+		ReadTimeout:    cfg.ReadTimeout,
+		WriteTimeout:   cfg.WriteTimeout,
+		IdleTimeout:    cfg.IdleTimeout,
+	}
 }
 
 func MakeHTTPHandleFunc(f apiHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+		r = withRequestContext(w, r)
 		if err := f(w, r); err != nil {
 			logError(r, err, time.Since(start))
+			var lockedErr *lockout.LockedError
+			if errors.As(err, &lockedErr) {
+				retryAfter := int(math.Ceil(time.Until(lockedErr.Until).Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+			}
 			apiError := TranslateToAPIError(err)
 			ConstructResponseWithError(w, apiError)
 		} else {
@@ -54,30 +131,238 @@ func methodCheckMiddleware(allowedMethod string, next http.HandlerFunc) http.Han
 	}
 }
 
+// limitFailuresBySource blocks addresses that keep failing, requests rejected
+// as unauthorized or bad count as failures
+func (s *APIServer) limitFailuresBySource(next apiHandler) apiHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		address := clientAddress(r)
+		key := lockout.SourceKey(address)
+		if err := s.sourceGuard.Check(key); err != nil {
+			return err
+		}
+
+		err := next(w, r)
+		if err == nil {
+			return nil
+		}
+		switch models.ErrorContext(err) {
+		case models.ContextUnauthorized, models.ContextBadRequest:
+			lockedOut, failErr := s.sourceGuard.Fail(key)
+			if failErr != nil {
+				log.Printf("ERROR: failed to record failed attempt from %s: %v", address, failErr)
+			} else if lockedOut {
+				log.Printf("Address %s locked out after too many failed attempts at %v", address, time.Now())
+			}
+		}
+		return err
+	}
+}
+
+// Router wires the routes, those serving users' own credentials to them
+// (login, recovery, verification links and the OIDC endpoints) are public and
+// act as the system principal, everything else requires an API key or gateway token with the scope given
+// per route
 func (s *APIServer) Router() http.Handler {
 	router := http.NewServeMux()
 
-	getUserHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(s.HandleGetUser))
-	createUserHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.HandleCreateUser))
+	getUserHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersRead, s.HandleGetUser)))
+	createUserHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleCreateUser)))
+	suspendUserHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleSuspendUser)))
+	reactivateUserHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleReactivateUser)))
+	deactivateUserHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleDeactivateUser)))
+	getUserHistoryHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersRead, s.HandleGetUserHistory)))
+	getUserAuditHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleGetUserAudit)))
+	getUserAccessLogHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleGetUserAccessLog)))
+	exportPersonalDataHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleExportPersonalData)))
+	getPersonalDataExportHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleGetPersonalDataExport)))
+	downloadPersonalDataExportHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(public(s.limitFailuresBySource(s.HandleDownloadPersonalDataExport))))
+	erasePersonalDataHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleErasePersonalData)))
+	getErasureCertificateHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleGetErasureCertificate)))
+	verifyEmailHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(public(s.limitFailuresBySource(s.HandleVerifyEmail))))
+	resendEmailVerificationHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleResendEmailVerification)))
+	updateUserHandler := methodCheckMiddleware("PATCH", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleUpdateUser)))
+	sendPhoneVerificationHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleSendPhoneVerification)))
+	verifyPhoneHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.limitFailuresBySource(s.HandleVerifyPhone))))
+	confirmEmailChangeHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(public(s.limitFailuresBySource(s.HandleConfirmEmailChange))))
+	revertEmailChangeHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(public(s.limitFailuresBySource(s.HandleRevertEmailChange))))
+	setPasswordHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleSetPassword)))
+	changePasswordHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.limitFailuresBySource(s.HandleChangePassword))))
+	loginHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(public(s.limitFailuresBySource(s.HandleLogin))))
+	requestPasswordResetHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(public(s.HandleRequestPasswordReset)))
+	confirmPasswordResetHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(public(s.limitFailuresBySource(s.HandleConfirmPasswordReset))))
+	refreshSessionHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(public(s.HandleRefreshSession)))
+	listSessionsHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersRead, s.HandleListSessions)))
+	revokeSessionHandler := methodCheckMiddleware("DELETE", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleRevokeSession)))
+	revokeAllSessionsHandler := methodCheckMiddleware("DELETE", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleRevokeAllSessions)))
+	enrollTOTPHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleEnrollTOTP)))
+	confirmTOTPHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleConfirmTOTP)))
+	getMFAStatusHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersRead, s.HandleGetMFAStatus)))
+	resetMFAHandler := methodCheckMiddleware("DELETE", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleResetMFA)))
+	mfaLoginHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(public(s.limitFailuresBySource(s.HandleMFALogin))))
+	unlockAccountHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleUnlockAccount)))
+	requestMagicLinkHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(public(s.HandleRequestMagicLink)))
+	consumeMagicLinkHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(public(s.limitFailuresBySource(s.HandleConsumeMagicLink))))
+	beginPasskeyRegistrationHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleBeginPasskeyRegistration)))
+	finishPasskeyRegistrationHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleFinishPasskeyRegistration)))
+	listPasskeysHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersRead, s.HandleListPasskeys)))
+	renamePasskeyHandler := methodCheckMiddleware("PATCH", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleRenamePasskey)))
+	deletePasskeyHandler := methodCheckMiddleware("DELETE", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleDeletePasskey)))
+	beginPasskeyLoginHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(public(s.HandleBeginPasskeyLogin)))
+	passkeyLoginHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(public(s.limitFailuresBySource(s.HandlePasskeyLogin))))
+	openIDConfigurationHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(public(s.HandleOpenIDConfiguration)))
+	jwksHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(public(s.HandleJWKS)))
+	authorizeHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(public(s.HandleAuthorize)))
+	completeAuthorizationHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(public(s.HandleCompleteAuthorization)))
+	tokenHandler := methodCheckMiddleware("POST", MakeOAuthHandleFunc(public(s.HandleToken)))
+	userInfoHandler := MakeOAuthHandleFunc(public(s.HandleUserInfo))
+	registerOIDCClientHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleRegisterOIDCClient)))
+	getOIDCClientHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleGetOIDCClient)))
+	deleteOIDCClientHandler := methodCheckMiddleware("DELETE", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleDeleteOIDCClient)))
+	rotateSigningKeyHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleRotateSigningKey)))
+	listFederationProvidersHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(public(s.HandleListFederationProviders)))
+	beginFederatedLoginHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(public(s.HandleBeginFederatedLogin)))
+	federatedLoginHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(public(s.limitFailuresBySource(s.HandleFederatedLogin))))
+	federatedSignupHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(public(s.limitFailuresBySource(s.HandleFederatedSignup))))
+	beginIdentityLinkHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleBeginIdentityLink)))
+	linkIdentityHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleLinkIdentity)))
+	listIdentitiesHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersRead, s.HandleListIdentities)))
+	unlinkIdentityHandler := methodCheckMiddleware("DELETE", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleUnlinkIdentity)))
+	issueAPIKeyHandler := methodCheckMiddleware("POST", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleIssueAPIKey)))
+	listAPIKeysHandler := methodCheckMiddleware("GET", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleListAPIKeys)))
+	revokeAPIKeyHandler := methodCheckMiddleware("DELETE", MakeHTTPHandleFunc(s.requireScope(models.ScopeUsersAdmin, s.HandleRevokeAPIKey)))
+	scimListUsersHandler := methodCheckMiddleware("GET", MakeSCIMHandleFunc(s.requireScope(models.ScopeUsersRead, s.HandleSCIMListUsers)))
+	scimCreateUserHandler := methodCheckMiddleware("POST", MakeSCIMHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleSCIMCreateUser)))
+	scimGetUserHandler := methodCheckMiddleware("GET", MakeSCIMHandleFunc(s.requireScope(models.ScopeUsersRead, s.HandleSCIMGetUser)))
+	scimReplaceUserHandler := methodCheckMiddleware("PUT", MakeSCIMHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleSCIMReplaceUser)))
+	scimPatchUserHandler := methodCheckMiddleware("PATCH", MakeSCIMHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleSCIMPatchUser)))
+	scimDeleteUserHandler := methodCheckMiddleware("DELETE", MakeSCIMHandleFunc(s.requireScope(models.ScopeUsersWrite, s.HandleSCIMDeleteUser)))
+	scimServiceProviderConfigHandler := methodCheckMiddleware("GET", MakeSCIMHandleFunc(public(s.HandleSCIMServiceProviderConfig)))
+	scimSchemasHandler := methodCheckMiddleware("GET", MakeSCIMHandleFunc(public(s.HandleSCIMSchemas)))
+	scimSchemaHandler := methodCheckMiddleware("GET", MakeSCIMHandleFunc(public(s.HandleSCIMSchema)))
+	scimResourceTypesHandler := methodCheckMiddleware("GET", MakeSCIMHandleFunc(public(s.HandleSCIMResourceTypes)))
+	scimResourceTypeHandler := methodCheckMiddleware("GET", MakeSCIMHandleFunc(public(s.HandleSCIMResourceType)))
 
 	router.Handle("GET /{id}", getUserHandler)
 	router.Handle("POST /save", createUserHandler)
+	router.Handle("POST /users/{id}/suspend", suspendUserHandler)
+	router.Handle("POST /users/{id}/reactivate", reactivateUserHandler)
+	router.Handle("POST /users/{id}/deactivate", deactivateUserHandler)
+	router.Handle("GET /users/{id}/history", getUserHistoryHandler)
+	router.Handle("GET /users/{id}/audit", getUserAuditHandler)
+	router.Handle("GET /users/{id}/access-log", getUserAccessLogHandler)
+	router.Handle("POST /users/{id}", customMethods(map[string]http.Handler{
+		"export-personal-data": exportPersonalDataHandler,
+		"erase-personal-data":  erasePersonalDataHandler,
+	}))
+	router.Handle("GET /users/{id}/personal-data-exports/{exportID}", getPersonalDataExportHandler)
+	router.Handle("GET /personal-data-exports/{token}", downloadPersonalDataExportHandler)
+	router.Handle("GET /users/{id}/erasure-certificate", getErasureCertificateHandler)
+	router.Handle("POST /users/verify-email", verifyEmailHandler)
+	router.Handle("POST /users/{id}/verify-email/resend", resendEmailVerificationHandler)
+	router.Handle("PATCH /users/{id}", updateUserHandler)
+	router.Handle("POST /users/{id}/phone/verification", sendPhoneVerificationHandler)
+	router.Handle("POST /users/{id}/phone/verify", verifyPhoneHandler)
+	router.Handle("POST /users/confirm-email-change", confirmEmailChangeHandler)
+	router.Handle("POST /users/revert-email-change", revertEmailChangeHandler)
+	router.Handle("POST /users/{id}/password", setPasswordHandler)
+	router.Handle("POST /users/{id}/password/change", changePasswordHandler)
+	router.Handle("POST /auth/login", loginHandler)
+	router.Handle("POST /auth/password-reset", requestPasswordResetHandler)
+	router.Handle("POST /auth/password-reset/confirm", confirmPasswordResetHandler)
+	router.Handle("POST /auth/refresh", refreshSessionHandler)
+	router.Handle("GET /users/{id}/sessions", listSessionsHandler)
+	router.Handle("DELETE /users/{id}/sessions/{sessionID}", revokeSessionHandler)
+	router.Handle("DELETE /users/{id}/sessions", revokeAllSessionsHandler)
+	router.Handle("POST /users/{id}/mfa/totp", enrollTOTPHandler)
+	router.Handle("POST /users/{id}/mfa/totp/confirm", confirmTOTPHandler)
+	router.Handle("GET /users/{id}/mfa", getMFAStatusHandler)
+	router.Handle("DELETE /users/{id}/mfa", resetMFAHandler)
+	router.Handle("POST /auth/login/mfa", mfaLoginHandler)
+	router.Handle("POST /users/{id}/unlock", unlockAccountHandler)
+	router.Handle("POST /auth/magic-link", requestMagicLinkHandler)
+	router.Handle("POST /auth/magic-link/consume", consumeMagicLinkHandler)
+	router.Handle("POST /users/{id}/passkeys/options", beginPasskeyRegistrationHandler)
+	router.Handle("POST /users/{id}/passkeys", finishPasskeyRegistrationHandler)
+	router.Handle("GET /users/{id}/passkeys", listPasskeysHandler)
+	router.Handle("PATCH /users/{id}/passkeys/{passkeyID}", renamePasskeyHandler)
+	router.Handle("DELETE /users/{id}/passkeys/{passkeyID}", deletePasskeyHandler)
+	router.Handle("POST /auth/passkey/options", beginPasskeyLoginHandler)
+	router.Handle("POST /auth/passkey/login", passkeyLoginHandler)
+	router.Handle("GET /.well-known/openid-configuration", openIDConfigurationHandler)
+	router.Handle("GET /.well-known/jwks.json", jwksHandler)
+	router.Handle("GET /oauth2/authorize", authorizeHandler)
+	router.Handle("POST /oauth2/authorize", completeAuthorizationHandler)
+	router.Handle("POST /oauth2/token", tokenHandler)
+	// both methods are allowed for userinfo
+	router.Handle("GET /userinfo", userInfoHandler)
+	router.Handle("POST /userinfo", userInfoHandler)
+	router.Handle("POST /oauth2/clients", registerOIDCClientHandler)
+	router.Handle("GET /oauth2/clients/{clientID}", getOIDCClientHandler)
+	router.Handle("DELETE /oauth2/clients/{clientID}", deleteOIDCClientHandler)
+	router.Handle("POST /oauth2/keys/rotate", rotateSigningKeyHandler)
+	router.Handle("GET /auth/federated", listFederationProvidersHandler)
+	router.Handle("POST /auth/federated/signup", federatedSignupHandler)
+	router.Handle("POST /auth/federated/{provider}", beginFederatedLoginHandler)
+	router.Handle("POST /auth/federated/{provider}/callback", federatedLoginHandler)
+	router.Handle("POST /users/{id}/identities/{provider}", beginIdentityLinkHandler)
+	router.Handle("POST /users/{id}/identities/{provider}/callback", linkIdentityHandler)
+	router.Handle("GET /users/{id}/identities", listIdentitiesHandler)
+	router.Handle("DELETE /users/{id}/identities/{identityID}", unlinkIdentityHandler)
+	router.Handle("POST /api-keys", issueAPIKeyHandler)
+	router.Handle("GET /api-keys", listAPIKeysHandler)
+	router.Handle("DELETE /api-keys/{keyID}", revokeAPIKeyHandler)
+	router.Handle("GET /scim/v2/Users", scimListUsersHandler)
+	router.Handle("POST /scim/v2/Users", scimCreateUserHandler)
+	router.Handle("GET /scim/v2/Users/{id}", scimGetUserHandler)
+	router.Handle("PUT /scim/v2/Users/{id}", scimReplaceUserHandler)
+	router.Handle("PATCH /scim/v2/Users/{id}", scimPatchUserHandler)
+	router.Handle("DELETE /scim/v2/Users/{id}", scimDeleteUserHandler)
+	router.Handle("GET /scim/v2/ServiceProviderConfig", scimServiceProviderConfigHandler)
+	router.Handle("GET /scim/v2/Schemas", scimSchemasHandler)
+	router.Handle("GET /scim/v2/Schemas/{id}", scimSchemaHandler)
+	router.Handle("GET /scim/v2/ResourceTypes", scimResourceTypesHandler)
+	router.Handle("GET /scim/v2/ResourceTypes/{id}", scimResourceTypeHandler)
 
 	return router
 }
 
 func (s *APIServer) NewServer() *http.Server {
-	return &http.Server{
+	server := &http.Server{
 		Addr:         s.listenAddr,
 		Handler:      s.Router(),
 		ReadTimeout:  s.ReadTimeout,
 		WriteTimeout: s.WriteTimeout,
 		IdleTimeout:  s.IdleTimeout,
 	}
+	if s.tls != nil {
+		server.TLSConfig = s.tls.Config
+	}
+	return server
 }
 
-func (s *APIServer) Run() error {
+// Run serves until ctx is done, then stops taking connections and waits up
+// to shutdownTimeout for the requests in flight
+func (s *APIServer) Run(ctx context.Context) error {
 	server := s.NewServer()
-	log.Printf("Listening on %s", s.listenAddr)
-	return server.ListenAndServe()
+	served := make(chan error, 1)
+	go func() {
+		if s.tls != nil {
+			log.Printf("Listening on %s with TLS", s.listenAddr)
+			// the certificate comes from the TLS config
+			served <- server.ListenAndServeTLS("", "")
+			return
+		}
+		log.Printf("Listening on %s", s.listenAddr)
+		served <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	log.Print("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
 }
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/services"
+
+	"github.com/google/uuid"
+)
+
+type SessionAPI struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type SessionTokensAPI struct {
+	SessionID             uuid.UUID `json:"session_id"`
+	TokenType             string    `json:"token_type"`
+	AccessToken           string    `json:"access_token"`
+	ExpiresIn             int64     `json:"expires_in"`
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+}
+
+// LoginResponseAPI holds either the session tokens or, when a second factor
+// is needed, the token to complete the login with. Sign-ins through a
+// provider nobody is linked to get the signup instead
+type LoginResponseAPI struct {
+	User              *UserAPI                    `json:"user,omitempty"`
+	MFARequired       bool                        `json:"mfa_required"`
+	MFAToken          string                      `json:"mfa_token,omitempty"`
+	MFATokenExpiresAt *time.Time                  `json:"mfa_token_expires_at,omitempty"`
+	Signup            *FederatedSignupRequiredAPI `json:"signup,omitempty"`
+	*SessionTokensAPI
+}
+
+type RefreshAPI struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func NewSessionResponse(session *models.Session) SessionAPI {
+	return SessionAPI{
+		ID:         session.ID,
+		UserAgent:  session.UserAgent,
+		IPAddress:  session.IPAddress,
+		CreatedAt:  session.CreatedAt,
+		LastUsedAt: session.LastUsedAt,
+		ExpiresAt:  session.ExpiresAt,
+	}
+}
+
+func NewSessionTokensResponse(sessionTokens *services.SessionTokens) SessionTokensAPI {
+	return SessionTokensAPI{
+		SessionID:             sessionTokens.Session.ID,
+		TokenType:             "Bearer",
+		AccessToken:           sessionTokens.AccessToken,
+		ExpiresIn:             int64(time.Until(sessionTokens.AccessTokenExpiresAt).Seconds()),
+		RefreshToken:          sessionTokens.RefreshToken,
+		RefreshTokenExpiresAt: sessionTokens.RefreshTokenExpiresAt,
+	}
+}
+
+func NewLoginResponse(result *services.LoginResult) LoginResponseAPI {
+	if result.SignupRequired() {
+		return LoginResponseAPI{
+			Signup: &FederatedSignupRequiredAPI{
+				SignupToken: result.Signup.Token,
+				ExpiresAt:   result.Signup.ExpiresAt,
+				Provider:    result.Signup.Provider,
+				Email:       result.Signup.Email,
+				Name:        result.Signup.Name,
+			},
+		}
+	}
+	if result.MFARequired() {
+		return LoginResponseAPI{
+			MFARequired:       true,
+			MFAToken:          result.MFAToken,
+			MFATokenExpiresAt: &result.MFATokenExpiresAt,
+		}
+	}
+
+	user := NewUserResponse(result.User)
+	sessionTokens := NewSessionTokensResponse(result.Tokens)
+	return LoginResponseAPI{
+		User:             &user,
+		SessionTokensAPI: &sessionTokens,
+	}
+}
+
+func (s *APIServer) HandleRefreshSession(w http.ResponseWriter, r *http.Request) error {
+	var refreshRequest RefreshAPI
+	if err := json.NewDecoder(r.Body).Decode(&refreshRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	sessionTokens, err := s.authService.RefreshSession(ctx, refreshRequest.RefreshToken, sessionMetadata(r))
+	if err != nil {
+		return err
+	}
+
+	response := NewSessionTokensResponse(sessionTokens)
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+func (s *APIServer) HandleListSessions(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	sessions, err := s.authService.ListSessions(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+
+	response := make([]SessionAPI, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, NewSessionResponse(&session))
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+func (s *APIServer) HandleRevokeSession(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+	sessionID := r.PathValue("sessionID")
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, fmt.Sprintf("UUID '%s' is not formatted correctly.", sessionID))
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := s.authService.RevokeSession(ctx, userUUID, sessionUUID); err != nil {
+		return err
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, nil)
+}
+
+func (s *APIServer) HandleRevokeAllSessions(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := s.authService.RevokeAllSessions(ctx, userUUID); err != nil {
+		return err
+	}
+	return ConstructSuccessResponse(w, http.StatusOK, nil)
+}
+
+func sessionMetadata(r *http.Request) services.SessionMetadata {
+	return services.SessionMetadata{
+		UserAgent: r.UserAgent(),
+		IPAddress: clientAddress(r),
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+type StatusChangeAPI struct {
+	Reason string `json:"reason"`
+}
+
+type UserHistoryEntryAPI struct {
+	Event      string    `json:"event"`
+	FromStatus string    `json:"from_status,omitempty"`
+	ToStatus   string    `json:"to_status,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func NewUserHistoryResponse(history []models.UserHistoryEntry) []UserHistoryEntryAPI {
+	response := make([]UserHistoryEntryAPI, 0, len(history))
+	for _, entry := range history {
+		response = append(response, UserHistoryEntryAPI{
+			Event:      entry.Event,
+			FromStatus: string(entry.FromStatus),
+			ToStatus:   string(entry.ToStatus),
+			Reason:     entry.Reason,
+			CreatedAt:  entry.CreatedAt,
+		})
+	}
+	return response
+}
+
+type statusChangeFunc func(context.Context, uuid.UUID, string) (*models.User, error)
+
+func (s *APIServer) HandleSuspendUser(w http.ResponseWriter, r *http.Request) error {
+	return s.handleStatusChange(w, r, s.service.SuspendUser)
+}
+
+func (s *APIServer) HandleReactivateUser(w http.ResponseWriter, r *http.Request) error {
+	return s.handleStatusChange(w, r, s.service.ReactivateUser)
+}
+
+func (s *APIServer) HandleDeactivateUser(w http.ResponseWriter, r *http.Request) error {
+	return s.handleStatusChange(w, r, s.service.DeactivateUser)
+}
+
+func (s *APIServer) handleStatusChange(w http.ResponseWriter, r *http.Request, change statusChangeFunc) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var statusRequest StatusChangeAPI
+	if err := json.NewDecoder(r.Body).Decode(&statusRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, err := change(ctx, userUUID, statusRequest.Reason)
+	if err != nil {
+		return err
+	}
+
+	response := NewUserResponse(user)
+	redactUserResponse(w, r, &response)
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+func (s *APIServer) HandleGetUserHistory(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	history, err := s.service.GetUserHistory(ctx, userUUID)
+	if err != nil {
+		return err
+	}
+
+	response := NewUserHistoryResponse(history)
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
@@ -3,7 +3,6 @@ package api
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"time"
 	"users-microservice/pkg/models"
@@ -13,19 +12,54 @@ import (
 )
 
 type UserAPI struct {
-	ID          uuid.UUID `json:"external_id"`
-	Name        string    `json:"name"`
-	Email       string    `json:"email"`
-	DateOfBirth time.Time `json:"date_of_birth"`
+	ID    uuid.UUID `json:"external_id"`
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	// left out and replaced by the birth year for callers that may not see
+	// personal data
+	DateOfBirth time.Time `json:"date_of_birth,omitzero"`
+	BirthYear   int       `json:"birth_year,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	// returned in E.164 form
+	PhoneNumber string `json:"phone_number,omitempty"`
+	// only read on creation, country code used to parse a national number
+	PhoneRegion string `json:"phone_region,omitempty"`
+	// ISO 3166 country code the user belongs to
+	Region string `json:"region,omitempty"`
+	// read only, ignored on creation
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	PendingEmail    string     `json:"pending_email,omitempty"`
+	PhoneVerifiedAt *time.Time `json:"phone_verified_at,omitempty"`
+}
+
+// fields missing from the request are left unchanged
+type UserUpdateAPI struct {
+	Name        *string    `json:"name"`
+	Email       *string    `json:"email"`
+	DateOfBirth *time.Time `json:"date_of_birth"`
+	// an empty string removes the phone number
+	PhoneNumber *string `json:"phone_number"`
+	PhoneRegion string  `json:"phone_region"`
+	// an empty string removes the region
+	Region *string `json:"region"`
 }
 
 func NewUserResponse(user *models.User) UserAPI {
-	return UserAPI{
-		ID:          user.ID,
-		Name:        user.Name,
-		Email:       user.Email,
-		DateOfBirth: user.DateOfBirth,
+	response := UserAPI{
+		ID:              user.ID,
+		Name:            user.Name,
+		Email:           user.Email,
+		DateOfBirth:     user.DateOfBirth,
+		Status:          string(user.Status),
+		PhoneNumber:     user.PhoneNumber,
+		Region:          user.Region,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		PhoneVerifiedAt: user.PhoneVerifiedAt,
+	}
+	if user.HasPendingEmail() {
+		response.PendingEmail = user.PendingEmail
 	}
+	return response
 }
 
 func (s *APIServer) HandleCreateUser(w http.ResponseWriter, r *http.Request) error {
@@ -39,6 +73,9 @@ func (s *APIServer) HandleCreateUser(w http.ResponseWriter, r *http.Request) err
 		Name:        userRequest.Name,
 		Email:       userRequest.Email,
 		DateOfBirth: userRequest.DateOfBirth,
+		PhoneNumber: userRequest.PhoneNumber,
+		PhoneRegion: userRequest.PhoneRegion,
+		Region:      userRequest.Region,
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
@@ -50,14 +87,14 @@ func (s *APIServer) HandleCreateUser(w http.ResponseWriter, r *http.Request) err
 	}
 
 	response := NewUserResponse(user)
+	redactUserResponse(w, r, &response)
 	return ConstructSuccessResponse(w, http.StatusCreated, response)
 }
 
 func (s *APIServer) HandleGetUser(w http.ResponseWriter, r *http.Request) error {
-	id := r.PathValue("id")
-	userUUID, err := uuid.Parse(id)
+	userUUID, err := parseUserID(r)
 	if err != nil {
-		return models.NewWrappedError(err, models.ContextBadRequest, fmt.Sprintf("UUID '%s' is not formatted correctly.", id))
+		return err
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
@@ -69,5 +106,39 @@ func (s *APIServer) HandleGetUser(w http.ResponseWriter, r *http.Request) error
 	}
 
 	response := NewUserResponse(user)
+	redactUserResponse(w, r, &response)
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+func (s *APIServer) HandleUpdateUser(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	var updateRequest UserUpdateAPI
+	if err := json.NewDecoder(r.Body).Decode(&updateRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	serviceReq := services.UserUpdateRequest{
+		Name:        updateRequest.Name,
+		Email:       updateRequest.Email,
+		DateOfBirth: updateRequest.DateOfBirth,
+		PhoneNumber: updateRequest.PhoneNumber,
+		PhoneRegion: updateRequest.PhoneRegion,
+		Region:      updateRequest.Region,
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, err := s.service.UpdateUser(ctx, userUUID, serviceReq)
+	if err != nil {
+		return err
+	}
+
+	response := NewUserResponse(user)
+	redactUserResponse(w, r, &response)
 	return ConstructSuccessResponse(w, http.StatusOK, response)
 }
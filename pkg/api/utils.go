@@ -3,9 +3,16 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"regexp"
+	"strings"
 	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
 )
 
 func WriteResponse(w http.ResponseWriter, status int, payload []byte) {
@@ -39,7 +46,7 @@ func ConstructResponseWithError(w http.ResponseWriter, err APIError) error {
 	return ConstructResponse(w, err.Status, response)
 }
 
-func ConstructSuccessResponse(w http.ResponseWriter, status int, data UserAPI) error {
+func ConstructSuccessResponse(w http.ResponseWriter, status int, data any) error {
 	response := APIResponse{
 		Success:   true,
 		Data:      data,
@@ -49,6 +56,66 @@ func ConstructSuccessResponse(w http.ResponseWriter, status int, data UserAPI) e
 	return ConstructResponse(w, status, response)
 }
 
+func parseUserID(r *http.Request) (uuid.UUID, error) {
+	id := r.PathValue("id")
+	userUUID, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, models.NewWrappedError(err, models.ContextBadRequest, fmt.Sprintf("UUID '%s' is not formatted correctly.", id))
+	}
+	return userUUID, nil
+}
+
+// requestIDHeader carries the ID of a request, as assigned by the gateway or
+// made up here, it is echoed in the response so callers can quote it
+const requestIDHeader = "X-Request-ID"
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// accessPurposeHeader states why the caller reads user data, it is kept in
+// the access log
+const accessPurposeHeader = "X-Access-Purpose"
+
+// longer purposes are cut
+const maxAccessPurposeLength = 200
+
+// withRequestContext hands the ID of the request and the stated purpose on in
+// its context
+func withRequestContext(w http.ResponseWriter, r *http.Request) *http.Request {
+	requestID := r.Header.Get(requestIDHeader)
+	if !requestIDPattern.MatchString(requestID) {
+		requestID = uuid.NewString()
+	}
+	w.Header().Set(requestIDHeader, requestID)
+	ctx := models.ContextWithRequestID(r.Context(), requestID)
+
+	if purpose := strings.TrimSpace(r.Header.Get(accessPurposeHeader)); purpose != "" {
+		if len(purpose) > maxAccessPurposeLength {
+			purpose = strings.ToValidUTF8(purpose[:maxAccessPurposeLength], "")
+		}
+		ctx = models.ContextWithAccessPurpose(ctx, purpose)
+	}
+	return r.WithContext(ctx)
+}
+
+// clientAddress is the IP address of the peer without the port
+func clientAddress(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// bearerToken extracts the token of an "Authorization: Bearer" header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
 func logError(r *http.Request, err error, duration time.Duration) {
 	log.Printf("ERROR: %s %s - %v (took %v)",
 		r.Method,
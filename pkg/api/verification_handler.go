@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"users-microservice/pkg/models"
+)
+
+// request body of endpoints consuming a single-use token
+type TokenAPI struct {
+	Token string `json:"token"`
+}
+
+type tokenConsumeFunc func(context.Context, string) (*models.User, error)
+
+func (s *APIServer) HandleVerifyEmail(w http.ResponseWriter, r *http.Request) error {
+	return s.handleTokenConsume(w, r, s.service.VerifyEmail)
+}
+
+func (s *APIServer) HandleConfirmEmailChange(w http.ResponseWriter, r *http.Request) error {
+	return s.handleTokenConsume(w, r, s.service.ConfirmEmailChange)
+}
+
+func (s *APIServer) HandleRevertEmailChange(w http.ResponseWriter, r *http.Request) error {
+	return s.handleTokenConsume(w, r, s.service.RevertEmailChange)
+}
+
+func (s *APIServer) handleTokenConsume(w http.ResponseWriter, r *http.Request, consume tokenConsumeFunc) error {
+	var tokenRequest TokenAPI
+	if err := json.NewDecoder(r.Body).Decode(&tokenRequest); err != nil {
+		return models.NewWrappedError(err, models.ContextBadRequest, "request body contains malformed data")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	user, err := consume(ctx, tokenRequest.Token)
+	if err != nil {
+		return err
+	}
+
+	response := NewUserResponse(user)
+	redactUserResponse(w, r, &response)
+	return ConstructSuccessResponse(w, http.StatusOK, response)
+}
+
+func (s *APIServer) HandleResendEmailVerification(w http.ResponseWriter, r *http.Request) error {
+	userUUID, err := parseUserID(r)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := s.service.ResendEmailVerification(ctx, userUUID); err != nil {
+		return err
+	}
+
+	return ConstructSuccessResponse(w, http.StatusAccepted, nil)
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// AccessClaims are carried by the short-lived access tokens of a session
+type AccessClaims struct {
+	SessionID uuid.UUID `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// AccessTokenSigner issues and checks HS256 access tokens bound to a session
+type AccessTokenSigner struct {
+	key    []byte
+	issuer string
+	ttl    time.Duration
+}
+
+func NewAccessTokenSigner(key []byte, issuer string, ttl time.Duration) *AccessTokenSigner {
+	return &AccessTokenSigner{key: key, issuer: issuer, ttl: ttl}
+}
+
+func (s *AccessTokenSigner) Sign(userID uuid.UUID, sessionID uuid.UUID) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(s.ttl)
+	claims := AccessClaims{
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.issuer,
+			Subject:   userID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			ID:        uuid.NewString(),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+func (s *AccessTokenSigner) Parse(token string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return s.key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(s.issuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if claims.SessionID == uuid.Nil {
+		return nil, errors.New("access token is not bound to a session")
+	}
+	return claims, nil
+}
@@ -0,0 +1,43 @@
+package authz
+
+import "users-microservice/pkg/models"
+
+// PrincipalAttributes is what conditions see of the caller, claims are the
+// ones of the gateway token and empty for API keys
+func PrincipalAttributes(principal *models.Principal) map[string]any {
+	scopes := make([]string, 0, len(principal.Scopes))
+	for _, scope := range principal.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+	userID := ""
+	if principal.Kind == models.PrincipalUser {
+		userID = principal.UserID.String()
+	}
+	claims := principal.Claims
+	if claims == nil {
+		claims = map[string]any{}
+	}
+	return map[string]any{
+		"kind":    string(principal.Kind),
+		"subject": principal.Subject,
+		"user_id": userID,
+		"scopes":  scopes,
+		"claims":  claims,
+	}
+}
+
+// UserAttributes is what conditions see of a user acted on
+func UserAttributes(user *models.User) map[string]any {
+	return map[string]any{
+		"id":             user.ID.String(),
+		"name":           user.Name,
+		"email":          user.Email,
+		"date_of_birth":  user.DateOfBirth,
+		"status":         string(user.Status),
+		"email_verified": user.EmailVerifiedAt != nil,
+		"phone_number":   user.PhoneNumber,
+		"phone_verified": user.PhoneVerifiedAt != nil,
+		"region":         user.Region,
+		"created_at":     user.CreatedAt,
+	}
+}
@@ -0,0 +1,123 @@
+// Package authz decides with policies whether callers may perform actions,
+// on top of what their scopes already allow
+package authz
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Input is what a decision is made about
+type Input struct {
+	Principal map[string]any `json:"principal"`
+	Action    string         `json:"action"`
+	Resource  map[string]any `json:"resource"`
+}
+
+type Decision struct {
+	Allowed bool
+	// rule that decided, empty when the default did
+	Rule string
+	// fields the allow rules that hold mask, the caller sees users without
+	// them
+	Mask []string
+	// failed condition, such requests are denied
+	Err error
+}
+
+// Engine evaluates the policy of a file, it can be read again while requests
+// are evaluated
+type Engine struct {
+	path   string
+	mu     sync.RWMutex
+	policy *compiledPolicy
+}
+
+func Load(path string) (*Engine, error) {
+	policy, err := readPolicy(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{path: path, policy: policy}, nil
+}
+
+// Reload reads the policy file again, the current policy stays when the file
+// is invalid
+func (e *Engine) Reload() error {
+	policy, err := readPolicy(e.path)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.policy = policy
+	e.mu.Unlock()
+	return nil
+}
+
+// Audit reports whether decisions are only logged
+func (e *Engine) Audit() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.policy.mode == ModeAudit
+}
+
+// Evaluate applies the rules of the action, a deny rule that holds wins over
+// allow rules, without either the default decides. Every allow rule that
+// holds adds its mask
+func (e *Engine) Evaluate(input Input) Decision {
+	e.mu.RLock()
+	policy := e.policy
+	e.mu.RUnlock()
+
+	activation := map[string]any{
+		"principal": orEmpty(input.Principal),
+		"action":    input.Action,
+		"resource":  orEmpty(input.Resource),
+	}
+	var allowedBy string
+	var mask []string
+	for _, rule := range policy.rules {
+		if !rule.matches(input.Action) {
+			continue
+		}
+		holds := true
+		if rule.program != nil {
+			out, _, err := rule.program.Eval(activation)
+			if err != nil {
+				return Decision{Rule: rule.Name, Err: err}
+			}
+			value, ok := out.Value().(bool)
+			if !ok {
+				return Decision{Rule: rule.Name, Err: fmt.Errorf("condition evaluated to %v", out.Value())}
+			}
+			holds = value
+		}
+		if !holds {
+			continue
+		}
+		if rule.Effect == EffectDeny {
+			return Decision{Rule: rule.Name}
+		}
+		if allowedBy == "" {
+			allowedBy = rule.Name
+		}
+		for _, field := range rule.Mask {
+			if !slices.Contains(mask, field) {
+				mask = append(mask, field)
+			}
+		}
+	}
+
+	if allowedBy != "" {
+		return Decision{Allowed: true, Rule: allowedBy, Mask: mask}
+	}
+	return Decision{Allowed: policy.fallback == EffectAllow}
+}
+
+func orEmpty(attributes map[string]any) map[string]any {
+	if attributes == nil {
+		return map[string]any{}
+	}
+	return attributes
+}
@@ -0,0 +1,171 @@
+package authz
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"users-microservice/pkg/models"
+
+	"github.com/google/cel-go/cel"
+)
+
+type Effect string
+
+const (
+	EffectAllow Effect = "allow"
+	EffectDeny  Effect = "deny"
+)
+
+type Mode string
+
+const (
+	// decisions are applied
+	ModeEnforce Mode = "enforce"
+	// decisions are only logged, to try out policies on real traffic
+	ModeAudit Mode = "audit"
+)
+
+// Policy is the content of a policy file
+type Policy struct {
+	// enforce by default
+	Mode Mode `json:"mode"`
+	// decision when no rule applies, allow by default
+	Default Effect `json:"default"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Rule allows or denies actions when its condition holds
+type Rule struct {
+	Name   string `json:"name"`
+	Effect Effect `json:"effect"`
+	// names of the actions, "users.*" matches every action starting with
+	// "users." and "*" every action
+	Actions []string `json:"actions"`
+	// CEL expression over principal, action and resource, an empty
+	// condition always holds
+	Condition string `json:"condition"`
+	// personal data of users the caller gets masked when the rule allows,
+	// see models.PersonalDataFields
+	Mask []string `json:"mask"`
+}
+
+func (r *Rule) matches(action string) bool {
+	return slices.ContainsFunc(r.Actions, func(pattern string) bool {
+		if pattern == "*" || pattern == action {
+			return true
+		}
+		prefix, ok := strings.CutSuffix(pattern, "*")
+		return ok && strings.HasPrefix(action, prefix)
+	})
+}
+
+// compiledPolicy is a policy whose conditions are ready to be evaluated
+type compiledPolicy struct {
+	mode     Mode
+	fallback Effect
+	rules    []compiledRule
+}
+
+type compiledRule struct {
+	Rule
+	// nil when the condition is empty
+	program cel.Program
+}
+
+// environment declares what conditions can refer to, principal and resource
+// are maps so attributes can be added without breaking policies. Optional
+// types allow for attributes that may be missing, like principal.claims.?role
+var environment = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.OptionalTypes(),
+		cel.Variable("principal", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("action", cel.StringType),
+		cel.Variable("resource", cel.MapType(cel.StringType, cel.DynType)),
+	)
+})
+
+func readPolicy(path string) (*compiledPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file: %w", err)
+	}
+	return compile(&policy)
+}
+
+// compile checks the policy and type checks every condition so mistakes
+// surface when the policy is loaded instead of on requests
+func compile(policy *Policy) (*compiledPolicy, error) {
+	env, err := environment()
+	if err != nil {
+		return nil, err
+	}
+
+	compiled := &compiledPolicy{mode: policy.Mode, fallback: policy.Default}
+	if compiled.mode == "" {
+		compiled.mode = ModeEnforce
+	}
+	if compiled.mode != ModeEnforce && compiled.mode != ModeAudit {
+		return nil, fmt.Errorf("unknown policy mode %q", policy.Mode)
+	}
+	if compiled.fallback == "" {
+		compiled.fallback = EffectAllow
+	}
+	if compiled.fallback != EffectAllow && compiled.fallback != EffectDeny {
+		return nil, fmt.Errorf("unknown default effect %q", policy.Default)
+	}
+
+	var errs error
+	for i, rule := range policy.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if rule.Effect != EffectAllow && rule.Effect != EffectDeny {
+			errs = errors.Join(errs, fmt.Errorf("%s: unknown effect %q", rule.Name, rule.Effect))
+			continue
+		}
+		if len(rule.Actions) == 0 {
+			errs = errors.Join(errs, fmt.Errorf("%s: no actions", rule.Name))
+			continue
+		}
+		if len(rule.Mask) > 0 && rule.Effect != EffectAllow {
+			errs = errors.Join(errs, fmt.Errorf("%s: only allow rules mask fields", rule.Name))
+			continue
+		}
+		if i := slices.IndexFunc(rule.Mask, func(field string) bool { return !slices.Contains(models.PersonalDataFields, field) }); i >= 0 {
+			errs = errors.Join(errs, fmt.Errorf("%s: %q is not personal data that can be masked", rule.Name, rule.Mask[i]))
+			continue
+		}
+
+		compiledRule := compiledRule{Rule: rule}
+		if strings.TrimSpace(rule.Condition) != "" {
+			ast, issues := env.Compile(rule.Condition)
+			if issues.Err() != nil {
+				errs = errors.Join(errs, fmt.Errorf("%s: %w", rule.Name, issues.Err()))
+				continue
+			}
+			if ast.OutputType() != cel.BoolType {
+				errs = errors.Join(errs, fmt.Errorf("%s: condition is %s instead of bool", rule.Name, ast.OutputType()))
+				continue
+			}
+			program, err := env.Program(ast)
+			if err != nil {
+				errs = errors.Join(errs, fmt.Errorf("%s: %w", rule.Name, err))
+				continue
+			}
+			compiledRule.program = program
+		}
+		compiled.rules = append(compiled.rules, compiledRule)
+	}
+	if errs != nil {
+		return nil, fmt.Errorf("invalid policy: %w", errs)
+	}
+	return compiled, nil
+}
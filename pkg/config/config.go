@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strings"
 	"time"
 	"users-microservice/pkg/models"
 )
@@ -15,6 +16,133 @@ type Config struct {
 	ReadTimeout     time.Duration
 	WriteTimeout    time.Duration
 	IdleTimeout     time.Duration
+
+	// base URL of the user facing application, used for links in messages
+	AppBaseURL string
+	// cookies are only sent over HTTPS, disable for plain HTTP development
+	CookieSecure bool
+
+	// mailer selection, one of smtp, file or stdout
+	Mailer         string
+	MailFrom       string
+	SMTPHost       string
+	SMTPPort       int
+	SMTPUsername   string
+	SMTPPassword   string
+	MailOutboxPath string
+
+	// sms sender selection, one of file or log
+	SMSSender     string
+	SMSOutboxPath string
+	// region national phone numbers are parsed for, e.g. "DE", unset requires
+	// numbers in international format
+	PhoneDefaultRegion   string
+	PhoneVerificationTTL time.Duration
+
+	EmailVerificationTTL     time.Duration
+	VerificationResendDelay  time.Duration
+	VerificationResendPerDay int
+	EmailChangeTTL           time.Duration
+	EmailRevertTTL           time.Duration
+
+	PasswordMinLength     int
+	PasswordMaxLength     int
+	BreachedPasswordsFile string
+	// argon2id parameters, raising them rehashes passwords on next login
+	Argon2Memory      int
+	Argon2Iterations  int
+	Argon2Parallelism int
+
+	PasswordResetTTL time.Duration
+	MagicLinkTTL     time.Duration
+
+	// HMAC key of access tokens, shared by all replicas
+	SessionSigningKey string
+	TokenIssuer       string
+	AccessTokenTTL    time.Duration
+	RefreshTokenTTL   time.Duration
+	SessionMaxAge     time.Duration
+
+	// base64 encoded 32 byte key sealing authenticator secrets and OIDC
+	// signing keys
+	MFAEncryptionKey string
+	MFAIssuer        string
+	MFAChallengeTTL  time.Duration
+
+	// WebAuthn relying party, the ID is the domain passkeys are bound to and
+	// the origins are the pages allowed to use them, APP_BASE_URL by default
+	WebAuthnRPID         string
+	WebAuthnRPName       string
+	WebAuthnOrigins      []string
+	WebAuthnChallengeTTL time.Duration
+
+	// OpenID Connect provider, the issuer is the public URL of this service
+	// and the login URL the page of the application signing users in
+	OIDCIssuer      string
+	OIDCLoginURL    string
+	OIDCCodeTTL     time.Duration
+	OIDCTokenTTL    time.Duration
+	OIDCKeyRotation time.Duration
+
+	// external OpenID providers users can sign in with, named in
+	// FEDERATION_PROVIDERS and configured with FEDERATION_<NAME>_* variables
+	FederationProviders []FederationProvider
+	// page of the application providers send the browser back to
+	FederationRedirectURL string
+	FederationStateTTL    time.Duration
+	// whether users without an account can sign up through a provider
+	FederationSignup bool
+
+	// SCIM provisioning, the base URL is where clients find the resources,
+	// OIDC_ISSUER followed by /scim/v2 by default
+	SCIMBaseURL    string
+	SCIMMaxResults int
+
+	// bearer tokens of the gateway, nil when they are not accepted
+	JWT *JWTValidation
+
+	// CEL policies every operation is checked against, none when unset
+	PolicyFile string
+
+	// HTTPS and client certificates, nil when the API is served over HTTP
+	TLS *TLS
+	// requests signed with shared secrets, nil when none are accepted
+	Signing *Signing
+
+	// failed login and verification attempts, counted per account and per
+	// source address in the "postgres" or "memory" store
+	LockoutStore              string
+	LockoutFreeAttempts       int
+	LockoutThreshold          int
+	LockoutSourceFreeAttempts int
+	LockoutSourceThreshold    int
+	LockoutBaseDelay          time.Duration
+	LockoutMaxDelay           time.Duration
+	LockoutDuration           time.Duration
+	LockoutResetAfter         time.Duration
+
+	// reads of users are buffered and written in the background, records
+	// older than the retention are deleted, 0 keeps them for good
+	AccessLogBufferSize    int
+	AccessLogFlushInterval time.Duration
+	AccessLogRetention     time.Duration
+
+	// personal data exports are deleted after the TTL, their archives are
+	// downloaded through single-use links under the download URL,
+	// OIDC_ISSUER followed by /personal-data-exports by default
+	ExportTTL         time.Duration
+	ExportLinkTTL     time.Duration
+	ExportDownloadURL string
+
+	// events for downstream systems are relayed from the database by the
+	// publisher, one of webhook, file or stdout. Webhook requests are signed
+	// when a key ID and secret are set, see pkg/signing
+	EventsPublisher     string
+	EventsOutboxPath    string
+	EventsWebhookURL    string
+	EventsWebhookKeyID  string
+	EventsWebhookSecret string
+	EventsRelayInterval time.Duration
 }
 
 func Load() (*Config, error) {
@@ -23,6 +151,7 @@ func Load() (*Config, error) {
 		return nil, models.ErrDatabaseEnvConfigNotSet
 	}
 
+	env := &envLoader{}
 	cfg := &Config{
 		DatabaseURL:     dbURL,
 		MaxOpenConns:    25,
@@ -32,6 +161,109 @@ func Load() (*Config, error) {
 		ReadTimeout:     10 * time.Second,
 		WriteTimeout:    10 * time.Second,
 		IdleTimeout:     120 * time.Second,
+
+		AppBaseURL:   env.String("APP_BASE_URL", "http://localhost:8080"),
+		CookieSecure: env.Bool("COOKIE_SECURE", true),
+
+		Mailer:         env.String("MAILER", "stdout"),
+		MailFrom:       env.String("MAIL_FROM", "no-reply@localhost"),
+		SMTPHost:       env.String("SMTP_HOST", "localhost"),
+		SMTPPort:       env.Int("SMTP_PORT", 587),
+		SMTPUsername:   env.String("SMTP_USERNAME", ""),
+		SMTPPassword:   env.String("SMTP_PASSWORD", ""),
+		MailOutboxPath: env.String("MAIL_OUTBOX_PATH", "outbox.jsonl"),
+
+		SMSSender:            env.String("SMS_SENDER", "log"),
+		SMSOutboxPath:        env.String("SMS_OUTBOX_PATH", "sms-outbox.jsonl"),
+		PhoneDefaultRegion:   env.String("PHONE_DEFAULT_REGION", ""),
+		PhoneVerificationTTL: env.Duration("PHONE_VERIFICATION_TTL", 10*time.Minute),
+
+		EmailVerificationTTL:     env.Duration("EMAIL_VERIFICATION_TTL", 24*time.Hour),
+		VerificationResendDelay:  env.Duration("VERIFICATION_RESEND_DELAY", 1*time.Minute),
+		VerificationResendPerDay: env.Int("VERIFICATION_RESEND_PER_DAY", 5),
+		EmailChangeTTL:           env.Duration("EMAIL_CHANGE_TTL", 24*time.Hour),
+		EmailRevertTTL:           env.Duration("EMAIL_REVERT_TTL", 7*24*time.Hour),
+
+		PasswordMinLength:     env.Int("PASSWORD_MIN_LENGTH", 12),
+		PasswordMaxLength:     env.Int("PASSWORD_MAX_LENGTH", 128),
+		BreachedPasswordsFile: env.String("BREACHED_PASSWORDS_FILE", ""),
+		Argon2Memory:          env.Int("ARGON2_MEMORY_KIB", 64*1024),
+		Argon2Iterations:      env.Int("ARGON2_ITERATIONS", 3),
+		Argon2Parallelism:     env.Int("ARGON2_PARALLELISM", 2),
+
+		PasswordResetTTL: env.Duration("PASSWORD_RESET_TTL", 30*time.Minute),
+		MagicLinkTTL:     env.Duration("MAGIC_LINK_TTL", 10*time.Minute),
+
+		SessionSigningKey: env.String("SESSION_SIGNING_KEY", ""),
+		TokenIssuer:       env.String("TOKEN_ISSUER", "users-microservice"),
+		AccessTokenTTL:    env.Duration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:   env.Duration("REFRESH_TOKEN_TTL", 14*24*time.Hour),
+		SessionMaxAge:     env.Duration("SESSION_MAX_AGE", 90*24*time.Hour),
+
+		MFAEncryptionKey: env.String("MFA_ENCRYPTION_KEY", ""),
+		MFAIssuer:        env.String("MFA_ISSUER", "Users"),
+		MFAChallengeTTL:  env.Duration("MFA_CHALLENGE_TTL", 5*time.Minute),
+
+		WebAuthnRPID:         env.String("WEBAUTHN_RP_ID", "localhost"),
+		WebAuthnRPName:       env.String("WEBAUTHN_RP_NAME", "Users"),
+		WebAuthnChallengeTTL: env.Duration("WEBAUTHN_CHALLENGE_TTL", 5*time.Minute),
+
+		OIDCIssuer:      env.String("OIDC_ISSUER", "http://localhost:8080"),
+		OIDCCodeTTL:     env.Duration("OIDC_CODE_TTL", time.Minute),
+		OIDCTokenTTL:    env.Duration("OIDC_TOKEN_TTL", time.Hour),
+		OIDCKeyRotation: env.Duration("OIDC_KEY_ROTATION", 30*24*time.Hour),
+
+		FederationStateTTL: env.Duration("FEDERATION_STATE_TTL", 10*time.Minute),
+		FederationSignup:   env.Bool("FEDERATION_SIGNUP", true),
+
+		SCIMMaxResults: env.Int("SCIM_MAX_RESULTS", 200),
+
+		PolicyFile: env.String("POLICY_FILE", ""),
+
+		LockoutStore:              env.String("LOCKOUT_STORE", "postgres"),
+		LockoutFreeAttempts:       env.Int("LOCKOUT_FREE_ATTEMPTS", 3),
+		LockoutThreshold:          env.Int("LOCKOUT_THRESHOLD", 10),
+		LockoutSourceFreeAttempts: env.Int("LOCKOUT_SOURCE_FREE_ATTEMPTS", 20),
+		LockoutSourceThreshold:    env.Int("LOCKOUT_SOURCE_THRESHOLD", 100),
+		LockoutBaseDelay:          env.Duration("LOCKOUT_BASE_DELAY", time.Second),
+		LockoutMaxDelay:           env.Duration("LOCKOUT_MAX_DELAY", 5*time.Minute),
+		LockoutDuration:           env.Duration("LOCKOUT_DURATION", 30*time.Minute),
+		LockoutResetAfter:         env.Duration("LOCKOUT_RESET_AFTER", 24*time.Hour),
+
+		AccessLogBufferSize:    env.Int("ACCESS_LOG_BUFFER_SIZE", 1000),
+		AccessLogFlushInterval: env.Duration("ACCESS_LOG_FLUSH_INTERVAL", time.Second),
+		AccessLogRetention:     env.Duration("ACCESS_LOG_RETENTION", 365*24*time.Hour),
+
+		ExportTTL:     env.Duration("EXPORT_TTL", 7*24*time.Hour),
+		ExportLinkTTL: env.Duration("EXPORT_LINK_TTL", 15*time.Minute),
+
+		EventsPublisher:     env.String("EVENTS_PUBLISHER", "stdout"),
+		EventsOutboxPath:    env.String("EVENTS_OUTBOX_PATH", "events.jsonl"),
+		EventsWebhookURL:    env.String("EVENTS_WEBHOOK_URL", ""),
+		EventsWebhookKeyID:  env.String("EVENTS_WEBHOOK_KEY_ID", ""),
+		EventsWebhookSecret: env.String("EVENTS_WEBHOOK_SECRET", ""),
+		EventsRelayInterval: env.Duration("EVENTS_RELAY_INTERVAL", 5*time.Second),
+	}
+	if env.err != nil {
+		return nil, env.err
+	}
+
+	cfg.OIDCLoginURL = env.String("OIDC_LOGIN_URL", cfg.AppBaseURL+"/login")
+	cfg.FederationRedirectURL = env.String("FEDERATION_REDIRECT_URL", cfg.AppBaseURL+"/login/callback")
+	cfg.SCIMBaseURL = strings.TrimSuffix(env.String("SCIM_BASE_URL", strings.TrimSuffix(cfg.OIDCIssuer, "/")+"/scim/v2"), "/")
+	cfg.ExportDownloadURL = strings.TrimSuffix(env.String("EXPORT_DOWNLOAD_URL", strings.TrimSuffix(cfg.OIDCIssuer, "/")+"/personal-data-exports"), "/")
+	for _, origin := range strings.Split(env.String("WEBAUTHN_ORIGINS", cfg.AppBaseURL), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			cfg.WebAuthnOrigins = append(cfg.WebAuthnOrigins, origin)
+		}
+	}
+
+	cfg.FederationProviders = loadFederationProviders(env)
+	cfg.JWT = loadJWTValidation(env)
+	cfg.TLS = loadTLS(env)
+	cfg.Signing = loadSigning(env)
+	if env.err != nil {
+		return nil, env.err
 	}
 
 	return cfg, nil
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// envLoader reads optional settings from the environment, falling back to
+// defaults for unset variables and collecting errors for malformed ones
+type envLoader struct {
+	err error
+}
+
+func (l *envLoader) String(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func (l *envLoader) Int(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		l.fail(key, value, err)
+		return fallback
+	}
+	return parsed
+}
+
+func (l *envLoader) Duration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		l.fail(key, value, err)
+		return fallback
+	}
+	return parsed
+}
+
+func (l *envLoader) Bool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		l.fail(key, value, err)
+		return fallback
+	}
+	return parsed
+}
+
+func (l *envLoader) fail(key string, value string, err error) {
+	l.err = errors.Join(l.err, fmt.Errorf("invalid value '%s' of %s: %w", value, key, err))
+}
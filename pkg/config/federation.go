@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// link policies for external identities that are not linked yet but share
+// the email of an account
+const (
+	// link when the provider verified the email and so did the account
+	LinkPolicyVerifiedEmail = "verified_email"
+	// never link automatically, the user has to link while signed in
+	LinkPolicyNever = "never"
+)
+
+type FederationProvider struct {
+	// name used in URLs, e.g. "google"
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	LinkPolicy   string
+}
+
+// loadFederationProviders reads FEDERATION_<NAME>_* for every name listed in
+// FEDERATION_PROVIDERS
+func loadFederationProviders(env *envLoader) []FederationProvider {
+	defaultPolicy := env.String("FEDERATION_LINK_POLICY", LinkPolicyVerifiedEmail)
+
+	var providers []FederationProvider
+	for _, name := range strings.Split(env.String("FEDERATION_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "FEDERATION_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		provider := FederationProvider{
+			Name:         name,
+			Issuer:       strings.TrimSuffix(env.String(prefix+"ISSUER", ""), "/"),
+			ClientID:     env.String(prefix+"CLIENT_ID", ""),
+			ClientSecret: env.String(prefix+"CLIENT_SECRET", ""),
+			Scopes:       strings.Fields(env.String(prefix+"SCOPES", "openid email profile")),
+			LinkPolicy:   env.String(prefix+"LINK_POLICY", defaultPolicy),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			env.fail(prefix+"ISSUER", provider.Issuer, fmt.Errorf("issuer and client ID of provider %s are required", name))
+		}
+		if provider.LinkPolicy != LinkPolicyVerifiedEmail && provider.LinkPolicy != LinkPolicyNever {
+			env.fail(prefix+"LINK_POLICY", provider.LinkPolicy, fmt.Errorf("must be %s or %s", LinkPolicyVerifiedEmail, LinkPolicyNever))
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
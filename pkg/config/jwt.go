@@ -0,0 +1,76 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+)
+
+// JWTValidation tells how bearer tokens issued by the gateway are checked
+type JWTValidation struct {
+	// the key set is read from either the URL or the file
+	JWKSURL  string
+	JWKSFile string
+	// how long keys are used before the set is read again, and how often a
+	// token signed with an unknown key can make it read sooner
+	JWKSRefresh    time.Duration
+	JWKSMinRefresh time.Duration
+	Issuer         string
+	Audience       string
+	// tolerated difference between the clocks of the gateway and ours
+	ClockSkew time.Duration
+	// scopes of the gateway granting scopes here, scopes named like ours
+	// are granted without an entry
+	ScopeMapping map[string][]models.Scope
+	// subjects of tokens the gateway issues to services, tokens of users
+	// carry their ID and any other subject is rejected
+	ServiceSubjects []string
+}
+
+// loadJWTValidation reads the JWT_* settings, tokens are only accepted when
+// a key set is configured
+func loadJWTValidation(env *envLoader) *JWTValidation {
+	validation := &JWTValidation{
+		JWKSURL:        env.String("JWT_JWKS_URL", ""),
+		JWKSFile:       env.String("JWT_JWKS_FILE", ""),
+		JWKSRefresh:    env.Duration("JWT_JWKS_REFRESH", time.Hour),
+		JWKSMinRefresh: env.Duration("JWT_JWKS_MIN_REFRESH", 5*time.Second),
+		Issuer:         env.String("JWT_ISSUER", ""),
+		Audience:       env.String("JWT_AUDIENCE", ""),
+		ClockSkew:      env.Duration("JWT_CLOCK_SKEW", time.Minute),
+		ScopeMapping:   map[string][]models.Scope{},
+	}
+	if validation.JWKSURL == "" && validation.JWKSFile == "" {
+		return nil
+	}
+	if validation.JWKSURL != "" && validation.JWKSFile != "" {
+		env.fail("JWT_JWKS_FILE", validation.JWKSFile, errors.New("only one of JWT_JWKS_URL and JWT_JWKS_FILE can be set"))
+	}
+	if validation.Issuer == "" || validation.Audience == "" {
+		env.fail("JWT_ISSUER", validation.Issuer, errors.New("issuer and audience of bearer tokens are required"))
+	}
+
+	for _, subject := range strings.Split(env.String("JWT_SERVICE_SUBJECTS", ""), ",") {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			validation.ServiceSubjects = append(validation.ServiceSubjects, subject)
+		}
+	}
+
+	// entries look like gateway.users.read=users:read, separated by commas
+	mapping := env.String("JWT_SCOPE_MAPPING", "")
+	for _, entry := range strings.Split(mapping, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(entry, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || !models.IsKnownScope(models.Scope(to)) {
+			env.fail("JWT_SCOPE_MAPPING", mapping, fmt.Errorf("entry '%s' does not map a scope onto a known one", entry))
+			continue
+		}
+		validation.ScopeMapping[from] = append(validation.ScopeMapping[from], models.Scope(to))
+	}
+	return validation
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Signing tells how requests signed with a shared secret are verified
+type Signing struct {
+	// JSON file with the key ID, secret and scopes of every client
+	ClientsFile string
+	// how far the timestamp of a request may be off, nonces are remembered
+	// for as long
+	MaxSkew time.Duration
+	// headers every signature has to cover besides method, path, query and
+	// body, lower case
+	RequiredHeaders []string
+	// where nonces are remembered, postgres or memory
+	NonceStore string
+}
+
+// loadSigning reads the SIGNING_* settings, signed requests are rejected
+// unless clients are configured
+func loadSigning(env *envLoader) *Signing {
+	settings := &Signing{
+		ClientsFile: env.String("SIGNING_CLIENTS_FILE", ""),
+		MaxSkew:     env.Duration("SIGNING_MAX_SKEW", 5*time.Minute),
+		NonceStore:  env.String("SIGNING_NONCE_STORE", "postgres"),
+	}
+	if settings.ClientsFile == "" {
+		return nil
+	}
+	if settings.MaxSkew <= 0 {
+		env.fail("SIGNING_MAX_SKEW", settings.MaxSkew.String(), errors.New("skew has to be positive"))
+	}
+	if settings.NonceStore != "postgres" && settings.NonceStore != "memory" {
+		env.fail("SIGNING_NONCE_STORE", settings.NonceStore, errors.New("store has to be postgres or memory"))
+	}
+	for _, name := range strings.Split(env.String("SIGNING_REQUIRED_HEADERS", "host"), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			settings.RequiredHeaders = append(settings.RequiredHeaders, name)
+		}
+	}
+	return settings
+}
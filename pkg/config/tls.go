@@ -0,0 +1,80 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TLS tells how the API is served over HTTPS
+type TLS struct {
+	CertFile string
+	KeyFile  string
+	// how often the files are checked for a renewed certificate
+	ReloadInterval time.Duration
+	MinVersion     uint16
+	// TLS 1.2 cipher suites, Go's defaults when empty, TLS 1.3 suites cannot
+	// be chosen
+	CipherSuites []uint16
+	// client certificates are verified against the bundle when set
+	ClientCAFile string
+	// whether connections without a client certificate are refused
+	ClientCertRequired bool
+	// JSON file mapping client certificates to principals and scopes
+	ClientIdentitiesFile string
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// loadTLS reads the TLS_* settings, the API is served over plain HTTP unless
+// a certificate is configured
+func loadTLS(env *envLoader) *TLS {
+	settings := &TLS{
+		CertFile:             env.String("TLS_CERT_FILE", ""),
+		KeyFile:              env.String("TLS_KEY_FILE", ""),
+		ReloadInterval:       env.Duration("TLS_RELOAD_INTERVAL", 30*time.Second),
+		ClientCAFile:         env.String("TLS_CLIENT_CA_FILE", ""),
+		ClientCertRequired:   env.Bool("TLS_CLIENT_CERT_REQUIRED", false),
+		ClientIdentitiesFile: env.String("TLS_CLIENT_IDENTITIES_FILE", ""),
+	}
+	if settings.CertFile == "" && settings.KeyFile == "" {
+		return nil
+	}
+	if settings.CertFile == "" || settings.KeyFile == "" {
+		env.fail("TLS_CERT_FILE", settings.CertFile, errors.New("certificate and key file are both required"))
+	}
+	if settings.ClientCAFile == "" && (settings.ClientCertRequired || settings.ClientIdentitiesFile != "") {
+		env.fail("TLS_CLIENT_CA_FILE", settings.ClientCAFile, errors.New("client certificates cannot be verified without a CA"))
+	}
+
+	minVersion := env.String("TLS_MIN_VERSION", "1.2")
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		env.fail("TLS_MIN_VERSION", minVersion, errors.New("version has to be 1.2 or 1.3"))
+	}
+	settings.MinVersion = version
+
+	// only suites Go considers secure can be chosen
+	secure := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+	suites := env.String("TLS_CIPHER_SUITES", "")
+	for _, name := range strings.Split(suites, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		id, ok := secure[name]
+		if !ok {
+			env.fail("TLS_CIPHER_SUITES", suites, fmt.Errorf("'%s' is not a secure cipher suite", name))
+			continue
+		}
+		settings.CipherSuites = append(settings.CipherSuites, id)
+	}
+	return settings
+}
@@ -0,0 +1,72 @@
+// Package encryption seals small secrets that have to be stored but must not
+// be readable from the database alone.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const version = "v1"
+
+var ErrMalformedCiphertext = errors.New("ciphertext is malformed")
+
+// Cipher encrypts with AES-256-GCM, the output is "v1:" followed by the
+// base64 encoded nonce and sealed data
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// NewCipherFromBase64 accepts the key the way it is kept in configuration
+func NewCipherFromBase64(encodedKey string) (*Cipher, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not valid base64: %w", err)
+	}
+	return NewCipher(key)
+}
+
+// Encrypt seals the plaintext, associated data binds the ciphertext to its
+// owner so it cannot be copied over to another record
+func (c *Cipher) Encrypt(plaintext []byte, associatedData []byte) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, plaintext, associatedData)
+	return version + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (c *Cipher) Decrypt(ciphertext string, associatedData []byte) ([]byte, error) {
+	prefix, encoded, found := strings.Cut(ciphertext, ":")
+	if !found || prefix != version {
+		return nil, ErrMalformedCiphertext
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return nil, ErrMalformedCiphertext
+	}
+
+	nonce, data := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	return c.aead.Open(nil, nonce, data, associatedData)
+}
@@ -0,0 +1,72 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// OutboxPublisher appends every message as a JSON line to the underlying
+// writer, meant for local runs and tests
+type OutboxPublisher struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewOutboxPublisher(w io.Writer) *OutboxPublisher {
+	return &OutboxPublisher{w: w}
+}
+
+func NewStdoutOutbox() *OutboxPublisher {
+	return NewOutboxPublisher(os.Stdout)
+}
+
+func NewFileOutbox(path string) (*OutboxPublisher, error) {
+	if path == "" {
+		return nil, fmt.Errorf("events outbox path is not set")
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open events outbox %s: %w", path, err)
+	}
+	return NewOutboxPublisher(file), nil
+}
+
+func (p *OutboxPublisher) Publish(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.w.Write(append(line, '\n'))
+	return err
+}
+
+// ReadOutbox returns all messages written to an outbox file, oldest first
+func ReadOutbox(path string) ([]Message, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var messages []Message
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, scanner.Err()
+}
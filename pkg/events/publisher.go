@@ -0,0 +1,45 @@
+// Package events publishes the events stored with changes to users, so
+// downstream systems can follow, e.g. erase the personal data they hold too
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// Message is the JSON document published for an event
+type Message struct {
+	ID        uuid.UUID       `json:"id"`
+	Type      string          `json:"type"`
+	UserID    uuid.UUID       `json:"user_id"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func NewMessage(event *models.Event) Message {
+	return Message{ID: event.ID, Type: event.Type, UserID: event.UserID, Data: event.Data, CreatedAt: event.CreatedAt}
+}
+
+type Publisher interface {
+	Publish(context.Context, Message) error
+}
+
+// New builds the publisher selected in the configuration
+func New(cfg *config.Config) (Publisher, error) {
+	switch cfg.EventsPublisher {
+	case "webhook":
+		return NewWebhookPublisher(cfg.EventsWebhookURL, cfg.EventsWebhookKeyID, cfg.EventsWebhookSecret)
+	case "file":
+		return NewFileOutbox(cfg.EventsOutboxPath)
+	case "stdout", "":
+		return NewStdoutOutbox(), nil
+	default:
+		return nil, fmt.Errorf("unknown events publisher '%s'", cfg.EventsPublisher)
+	}
+}
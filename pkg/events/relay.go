@@ -0,0 +1,66 @@
+package events
+
+import (
+	"context"
+	"log"
+	"time"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/storage"
+)
+
+// events read from the outbox at once
+const relayBatchSize = 100
+
+// Relay publishes the stored events in the order they happened. An event
+// that fails stops the batch and is tried again on the next run, so events
+// are published at least once and never out of order
+type Relay struct {
+	storage   storage.EventStorage
+	publisher Publisher
+	interval  time.Duration
+}
+
+func NewRelay(storage storage.EventStorage, publisher Publisher, cfg *config.Config) *Relay {
+	interval := cfg.EventsRelayInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &Relay{storage: storage, publisher: publisher, interval: interval}
+}
+
+// Run publishes pending events every interval until the context is done
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if err := r.PublishPending(ctx); err != nil {
+			log.Printf("ERROR: failed to publish events: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PublishPending publishes every event not published yet
+func (r *Relay) PublishPending(ctx context.Context) error {
+	for {
+		pending, err := r.storage.RetrieveUnpublishedEvents(relayBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, event := range pending {
+			if err := r.publisher.Publish(ctx, NewMessage(&event)); err != nil {
+				return err
+			}
+			if err := r.storage.MarkEventPublished(event.ID, time.Now()); err != nil {
+				return err
+			}
+		}
+		if len(pending) < relayBatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"users-microservice/pkg/signing"
+)
+
+// WebhookPublisher posts every message to a URL, any status but 2xx fails
+// the message so it is published again
+type WebhookPublisher struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookPublisher signs the requests when a key ID and secret are given,
+// receivers verify them like this service verifies signed requests
+func NewWebhookPublisher(url string, keyID string, secret string) (*WebhookPublisher, error) {
+	if url == "" {
+		return nil, fmt.Errorf("events webhook URL is not set")
+	}
+	if (keyID == "") != (secret == "") {
+		return nil, fmt.Errorf("events webhook key ID and secret have to be set together")
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	if keyID != "" {
+		client.Transport = &signing.Transport{Signer: signing.NewSigner(keyID, []byte(secret), "content-type")}
+	}
+	return &WebhookPublisher{url: url, client: client}, nil
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, msg Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered event %s with status %d", msg.ID, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// Package export gathers everything held about a user into one archive. The
+// data comes from collectors every subsystem registers for its own data, so
+// new subsystems show up in archives without touching the export itself
+package export
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Format and Version identify the layout of archives for whoever reads them
+const (
+	Format  = "users-microservice/personal-data-export"
+	Version = 1
+)
+
+var sectionNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Collector returns the data a subsystem holds about a user, in the form it
+// should appear in the archive. Nil stands for nothing held
+type Collector interface {
+	Collect(ctx context.Context, userID uuid.UUID) (any, error)
+}
+
+type CollectorFunc func(ctx context.Context, userID uuid.UUID) (any, error)
+
+func (f CollectorFunc) Collect(ctx context.Context, userID uuid.UUID) (any, error) {
+	return f(ctx, userID)
+}
+
+type section struct {
+	name      string
+	collector Collector
+}
+
+// Exporter builds archives from the registered collectors, in the order
+// they were registered
+type Exporter struct {
+	mu       sync.RWMutex
+	sections []section
+}
+
+func NewExporter() *Exporter {
+	return &Exporter{}
+}
+
+// Register adds the collector for the section of the given name, names are
+// snake case and unique
+func (e *Exporter) Register(name string, collector Collector) {
+	if !sectionNamePattern.MatchString(name) {
+		panic(fmt.Sprintf("export: section name %q is not snake case", name))
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, s := range e.sections {
+		if s.name == name {
+			panic(fmt.Sprintf("export: section %q is registered twice", name))
+		}
+	}
+	e.sections = append(e.sections, section{name: name, collector: collector})
+}
+
+// Sections names the registered sections
+func (e *Exporter) Sections() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	names := make([]string, 0, len(e.sections))
+	for _, s := range e.sections {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+// ManifestSection describes one section of an archive, the hash covers its
+// JSON as found in the archive
+type ManifestSection struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"`
+	SHA256  string `json:"sha256"`
+}
+
+type Manifest struct {
+	Format      string            `json:"format"`
+	Version     int               `json:"version"`
+	ExportID    uuid.UUID         `json:"export_id"`
+	UserID      uuid.UUID         `json:"user_id"`
+	GeneratedAt time.Time         `json:"generated_at"`
+	Sections    []ManifestSection `json:"sections"`
+}
+
+type Archive struct {
+	Manifest Manifest                   `json:"manifest"`
+	Data     map[string]json.RawMessage `json:"data"`
+}
+
+// Build runs every collector for the user, any failing fails the archive as
+// it would be incomplete
+func (e *Exporter) Build(ctx context.Context, exportID uuid.UUID, userID uuid.UUID) (*Archive, error) {
+	e.mu.RLock()
+	sections := append([]section(nil), e.sections...)
+	e.mu.RUnlock()
+
+	archive := &Archive{
+		Manifest: Manifest{
+			Format:      Format,
+			Version:     Version,
+			ExportID:    exportID,
+			UserID:      userID,
+			GeneratedAt: time.Now().UTC(),
+			Sections:    make([]ManifestSection, 0, len(sections)),
+		},
+		Data: make(map[string]json.RawMessage, len(sections)),
+	}
+	for _, s := range sections {
+		data, err := s.collector.Collect(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("collecting %s: %w", s.name, err)
+		}
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("encoding %s: %w", s.name, err)
+		}
+		sum := sha256.Sum256(encoded)
+		archive.Data[s.name] = encoded
+		archive.Manifest.Sections = append(archive.Manifest.Sections, ManifestSection{
+			Name:    s.name,
+			Records: countRecords(data),
+			SHA256:  hex.EncodeToString(sum[:]),
+		})
+	}
+	return archive, nil
+}
+
+// countRecords counts the elements of lists, any other data is one record
+func countRecords(data any) int {
+	if data == nil {
+		return 0
+	}
+	value := reflect.ValueOf(data)
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		return value.Len()
+	case reflect.Pointer, reflect.Map:
+		if value.IsNil() {
+			return 0
+		}
+	}
+	return 1
+}
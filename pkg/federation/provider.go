@@ -0,0 +1,276 @@
+package federation
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/oidc"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// discovery documents rarely change, keys are reloaded sooner when a
+	// token is signed with one that is not known yet
+	metadataTTL = time.Hour
+	keysTTL     = time.Hour
+	// unknown keys reload at most this often so a provider sending made up
+	// key IDs cannot make every sign-in fetch the key set
+	keyReloadInterval = 5 * time.Second
+	// clocks of providers are not always in sync with ours
+	clockSkew = time.Minute
+	// responses are small, anything bigger is not a provider talking
+	maxResponseSize = 1 << 20
+)
+
+var signingAlgorithms = []string{
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodES256.Alg(),
+}
+
+// Identity is what a provider asserted about the user in a verified ID token
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider signs users in with an external OpenID provider using the
+// authorization code flow, discovery document and keys are fetched lazily
+type Provider struct {
+	config.FederationProvider
+	redirectURL string
+	client      *http.Client
+
+	mu         sync.Mutex
+	metadata   *oidc.ProviderMetadata
+	metadataAt time.Time
+	keys       map[string]crypto.PublicKey
+	keysAt     time.Time
+}
+
+func NewProvider(cfg config.FederationProvider, redirectURL string, client *http.Client) *Provider {
+	return &Provider{FederationProvider: cfg, redirectURL: redirectURL, client: client}
+}
+
+// AuthorizationURL is where the browser is sent to sign in with the provider
+func (p *Provider) AuthorizationURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oidc.RedirectURL(metadata.AuthorizationEndpoint, url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.redirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {oidc.CodeChallengeMethodS256},
+	}), nil
+}
+
+// Exchange redeems the authorization code and verifies the ID token that
+// comes with it, nonce is the one sent with the authorization request
+func (p *Provider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*Identity, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if p.ClientSecret == "" {
+		// public clients only identify themselves
+		form.Set("client_id", p.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	var response struct {
+		IDToken string `json:"id_token"`
+		oidc.Error
+	}
+	status, err := p.do(req, &response)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		if response.Code != "" {
+			return nil, fmt.Errorf("token endpoint refused the code: %w", &response.Error)
+		}
+		return nil, fmt.Errorf("token endpoint answered with status %d", status)
+	}
+	if response.IDToken == "" {
+		return nil, errors.New("token response carries no ID token")
+	}
+
+	return p.verifyIDToken(ctx, response.IDToken, nonce)
+}
+
+func (p *Provider) verifyIDToken(ctx context.Context, idToken string, nonce string) (*Identity, error) {
+	claims := &oidc.IDTokenClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, kid)
+	},
+		jwt.WithValidMethods(signingAlgorithms),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(clockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ID token is invalid: %w", err)
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("ID token was issued for another request")
+	}
+
+	return &Identity{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified != nil && *claims.EmailVerified,
+		Name:          claims.Name,
+	}, nil
+}
+
+func (p *Provider) discover(ctx context.Context) (*oidc.ProviderMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil && time.Since(p.metadataAt) < metadataTTL {
+		return p.metadata, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var metadata oidc.ProviderMetadata
+	status, err := p.do(req, &metadata)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("discovery document of %s answered with status %d", p.Issuer, status)
+	}
+	// a document claiming another issuer could make us trust its tokens
+	if metadata.Issuer != p.Issuer {
+		return nil, fmt.Errorf("discovery document of %s names issuer %s", p.Issuer, metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document of %s lacks endpoints", p.Issuer)
+	}
+
+	p.metadata = &metadata
+	p.metadataAt = time.Now()
+	return p.metadata, nil
+}
+
+func (p *Provider) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	key, known := p.keys[kid]
+	stale := time.Since(p.keysAt) > keysTTL
+	throttled := time.Since(p.keysAt) < keyReloadInterval
+	p.mu.Unlock()
+
+	if known && !stale {
+		return key, nil
+	}
+	if !known && throttled {
+		return nil, errors.New("token is signed with an unknown key")
+	}
+	if err := p.loadKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.New("token is signed with an unknown key")
+}
+
+func (p *Provider) loadKeys(ctx context.Context) error {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadata.JWKSURI, nil)
+	if err != nil {
+		return err
+	}
+	var set oidc.JSONWebKeySet
+	status, err := p.do(req, &set)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("key set of %s answered with status %d", p.Issuer, status)
+	}
+
+	// keys of unsupported types are left out, tokens signed with them fail
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.PublicKey(); err == nil {
+			keys[jwk.KeyID] = key
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = keys
+	p.keysAt = time.Now()
+	return nil
+}
+
+// do sends the request and decodes the JSON response into v whatever the
+// status, error responses of the protocol are JSON too
+func (p *Provider) do(req *http.Request, v any) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reach %s: %w", p.Issuer, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read response of %s: %w", p.Issuer, err)
+	}
+	if err := json.Unmarshal(body, v); err != nil && resp.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("response of %s is malformed: %w", p.Issuer, err)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,33 @@
+package federation
+
+import (
+	"net/http"
+	"time"
+	"users-microservice/pkg/config"
+)
+
+// Registry holds the configured providers by name
+type Registry struct {
+	providers map[string]*Provider
+	names     []string
+}
+
+func NewRegistry(cfg *config.Config) *Registry {
+	client := &http.Client{Timeout: 10 * time.Second}
+	registry := &Registry{providers: make(map[string]*Provider, len(cfg.FederationProviders))}
+	for _, provider := range cfg.FederationProviders {
+		registry.providers[provider.Name] = NewProvider(provider, cfg.FederationRedirectURL, client)
+		registry.names = append(registry.names, provider.Name)
+	}
+	return registry
+}
+
+func (r *Registry) Provider(name string) (*Provider, bool) {
+	provider, ok := r.providers[name]
+	return provider, ok
+}
+
+// Names lists the providers in configured order
+func (r *Registry) Names() []string {
+	return r.names
+}
@@ -0,0 +1,149 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	"users-microservice/pkg/oidc"
+)
+
+// key sets are small, anything bigger is not one
+const maxKeySetSize = 1 << 20
+
+// ErrKeySetUnavailable is returned when the keys could not be read, tokens
+// are then neither valid nor invalid
+var ErrKeySetUnavailable = errors.New("key set is unavailable")
+
+type publicKey struct {
+	key crypto.PublicKey
+	// the algorithm the key is restricted to, empty when it is not
+	algorithm string
+}
+
+// KeySet caches the keys of a JWKS read from a URL or a file. Keys are read
+// again once they are older than the refresh interval or when a token names
+// a key that is not known yet, so the gateway can rotate keys at any time.
+// Unknown keys read the set at most once per minimum refresh interval, so
+// tokens naming made up keys cannot make every request read it. Failed reads
+// count as well, so an unreachable set is not asked on every request
+type KeySet struct {
+	url        string
+	file       string
+	refresh    time.Duration
+	minRefresh time.Duration
+	client     *http.Client
+
+	mu       sync.Mutex
+	keys     map[string]publicKey
+	loadedAt time.Time
+	// the last read, successful or not, and why it failed
+	readAt  time.Time
+	readErr error
+}
+
+func NewURLKeySet(url string, refresh time.Duration, minRefresh time.Duration, client *http.Client) *KeySet {
+	return &KeySet{url: url, refresh: refresh, minRefresh: minRefresh, client: client}
+}
+
+func NewFileKeySet(file string, refresh time.Duration, minRefresh time.Duration) *KeySet {
+	return &KeySet{file: file, refresh: refresh, minRefresh: minRefresh}
+}
+
+func (ks *KeySet) key(ctx context.Context, kid string) (*publicKey, error) {
+	ks.mu.Lock()
+	key, known := ks.keys[kid]
+	stale := time.Since(ks.loadedAt) > ks.refresh
+	throttled := time.Since(ks.readAt) < ks.minRefresh
+	readErr := ks.readErr
+	ks.mu.Unlock()
+
+	if known && (!stale || throttled) {
+		return &key, nil
+	}
+	if !known && throttled {
+		if readErr != nil {
+			return nil, readErr
+		}
+		return nil, errors.New("token is signed with an unknown key")
+	}
+	if err := ks.load(ctx); err != nil {
+		// keys that were valid a moment ago still are while the set cannot
+		// be read
+		if known {
+			return &key, nil
+		}
+		return nil, err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if key, ok := ks.keys[kid]; ok {
+		return &key, nil
+	}
+	return nil, errors.New("token is signed with an unknown key")
+}
+
+func (ks *KeySet) load(ctx context.Context) error {
+	keys, err := ks.parse(ctx)
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.readAt, ks.readErr = time.Now(), err
+	if err != nil {
+		return err
+	}
+	ks.keys = keys
+	ks.loadedAt = ks.readAt
+	return nil
+}
+
+func (ks *KeySet) parse(ctx context.Context) (map[string]publicKey, error) {
+	raw, err := ks.read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrKeySetUnavailable, err)
+	}
+	var set oidc.JSONWebKeySet
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("%w: key set is malformed: %w", ErrKeySetUnavailable, err)
+	}
+
+	// keys of unsupported types are left out, tokens signed with them fail
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.PublicKey(); err == nil {
+			keys[jwk.KeyID] = publicKey{key: key, algorithm: jwk.Algorithm}
+		}
+	}
+	return keys, nil
+}
+
+func (ks *KeySet) read(ctx context.Context) ([]byte, error) {
+	if ks.file != "" {
+		return os.ReadFile(ks.file)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", ks.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered with status %d", ks.url, resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxKeySetSize))
+}
@@ -0,0 +1,148 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var signingAlgorithms = []string{
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodES256.Alg(),
+	jwt.SigningMethodEdDSA.Alg(),
+}
+
+// Identity is what a verified token says about the caller
+type Identity struct {
+	Subject string
+	Kind    models.PrincipalKind
+	// account of user tokens
+	UserID uuid.UUID
+	Scopes []models.Scope
+	// every claim of the token
+	Claims map[string]any
+}
+
+// scopeClaim reads scopes as a space separated string, like the scope claim
+// of RFC 9068, or as a list, like the scp claim some gateways send
+type scopeClaim []string
+
+func (s *scopeClaim) UnmarshalJSON(data []byte) error {
+	var joined string
+	if err := json.Unmarshal(data, &joined); err == nil {
+		*s = strings.Fields(joined)
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("scopes are neither a string nor a list")
+	}
+	*s = list
+	return nil
+}
+
+type tokenClaims struct {
+	Scope scopeClaim `json:"scope"`
+	SCP   scopeClaim `json:"scp"`
+	jwt.RegisteredClaims
+}
+
+// Verifier checks bearer tokens issued by the gateway
+type Verifier struct {
+	keys         *KeySet
+	issuer       string
+	audience     string
+	leeway       time.Duration
+	scopeMapping map[string][]models.Scope
+	services     []string
+}
+
+func NewVerifier(cfg *config.JWTValidation, client *http.Client) *Verifier {
+	keys := NewURLKeySet(cfg.JWKSURL, cfg.JWKSRefresh, cfg.JWKSMinRefresh, client)
+	if cfg.JWKSFile != "" {
+		keys = NewFileKeySet(cfg.JWKSFile, cfg.JWKSRefresh, cfg.JWKSMinRefresh)
+	}
+	return &Verifier{keys: keys, issuer: cfg.Issuer, audience: cfg.Audience, leeway: cfg.ClockSkew, scopeMapping: cfg.ScopeMapping, services: cfg.ServiceSubjects}
+}
+
+// Verify checks signature, issuer, audience and lifetime of the token and
+// returns who it was issued to, users by their ID and services by a subject
+// configured for them. Errors wrapping ErrKeySetUnavailable are not the fault
+// of the token
+func (v *Verifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	claims := &tokenClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := v.keys.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.algorithm != "" && key.algorithm != token.Method.Alg() {
+			return nil, fmt.Errorf("key %s is not used with %s", kid, token.Method.Alg())
+		}
+		return key.key, nil
+	},
+		jwt.WithValidMethods(signingAlgorithms),
+		jwt.WithIssuer(v.issuer),
+		jwt.WithAudience(v.audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(v.leeway),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	identity := &Identity{Subject: claims.Subject, Kind: models.PrincipalService, Scopes: v.scopes(append(claims.Scope, claims.SCP...))}
+	// only subjects known to belong to services act on any user
+	if !slices.Contains(v.services, claims.Subject) {
+		userID, err := uuid.Parse(claims.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("subject %s is neither a user ID nor a known service", claims.Subject)
+		}
+		identity.Kind = models.PrincipalUser
+		identity.UserID = userID
+	}
+
+	// the signature covers the payload so its claims can be taken as they are
+	allClaims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, allClaims); err != nil {
+		return nil, err
+	}
+
+	identity.Claims = allClaims
+	return identity, nil
+}
+
+// scopes maps what the gateway granted onto scopes of this service, anything
+// else is ignored
+func (v *Verifier) scopes(granted []string) []models.Scope {
+	var scopes []models.Scope
+	add := func(scope models.Scope) {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	// the mapping is shared by every request, it is only ever read
+	for _, name := range granted {
+		for _, scope := range v.scopeMapping[name] {
+			add(scope)
+		}
+		if models.IsKnownScope(models.Scope(name)) {
+			add(models.Scope(name))
+		}
+	}
+	return scopes
+}
@@ -0,0 +1,148 @@
+package lockout
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/storage"
+
+	"github.com/google/uuid"
+)
+
+// Policy decides how long a key is blocked after its n-th failure
+type Policy struct {
+	// failures allowed before any delay is imposed
+	FreeAttempts int
+	// delay after the first failure past the free ones, doubled with every
+	// further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// failures after which the key is locked out for LockoutDuration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// quiet period after which failures are forgotten
+	ResetAfter time.Duration
+}
+
+func AccountPolicy(cfg *config.Config) Policy {
+	return Policy{
+		FreeAttempts:     cfg.LockoutFreeAttempts,
+		BaseDelay:        cfg.LockoutBaseDelay,
+		MaxDelay:         cfg.LockoutMaxDelay,
+		LockoutThreshold: cfg.LockoutThreshold,
+		LockoutDuration:  cfg.LockoutDuration,
+		ResetAfter:       cfg.LockoutResetAfter,
+	}
+}
+
+// SourcePolicy is looser than the account one as many users can share an
+// address
+func SourcePolicy(cfg *config.Config) Policy {
+	return Policy{
+		FreeAttempts:     cfg.LockoutSourceFreeAttempts,
+		BaseDelay:        cfg.LockoutBaseDelay,
+		MaxDelay:         cfg.LockoutMaxDelay,
+		LockoutThreshold: cfg.LockoutSourceThreshold,
+		LockoutDuration:  cfg.LockoutDuration,
+		ResetAfter:       cfg.LockoutResetAfter,
+	}
+}
+
+// Delay returns for how long the key is blocked after the given number of
+// failures
+func (p Policy) Delay(failures int) time.Duration {
+	if failures >= p.LockoutThreshold {
+		return p.LockoutDuration
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for range failures - p.FreeAttempts - 1 {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// LockedError is wrapped into the error returned for blocked keys so the API
+// can tell the caller when to come back
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("locked until %s", e.Until.Format(time.RFC3339))
+}
+
+// Guard counts failed attempts per key and blocks keys with too many
+type Guard struct {
+	store  storage.FailureCounterStorage
+	policy Policy
+}
+
+func NewGuard(store storage.FailureCounterStorage, policy Policy) *Guard {
+	return &Guard{store: store, policy: policy}
+}
+
+func AccountKey(userID uuid.UUID) string {
+	return "account:" + userID.String()
+}
+
+// UnknownAccountKey counts attempts against emails nobody is registered with
+func UnknownAccountKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+// PhoneVerificationKey counts wrong SMS codes apart from the account so they
+// do not block logins
+func PhoneVerificationKey(userID uuid.UUID) string {
+	return "phone:" + userID.String()
+}
+
+func SourceKey(address string) string {
+	return "source:" + address
+}
+
+// Check refuses keys that are currently blocked
+func (g *Guard) Check(key string) error {
+	counter, err := g.store.RetrieveFailureCounter(key)
+	if err != nil {
+		if models.ErrorContext(err) == models.ContextNotFound {
+			return nil
+		}
+		return err
+	}
+	if counter.IsLocked(time.Now()) {
+		return models.NewWrappedError(&LockedError{Until: *counter.LockedUntil}, models.ContextTooManyRequests, "too many failed attempts, try again later")
+	}
+	return nil
+}
+
+// Fail records a failed attempt and blocks the key if the policy says so,
+// lockedOut is true when the failure reached the lockout threshold
+func (g *Guard) Fail(key string) (lockedOut bool, err error) {
+	now := time.Now()
+	counter, err := g.store.RecordFailure(key, now, now.Add(-g.policy.ResetAfter))
+	if err != nil {
+		return false, err
+	}
+
+	delay := g.policy.Delay(counter.Failures)
+	if delay <= 0 {
+		return false, nil
+	}
+	if err := g.store.LockFailureCounter(key, now.Add(delay)); err != nil {
+		return false, err
+	}
+	return counter.Failures >= g.policy.LockoutThreshold, nil
+}
+
+// Reset forgets the failures of the key, lifting any block
+func (g *Guard) Reset(key string) error {
+	return g.store.DeleteFailureCounter(key)
+}
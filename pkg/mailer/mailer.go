@@ -0,0 +1,31 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"users-microservice/pkg/config"
+)
+
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+type Mailer interface {
+	Send(context.Context, Message) error
+}
+
+// New builds the mailer selected in the configuration
+func New(cfg *config.Config) (Mailer, error) {
+	switch cfg.Mailer {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom), nil
+	case "file":
+		return NewFileOutbox(cfg.MailOutboxPath)
+	case "stdout", "":
+		return NewStdoutOutbox(), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer '%s'", cfg.Mailer)
+	}
+}
@@ -0,0 +1,78 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// OutboxMailer does not deliver anything, every message is appended as a JSON
+// line to the underlying writer, meant for local runs and tests
+type OutboxMailer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type outboxEntry struct {
+	Message
+	SentAt time.Time `json:"sent_at"`
+}
+
+func NewOutboxMailer(w io.Writer) *OutboxMailer {
+	return &OutboxMailer{w: w}
+}
+
+func NewStdoutOutbox() *OutboxMailer {
+	return NewOutboxMailer(os.Stdout)
+}
+
+func NewFileOutbox(path string) (*OutboxMailer, error) {
+	if path == "" {
+		return nil, fmt.Errorf("outbox path is not set")
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox %s: %w", path, err)
+	}
+	return NewOutboxMailer(file), nil
+}
+
+func (m *OutboxMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(outboxEntry{Message: msg, SentAt: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err = m.w.Write(append(line, '\n'))
+	return err
+}
+
+// ReadOutbox returns all messages written to an outbox file, oldest first
+func ReadOutbox(path string) ([]Message, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var messages []Message
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry outboxEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		messages = append(messages, entry.Message)
+	}
+	return messages, scanner.Err()
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AccessRecord tells who read the data of a user, and what of it
+type AccessRecord struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	// the caller, see AuditActor
+	Accessor string
+	// fields returned unmasked
+	Fields []string
+	// why the caller says it read the user, empty when it did not say
+	Purpose    string
+	RequestID  string
+	AccessedAt time.Time
+}
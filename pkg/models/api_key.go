@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey authenticates a service calling the API. Only the hash of the key is
+// kept, the prefix identifies the key in listings and when it is presented
+type APIKey struct {
+	ID         uuid.UUID
+	Name       string
+	Prefix     string
+	Hash       string
+	Scopes     []Scope
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+func NewAPIKey(name string, prefix string, hash string, scopes []Scope, expiresAt *time.Time) *APIKey {
+	return &APIKey{
+		ID:        uuid.New(),
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+}
+
+// IsExpired reports whether the key can no longer be used, keys without an
+// expiry stay valid until they are revoked
+func (k *APIKey) IsExpired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
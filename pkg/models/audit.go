@@ -0,0 +1,139 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditActionCreate       = "create"
+	AuditActionUpdate       = "update"
+	AuditActionStatusChange = "status_change"
+	AuditActionDelete       = "delete"
+	AuditActionErase        = "erase"
+)
+
+// AuditChange is the value of a field before and after a mutation. Values of
+// personal data are HMACs keyed with a salt of the user rather than the data
+// itself, so equal values still compare equal
+type AuditChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+	Hashed bool   `json:"hashed"`
+}
+
+// AuditEntry is one link of the hash chain of a user's mutations. Every entry
+// covers the hash of the one before, so changing or removing an entry breaks
+// every later one
+type AuditEntry struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	// position in the chain of the user, starting at 1
+	Sequence int64
+	Action   string
+	// who made the change, see AuditActor
+	Actor string
+	// ID of the API request that made the change, empty for changes made
+	// outside of one
+	RequestID string
+	Changes   []AuditChange
+	CreatedAt time.Time
+	// hash of the previous entry, empty for the first one
+	PrevHash string
+	Hash     string
+}
+
+// AuditHead is the last entry of a user's chain, recorded apart from the
+// entries with every append. A chain that was cut short or removed no longer
+// ends at its head
+type AuditHead struct {
+	UserID   uuid.UUID
+	Sequence int64
+	Hash     string
+}
+
+func NewAuditEntry(userID uuid.UUID, action string, actor string, requestID string, changes []AuditChange) *AuditEntry {
+	return &AuditEntry{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Action:    action,
+		Actor:     actor,
+		RequestID: requestID,
+		Changes:   changes,
+		// the database keeps microseconds, anything finer would not hash
+		// the same once read back
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+}
+
+// ComputeHash hashes every field of the entry but the hash itself
+func (e *AuditEntry) ComputeHash() string {
+	payload, _ := json.Marshal(struct {
+		ID        uuid.UUID     `json:"id"`
+		UserID    uuid.UUID     `json:"user_id"`
+		Sequence  int64         `json:"sequence"`
+		Action    string        `json:"action"`
+		Actor     string        `json:"actor"`
+		RequestID string        `json:"request_id"`
+		Changes   []AuditChange `json:"changes"`
+		CreatedAt int64         `json:"created_at"`
+		PrevHash  string        `json:"prev_hash"`
+	}{e.ID, e.UserID, e.Sequence, e.Action, e.Actor, e.RequestID, e.Changes, e.CreatedAt.UnixMicro(), e.PrevHash})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyAuditChain checks the entries of a user, in sequence order, and
+// reports the first that is out of place or altered. The chain has to end at
+// its recorded head, which is nil when none was recorded
+func VerifyAuditChain(entries []AuditEntry, head *AuditHead) error {
+	prevHash := ""
+	for i, entry := range entries {
+		if entry.Sequence != int64(i+1) {
+			return fmt.Errorf("entry %d follows entry %d, entries are missing", entry.Sequence, i)
+		}
+		if entry.PrevHash != prevHash {
+			return fmt.Errorf("entry %d does not link to the entry before", entry.Sequence)
+		}
+		if entry.ComputeHash() != entry.Hash {
+			return fmt.Errorf("entry %d has been altered", entry.Sequence)
+		}
+		prevHash = entry.Hash
+	}
+
+	if head == nil {
+		return fmt.Errorf("chain of %d entries has no recorded head", len(entries))
+	}
+	if int64(len(entries)) != head.Sequence {
+		return fmt.Errorf("chain ends at entry %d but its head is entry %d, entries are missing", len(entries), head.Sequence)
+	}
+	if prevHash != head.Hash {
+		return fmt.Errorf("entry %d is not the recorded head", head.Sequence)
+	}
+	return nil
+}
+
+// AuditActor names the caller for the audit log, "user:<id>",
+// "service:<subject>" or the subject of the system principal, e.g. "public"
+// for users authenticating themselves by following an emailed link
+func AuditActor(ctx context.Context) string {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return "unknown"
+	}
+	switch principal.Kind {
+	case PrincipalUser:
+		return "user:" + principal.UserID.String()
+	case PrincipalSystem:
+		return principal.Subject
+	}
+	return "service:" + principal.Subject
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Password of a user, only the encoded argon2id hash is kept
+type PasswordCredential struct {
+	UserID    uuid.UUID
+	Hash      string
+	UpdatedAt time.Time
+}
+
+func NewPasswordCredential(userID uuid.UUID, hash string) *PasswordCredential {
+	return &PasswordCredential{
+		UserID:    userID,
+		Hash:      hash,
+		UpdatedAt: time.Now(),
+	}
+}
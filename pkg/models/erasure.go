@@ -0,0 +1,61 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Values the personal data of erased users is replaced with, the email stays
+// unique so the address itself is free again
+const ErasedName = "Erased User"
+
+func ErasedEmail(userID uuid.UUID) string {
+	return fmt.Sprintf("erased-%s@erased.invalid", userID)
+}
+
+// ErasureCertificate proves the personal data of a user was erased, and
+// what of it. It holds nothing personal, so it is kept for good
+type ErasureCertificate struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	// who asked for the erasure, see AuditActor
+	ErasedBy  string
+	RequestID string
+	// the data that was erased or anonymized, e.g. "profile.email"
+	Erased   []string
+	ErasedAt time.Time
+	// covers every other field, so changes to the certificate show
+	Hash string
+}
+
+func NewErasureCertificate(userID uuid.UUID, erasedBy string, requestID string, erased []string) *ErasureCertificate {
+	certificate := &ErasureCertificate{
+		ID:        uuid.New(),
+		UserID:    userID,
+		ErasedBy:  erasedBy,
+		RequestID: requestID,
+		Erased:    erased,
+		// microseconds survive the database, see NewAuditEntry
+		ErasedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	certificate.Hash = certificate.ComputeHash()
+	return certificate
+}
+
+func (c *ErasureCertificate) ComputeHash() string {
+	payload, _ := json.Marshal(struct {
+		ID        uuid.UUID `json:"id"`
+		UserID    uuid.UUID `json:"user_id"`
+		ErasedBy  string    `json:"erased_by"`
+		RequestID string    `json:"request_id"`
+		Erased    []string  `json:"erased"`
+		ErasedAt  int64     `json:"erased_at"`
+	}{c.ID, c.UserID, c.ErasedBy, c.RequestID, c.Erased, c.ErasedAt.UnixMicro()})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
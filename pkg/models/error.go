@@ -27,6 +27,9 @@ var (
 	ContextInternalServer     = "internal_server_error"
 	ContextConflictValue      = "value_conflict"
 	ContextBadRequest         = "bad_request"
+	ContextTooManyRequests    = "too_many_requests"
+	ContextUnauthorized       = "unauthorized"
+	ContextForbidden          = "forbidden"
 )
 
 func (e *WrappedError) Error() string {
@@ -48,3 +51,17 @@ func (e *InternalError) Error() string {
 func NewInternalError(context string, reason string) *InternalError {
 	return &InternalError{Context: context, Reason: reason}
 }
+
+// ErrorContext returns the context of a wrapped or internal error, errors
+// without one are treated as internal server errors
+func ErrorContext(err error) string {
+	var wrappedErr *WrappedError
+	if errors.As(err, &wrappedErr) {
+		return wrappedErr.Context
+	}
+	var internalErr *InternalError
+	if errors.As(err, &internalErr) {
+		return internalErr.Context
+	}
+	return ContextInternalServer
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Types of the events published to downstream systems
+const (
+	EventAccountLockedOut   = "account.locked_out"
+	EventPersonalDataErased = "user.personal_data_erased"
+)
+
+// Event tells downstream systems about something that happened to a user.
+// Events are stored with the change that caused them and published
+// afterwards, at least once, consumers tell repeats apart by the ID
+type Event struct {
+	ID     uuid.UUID
+	Type   string
+	UserID uuid.UUID
+	// JSON document specific to the type
+	Data        json.RawMessage
+	CreatedAt   time.Time
+	PublishedAt *time.Time
+}
+
+func NewEvent(eventType string, userID uuid.UUID, data any) (*Event, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{
+		ID:        uuid.New(),
+		Type:      eventType,
+		UserID:    userID,
+		Data:      payload,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ExportStatus string
+
+const (
+	ExportStatusPending   ExportStatus = "pending"
+	ExportStatusRunning   ExportStatus = "running"
+	ExportStatusCompleted ExportStatus = "completed"
+	ExportStatusFailed    ExportStatus = "failed"
+)
+
+// PersonalDataExport is a job gathering everything held about a user into
+// an archive, the archive is kept encrypted until the export expires
+type PersonalDataExport struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	Status ExportStatus
+	// who asked for the export, see AuditActor
+	RequestedBy string
+	RequestID   string
+	// empty until the export is completed
+	EncryptedArchive string
+	// the outstanding download link, empty until it is first asked for and
+	// again once it is used
+	EncryptedDownloadToken string
+	DownloadExpiresAt      *time.Time
+	// why the export failed
+	Error       string
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+	// the export and its archive are deleted afterwards
+	ExpiresAt time.Time
+}
+
+func NewPersonalDataExport(userID uuid.UUID, requestedBy string, requestID string, ttl time.Duration) *PersonalDataExport {
+	now := time.Now()
+	return &PersonalDataExport{
+		ID:          uuid.New(),
+		UserID:      userID,
+		Status:      ExportStatusPending,
+		RequestedBy: requestedBy,
+		RequestID:   requestID,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+	}
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FederatedIdentity links an account of an external OpenID provider to a
+// user, who can then sign in with it. A user has at most one per provider
+type FederatedIdentity struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	// configured name of the provider
+	Provider string
+	// issuer and subject identify the account at the provider
+	Issuer     string
+	Subject    string
+	Email      string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+func NewFederatedIdentity(userID uuid.UUID, provider string, issuer string, subject string, email string) *FederatedIdentity {
+	return &FederatedIdentity{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Provider:  provider,
+		Issuer:    issuer,
+		Subject:   subject,
+		Email:     email,
+		CreatedAt: time.Now(),
+	}
+}
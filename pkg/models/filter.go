@@ -0,0 +1,85 @@
+package models
+
+import "slices"
+
+// UserAttribute is a field of the user that filters can compare
+type UserAttribute string
+
+const (
+	UserAttributeID          UserAttribute = "id"
+	UserAttributeName        UserAttribute = "name"
+	UserAttributeEmail       UserAttribute = "email"
+	UserAttributePhoneNumber UserAttribute = "phone_number"
+	UserAttributeDateOfBirth UserAttribute = "date_of_birth"
+	UserAttributeStatus      UserAttribute = "status"
+	UserAttributeCreatedAt   UserAttribute = "created_at"
+)
+
+type FilterOperator string
+
+const (
+	FilterEqual          FilterOperator = "eq"
+	FilterNotEqual       FilterOperator = "ne"
+	FilterContains       FilterOperator = "co"
+	FilterStartsWith     FilterOperator = "sw"
+	FilterEndsWith       FilterOperator = "ew"
+	FilterGreater        FilterOperator = "gt"
+	FilterGreaterOrEqual FilterOperator = "ge"
+	FilterLess           FilterOperator = "lt"
+	FilterLessOrEqual    FilterOperator = "le"
+	// attribute has a value
+	FilterPresent FilterOperator = "pr"
+	FilterAnd     FilterOperator = "and"
+	FilterOr      FilterOperator = "or"
+	FilterNot     FilterOperator = "not"
+)
+
+// UserFilter selects users, either by comparing an attribute with the value
+// or by combining the operands with and, or and not. Values are strings, text
+// comparisons ignore case, times and UUIDs are compared as such
+type UserFilter struct {
+	Operator  FilterOperator
+	Attribute UserAttribute
+	Value     any
+	Operands  []UserFilter
+}
+
+func NewUserComparison(attribute UserAttribute, operator FilterOperator, value any) UserFilter {
+	return UserFilter{Operator: operator, Attribute: attribute, Value: value}
+}
+
+func NewUserFilterAnd(operands ...UserFilter) UserFilter {
+	return UserFilter{Operator: FilterAnd, Operands: operands}
+}
+
+func NewUserFilterOr(operands ...UserFilter) UserFilter {
+	return UserFilter{Operator: FilterOr, Operands: operands}
+}
+
+func NewUserFilterNot(operand UserFilter) UserFilter {
+	return UserFilter{Operator: FilterNot, Operands: []UserFilter{operand}}
+}
+
+// personalDataAttributes maps attributes holding personal data onto their
+// field, see PersonalDataFields
+var personalDataAttributes = map[UserAttribute]string{
+	UserAttributeEmail:       FieldEmail,
+	UserAttributePhoneNumber: FieldPhoneNumber,
+	UserAttributeDateOfBirth: FieldDateOfBirth,
+}
+
+// PersonalDataFields returns the fields of personal data the filter compares
+func (f UserFilter) PersonalDataFields() []string {
+	var fields []string
+	if field, ok := personalDataAttributes[f.Attribute]; ok {
+		fields = append(fields, field)
+	}
+	for _, operand := range f.Operands {
+		for _, field := range operand.PersonalDataFields() {
+			if !slices.Contains(fields, field) {
+				fields = append(fields, field)
+			}
+		}
+	}
+	return fields
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Events recorded in a user's history
+const (
+	HistoryEventStatusChanged       = "status_changed"
+	HistoryEventEmailChangeStarted  = "email_change_requested"
+	HistoryEventEmailChanged        = "email_changed"
+	HistoryEventEmailChangeReverted = "email_change_reverted"
+	HistoryEventPasswordSet         = "password_set"
+	HistoryEventPasswordChanged     = "password_changed"
+	HistoryEventPasswordReset       = "password_reset"
+	HistoryEventMFAEnabled          = "mfa_enabled"
+	HistoryEventMFAReset            = "mfa_reset"
+	HistoryEventAccountLocked       = "account_locked"
+	HistoryEventAccountUnlocked     = "account_unlocked"
+	HistoryEventPasskeyAdded        = "passkey_added"
+	HistoryEventPasskeyRemoved      = "passkey_removed"
+	HistoryEventPhoneChanged        = "phone_changed"
+	HistoryEventPhoneVerified       = "phone_verified"
+	HistoryEventIdentityLinked      = "identity_linked"
+	HistoryEventIdentityUnlinked    = "identity_unlinked"
+	HistoryEventPersonalDataErased  = "personal_data_erased"
+)
+
+// Single entry of the per user history, status transitions keep both sides
+// of the change, other events leave them empty
+type UserHistoryEntry struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Event      string
+	FromStatus UserStatus
+	ToStatus   UserStatus
+	Reason     string
+	CreatedAt  time.Time
+}
+
+func NewUserHistoryEntry(userID uuid.UUID, event string, reason string) *UserHistoryEntry {
+	return &UserHistoryEntry{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Event:     event,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+}
+
+func NewStatusChangeEntry(userID uuid.UUID, from UserStatus, to UserStatus, reason string) *UserHistoryEntry {
+	entry := NewUserHistoryEntry(userID, HistoryEventStatusChanged, reason)
+	entry.FromStatus = from
+	entry.ToStatus = to
+	return entry
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// FailureCounter tracks the failed attempts made against one account or from
+// one source address
+type FailureCounter struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+func (c *FailureCounter) IsLocked(now time.Time) bool {
+	return c.LockedUntil != nil && now.Before(*c.LockedUntil)
+}
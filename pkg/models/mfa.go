@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TOTP second factor of a user, the secret is kept encrypted and the factor
+// only counts once the user confirmed it with a first code
+type TOTPCredential struct {
+	UserID          uuid.UUID
+	EncryptedSecret string
+	ConfirmedAt     *time.Time
+	// last accepted time step, codes from it or earlier are refused
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+func NewTOTPCredential(userID uuid.UUID, encryptedSecret string) *TOTPCredential {
+	return &TOTPCredential{
+		UserID:          userID,
+		EncryptedSecret: encryptedSecret,
+		CreatedAt:       time.Now(),
+	}
+}
+
+func (c *TOTPCredential) IsConfirmed() bool {
+	return c.ConfirmedAt != nil
+}
+
+// One-time code to sign in when the authenticator is lost, stored hashed
+type RecoveryCode struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	CodeHash  string
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func NewRecoveryCode(userID uuid.UUID, codeHash string) *RecoveryCode {
+	return &RecoveryCode{
+		ID:        uuid.New(),
+		UserID:    userID,
+		CodeHash:  codeHash,
+		CreatedAt: time.Now(),
+	}
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// OIDCClient is an application allowed to sign users in through this service,
+// public clients have no secret and rely on PKCE alone
+type OIDCClient struct {
+	ID           string
+	Name         string
+	SecretHash   string
+	RedirectURIs []string
+	CreatedAt    time.Time
+}
+
+func NewOIDCClient(id string, name string, secretHash string, redirectURIs []string) *OIDCClient {
+	return &OIDCClient{
+		ID:           id,
+		Name:         name,
+		SecretHash:   secretHash,
+		RedirectURIs: redirectURIs,
+		CreatedAt:    time.Now(),
+	}
+}
+
+func (c *OIDCClient) IsPublic() bool {
+	return c.SecretHash == ""
+}
+
+// AllowsRedirectURI compares exactly, registered URIs are not patterns
+func (c *OIDCClient) AllowsRedirectURI(uri string) bool {
+	return slices.Contains(c.RedirectURIs, uri)
+}
+
+// OIDCSigningKey signs ID and access tokens, the newest key is used while
+// older ones stay published until tokens signed with them have expired
+type OIDCSigningKey struct {
+	ID        string
+	Algorithm string
+	// PKCS #8 private key sealed with the service cipher
+	EncryptedKey string
+	CreatedAt    time.Time
+}
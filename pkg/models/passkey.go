@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Passkey is a WebAuthn credential a user can sign in with instead of the
+// password, users name them to tell their devices apart
+type Passkey struct {
+	ID           uuid.UUID
+	UserID       uuid.UUID
+	Name         string
+	CredentialID []byte
+	// COSE encoded public key
+	PublicKey  []byte
+	SignCount  uint32
+	AAGUID     []byte
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+func NewPasskey(userID uuid.UUID, name string, credentialID []byte, publicKey []byte, signCount uint32, aaguid []byte) *Passkey {
+	return &Passkey{
+		ID:           uuid.New(),
+		UserID:       userID,
+		Name:         name,
+		CredentialID: credentialID,
+		PublicKey:    publicKey,
+		SignCount:    signCount,
+		AAGUID:       aaguid,
+		CreatedAt:    time.Now(),
+	}
+}
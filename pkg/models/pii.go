@@ -0,0 +1,108 @@
+package models
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Personal data of users, masked for callers that may not see it and by
+// policies
+const (
+	FieldEmail        = "email"
+	FieldPendingEmail = "pending_email"
+	FieldDateOfBirth  = "date_of_birth"
+	FieldPhoneNumber  = "phone_number"
+)
+
+var PersonalDataFields = []string{FieldEmail, FieldPendingEmail, FieldDateOfBirth, FieldPhoneNumber}
+
+type fieldMasksContextKey struct{}
+
+// fieldMasks collects the fields policies masked while a request is served
+type fieldMasks struct {
+	mu     sync.Mutex
+	fields []string
+}
+
+// ContextWithFieldMasks lets policies mask fields of the users the request
+// returns
+func ContextWithFieldMasks(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fieldMasksContextKey{}, &fieldMasks{})
+}
+
+// MaskFields masks the fields of every user the request returns, outside of
+// requests there is nothing to mask
+func MaskFields(ctx context.Context, fields []string) {
+	masks, ok := ctx.Value(fieldMasksContextKey{}).(*fieldMasks)
+	if !ok {
+		return
+	}
+	masks.mu.Lock()
+	defer masks.mu.Unlock()
+	for _, field := range fields {
+		if !slices.Contains(masks.fields, field) {
+			masks.fields = append(masks.fields, field)
+		}
+	}
+}
+
+// MaskedFields returns the personal data of the user the caller gets masked,
+// all of it when the caller may not see personal data and otherwise what
+// policies masked
+func MaskedFields(ctx context.Context, userID uuid.UUID) []string {
+	if !SeesPersonalData(ctx, userID) {
+		return PersonalDataFields
+	}
+	masks, ok := ctx.Value(fieldMasksContextKey{}).(*fieldMasks)
+	if !ok {
+		return nil
+	}
+	masks.mu.Lock()
+	defer masks.mu.Unlock()
+	return slices.Clone(masks.fields)
+}
+
+// SeesPersonalData reports whether the caller may see personal data of the
+// user unmasked. Users see their own, services need the PII scope and the
+// system sees everything. Calls without a principal see nothing
+func SeesPersonalData(ctx context.Context, userID uuid.UUID) bool {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return false
+	}
+	switch principal.Kind {
+	case PrincipalUser:
+		return principal.UserID == userID
+	case PrincipalSystem:
+		return true
+	}
+	return principal.HasScope(ScopeUsersPII)
+}
+
+// MaskEmail keeps the first character and the domain, e.g. j***@example.com
+func MaskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return "***"
+	}
+	first, _ := utf8.DecodeRuneInString(email)
+	return string(first) + "***" + email[at:]
+}
+
+// MaskPhoneNumber keeps the last two digits, e.g. +*********67
+func MaskPhoneNumber(phoneNumber string) string {
+	digits := strings.TrimPrefix(phoneNumber, "+")
+	if len(digits) <= 2 {
+		return "***"
+	}
+	masked := strings.Repeat("*", len(digits)-2) + digits[len(digits)-2:]
+	if len(digits) < len(phoneNumber) {
+		masked = "+" + masked
+	}
+	return masked
+}
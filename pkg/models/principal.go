@@ -0,0 +1,57 @@
+package models
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type PrincipalKind string
+
+const (
+	// services act on any user as far as their scopes allow
+	PrincipalService PrincipalKind = "service"
+	// users act on their own account
+	PrincipalUser PrincipalKind = "user"
+	// the service itself, on public routes where users authenticate with
+	// their own credentials and in tools run next to the database
+	PrincipalSystem PrincipalKind = "system"
+)
+
+// SystemSubjectPublic is the system principal of public routes
+const SystemSubjectPublic = "public"
+
+// Principal is the authenticated caller of the API
+type Principal struct {
+	Kind PrincipalKind
+	// ID of the API key or subject of the bearer token
+	Subject string
+	// account of user principals
+	UserID uuid.UUID
+	Scopes []Scope
+	// claims of the bearer token, nil for API keys
+	Claims map[string]any
+}
+
+// SystemPrincipal is trusted with everything, the subject names what it does
+func SystemPrincipal(subject string) *Principal {
+	return &Principal{Kind: PrincipalSystem, Subject: subject}
+}
+
+func (p *Principal) HasScope(scope Scope) bool {
+	return GrantsScope(p.Scopes, scope)
+}
+
+type principalContextKey struct{}
+
+// ContextWithPrincipal hands the caller on to whatever serves the request
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFromContext returns the caller, there is none when the request
+// was never authenticated
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
@@ -0,0 +1,33 @@
+package models
+
+import "context"
+
+type requestIDContextKey struct{}
+
+// ContextWithRequestID hands the ID of the API request on, so records made
+// while serving it can name it
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, requestID)
+}
+
+// RequestIDFromContext returns the ID of the API request, empty outside of
+// one
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return requestID
+}
+
+type accessPurposeContextKey struct{}
+
+// ContextWithAccessPurpose hands on why the caller reads data, as it stated
+// in the request
+func ContextWithAccessPurpose(ctx context.Context, purpose string) context.Context {
+	return context.WithValue(ctx, accessPurposeContextKey{}, purpose)
+}
+
+// AccessPurposeFromContext returns the stated purpose, empty when the caller
+// did not give one
+func AccessPurposeFromContext(ctx context.Context) string {
+	purpose, _ := ctx.Value(accessPurposeContextKey{}).(string)
+	return purpose
+}
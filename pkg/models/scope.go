@@ -0,0 +1,40 @@
+package models
+
+import "slices"
+
+// Scope is a permission granted to a caller of the API
+type Scope string
+
+const (
+	ScopeUsersRead  Scope = "users:read"
+	ScopeUsersWrite Scope = "users:write"
+	ScopeUsersAdmin Scope = "users:admin"
+	// personal data is masked for services without it, no other scope
+	// includes it
+	ScopeUsersPII Scope = "users:pii"
+)
+
+// impliedScopes lists what a scope includes besides itself, administrators
+// can write and whoever can write can read
+var impliedScopes = map[Scope][]Scope{
+	ScopeUsersAdmin: {ScopeUsersWrite, ScopeUsersRead},
+	ScopeUsersWrite: {ScopeUsersRead},
+}
+
+func IsKnownScope(scope Scope) bool {
+	switch scope {
+	case ScopeUsersRead, ScopeUsersWrite, ScopeUsersAdmin, ScopeUsersPII:
+		return true
+	}
+	return false
+}
+
+// GrantsScope reports whether holding the scopes allows what required does
+func GrantsScope(held []Scope, required Scope) bool {
+	for _, scope := range held {
+		if scope == required || slices.Contains(impliedScopes[scope], required) {
+			return true
+		}
+	}
+	return false
+}
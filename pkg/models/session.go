@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is one signed-in device, its refresh tokens form a family that is
+// rotated on every use and revoked together
+type Session struct {
+	ID            uuid.UUID
+	UserID        uuid.UUID
+	UserAgent     string
+	IPAddress     string
+	CreatedAt     time.Time
+	LastUsedAt    time.Time
+	ExpiresAt     time.Time
+	RevokedAt     *time.Time
+	RevokedReason string
+}
+
+func NewSession(userID uuid.UUID, userAgent string, ipAddress string, maxAge time.Duration) *Session {
+	now := time.Now()
+	return &Session{
+		ID:         uuid.New(),
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IPAddress:  ipAddress,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(maxAge),
+	}
+}
+
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && s.ExpiresAt.After(time.Now())
+}
+
+// Refresh token of a session, only its hash is kept
+type RefreshToken struct {
+	ID        uuid.UUID
+	SessionID uuid.UUID
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// NewRefreshToken never outlives the session it belongs to
+func NewRefreshToken(session *Session, tokenHash string, ttl time.Duration) *RefreshToken {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	if expiresAt.After(session.ExpiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+	return &RefreshToken{
+		ID:        uuid.New(),
+		SessionID: session.ID,
+		TokenHash: tokenHash,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type TokenPurpose string
+
+const (
+	TokenPurposeEmailVerification TokenPurpose = "email_verification"
+	TokenPurposeEmailChange       TokenPurpose = "email_change"
+	TokenPurposeEmailRevert       TokenPurpose = "email_revert"
+	TokenPurposePasswordReset     TokenPurpose = "password_reset"
+	TokenPurposeMFAChallenge      TokenPurpose = "mfa_challenge"
+	TokenPurposeMagicLink         TokenPurpose = "magic_link"
+	TokenPurposePasskeyRegister   TokenPurpose = "passkey_registration"
+	TokenPurposePasskeyLogin      TokenPurpose = "passkey_login"
+	TokenPurposePhoneVerification TokenPurpose = "phone_verification"
+	TokenPurposeOIDCCode          TokenPurpose = "oidc_authorization_code"
+	TokenPurposeFederatedLogin    TokenPurpose = "federated_login"
+	TokenPurposeFederatedLink     TokenPurpose = "federated_link"
+	TokenPurposeFederatedSignup   TokenPurpose = "federated_signup"
+	TokenPurposeExportDownload    TokenPurpose = "export_download"
+)
+
+// Single-use token handed out to a user, only the hash of the token is kept
+type UserToken struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Purpose   TokenPurpose
+	TokenHash string
+	// purpose specific data, e.g. the address an email change is confirming
+	Payload    string
+	ExpiresAt  time.Time
+	ConsumedAt *time.Time
+	CreatedAt  time.Time
+}
+
+func NewUserToken(userID uuid.UUID, purpose TokenPurpose, tokenHash string, ttl time.Duration) *UserToken {
+	now := time.Now()
+	return &UserToken{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: tokenHash,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+}
@@ -6,13 +6,40 @@ import (
 	"github.com/google/uuid"
 )
 
+type UserStatus string
+
+const (
+	UserStatusPending     UserStatus = "pending"
+	UserStatusActive      UserStatus = "active"
+	UserStatusSuspended   UserStatus = "suspended"
+	UserStatusDeactivated UserStatus = "deactivated"
+	UserStatusDeleted     UserStatus = "deleted"
+)
+
 // BU representation for users
 type User struct {
 	ID    uuid.UUID
 	Name  string
 	Email string
 	// convert to age maybe
-	DateOfBirth time.Time
+	DateOfBirth     time.Time
+	Status          UserStatus
+	EmailVerifiedAt *time.Time
+	// requested address that becomes the email once confirmed
+	PendingEmail          string
+	PendingEmailExpiresAt *time.Time
+	// E.164 formatted, empty when the user has not given one
+	PhoneNumber     string
+	PhoneVerifiedAt *time.Time
+	// ISO 3166 country code the user belongs to, e.g. "DE", empty when not
+	// known. Policies can decide on it
+	Region    string
+	CreatedAt time.Time
+}
+
+// HasPendingEmail reports whether an email change is waiting for confirmation
+func (u *User) HasPendingEmail() bool {
+	return u.PendingEmail != "" && u.PendingEmailExpiresAt != nil && u.PendingEmailExpiresAt.After(time.Now())
 }
 
 func NewUser(id uuid.UUID, name string, email string, dateOfBirth time.Time) *User {
@@ -21,5 +48,6 @@ func NewUser(id uuid.UUID, name string, email string, dateOfBirth time.Time) *Us
 		DateOfBirth: dateOfBirth,
 		Name:        name,
 		Email:       email,
+		Status:      UserStatusPending,
 	}
 }
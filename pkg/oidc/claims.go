@@ -0,0 +1,111 @@
+package oidc
+
+import (
+	"slices"
+	"strings"
+	"users-microservice/pkg/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// AccessTokenType marks access tokens so they cannot be passed off as ID
+// tokens and the other way round, see RFC 9068
+const AccessTokenType = "at+jwt"
+
+var supportedScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// UserClaims are the standard claims released for the granted scopes
+type UserClaims struct {
+	Name          string `json:"name,omitempty"`
+	Birthdate     string `json:"birthdate,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+}
+
+type IDTokenClaims struct {
+	Nonce    string           `json:"nonce,omitempty"`
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	UserClaims
+	jwt.RegisteredClaims
+}
+
+type AccessTokenClaims struct {
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+	jwt.RegisteredClaims
+}
+
+// UserInfo is the response of the userinfo endpoint
+type UserInfo struct {
+	Subject string `json:"sub"`
+	UserClaims
+}
+
+func NewUserClaims(user *models.User, scopes []string) UserClaims {
+	var claims UserClaims
+	if slices.Contains(scopes, ScopeProfile) {
+		claims.Name = user.Name
+		if !user.DateOfBirth.IsZero() {
+			claims.Birthdate = user.DateOfBirth.Format("2006-01-02")
+		}
+	}
+	if slices.Contains(scopes, ScopeEmail) {
+		verified := user.EmailVerifiedAt != nil
+		claims.Email = user.Email
+		claims.EmailVerified = &verified
+	}
+	return claims
+}
+
+// ParseScope splits a scope parameter and drops scopes that are not
+// supported, the protocol asks for unknown ones to be ignored
+func ParseScope(scope string) []string {
+	var scopes []string
+	for _, s := range strings.Fields(scope) {
+		if slices.Contains(supportedScopes, s) && !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// ProviderMetadata is served as the discovery document
+type ProviderMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+func NewProviderMetadata(issuer string) *ProviderMetadata {
+	return &ProviderMetadata{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth2/authorize",
+		TokenEndpoint:                     issuer + "/oauth2/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   supportedScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwt.SigningMethodRS256.Alg()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{CodeChallengeMethodS256},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "name", "birthdate", "email", "email_verified"},
+	}
+}
@@ -0,0 +1,67 @@
+package oidc
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// error codes of RFC 6749 and OpenID Connect Core
+const (
+	ErrorInvalidRequest          = "invalid_request"
+	ErrorInvalidClient           = "invalid_client"
+	ErrorInvalidGrant            = "invalid_grant"
+	ErrorInvalidScope            = "invalid_scope"
+	ErrorInvalidToken            = "invalid_token"
+	ErrorUnsupportedGrantType    = "unsupported_grant_type"
+	ErrorUnsupportedResponseType = "unsupported_response_type"
+	ErrorAccessDenied            = "access_denied"
+	ErrorServerError             = "server_error"
+)
+
+// Error is reported to clients in the shape the protocol defines instead of
+// the usual API envelope
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func NewError(code string, description string) *Error {
+	return &Error{Code: code, Description: description}
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// AsError finds the protocol error in err, nil for anything unexpected
+func AsError(err error) *Error {
+	var oidcErr *Error
+	if errors.As(err, &oidcErr) {
+		return oidcErr
+	}
+	return nil
+}
+
+// RedirectURL appends the parameters to a registered redirect URI, which may
+// already carry a query of its own
+func RedirectURL(redirectURI string, params url.Values) string {
+	separator := "?"
+	if strings.Contains(redirectURI, "?") {
+		separator = "&"
+	}
+	return redirectURI + separator + params.Encode()
+}
+
+// ErrorRedirectURL sends the error back to the client, state is echoed so the
+// client can match the response to its request
+func ErrorRedirectURL(redirectURI string, state string, issuer string, err *Error) string {
+	params := url.Values{"error": {err.Code}, "iss": {issuer}}
+	if err.Description != "" {
+		params.Set("error_description", err.Description)
+	}
+	if state != "" {
+		params.Set("state", state)
+	}
+	return RedirectURL(redirectURI, params)
+}
@@ -0,0 +1,228 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"users-microservice/pkg/encryption"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/storage"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	rsaKeyBits = 2048
+	// keys rotated by another replica are picked up after at most this long,
+	// or sooner when a token signed with an unknown key shows up
+	keyCacheTTL = time.Minute
+	// unknown keys reload at most this often so made up key IDs cannot keep
+	// hitting the database
+	keyReloadInterval = 5 * time.Second
+)
+
+type signingKey struct {
+	id        string
+	createdAt time.Time
+	private   *rsa.PrivateKey
+}
+
+// KeySet signs tokens with the newest RSA key and rotates it once it is older
+// than the rotation period, replaced keys stay published for the retention
+// period so tokens signed with them can still be verified
+type KeySet struct {
+	store     storage.OIDCStorage
+	cipher    *encryption.Cipher
+	rotation  time.Duration
+	retention time.Duration
+
+	mu       sync.Mutex
+	keys     []signingKey
+	loadedAt time.Time
+}
+
+func NewKeySet(store storage.OIDCStorage, cipher *encryption.Cipher, rotation time.Duration, retention time.Duration) *KeySet {
+	return &KeySet{store: store, cipher: cipher, rotation: rotation, retention: retention}
+}
+
+// Sign issues a token of the given type signed with the current key, an
+// empty type leaves the default "JWT"
+func (ks *KeySet) Sign(tokenType string, claims jwt.Claims) (string, error) {
+	key, err := ks.current()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.id
+	if tokenType != "" {
+		token.Header["typ"] = tokenType
+	}
+	return token.SignedString(key.private)
+}
+
+// Parse verifies a token signed with one of the published keys
+func (ks *KeySet) Parse(tokenString string, claims jwt.Claims, options ...jwt.ParserOption) (*jwt.Token, error) {
+	options = append(options, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return ks.publicKey(kid)
+	}, options...)
+}
+
+// JWKS returns the public keys clients verify tokens with
+func (ks *KeySet) JWKS() (*JSONWebKeySet, error) {
+	if _, err := ks.current(); err != nil {
+		return nil, err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	set := &JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range ks.published() {
+		set.Keys = append(set.Keys, NewRSAJSONWebKey(key.id, &key.private.PublicKey))
+	}
+	return set, nil
+}
+
+// Rotate starts signing with a new key right away and returns its ID
+func (ks *KeySet) Rotate() (string, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	key, err := ks.generate()
+	if err != nil {
+		return "", err
+	}
+	return key.id, nil
+}
+
+func (ks *KeySet) current() (*signingKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if time.Since(ks.loadedAt) > keyCacheTTL {
+		if err := ks.load(); err != nil {
+			return nil, err
+		}
+	}
+	if len(ks.keys) == 0 || time.Since(ks.keys[0].createdAt) > ks.rotation {
+		return ks.generate()
+	}
+	return &ks.keys[0], nil
+}
+
+func (ks *KeySet) publicKey(kid string) (*rsa.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if key := ks.find(kid); key != nil {
+		return &key.private.PublicKey, nil
+	}
+	if time.Since(ks.loadedAt) < keyReloadInterval {
+		return nil, errors.New("token is signed with an unknown key")
+	}
+	if err := ks.load(); err != nil {
+		return nil, err
+	}
+	if key := ks.find(kid); key != nil {
+		return &key.private.PublicKey, nil
+	}
+	return nil, errors.New("token is signed with an unknown key")
+}
+
+func (ks *KeySet) find(kid string) *signingKey {
+	for _, key := range ks.published() {
+		if key.id == kid {
+			return &key
+		}
+	}
+	return nil
+}
+
+// published is the newest key and every key replaced less than the retention
+// period ago, keys are kept newest first
+func (ks *KeySet) published() []signingKey {
+	now := time.Now()
+	for i := 1; i < len(ks.keys); i++ {
+		if now.Sub(ks.keys[i-1].createdAt) > ks.retention {
+			return ks.keys[:i]
+		}
+	}
+	return ks.keys
+}
+
+func (ks *KeySet) load() error {
+	stored, err := ks.store.RetrieveOIDCSigningKeys(time.Time{})
+	if err != nil {
+		return err
+	}
+
+	keys := make([]signingKey, 0, len(stored))
+	for _, key := range stored {
+		der, err := ks.cipher.Decrypt(key.EncryptedKey, []byte(key.ID))
+		if err != nil {
+			// sealed with a key this replica does not have, e.g. a random
+			// one from before a restart, a fresh key takes over
+			log.Printf("WARNING: skipping signing key %s: %v", key.ID, err)
+			continue
+		}
+		private, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return fmt.Errorf("failed to parse signing key %s: %w", key.ID, err)
+		}
+		rsaKey, ok := private.(*rsa.PrivateKey)
+		if !ok {
+			return fmt.Errorf("signing key %s is not an RSA key", key.ID)
+		}
+		keys = append(keys, signingKey{id: key.ID, createdAt: key.CreatedAt, private: rsaKey})
+	}
+	ks.keys = keys
+	ks.loadedAt = time.Now()
+	return nil
+}
+
+// generate stores a new key, replicas rotating at the same time each add one
+// which is harmless as all of them get published
+func (ks *KeySet) generate() (*signingKey, error) {
+	private, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newKeyID()
+	if err != nil {
+		return nil, err
+	}
+	key := signingKey{id: id, createdAt: time.Now(), private: private}
+	sealed, err := ks.cipher.Encrypt(der, []byte(key.id))
+	if err != nil {
+		return nil, err
+	}
+	if err := ks.store.CreateOIDCSigningKey(&models.OIDCSigningKey{
+		ID:           key.id,
+		Algorithm:    jwt.SigningMethodRS256.Alg(),
+		EncryptedKey: sealed,
+		CreatedAt:    key.createdAt,
+	}); err != nil {
+		return nil, err
+	}
+
+	ks.keys = append([]signingKey{key}, ks.keys...)
+	return &ks.keys[0], nil
+}
+
+func newKeyID() (string, error) {
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", raw), nil
+}
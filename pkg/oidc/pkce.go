@@ -0,0 +1,39 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+// S256 is the only code challenge method accepted, plain challenges would
+// leak the verifier together with the authorization request
+const CodeChallengeMethodS256 = "S256"
+
+var (
+	codeVerifierPattern  = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+	codeChallengePattern = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+)
+
+// ValidCodeChallenge reports whether the challenge looks like a base64url
+// encoded SHA-256 digest
+func ValidCodeChallenge(challenge string) bool {
+	return codeChallengePattern.MatchString(challenge)
+}
+
+// VerifyCodeChallenge checks the verifier sent to the token endpoint against
+// the challenge sent with the authorization request
+func VerifyCodeChallenge(verifier string, challenge string) bool {
+	if !codeVerifierPattern.MatchString(verifier) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(CodeChallenge(verifier)), []byte(challenge)) == 1
+}
+
+// CodeChallenge derives the S256 challenge sent along with the
+// authorization request from the verifier kept back for the token request
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,103 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const rangePrefixLength = 5
+
+// BreachedList looks passwords up in a local copy of a breached password
+// corpus, the file holds one uppercase SHA-1 hash per line optionally
+// followed by ":count" and has to be sorted by hash. Like the k-anonymity
+// range API only the block of lines sharing the 5 character prefix is read
+// for a lookup, so the file itself never has to fit into memory.
+type BreachedList struct {
+	file   *os.File
+	ranges map[string]byteRange
+}
+
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+func OpenBreachedList(path string) (*BreachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+
+	ranges, err := indexRanges(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to index breached password list: %w", err)
+	}
+	return &BreachedList{file: file, ranges: ranges}, nil
+}
+
+// indexRanges records where the lines of every hash prefix start and end
+func indexRanges(r io.Reader) (map[string]byteRange, error) {
+	ranges := make(map[string]byteRange)
+	reader := bufio.NewReader(r)
+
+	var offset int64
+	current := ""
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) >= rangePrefixLength {
+			prefix := strings.ToUpper(line[:rangePrefixLength])
+			if prefix != current {
+				if _, seen := ranges[prefix]; seen {
+					return nil, fmt.Errorf("hashes with prefix %s are not sorted", prefix)
+				}
+				current = prefix
+				ranges[prefix] = byteRange{start: offset}
+			}
+			entry := ranges[prefix]
+			entry.end = offset + int64(len(line))
+			ranges[prefix] = entry
+		}
+		offset += int64(len(line))
+
+		if err == io.EOF {
+			return ranges, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (b *BreachedList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	block, ok := b.ranges[hash[:rangePrefixLength]]
+	if !ok {
+		return false, nil
+	}
+
+	buf := make([]byte, block.end-block.start)
+	_, err := b.file.ReadAt(buf, block.start)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+
+	for _, line := range strings.Split(string(buf), "\n") {
+		candidate, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+		if strings.EqualFold(candidate, hash) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (b *BreachedList) Close() error {
+	return b.file.Close()
+}
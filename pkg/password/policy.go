@@ -0,0 +1,48 @@
+package password
+
+import (
+	"fmt"
+	"unicode/utf8"
+	"users-microservice/pkg/models"
+)
+
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// optional, no breach check is done when nil
+	Breached *BreachedList
+}
+
+// NewPolicy builds the policy, breachedPath may be empty to skip the breach check
+func NewPolicy(minLength int, maxLength int, breachedPath string) (*Policy, error) {
+	policy := &Policy{MinLength: minLength, MaxLength: maxLength}
+	if breachedPath != "" {
+		breached, err := OpenBreachedList(breachedPath)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breached = breached
+	}
+	return policy, nil
+}
+
+func (p *Policy) Validate(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return models.NewInternalError(models.ContextBadRequest, fmt.Sprintf("password must be at least %d characters long", p.MinLength))
+	}
+	if length > p.MaxLength {
+		return models.NewInternalError(models.ContextBadRequest, fmt.Sprintf("password cannot be longer than %d characters", p.MaxLength))
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return models.NewWrappedError(err, models.ContextInternalServer, "failed to check password against breached passwords")
+		}
+		if breached {
+			return models.NewInternalError(models.ContextBadRequest, "password appears in a known data breach, choose a different one")
+		}
+	}
+	return nil
+}
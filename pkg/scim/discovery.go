@@ -0,0 +1,189 @@
+package scim
+
+// discovery documents of RFC 7643 sections 5 to 7, they only describe what
+// is actually supported here
+
+type Supported struct {
+	Supported bool `json:"supported"`
+}
+
+type FilterSupport struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type BulkSupport struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type AuthenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 Supported              `json:"patch"`
+	Bulk                  BulkSupport            `json:"bulk"`
+	Filter                FilterSupport          `json:"filter"`
+	ChangePassword        Supported              `json:"changePassword"`
+	Sort                  Supported              `json:"sort"`
+	ETag                  Supported              `json:"etag"`
+	AuthenticationSchemes []AuthenticationScheme `json:"authenticationSchemes"`
+	Meta                  Meta                   `json:"meta"`
+}
+
+type Attribute struct {
+	Name          string      `json:"name"`
+	Type          string      `json:"type"`
+	MultiValued   bool        `json:"multiValued"`
+	Description   string      `json:"description,omitempty"`
+	Required      bool        `json:"required"`
+	CaseExact     bool        `json:"caseExact"`
+	Mutability    string      `json:"mutability"`
+	Returned      string      `json:"returned"`
+	Uniqueness    string      `json:"uniqueness"`
+	SubAttributes []Attribute `json:"subAttributes,omitempty"`
+}
+
+type Schema struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Attributes  []Attribute `json:"attributes"`
+	Meta        Meta        `json:"meta"`
+}
+
+type SchemaExtension struct {
+	Schema   string `json:"schema"`
+	Required bool   `json:"required"`
+}
+
+type ResourceType struct {
+	Schemas          []string          `json:"schemas"`
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	Endpoint         string            `json:"endpoint"`
+	Description      string            `json:"description"`
+	Schema           string            `json:"schema"`
+	SchemaExtensions []SchemaExtension `json:"schemaExtensions"`
+	Meta             Meta              `json:"meta"`
+}
+
+func NewServiceProviderConfig(baseURL string, maxResults int) *ServiceProviderConfig {
+	return &ServiceProviderConfig{
+		Schemas:        []string{SchemaServiceProviderConfig},
+		Patch:          Supported{Supported: true},
+		Bulk:           BulkSupport{Supported: false},
+		Filter:         FilterSupport{Supported: true, MaxResults: maxResults},
+		ChangePassword: Supported{Supported: false},
+		Sort:           Supported{Supported: false},
+		ETag:           Supported{Supported: false},
+		AuthenticationSchemes: []AuthenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "API key",
+			Description: "An API key of the service sent as a bearer token",
+		}},
+		Meta: Meta{
+			ResourceType: "ServiceProviderConfig",
+			Location:     baseURL + "/ServiceProviderConfig",
+		},
+	}
+}
+
+func NewResourceTypes(baseURL string) []ResourceType {
+	return []ResourceType{{
+		Schemas:          []string{SchemaResourceType},
+		ID:               ResourceTypeUser,
+		Name:             ResourceTypeUser,
+		Endpoint:         "/Users",
+		Description:      "User account",
+		Schema:           SchemaUser,
+		SchemaExtensions: []SchemaExtension{{Schema: SchemaUserExtension, Required: true}},
+		Meta: Meta{
+			ResourceType: "ResourceType",
+			Location:     baseURL + "/ResourceTypes/" + ResourceTypeUser,
+		},
+	}}
+}
+
+func NewSchemas(baseURL string) []Schema {
+	schemas := []Schema{
+		{
+			ID:          SchemaUser,
+			Name:        ResourceTypeUser,
+			Description: "User account",
+			Attributes: []Attribute{
+				stringAttribute("userName", "Email address of the user, unique", true, "readWrite", "server"),
+				{
+					Name: "name", Type: "complex", Description: "Name of the user, kept as the formatted name",
+					Mutability: "readWrite", Returned: "default", Uniqueness: "none",
+					SubAttributes: []Attribute{
+						stringAttribute("formatted", "Full name", false, "readWrite", "none"),
+						writeOnlyAttribute(stringAttribute("givenName", "Given name, only read to build the full name", false, "writeOnly", "none")),
+						writeOnlyAttribute(stringAttribute("familyName", "Family name, only read to build the full name", false, "writeOnly", "none")),
+					},
+				},
+				stringAttribute("displayName", "Full name, the same as name.formatted", false, "readWrite", "none"),
+				multiValuedAttribute("emails", "Email address, always the userName", "readOnly"),
+				multiValuedAttribute("phoneNumbers", "Mobile number in E.164 format, users have at most one", "readWrite"),
+				{
+					Name: "active", Type: "boolean", Description: "Whether the user is neither suspended, deactivated nor deleted",
+					Mutability: "readWrite", Returned: "default", Uniqueness: "none",
+				},
+			},
+		},
+		{
+			ID:          SchemaUserExtension,
+			Name:        "UserExtension",
+			Description: "Attributes users need beyond the core schema",
+			Attributes: []Attribute{
+				stringAttribute("dateOfBirth", "Date of birth like 2000-01-31, users have to be at least 13 years old", true, "readWrite", "none"),
+			},
+		},
+	}
+	for i := range schemas {
+		schemas[i].Schemas = []string{SchemaSchema}
+		schemas[i].Meta = Meta{ResourceType: "Schema", Location: baseURL + "/Schemas/" + schemas[i].ID}
+	}
+	return schemas
+}
+
+func stringAttribute(name string, description string, required bool, mutability string, uniqueness string) Attribute {
+	return Attribute{
+		Name:        name,
+		Type:        "string",
+		Description: description,
+		Required:    required,
+		Mutability:  mutability,
+		Returned:    "default",
+		Uniqueness:  uniqueness,
+	}
+}
+
+// writeOnlyAttribute is never returned, as RFC 7643 requires
+func writeOnlyAttribute(attribute Attribute) Attribute {
+	attribute.Returned = "never"
+	return attribute
+}
+
+func multiValuedAttribute(name string, description string, mutability string) Attribute {
+	return Attribute{
+		Name:        name,
+		Type:        "complex",
+		MultiValued: true,
+		Description: description,
+		Mutability:  mutability,
+		Returned:    "default",
+		Uniqueness:  "none",
+		SubAttributes: []Attribute{
+			stringAttribute("value", "The value", false, mutability, "none"),
+			stringAttribute("type", "Label of the value", false, mutability, "none"),
+			{Name: "primary", Type: "boolean", Mutability: mutability, Returned: "default", Uniqueness: "none"},
+		},
+	}
+}
@@ -0,0 +1,409 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// maxFilterLength keeps the nesting of parsed filters in check
+const maxFilterLength = 1024
+
+// Filter is a parsed filter expression of RFC 7644 section 3.4.2.2.
+// Comparisons have a path and value, and, or and not have operands. Value
+// paths like emails[value co "x"] are flattened to emails.value co "x", as
+// users have a single value of every multi-valued attribute
+type Filter struct {
+	Operator models.FilterOperator
+	Path     string
+	// string, float64, bool or nil
+	Value    any
+	Operands []Filter
+}
+
+var comparisonOperators = map[string]models.FilterOperator{
+	"eq": models.FilterEqual,
+	"ne": models.FilterNotEqual,
+	"co": models.FilterContains,
+	"sw": models.FilterStartsWith,
+	"ew": models.FilterEndsWith,
+	"gt": models.FilterGreater,
+	"ge": models.FilterGreaterOrEqual,
+	"lt": models.FilterLess,
+	"le": models.FilterLessOrEqual,
+}
+
+// ParseFilter parses the expression, operators and keywords are case
+// insensitive and not binds tighter than and, which binds tighter than or
+func ParseFilter(expression string) (*Filter, error) {
+	if len(expression) > maxFilterLength {
+		return nil, NewBadRequest(ErrorInvalidFilter, fmt.Sprintf("filter cannot be longer than %d characters", maxFilterLength))
+	}
+	tokens, err := lexFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != tokenEnd {
+		return nil, p.unexpected(next)
+	}
+	return filter, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOpenParen
+	tokenCloseParen
+	tokenOpenBracket
+	tokenCloseBracket
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lexFilter(input string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(input); {
+		switch c := input[i]; c {
+		case ' ', '\t', '\n', '\r':
+			i++
+		case '(':
+			tokens = append(tokens, filterToken{kind: tokenOpenParen, text: "(", pos: i})
+			i++
+		case ')':
+			tokens = append(tokens, filterToken{kind: tokenCloseParen, text: ")", pos: i})
+			i++
+		case '[':
+			tokens = append(tokens, filterToken{kind: tokenOpenBracket, text: "[", pos: i})
+			i++
+		case ']':
+			tokens = append(tokens, filterToken{kind: tokenCloseBracket, text: "]", pos: i})
+			i++
+		case '"':
+			// strings are JSON strings, escapes included
+			end := i + 1
+			for end < len(input) && input[end] != '"' {
+				if input[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(input) {
+				return nil, NewBadRequest(ErrorInvalidFilter, fmt.Sprintf("string starting at %d is not terminated", i))
+			}
+			var value string
+			if err := json.Unmarshal([]byte(input[i:end+1]), &value); err != nil {
+				return nil, NewBadRequest(ErrorInvalidFilter, fmt.Sprintf("string starting at %d is malformed", i))
+			}
+			tokens = append(tokens, filterToken{kind: tokenString, text: value, pos: i})
+			i = end + 1
+		default:
+			end := i
+			for end < len(input) && !strings.ContainsRune(" \t\n\r()[]\"", rune(input[end])) {
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: tokenWord, text: input[i:end], pos: i})
+			i = end
+		}
+	}
+	return append(tokens, filterToken{kind: tokenEnd, pos: len(input)}), nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	// attribute of the value path being parsed
+	prefix string
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEnd {
+		p.pos++
+	}
+	return token
+}
+
+func (p *filterParser) isKeyword(keyword string) bool {
+	token := p.peek()
+	return token.kind == tokenWord && strings.EqualFold(token.text, keyword)
+}
+
+func (p *filterParser) unexpected(token filterToken) error {
+	if token.kind == tokenEnd {
+		return NewBadRequest(ErrorInvalidFilter, "filter ends unexpectedly")
+	}
+	return NewBadRequest(ErrorInvalidFilter, fmt.Sprintf("unexpected %q at %d", token.text, token.pos))
+}
+
+func (p *filterParser) expect(kind tokenKind) error {
+	if token := p.next(); token.kind != kind {
+		return p.unexpected(token)
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr() (*Filter, error) {
+	return p.parseLogical(models.FilterOr, p.parseAnd)
+}
+
+func (p *filterParser) parseAnd() (*Filter, error) {
+	return p.parseLogical(models.FilterAnd, p.parseTerm)
+}
+
+// parseLogical reads operands separated by the operator, a single operand is
+// returned as it is
+func (p *filterParser) parseLogical(operator models.FilterOperator, parseOperand func() (*Filter, error)) (*Filter, error) {
+	first, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	operands := []Filter{*first}
+	for p.isKeyword(string(operator)) {
+		p.next()
+		operand, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, *operand)
+	}
+	if len(operands) == 1 {
+		return first, nil
+	}
+	return &Filter{Operator: operator, Operands: operands}, nil
+}
+
+func (p *filterParser) parseTerm() (*Filter, error) {
+	if p.isKeyword("not") && p.tokens[p.pos+1].kind == tokenOpenParen {
+		p.next()
+		operand, err := p.parseGroup()
+		if err != nil {
+			return nil, err
+		}
+		return &Filter{Operator: models.FilterNot, Operands: []Filter{*operand}}, nil
+	}
+	if p.peek().kind == tokenOpenParen {
+		return p.parseGroup()
+	}
+
+	token := p.next()
+	if token.kind != tokenWord {
+		return nil, p.unexpected(token)
+	}
+	path := p.prefix + token.text
+
+	if p.peek().kind == tokenOpenBracket {
+		if p.prefix != "" {
+			return nil, NewBadRequest(ErrorInvalidFilter, "value paths cannot be nested")
+		}
+		p.next()
+		p.prefix = path + "."
+		filter, err := p.parseOr()
+		p.prefix = ""
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenCloseBracket); err != nil {
+			return nil, err
+		}
+		return filter, nil
+	}
+
+	operatorToken := p.next()
+	if operatorToken.kind != tokenWord {
+		return nil, p.unexpected(operatorToken)
+	}
+	if strings.EqualFold(operatorToken.text, "pr") {
+		return &Filter{Operator: models.FilterPresent, Path: path}, nil
+	}
+	operator, ok := comparisonOperators[strings.ToLower(operatorToken.text)]
+	if !ok {
+		return nil, NewBadRequest(ErrorInvalidFilter, fmt.Sprintf("unknown operator %q at %d", operatorToken.text, operatorToken.pos))
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{Operator: operator, Path: path, Value: value}, nil
+}
+
+func (p *filterParser) parseGroup() (*Filter, error) {
+	if err := p.expect(tokenOpenParen); err != nil {
+		return nil, err
+	}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokenCloseParen); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func (p *filterParser) parseValue() (any, error) {
+	token := p.next()
+	switch token.kind {
+	case tokenString:
+		return token.text, nil
+	case tokenWord:
+		switch strings.ToLower(token.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		if number, err := strconv.ParseFloat(token.text, 64); err == nil {
+			return number, nil
+		}
+		return nil, NewBadRequest(ErrorInvalidFilter, fmt.Sprintf("value %q at %d is not a string, number, boolean or null", token.text, token.pos))
+	}
+	return nil, p.unexpected(token)
+}
+
+// attributePath is the path in lower case without the URI of the core schema,
+// attributes of the extension are left with just their name
+func attributePath(path string) string {
+	path = strings.ToLower(path)
+	for _, schema := range []string{SchemaUser, SchemaUserExtension} {
+		if rest, ok := strings.CutPrefix(path, strings.ToLower(schema)+":"); ok {
+			return rest
+		}
+	}
+	return path
+}
+
+// text attributes users can be filtered by
+var filterTextAttributes = map[string]models.UserAttribute{
+	"username":           models.UserAttributeEmail,
+	"emails":             models.UserAttributeEmail,
+	"emails.value":       models.UserAttributeEmail,
+	"displayname":        models.UserAttributeName,
+	"name.formatted":     models.UserAttributeName,
+	"phonenumbers":       models.UserAttributePhoneNumber,
+	"phonenumbers.value": models.UserAttributePhoneNumber,
+}
+
+// UserFilter maps the filter onto the fields of models.User
+func (f *Filter) UserFilter() (*models.UserFilter, error) {
+	switch f.Operator {
+	case models.FilterAnd, models.FilterOr, models.FilterNot:
+		operands := make([]models.UserFilter, 0, len(f.Operands))
+		for _, operand := range f.Operands {
+			mapped, err := operand.UserFilter()
+			if err != nil {
+				return nil, err
+			}
+			operands = append(operands, *mapped)
+		}
+		return &models.UserFilter{Operator: f.Operator, Operands: operands}, nil
+	}
+
+	path := attributePath(f.Path)
+	if attribute, ok := filterTextAttributes[path]; ok {
+		if f.Operator == models.FilterPresent {
+			filter := models.NewUserComparison(attribute, f.Operator, nil)
+			return &filter, nil
+		}
+		value, ok := f.Value.(string)
+		if !ok {
+			return nil, f.invalid("has to be compared with a string")
+		}
+		filter := models.NewUserComparison(attribute, f.Operator, value)
+		return &filter, nil
+	}
+
+	switch path {
+	case "id":
+		return f.idFilter()
+	case "active":
+		return f.activeFilter()
+	case "meta.created":
+		return f.timeFilter(models.UserAttributeCreatedAt, time.RFC3339)
+	case "dateofbirth":
+		return f.timeFilter(models.UserAttributeDateOfBirth, dateLayout)
+	}
+	return nil, NewBadRequest(ErrorInvalidFilter, fmt.Sprintf("users cannot be filtered by %q", f.Path))
+}
+
+func (f *Filter) invalid(reason string) error {
+	return NewBadRequest(ErrorInvalidFilter, fmt.Sprintf("%s %s %s", f.Path, f.Operator, reason))
+}
+
+func (f *Filter) idFilter() (*models.UserFilter, error) {
+	switch f.Operator {
+	case models.FilterPresent:
+		filter := models.NewUserComparison(models.UserAttributeID, f.Operator, nil)
+		return &filter, nil
+	case models.FilterEqual, models.FilterNotEqual:
+		value, _ := f.Value.(string)
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return nil, f.invalid("has to be compared with a user ID")
+		}
+		filter := models.NewUserComparison(models.UserAttributeID, f.Operator, id)
+		return &filter, nil
+	}
+	return nil, f.invalid("is not supported, IDs are only compared with eq and ne")
+}
+
+// activeFilter maps active to the statuses that count as active
+func (f *Filter) activeFilter() (*models.UserFilter, error) {
+	if f.Operator == models.FilterPresent {
+		filter := models.NewUserComparison(models.UserAttributeStatus, f.Operator, nil)
+		return &filter, nil
+	}
+	value, ok := f.Value.(bool)
+	if !ok || (f.Operator != models.FilterEqual && f.Operator != models.FilterNotEqual) {
+		return nil, f.invalid("is not supported, active is only compared with true or false")
+	}
+	active := models.NewUserFilterOr(
+		models.NewUserComparison(models.UserAttributeStatus, models.FilterEqual, string(models.UserStatusPending)),
+		models.NewUserComparison(models.UserAttributeStatus, models.FilterEqual, string(models.UserStatusActive)),
+	)
+	if value != (f.Operator == models.FilterEqual) {
+		active = models.NewUserFilterNot(active)
+	}
+	return &active, nil
+}
+
+func (f *Filter) timeFilter(attribute models.UserAttribute, layout string) (*models.UserFilter, error) {
+	if f.Operator == models.FilterPresent {
+		filter := models.NewUserComparison(attribute, f.Operator, nil)
+		return &filter, nil
+	}
+	switch f.Operator {
+	case models.FilterContains, models.FilterStartsWith, models.FilterEndsWith:
+		return nil, f.invalid("is not supported for dates")
+	}
+	value, _ := f.Value.(string)
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return nil, f.invalid("has to be compared with a date like " + time.Date(2000, 1, 31, 12, 0, 0, 0, time.UTC).Format(layout))
+	}
+	filter := models.NewUserComparison(attribute, f.Operator, t)
+	return &filter, nil
+}
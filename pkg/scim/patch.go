@@ -0,0 +1,223 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+const (
+	PatchAdd     = "add"
+	PatchReplace = "replace"
+	PatchRemove  = "remove"
+)
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Apply runs the operations on the resource in order. Users have a single
+// value of every multi-valued attribute, so adding a value replaces it.
+// Attributes that are read-only or not kept, like emails or externalId, are
+// ignored as RFC 7644 allows
+func (r *PatchRequest) Apply(user *User) error {
+	if !slices.Contains(r.Schemas, SchemaPatchOp) {
+		return NewBadRequest(ErrorInvalidSyntax, "request has to use the "+SchemaPatchOp+" schema")
+	}
+	if len(r.Operations) == 0 {
+		return NewBadRequest(ErrorInvalidSyntax, "request has no operations")
+	}
+	for _, operation := range r.Operations {
+		if err := operation.apply(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *PatchOperation) apply(user *User) error {
+	// some clients capitalize the operation
+	op := strings.ToLower(o.Op)
+	switch op {
+	case PatchAdd, PatchReplace, PatchRemove:
+	default:
+		return NewBadRequest(ErrorInvalidSyntax, fmt.Sprintf("unknown operation %q", o.Op))
+	}
+
+	if o.Path != "" {
+		return setAttribute(user, op, o.Path, o.Value)
+	}
+	if op == PatchRemove {
+		return NewBadRequest(ErrorNoTarget, "remove operation needs a path")
+	}
+
+	// without a path the value holds the attributes to set
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal(o.Value, &attributes); err != nil {
+		return NewBadRequest(ErrorInvalidValue, "value of an operation without path has to be an object")
+	}
+	for _, name := range slices.Sorted(maps.Keys(attributes)) {
+		if !strings.EqualFold(name, SchemaUserExtension) {
+			if err := setAttribute(user, op, name, attributes[name]); err != nil {
+				return err
+			}
+			continue
+		}
+		var extension map[string]json.RawMessage
+		if err := json.Unmarshal(attributes[name], &extension); err != nil {
+			return NewBadRequest(ErrorInvalidValue, "value of "+SchemaUserExtension+" has to be an object")
+		}
+		for _, extensionName := range slices.Sorted(maps.Keys(extension)) {
+			if err := setAttribute(user, op, SchemaUserExtension+":"+extensionName, extension[extensionName]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setAttribute changes the attribute at path, value filters like
+// emails[type eq "work"].value select the single value users have
+func setAttribute(user *User, op string, path string, value json.RawMessage) error {
+	attribute, err := patchPath(path)
+	if err != nil {
+		return err
+	}
+
+	switch attribute {
+	case "schemas", "id", "meta", "externalid", "emails", "emails.value", "emails.type", "emails.primary":
+		return nil
+	}
+
+	if op == PatchRemove {
+		switch attribute {
+		case "phonenumbers", "phonenumbers.value":
+			user.PhoneNumbers = nil
+			return nil
+		case "username", "displayname", "name", "name.formatted", "name.givenname", "name.familyname", "active", "dateofbirth":
+			return NewBadRequest(ErrorMutability, path+" is required and cannot be removed")
+		}
+		return NewBadRequest(ErrorInvalidPath, fmt.Sprintf("unknown attribute %q", path))
+	}
+
+	switch attribute {
+	case "username":
+		return decodeValue(path, value, &user.UserName)
+	case "displayname", "name.formatted":
+		var name string
+		if err := decodeValue(path, value, &name); err != nil {
+			return err
+		}
+		user.Name = &Name{Formatted: name}
+		user.DisplayName = name
+	case "name":
+		var name Name
+		if err := decodeValue(path, value, &name); err != nil {
+			return err
+		}
+		user.Name = &name
+		user.DisplayName = ""
+	case "name.givenname", "name.familyname":
+		// only the formatted name is kept, it is rebuilt from the parts
+		name := Name{}
+		if user.Name != nil {
+			name = *user.Name
+		}
+		part := &name.GivenName
+		if attribute == "name.familyname" {
+			part = &name.FamilyName
+		}
+		if err := decodeValue(path, value, part); err != nil {
+			return err
+		}
+		name.Formatted = ""
+		user.Name = &name
+		user.DisplayName = ""
+	case "active":
+		active, err := boolValue(path, value)
+		if err != nil {
+			return err
+		}
+		user.Active = &active
+	case "phonenumbers":
+		var numbers []MultiValue
+		if err := decodeValue(path, value, &numbers); err != nil {
+			return err
+		}
+		user.PhoneNumbers = numbers
+	case "phonenumbers.value":
+		var number string
+		if err := decodeValue(path, value, &number); err != nil {
+			return err
+		}
+		user.PhoneNumbers = []MultiValue{{Value: number, Type: "mobile", Primary: true}}
+	case "dateofbirth":
+		if user.Extension == nil {
+			user.Extension = &UserExtension{}
+		}
+		return decodeValue(path, value, &user.Extension.DateOfBirth)
+	default:
+		return NewBadRequest(ErrorInvalidPath, fmt.Sprintf("unknown attribute %q", path))
+	}
+	return nil
+}
+
+// patchPath normalizes the path like attributePath, a value filter is checked
+// and then dropped
+func patchPath(path string) (string, error) {
+	open := strings.IndexByte(path, '[')
+	if open < 0 {
+		return attributePath(path), nil
+	}
+	end := strings.LastIndexByte(path, ']')
+	if end < open {
+		return "", NewBadRequest(ErrorInvalidPath, fmt.Sprintf("value filter of %q is not closed", path))
+	}
+	if _, err := ParseFilter(path[open+1 : end]); err != nil {
+		return "", NewBadRequest(ErrorInvalidPath, fmt.Sprintf("value filter of %q is invalid: %s", path, AsError(err).Detail))
+	}
+
+	attribute := attributePath(path[:open])
+	if rest := path[end+1:]; rest != "" {
+		subAttribute, ok := strings.CutPrefix(rest, ".")
+		if !ok {
+			return "", NewBadRequest(ErrorInvalidPath, fmt.Sprintf("%q is not a valid path", path))
+		}
+		attribute += "." + strings.ToLower(subAttribute)
+	}
+	return attribute, nil
+}
+
+func decodeValue(path string, value json.RawMessage, v any) error {
+	if err := json.Unmarshal(value, v); err != nil {
+		return NewBadRequest(ErrorInvalidValue, fmt.Sprintf("value of %s has the wrong type", path))
+	}
+	return nil
+}
+
+// boolValue also accepts "True" and "False", which some clients send
+func boolValue(path string, value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, NewBadRequest(ErrorInvalidValue, fmt.Sprintf("value of %s has to be a boolean", path))
+}
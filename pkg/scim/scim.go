@@ -0,0 +1,101 @@
+package scim
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const ContentType = "application/scim+json"
+
+// schema URIs of RFC 7643 and RFC 7644, the extension carries what users
+// need here but the core schema has no attribute for
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaUserExtension         = "urn:users-microservice:params:scim:schemas:extension:2.0:User"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// error types of RFC 7644 section 3.12
+const (
+	ErrorInvalidFilter = "invalidFilter"
+	ErrorInvalidSyntax = "invalidSyntax"
+	ErrorInvalidPath   = "invalidPath"
+	ErrorInvalidValue  = "invalidValue"
+	ErrorMutability    = "mutability"
+	ErrorUniqueness    = "uniqueness"
+	ErrorNoTarget      = "noTarget"
+)
+
+// Error is reported to clients in the shape the protocol defines instead of
+// the usual API envelope
+type Error struct {
+	Schemas []string `json:"schemas"`
+	Status  string   `json:"status"`
+	Type    string   `json:"scimType,omitempty"`
+	Detail  string   `json:"detail,omitempty"`
+	status  int
+}
+
+func NewError(status int, errorType string, detail string) *Error {
+	return &Error{Schemas: []string{SchemaError}, Status: strconv.Itoa(status), Type: errorType, Detail: detail, status: status}
+}
+
+// NewBadRequest reports a request the client has to correct
+func NewBadRequest(errorType string, detail string) *Error {
+	return NewError(http.StatusBadRequest, errorType, detail)
+}
+
+func (e *Error) Error() string {
+	if e.Type == "" {
+		return e.Detail
+	}
+	return e.Type + ": " + e.Detail
+}
+
+func (e *Error) StatusCode() int {
+	return e.status
+}
+
+// AsError finds the protocol error in err, nil for anything unexpected
+func AsError(err error) *Error {
+	var scimErr *Error
+	if errors.As(err, &scimErr) {
+		return scimErr
+	}
+	return nil
+}
+
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	Location     string     `json:"location,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    any      `json:"Resources"`
+}
+
+// NewListResponse wraps resources, a slice, starting at the 1-based index
+func NewListResponse[T any](resources []T, total int, startIndex int) *ListResponse {
+	if resources == nil {
+		resources = []T{}
+	}
+	return &ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
@@ -0,0 +1,153 @@
+package scim
+
+import (
+	"slices"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+)
+
+const (
+	ResourceTypeUser = "User"
+	// dates of birth in the extension are full-date values of RFC 3339
+	dateLayout = "2006-01-02"
+)
+
+// User is the SCIM representation of a user. userName is the email, emails
+// only mirrors it, and the name is kept as a single formatted value
+type User struct {
+	Schemas      []string       `json:"schemas"`
+	ID           string         `json:"id,omitempty"`
+	UserName     string         `json:"userName"`
+	Name         *Name          `json:"name,omitempty"`
+	DisplayName  string         `json:"displayName,omitempty"`
+	Emails       []MultiValue   `json:"emails,omitempty"`
+	PhoneNumbers []MultiValue   `json:"phoneNumbers,omitempty"`
+	Active       *bool          `json:"active,omitempty"`
+	Extension    *UserExtension `json:"urn:users-microservice:params:scim:schemas:extension:2.0:User,omitempty"`
+	Meta         *Meta          `json:"meta,omitempty"`
+}
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// MultiValue is an element of a multi-valued attribute like emails
+type MultiValue struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type UserExtension struct {
+	DateOfBirth string `json:"dateOfBirth,omitempty"`
+}
+
+// Profile is what a User resource says about the fields of models.User
+type Profile struct {
+	Name        string
+	Email       string
+	DateOfBirth time.Time
+	// empty when the resource has no phone number
+	PhoneNumber string
+}
+
+// NewUser maps the user to its SCIM representation, located under baseURL
+func NewUser(user *models.User, baseURL string) *User {
+	active := user.Status == models.UserStatusPending || user.Status == models.UserStatusActive
+	resource := &User{
+		Schemas:     []string{SchemaUser, SchemaUserExtension},
+		ID:          user.ID.String(),
+		UserName:    user.Email,
+		Name:        &Name{Formatted: user.Name},
+		DisplayName: user.Name,
+		Emails:      []MultiValue{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Extension:   &UserExtension{DateOfBirth: user.DateOfBirth.Format(dateLayout)},
+		Meta: &Meta{
+			ResourceType: ResourceTypeUser,
+			Location:     baseURL + "/Users/" + user.ID.String(),
+		},
+	}
+	if user.PhoneNumber != "" {
+		resource.PhoneNumbers = []MultiValue{{Value: user.PhoneNumber, Type: "mobile", Primary: true}}
+	}
+	if !user.CreatedAt.IsZero() {
+		created := user.CreatedAt.UTC()
+		resource.Meta.Created = &created
+	}
+	return resource
+}
+
+// Redact masks the personal data fields, see models.PersonalDataFields, and
+// returns the attributes it changed. The date of birth is cut down to the
+// year so the resource cannot be written back by mistake
+func (u *User) Redact(fields []string) []string {
+	var attributes []string
+	if u.UserName != "" && slices.Contains(fields, models.FieldEmail) {
+		u.UserName = models.MaskEmail(u.UserName)
+		attributes = append(attributes, "userName")
+	}
+	if len(u.Emails) > 0 && slices.Contains(fields, models.FieldEmail) {
+		for i := range u.Emails {
+			u.Emails[i].Value = models.MaskEmail(u.Emails[i].Value)
+		}
+		attributes = append(attributes, "emails")
+	}
+	if len(u.PhoneNumbers) > 0 && slices.Contains(fields, models.FieldPhoneNumber) {
+		for i := range u.PhoneNumbers {
+			u.PhoneNumbers[i].Value = models.MaskPhoneNumber(u.PhoneNumbers[i].Value)
+		}
+		attributes = append(attributes, "phoneNumbers")
+	}
+	if u.Extension != nil && len(u.Extension.DateOfBirth) > 4 && slices.Contains(fields, models.FieldDateOfBirth) {
+		u.Extension.DateOfBirth = u.Extension.DateOfBirth[:4]
+		attributes = append(attributes, "dateOfBirth")
+	}
+	return attributes
+}
+
+// Profile reads the user fields from the resource. The name is taken from
+// name.formatted, given and family name or displayName, whichever is set
+// first, and a date of birth is required as users cannot do without
+func (u *User) Profile() (*Profile, error) {
+	profile := &Profile{Email: strings.TrimSpace(u.UserName)}
+	if profile.Email == "" {
+		return nil, NewBadRequest(ErrorInvalidValue, "userName is required")
+	}
+
+	if u.Name != nil {
+		profile.Name = strings.TrimSpace(u.Name.Formatted)
+		if profile.Name == "" {
+			profile.Name = strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName)
+		}
+	}
+	if profile.Name == "" {
+		profile.Name = strings.TrimSpace(u.DisplayName)
+	}
+	if profile.Name == "" {
+		return nil, NewBadRequest(ErrorInvalidValue, "name or displayName is required")
+	}
+
+	if u.Extension == nil || u.Extension.DateOfBirth == "" {
+		return nil, NewBadRequest(ErrorInvalidValue, "dateOfBirth of "+SchemaUserExtension+" is required")
+	}
+	dateOfBirth, err := time.Parse(dateLayout, u.Extension.DateOfBirth)
+	if err != nil {
+		return nil, NewBadRequest(ErrorInvalidValue, "dateOfBirth has to be a date like 2000-01-31")
+	}
+	profile.DateOfBirth = dateOfBirth
+
+	// users have a single number, the primary one or else the first
+	for i, number := range u.PhoneNumbers {
+		if i == 0 || number.Primary {
+			profile.PhoneNumber = strings.TrimSpace(number.Value)
+		}
+		if number.Primary {
+			break
+		}
+	}
+	return profile, nil
+}
@@ -0,0 +1,84 @@
+package servertls
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certificate serves the key pair of the files and loads it again once they
+// change, so renewed certificates are picked up without a restart. A renewal
+// that cannot be loaded, e.g. because only one of the files is written yet,
+// keeps the current pair until the next check
+type certificate struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu      sync.Mutex
+	current *tls.Certificate
+	// newest modification time of the files the current pair was read from
+	modified time.Time
+	checked  time.Time
+}
+
+func loadCertificate(certFile string, keyFile string, interval time.Duration) (*certificate, error) {
+	c := &certificate{certFile: certFile, keyFile: keyFile, interval: interval}
+	modified, err := c.modTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(modified); err != nil {
+		return nil, err
+	}
+	c.checked = time.Now()
+	return c, nil
+}
+
+// get is the GetCertificate callback of the TLS config
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) >= c.interval {
+		c.checked = time.Now()
+		modified, err := c.modTime()
+		if err != nil {
+			log.Printf("ERROR: failed to check TLS certificate for changes: %v", err)
+		} else if !modified.Equal(c.modified) {
+			if err := c.load(modified); err != nil {
+				log.Printf("ERROR: failed to reload TLS certificate, keeping the current one: %v", err)
+			} else {
+				log.Printf("TLS certificate reloaded at %v", time.Now())
+			}
+		}
+	}
+	return c.current, nil
+}
+
+func (c *certificate) load(modified time.Time) error {
+	pair, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	c.current = &pair
+	c.modified = modified
+	return nil
+}
+
+func (c *certificate) modTime() (time.Time, error) {
+	var newest time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest, nil
+}
@@ -0,0 +1,74 @@
+package servertls
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"users-microservice/pkg/models"
+)
+
+// ClientIdentity maps client certificates onto a service principal, by the
+// SPIFFE ID in a URI SAN or by the subject distinguished name like
+// "CN=reporting,O=Example"
+type ClientIdentity struct {
+	SPIFFEID string         `json:"spiffe_id,omitempty"`
+	Subject  string         `json:"subject,omitempty"`
+	Scopes   []models.Scope `json:"scopes"`
+}
+
+func readClientIdentities(path string) ([]ClientIdentity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client identities: %w", err)
+	}
+	var identities []ClientIdentity
+	if err := json.Unmarshal(data, &identities); err != nil {
+		return nil, fmt.Errorf("failed to parse client identities: %w", err)
+	}
+
+	var errs error
+	for i, identity := range identities {
+		if (identity.SPIFFEID == "") == (identity.Subject == "") {
+			errs = errors.Join(errs, fmt.Errorf("client identity %d needs either a SPIFFE ID or a subject", i+1))
+		}
+		for _, scope := range identity.Scopes {
+			if !models.IsKnownScope(scope) {
+				errs = errors.Join(errs, fmt.Errorf("client identity %d has unknown scope '%s'", i+1, scope))
+			}
+		}
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	return identities, nil
+}
+
+// Principal finds the service a verified client certificate belongs to, SPIFFE
+// IDs are matched before subjects. Connections without a verified
+// certificate or with one that is not mapped have none
+func (s *Settings) Principal(state *tls.ConnectionState) (*models.Principal, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil, false
+	}
+	leaf := state.VerifiedChains[0][0]
+
+	for _, uri := range leaf.URIs {
+		if uri.Scheme != "spiffe" {
+			continue
+		}
+		for _, identity := range s.identities {
+			if identity.SPIFFEID != "" && identity.SPIFFEID == uri.String() {
+				return &models.Principal{Kind: models.PrincipalService, Subject: identity.SPIFFEID, Scopes: identity.Scopes}, true
+			}
+		}
+	}
+	subject := leaf.Subject.String()
+	for _, identity := range s.identities {
+		if identity.Subject != "" && identity.Subject == subject {
+			return &models.Principal{Kind: models.PrincipalService, Subject: identity.Subject, Scopes: identity.Scopes}, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,56 @@
+// Package servertls serves the API over HTTPS and tells which service a
+// client certificate belongs to
+package servertls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"users-microservice/pkg/config"
+)
+
+// Settings are the TLS config of the server and the client identities
+// certificates are mapped to
+type Settings struct {
+	Config     *tls.Config
+	identities []ClientIdentity
+}
+
+func Load(cfg *config.TLS) (*Settings, error) {
+	cert, err := loadCertificate(cfg.CertFile, cfg.KeyFile, cfg.ReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+	settings := &Settings{Config: &tls.Config{
+		GetCertificate: cert.get,
+		MinVersion:     cfg.MinVersion,
+		CipherSuites:   cfg.CipherSuites,
+	}}
+
+	if cfg.ClientCAFile != "" {
+		bundle, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, errors.New("client CA bundle contains no certificate")
+		}
+		settings.Config.ClientCAs = pool
+		// callers without a certificate can still use API keys and tokens
+		settings.Config.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.ClientCertRequired {
+			settings.Config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	if cfg.ClientIdentitiesFile != "" {
+		settings.identities, err = readClientIdentities(cfg.ClientIdentitiesFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return settings, nil
+}
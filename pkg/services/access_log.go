@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"time"
+	"users-microservice/pkg/accesslog"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// access log entries returned when the caller does not ask for fewer
+const (
+	defaultAccessLogLimit = 100
+	maxAccessLogLimit     = 1000
+)
+
+// accessedField reads one field of a user shown to callers, masked fields
+// are not recorded for callers that cannot see personal data
+type accessedField struct {
+	name   string
+	masked bool
+	shown  func(*models.User) bool
+}
+
+var accessedFields = []accessedField{
+	{"id", false, func(u *models.User) bool { return true }},
+	{"name", false, func(u *models.User) bool { return u.Name != "" }},
+	{"email", true, func(u *models.User) bool { return u.Email != "" }},
+	{"date_of_birth", true, func(u *models.User) bool { return !u.DateOfBirth.IsZero() }},
+	{"status", false, func(u *models.User) bool { return u.Status != "" }},
+	{"email_verified_at", false, func(u *models.User) bool { return u.EmailVerifiedAt != nil }},
+	{"pending_email", true, func(u *models.User) bool { return u.PendingEmail != "" }},
+	{"phone_number", true, func(u *models.User) bool { return u.PhoneNumber != "" }},
+	{"phone_verified_at", false, func(u *models.User) bool { return u.PhoneVerifiedAt != nil }},
+	{"region", false, func(u *models.User) bool { return u.Region != "" }},
+}
+
+// accessRecorder records users read by callers in the access log
+type accessRecorder struct {
+	accessLog *accesslog.Writer
+}
+
+func (a accessRecorder) recordAccess(ctx context.Context, users ...*models.User) {
+	accessor := models.AuditActor(ctx)
+	purpose := models.AccessPurposeFromContext(ctx)
+	requestID := models.RequestIDFromContext(ctx)
+	now := time.Now().UTC()
+	for _, user := range users {
+		masked := models.MaskedFields(ctx, user.ID)
+		var fields []string
+		for _, field := range accessedFields {
+			if field.shown(user) && !(field.masked && slices.Contains(masked, field.name)) {
+				fields = append(fields, field.name)
+			}
+		}
+		a.accessLog.Record(models.AccessRecord{
+			ID:         uuid.New(),
+			UserID:     user.ID,
+			Accessor:   accessor,
+			Fields:     fields,
+			Purpose:    purpose,
+			RequestID:  requestID,
+			AccessedAt: now,
+		})
+	}
+}
+
+// GetUserAccessLog returns who read the user since the given time, newest
+// first
+func (us *userService) GetUserAccessLog(ctx context.Context, id uuid.UUID, since time.Time, limit int) ([]models.AccessRecord, error) {
+	if err := us.authorizeUser(ctx, actionUsersAccessLog, id); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultAccessLogLimit
+	}
+	limit = min(limit, maxAccessLogLimit)
+	if _, err := us.storage.RetrieveUser(id); err != nil {
+		return nil, err
+	}
+	return us.storage.RetrieveAccessRecords(id, since, limit)
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"users-microservice/pkg/authz"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/storage"
+	"users-microservice/pkg/tokens"
+
+	"github.com/google/uuid"
+)
+
+// APIKeyService issues the keys services authenticate to the API with
+type APIKeyService interface {
+	IssueAPIKey(context.Context, APIKeyRequest) (*APIKeyIssue, error)
+	ListAPIKeys(context.Context) ([]models.APIKey, error)
+	RevokeAPIKey(context.Context, uuid.UUID) error
+	// Authenticate finds the key presented by a caller, unknown and expired
+	// keys are unauthorized
+	Authenticate(context.Context, string) (*models.APIKey, error)
+}
+
+type APIKeyRequest struct {
+	Name   string
+	Scopes []models.Scope
+	// nil issues a key that is valid until revoked
+	ExpiresAt *time.Time
+}
+
+// APIKeyIssue carries the key itself, it is only available right after it
+// was issued
+type APIKeyIssue struct {
+	Key    *models.APIKey
+	Secret string
+}
+
+const (
+	// keys look like uk_<prefix>.<secret>, the prefix is stored in the clear
+	// to find the key and to tell keys apart without revealing them
+	apiKeyMarker       = "uk_"
+	apiKeyPrefixLength = 10
+	// last use is recorded at most this often per key
+	apiKeyUsageInterval = time.Minute
+)
+
+type apiKeyService struct {
+	authorizer
+	storage storage.Storage
+}
+
+func NewAPIKeyService(storage storage.Storage, policies *authz.Engine) (APIKeyService, error) {
+	return &apiKeyService{authorizer: newAuthorizer(storage, policies), storage: storage}, nil
+}
+
+func (ks *apiKeyService) IssueAPIKey(ctx context.Context, req APIKeyRequest) (*APIKeyIssue, error) {
+	if err := ks.authorizeService(ctx, actionAPIKeysIssue, nil); err != nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, models.NewInternalError(models.ContextBadRequest, "API key name is required and cannot be empty")
+	}
+	if len(req.Scopes) == 0 {
+		return nil, models.NewInternalError(models.ContextBadRequest, "at least one scope is required")
+	}
+	scopes := make([]models.Scope, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		if !models.IsKnownScope(scope) {
+			return nil, models.NewInternalError(models.ContextBadRequest, fmt.Sprintf("scope '%s' is not supported", scope))
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, models.NewInternalError(models.ContextBadRequest, "expiry has to be in the future")
+	}
+
+	prefix := apiKeyMarker + strings.ToLower(rand.Text()[:apiKeyPrefixLength])
+	secret, _, err := tokens.New()
+	if err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to generate API key")
+	}
+	fullKey := prefix + "." + secret
+
+	key := models.NewAPIKey(name, prefix, tokens.Hash(fullKey), scopes, req.ExpiresAt)
+	if err := ks.storage.CreateAPIKey(key); err != nil {
+		return nil, err
+	}
+
+	ks.logAPIKeyIssued(key.ID)
+	return &APIKeyIssue{Key: key, Secret: fullKey}, nil
+}
+
+func (ks *apiKeyService) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	if err := ks.authorizeService(ctx, actionAPIKeysList, nil); err != nil {
+		return nil, err
+	}
+	return ks.storage.RetrieveAPIKeys()
+}
+
+func (ks *apiKeyService) RevokeAPIKey(ctx context.Context, id uuid.UUID) error {
+	if err := ks.authorizeService(ctx, actionAPIKeysRevoke, map[string]any{"id": id.String()}); err != nil {
+		return err
+	}
+	if err := ks.storage.DeleteAPIKey(id); err != nil {
+		return err
+	}
+	ks.logAPIKeyRevoked(id)
+	return nil
+}
+
+func (ks *apiKeyService) Authenticate(ctx context.Context, presented string) (*models.APIKey, error) {
+	invalid := models.NewInternalError(models.ContextUnauthorized, "API key is invalid")
+	prefix, _, ok := strings.Cut(presented, ".")
+	if !ok || !strings.HasPrefix(prefix, apiKeyMarker) {
+		return nil, invalid
+	}
+
+	key, err := ks.storage.RetrieveAPIKeyByPrefix(prefix)
+	if err != nil {
+		if models.ErrorContext(err) == models.ContextNotFound {
+			return nil, invalid
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(tokens.Hash(presented)), []byte(key.Hash)) != 1 {
+		return nil, invalid
+	}
+	now := time.Now()
+	if key.IsExpired(now) {
+		return nil, models.NewInternalError(models.ContextUnauthorized, "API key has expired")
+	}
+
+	// a failed write only makes the last use less accurate, the caller is
+	// still let through
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyUsageInterval {
+		if err := ks.storage.UseAPIKey(key.ID, now); err != nil {
+			log.Printf("ERROR: failed to record use of API key %s: %v", key.ID, err)
+		} else {
+			key.LastUsedAt = &now
+		}
+	}
+	return key, nil
+}
+
+func (ks *apiKeyService) logAPIKeyIssued(id uuid.UUID) {
+	log.Printf("API key %s issued at %v", id, time.Now())
+}
+
+func (ks *apiKeyService) logAPIKeyRevoked(id uuid.UUID) {
+	log.Printf("API key %s revoked at %v", id, time.Now())
+}
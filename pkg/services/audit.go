@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/storage"
+
+	"github.com/google/uuid"
+)
+
+// auditedField reads one field of a user for the audit log, personal data is
+// hashed
+type auditedField struct {
+	name     string
+	personal bool
+	value    func(*models.User) string
+}
+
+var auditedFields = []auditedField{
+	{"name", true, func(u *models.User) string { return u.Name }},
+	{"email", true, func(u *models.User) string { return u.Email }},
+	{"date_of_birth", true, func(u *models.User) string { return formatAuditTime(u.DateOfBirth, time.DateOnly) }},
+	{"status", false, func(u *models.User) string { return string(u.Status) }},
+	{"email_verified_at", false, func(u *models.User) string { return formatAuditTimePtr(u.EmailVerifiedAt) }},
+	{"pending_email", true, func(u *models.User) string { return u.PendingEmail }},
+	{"pending_email_expires_at", false, func(u *models.User) string { return formatAuditTimePtr(u.PendingEmailExpiresAt) }},
+	{"phone_number", true, func(u *models.User) string { return u.PhoneNumber }},
+	{"phone_verified_at", false, func(u *models.User) string { return formatAuditTimePtr(u.PhoneVerifiedAt) }},
+	{"region", true, func(u *models.User) string { return u.Region }},
+}
+
+func formatAuditTime(t time.Time, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(layout)
+}
+
+func formatAuditTimePtr(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return formatAuditTime(*t, time.RFC3339)
+}
+
+// auditor records mutations of users in their audit chain
+type auditor struct {
+	audit storage.AuditStorage
+}
+
+// auditEntry builds the entry of the fields that differ between before and
+// after, before is nil for created users. It is nil for mutations changing
+// nothing, which are not recorded. The storage appends it with the mutation
+func (a auditor) auditEntry(ctx context.Context, action string, before *models.User, after *models.User) (*models.AuditEntry, error) {
+	var salt []byte
+	var changes []models.AuditChange
+	for _, field := range auditedFields {
+		from, to := "", field.value(after)
+		if before != nil {
+			from = field.value(before)
+		}
+		if from == to {
+			continue
+		}
+		if field.personal {
+			if salt == nil {
+				var err error
+				if salt, err = a.audit.AuditSalt(after.ID); err != nil {
+					return nil, err
+				}
+			}
+			from, to = hashAuditValue(salt, from), hashAuditValue(salt, to)
+		}
+		changes = append(changes, models.AuditChange{Field: field.name, Before: from, After: to, Hashed: field.personal})
+	}
+	if len(changes) == 0 && action != models.AuditActionCreate {
+		return nil, nil
+	}
+	return models.NewAuditEntry(after.ID, action, models.AuditActor(ctx), models.RequestIDFromContext(ctx), changes), nil
+}
+
+// hashAuditValue keeps empty values empty so added and removed data still
+// shows
+func hashAuditValue(salt []byte, value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// snapshot copies the user so it can be compared once the original changed
+func snapshot(user *models.User) *models.User {
+	copied := *user
+	return &copied
+}
+
+func (us *userService) GetUserAudit(ctx context.Context, id uuid.UUID) ([]models.AuditEntry, error) {
+	if err := us.authorizeUser(ctx, actionUsersAudit, id); err != nil {
+		return nil, err
+	}
+	// unknown IDs end up as 404 instead of an empty log
+	if _, err := us.storage.RetrieveUser(id); err != nil {
+		return nil, err
+	}
+	return us.storage.RetrieveAuditEntries(id)
+}
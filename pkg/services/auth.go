@@ -0,0 +1,389 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+	"users-microservice/pkg/auth"
+	"users-microservice/pkg/authz"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/encryption"
+	"users-microservice/pkg/export"
+	"users-microservice/pkg/federation"
+	"users-microservice/pkg/lockout"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/password"
+	"users-microservice/pkg/storage"
+	"users-microservice/pkg/webauthn"
+
+	"github.com/google/uuid"
+)
+
+type AuthService interface {
+	SetPassword(context.Context, uuid.UUID, string) error
+	ChangePassword(context.Context, uuid.UUID, PasswordChangeRequest) error
+	Login(context.Context, LoginRequest) (*LoginResult, error)
+	RequestPasswordReset(context.Context, string) error
+	ConfirmPasswordReset(context.Context, string, string) error
+	RefreshSession(context.Context, string, SessionMetadata) (*SessionTokens, error)
+	VerifyAccessToken(context.Context, string) (*models.Session, error)
+	ListSessions(context.Context, uuid.UUID) ([]models.Session, error)
+	RevokeSession(context.Context, uuid.UUID, uuid.UUID) error
+	RevokeAllSessions(context.Context, uuid.UUID) error
+	EnrollTOTP(context.Context, uuid.UUID) (*TOTPEnrollment, error)
+	ConfirmTOTP(context.Context, uuid.UUID, string) ([]string, error)
+	GetMFAStatus(context.Context, uuid.UUID) (*MFAStatus, error)
+	ResetMFA(context.Context, uuid.UUID, string) error
+	CompleteMFALogin(context.Context, MFALoginRequest) (*LoginResult, error)
+	UnlockAccount(context.Context, uuid.UUID, string) error
+	RequestMagicLink(context.Context, string, string) (string, error)
+	ConsumeMagicLink(context.Context, MagicLinkLoginRequest) (*LoginResult, error)
+	BeginPasskeyRegistration(context.Context, uuid.UUID) (*PasskeyRegistrationOptions, error)
+	FinishPasskeyRegistration(context.Context, uuid.UUID, PasskeyRegistrationRequest) (*models.Passkey, error)
+	ListPasskeys(context.Context, uuid.UUID) ([]models.Passkey, error)
+	RenamePasskey(context.Context, uuid.UUID, uuid.UUID, string) error
+	DeletePasskey(context.Context, uuid.UUID, uuid.UUID) error
+	BeginPasskeyLogin(context.Context) (*PasskeyLoginOptions, error)
+	PasskeyLogin(context.Context, PasskeyLoginRequest) (*LoginResult, error)
+	FederationProviders() []string
+	BeginFederatedLogin(context.Context, string) (*FederatedLoginStart, error)
+	FederatedLogin(context.Context, FederatedCallbackRequest) (*LoginResult, error)
+	CompleteFederatedSignup(context.Context, FederatedSignupRequest) (*LoginResult, error)
+	BeginIdentityLink(context.Context, uuid.UUID, string) (string, error)
+	LinkIdentity(context.Context, uuid.UUID, FederatedCallbackRequest) (*models.FederatedIdentity, error)
+	ListIdentities(context.Context, uuid.UUID) ([]models.FederatedIdentity, error)
+	UnlinkIdentity(context.Context, uuid.UUID, uuid.UUID) error
+}
+
+type PasswordChangeRequest struct {
+	CurrentPassword string
+	NewPassword     string
+}
+
+type LoginRequest struct {
+	Email    string
+	Password string
+	Metadata SessionMetadata
+}
+
+// LoginResult carries either the started session or, for users with a second
+// factor, the token needed to finish the login. Sign-ins through a provider
+// without an account get what is needed to sign up instead
+type LoginResult struct {
+	User              *models.User
+	Tokens            *SessionTokens
+	MFAToken          string
+	MFATokenExpiresAt time.Time
+	Signup            *FederatedSignup
+}
+
+func (r *LoginResult) MFARequired() bool {
+	return r.MFAToken != ""
+}
+
+func (r *LoginResult) SignupRequired() bool {
+	return r.Signup != nil
+}
+
+type authService struct {
+	authorizer
+	auditor
+	storage  storage.Storage
+	hasher   *password.Hasher
+	policy   *password.Policy
+	notifier AuthNotifier
+	signer   *auth.AccessTokenSigner
+	cipher   *encryption.Cipher
+	guard    *lockout.Guard
+	// built from the config, passkeys are verified against it
+	relyingParty *webauthn.RelyingParty
+	// external OpenID providers users sign in with
+	federation *federation.Registry
+	cfg        *config.Config
+	// verified against when there is nothing to verify, so unknown emails
+	// take as long as wrong passwords
+	dummyHash string
+}
+
+// AuthDependencies are what the auth service works with. The notifier,
+// cipher and exporter are optional, left nil the features needing them are
+// not available
+type AuthDependencies struct {
+	Storage  storage.Storage
+	Hasher   *password.Hasher
+	Policy   *password.Policy
+	Notifier AuthNotifier
+	Signer   *auth.AccessTokenSigner
+	Cipher   *encryption.Cipher
+	Guard    *lockout.Guard
+	// the credentials a user holds are registered with it
+	Exporter *export.Exporter
+	Policies *authz.Engine
+}
+
+func NewAuthService(deps AuthDependencies, cfg *config.Config) (AuthService, error) {
+	dummyHash, err := deps.Hasher.Hash(uuid.NewString())
+	if err != nil {
+		return nil, err
+	}
+	as := &authService{
+		authorizer:   newAuthorizer(deps.Storage, deps.Policies),
+		auditor:      auditor{audit: deps.Storage},
+		storage:      deps.Storage,
+		hasher:       deps.Hasher,
+		policy:       deps.Policy,
+		notifier:     deps.Notifier,
+		signer:       deps.Signer,
+		cipher:       deps.Cipher,
+		guard:        deps.Guard,
+		relyingParty: webauthn.NewRelyingParty(cfg.WebAuthnRPID, cfg.WebAuthnRPName, cfg.WebAuthnOrigins),
+		federation:   federation.NewRegistry(cfg),
+		cfg:          cfg,
+		dummyHash:    dummyHash,
+	}
+	if deps.Exporter != nil {
+		as.registerExportCollectors(deps.Exporter)
+	}
+	return as, nil
+}
+
+func (as *authService) SetPassword(ctx context.Context, id uuid.UUID, newPassword string) error {
+	if err := as.authorizeUser(ctx, actionPasswordSet, id); err != nil {
+		return err
+	}
+	user, err := as.storage.RetrieveUser(id)
+	if err != nil {
+		return err
+	}
+	if user.Status == models.UserStatusDeleted {
+		return models.NewInternalError(models.ContextConflictValue, "deleted user cannot have a password")
+	}
+
+	_, err = as.storage.RetrievePasswordCredential(id)
+	if err == nil {
+		return models.NewInternalError(models.ContextConflictValue, "password is already set, change it instead")
+	}
+	if models.ErrorContext(err) != models.ContextNotFound {
+		return err
+	}
+
+	if err := as.policy.Validate(newPassword); err != nil {
+		return err
+	}
+	if err := as.storePassword(id, newPassword); err != nil {
+		return err
+	}
+	if err := as.storage.AppendUserHistory(models.NewUserHistoryEntry(id, models.HistoryEventPasswordSet, "")); err != nil {
+		return err
+	}
+
+	as.logPasswordChanged(id)
+	return nil
+}
+
+func (as *authService) ChangePassword(ctx context.Context, id uuid.UUID, req PasswordChangeRequest) error {
+	if err := as.authorizeUser(ctx, actionPasswordChange, id); err != nil {
+		return err
+	}
+	accountKey := lockout.AccountKey(id)
+	if err := as.guard.Check(accountKey); err != nil {
+		return err
+	}
+
+	credential, err := as.storage.RetrievePasswordCredential(id)
+	if err != nil {
+		return err
+	}
+
+	match, _, err := as.hasher.Verify(req.CurrentPassword, credential.Hash)
+	if err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, "failed to verify current password")
+	}
+	if !match {
+		return as.recordFailure(id, accountKey, models.NewInternalError(models.ContextUnauthorized, "current password is incorrect"))
+	}
+	as.resetFailures(accountKey)
+	if req.CurrentPassword == req.NewPassword {
+		return models.NewInternalError(models.ContextBadRequest, "new password must differ from the current one")
+	}
+
+	if err := as.policy.Validate(req.NewPassword); err != nil {
+		return err
+	}
+	if err := as.storePassword(id, req.NewPassword); err != nil {
+		return err
+	}
+	if err := as.storage.AppendUserHistory(models.NewUserHistoryEntry(id, models.HistoryEventPasswordChanged, "")); err != nil {
+		return err
+	}
+
+	as.logPasswordChanged(id)
+	return nil
+}
+
+// Login verifies the credentials and starts a session, every failure looks the
+// same to the caller so it cannot tell whether the email is registered
+func (as *authService) Login(ctx context.Context, req LoginRequest) (*LoginResult, error) {
+	invalidCredentials := models.NewInternalError(models.ContextUnauthorized, "invalid email or password")
+
+	email := strings.TrimSpace(req.Email)
+	user, err := as.storage.RetrieveUserByEmail(email)
+	if err != nil {
+		if models.ErrorContext(err) != models.ContextNotFound {
+			return nil, err
+		}
+		// unknown emails are counted too, otherwise only registered ones
+		// could ever be locked out
+		unknownKey := lockout.UnknownAccountKey(email)
+		if err := as.guard.Check(unknownKey); err != nil {
+			return nil, err
+		}
+		as.hasher.Verify(req.Password, as.dummyHash)
+		return nil, as.recordFailure(uuid.Nil, unknownKey, invalidCredentials)
+	}
+
+	accountKey := lockout.AccountKey(user.ID)
+	if err := as.guard.Check(accountKey); err != nil {
+		return nil, err
+	}
+
+	credential, err := as.storage.RetrievePasswordCredential(user.ID)
+	if err != nil {
+		if models.ErrorContext(err) != models.ContextNotFound {
+			return nil, err
+		}
+		as.hasher.Verify(req.Password, as.dummyHash)
+		return nil, as.recordFailure(user.ID, accountKey, invalidCredentials)
+	}
+
+	match, needsRehash, err := as.hasher.Verify(req.Password, credential.Hash)
+	if err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to verify password")
+	}
+	if !match {
+		return nil, as.recordFailure(user.ID, accountKey, invalidCredentials)
+	}
+
+	if err := checkCanSignIn(user); err != nil {
+		return nil, err
+	}
+
+	// parameters were tuned since the hash was made, the plain password is
+	// only available now so upgrade it while we have it
+	if needsRehash {
+		if err := as.storePassword(user.ID, req.Password); err != nil {
+			log.Printf("ERROR: failed to rehash password of user %s: %v", user.ID, err)
+		}
+	}
+
+	// the failures are only forgotten once the second factor is passed too,
+	// a known password must not allow guessing codes forever
+	mfaEnabled, err := as.isMFAEnabled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if mfaEnabled {
+		return as.issueMFAChallenge(user)
+	}
+
+	sessionTokens, err := as.createSession(user, req.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	as.resetFailures(accountKey)
+
+	as.logUserLoggedIn(user.ID)
+	return &LoginResult{User: user, Tokens: sessionTokens}, nil
+}
+
+// UnlockAccount lifts a lockout before it runs out and forgets the failures
+func (as *authService) UnlockAccount(ctx context.Context, id uuid.UUID, reason string) error {
+	if err := as.authorizeUser(ctx, actionAccountUnlock, id); err != nil {
+		return err
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return models.NewInternalError(models.ContextBadRequest, "reason is required and cannot be empty")
+	}
+	if _, err := as.storage.RetrieveUser(id); err != nil {
+		return err
+	}
+
+	if err := as.guard.Reset(lockout.AccountKey(id)); err != nil {
+		return err
+	}
+	if err := as.storage.AppendUserHistory(models.NewUserHistoryEntry(id, models.HistoryEventAccountUnlocked, reason)); err != nil {
+		return err
+	}
+
+	log.Printf("Account of user %s unlocked (%s) at %v", id, reason, time.Now())
+	return nil
+}
+
+// lockedOutEvent is the data of the event telling downstream systems an
+// account was locked out
+type lockedOutEvent struct {
+	UserID   uuid.UUID `json:"user_id"`
+	LockedAt time.Time `json:"locked_at"`
+}
+
+// recordFailure counts a failed attempt against the account and returns
+// cause, a lockout of a known user is recorded in its history and published
+func (as *authService) recordFailure(userID uuid.UUID, key string, cause error) error {
+	lockedOut, err := as.guard.Fail(key)
+	if err != nil {
+		log.Printf("ERROR: failed to record failed attempt of %s: %v", key, err)
+		return cause
+	}
+	if !lockedOut {
+		return cause
+	}
+
+	log.Printf("Account %s locked out after too many failed attempts at %v", key, time.Now())
+	if userID != uuid.Nil {
+		entry := models.NewUserHistoryEntry(userID, models.HistoryEventAccountLocked, "too many failed attempts")
+		event, err := models.NewEvent(models.EventAccountLockedOut, userID, lockedOutEvent{UserID: userID, LockedAt: entry.CreatedAt})
+		if err != nil {
+			log.Printf("ERROR: failed to encode lockout event of user %s: %v", userID, err)
+			return cause
+		}
+		if err := as.storage.AppendUserHistoryAndEvent(entry, event); err != nil {
+			log.Printf("ERROR: failed to record lockout of user %s: %v", userID, err)
+		}
+	}
+	return cause
+}
+
+func (as *authService) resetFailures(key string) {
+	if err := as.guard.Reset(key); err != nil {
+		log.Printf("ERROR: failed to reset failed attempts of %s: %v", key, err)
+	}
+}
+
+func (as *authService) storePassword(id uuid.UUID, newPassword string) error {
+	hash, err := as.hasher.Hash(newPassword)
+	if err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, "failed to hash password")
+	}
+	return as.storage.SavePasswordCredential(models.NewPasswordCredential(id, hash))
+}
+
+// checkCanSignIn refuses users that were suspended or removed, users still
+// waiting for email verification may sign in
+func checkCanSignIn(user *models.User) error {
+	switch user.Status {
+	case models.UserStatusActive, models.UserStatusPending:
+		return nil
+	default:
+		return models.NewInternalError(models.ContextForbidden, "user is "+string(user.Status))
+	}
+}
+
+func (as *authService) logPasswordChanged(id uuid.UUID) {
+	log.Printf("User %s changed password at %v", id, time.Now())
+}
+
+func (as *authService) logUserLoggedIn(id uuid.UUID) {
+	log.Printf("User %s logged in at %v", id, time.Now())
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"users-microservice/pkg/authz"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/storage"
+
+	"github.com/google/uuid"
+)
+
+// Routes make sure callers hold the scope of an operation, what is left to
+// decide here is whom they may act on. Services act on any user, end users
+// only on themselves. Policies can narrow that down further. Public routes,
+// which authenticate users by their own credentials, and tools act as the
+// system principal and are trusted. Calls without a principal are refused.
+
+// actions name operations in policies
+const (
+	actionUsersCreate             = "users.create"
+	actionUsersGet                = "users.get"
+	actionUsersUpdate             = "users.update"
+	actionUsersHistory            = "users.history"
+	actionUsersAudit              = "users.audit"
+	actionUsersAccessLog          = "users.access_log"
+	actionUsersExport             = "users.export_personal_data"
+	actionUsersErase              = "users.erase_personal_data"
+	actionUsersErasureCertificate = "users.erasure_certificate"
+	actionEmailResendVerification = "users.email.resend_verification"
+	actionPhoneSendVerification   = "users.phone.send_verification"
+	actionPhoneVerify             = "users.phone.verify"
+	actionPasswordSet             = "users.password.set"
+	actionPasswordChange          = "users.password.change"
+	actionAccountUnlock           = "users.unlock"
+	actionSessionsList            = "users.sessions.list"
+	actionSessionsRevoke          = "users.sessions.revoke"
+	actionSessionsRevokeAll       = "users.sessions.revoke_all"
+	actionMFAEnroll               = "users.mfa.enroll"
+	actionMFAConfirm              = "users.mfa.confirm"
+	actionMFAStatus               = "users.mfa.status"
+	actionMFAReset                = "users.mfa.reset"
+	actionPasskeysRegister        = "users.passkeys.register"
+	actionPasskeysList            = "users.passkeys.list"
+	actionPasskeysRename          = "users.passkeys.rename"
+	actionPasskeysDelete          = "users.passkeys.delete"
+	actionIdentitiesLink          = "users.identities.link"
+	actionIdentitiesList          = "users.identities.list"
+	actionIdentitiesUnlink        = "users.identities.unlink"
+	actionAPIKeysIssue            = "api_keys.issue"
+	actionAPIKeysList             = "api_keys.list"
+	actionAPIKeysRevoke           = "api_keys.revoke"
+	actionClientsRegister         = "oidc.clients.register"
+	actionClientsGet              = "oidc.clients.get"
+	actionClientsDelete           = "oidc.clients.delete"
+	actionSigningKeysRotate       = "oidc.keys.rotate"
+	actionSCIMCreate              = "scim.users.create"
+	actionSCIMGet                 = "scim.users.get"
+	actionSCIMReplace             = "scim.users.replace"
+	actionSCIMPatch               = "scim.users.patch"
+	actionSCIMDelete              = "scim.users.delete"
+	actionSCIMList                = "scim.users.list"
+)
+
+// statusActionName names status changes in policies, e.g. "users.suspend"
+func statusActionName(action statusAction) string {
+	return "users." + string(action)
+}
+
+// authorizer is embedded by the services, policies are nil when none are
+// configured
+type authorizer struct {
+	users    storage.Storage
+	policies *authz.Engine
+}
+
+func newAuthorizer(storage storage.Storage, policies *authz.Engine) authorizer {
+	return authorizer{users: storage, policies: policies}
+}
+
+// caller returns the principal of the call, the system principal is trusted
+// and not returned
+func caller(ctx context.Context, action string) (*models.Principal, error) {
+	principal, ok := models.PrincipalFromContext(ctx)
+	if !ok {
+		return nil, models.NewInternalError(models.ContextUnauthorized, fmt.Sprintf("'%s' requires an authenticated caller", action))
+	}
+	if principal.Kind == models.PrincipalSystem {
+		return nil, nil
+	}
+	return principal, nil
+}
+
+// authorizeUser lets the caller act on the user. Other users look like they
+// do not exist to end users so their IDs cannot be probed
+func (a authorizer) authorizeUser(ctx context.Context, action string, id uuid.UUID) error {
+	principal, err := caller(ctx, action)
+	if err != nil || principal == nil {
+		return err
+	}
+	if principal.Kind == models.PrincipalUser && principal.UserID != id {
+		return userNotFound(id)
+	}
+	if a.policies == nil {
+		return nil
+	}
+
+	user, err := a.users.RetrieveUser(id)
+	if err != nil {
+		return err
+	}
+	return a.evaluate(ctx, principal, action, authz.UserAttributes(user))
+}
+
+// authorizeCreation lets end users only sign themselves up
+func (a authorizer) authorizeCreation(ctx context.Context, user *models.User) error {
+	principal, err := caller(ctx, actionUsersCreate)
+	if err != nil || principal == nil {
+		return err
+	}
+	if principal.Kind == models.PrincipalUser && principal.UserID != user.ID {
+		return models.NewInternalError(models.ContextForbidden, "users can only create their own account")
+	}
+	return a.evaluate(ctx, principal, actionUsersCreate, authz.UserAttributes(user))
+}
+
+// authorizeService reserves operations that are not about a single user to
+// services, the resource may be nil
+func (a authorizer) authorizeService(ctx context.Context, action string, resource map[string]any) error {
+	principal, err := caller(ctx, action)
+	if err != nil || principal == nil {
+		return err
+	}
+	if principal.Kind != models.PrincipalService {
+		return models.NewInternalError(models.ContextForbidden, fmt.Sprintf("'%s' is only available to services", action))
+	}
+	return a.evaluate(ctx, principal, action, resource)
+}
+
+// evaluate asks the policies, in audit mode their decision is only logged.
+// Fields an allowed decision masks are masked in the users the request
+// returns
+func (a authorizer) evaluate(ctx context.Context, principal *models.Principal, action string, resource map[string]any) error {
+	if a.policies == nil {
+		return nil
+	}
+	decision := a.policies.Evaluate(authz.Input{Principal: authz.PrincipalAttributes(principal), Action: action, Resource: resource})
+	if decision.Err != nil {
+		log.Printf("ERROR: policy rule %q failed on %s: %v", decision.Rule, action, decision.Err)
+	}
+
+	rule := decision.Rule
+	if rule == "" {
+		rule = "default"
+	}
+	if a.policies.Audit() {
+		verdict := "deny"
+		if decision.Allowed {
+			verdict = "allow"
+		}
+		log.Printf("Policy would %s %s to %s %s by %s at %v", verdict, action, principal.Kind, principal.Subject, rule, time.Now())
+		if decision.Allowed && len(decision.Mask) > 0 {
+			log.Printf("Policy would mask %s on %s to %s %s at %v", strings.Join(decision.Mask, ", "), action, principal.Kind, principal.Subject, time.Now())
+		}
+		return nil
+	}
+	if !decision.Allowed {
+		log.Printf("Policy denied %s to %s %s by %s at %v", action, principal.Kind, principal.Subject, rule, time.Now())
+		return models.NewInternalError(models.ContextForbidden, fmt.Sprintf("'%s' is denied by policy", action))
+	}
+	models.MaskFields(ctx, decision.Mask)
+	return nil
+}
+
+func userNotFound(id uuid.UUID) error {
+	return models.NewInternalError(models.ContextNotFound, fmt.Sprintf("user with '%s' ID does not exist", id))
+}
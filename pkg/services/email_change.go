@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+	"users-microservice/pkg/mailer"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/validation"
+
+	"github.com/google/uuid"
+)
+
+func (us *userService) ConfirmEmailChange(ctx context.Context, token string) (*models.User, error) {
+	userToken, err := consumeToken(us.storage, models.TokenPurposeEmailChange, token)
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := us.storage.RetrieveUser(userToken.UserID)
+	if err != nil {
+		return nil, err
+	}
+	confirmedAt := time.Now()
+	after := snapshot(before)
+	after.Email, after.EmailVerifiedAt = userToken.Payload, &confirmedAt
+	after.PendingEmail, after.PendingEmailExpiresAt = "", nil
+	audit, err := us.auditEntry(ctx, models.AuditActionUpdate, before, after)
+	if err != nil {
+		return nil, err
+	}
+	if err := us.storage.ConfirmPendingEmail(userToken.UserID, userToken.Payload, confirmedAt, audit); err != nil {
+		return nil, err
+	}
+	// only the change just confirmed can be reverted, links of earlier ones
+	// would roll back to a stale address
+	if err := us.storage.InvalidateOtherUserTokens(userToken.UserID, models.TokenPurposeEmailRevert, before.Email); err != nil {
+		return nil, err
+	}
+	if err := us.storage.AppendUserHistory(models.NewUserHistoryEntry(userToken.UserID, models.HistoryEventEmailChanged, "new email address confirmed")); err != nil {
+		return nil, err
+	}
+
+	user, err := us.storage.RetrieveUser(userToken.UserID)
+	if err != nil {
+		return nil, err
+	}
+	// the new address is verified now, which is all a pending user was waiting for
+	if user.Status == models.UserStatusPending {
+		if err := us.applyTransition(ctx, user, statusActionActivate, "email address verified"); err != nil {
+			return nil, err
+		}
+	}
+
+	us.logEmailChanged(user.ID)
+	return user, nil
+}
+
+func (us *userService) RevertEmailChange(ctx context.Context, token string) (*models.User, error) {
+	userToken, err := consumeToken(us.storage, models.TokenPurposeEmailRevert, token)
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := us.storage.RetrieveUser(userToken.UserID)
+	if err != nil {
+		return nil, err
+	}
+	revertedAt := time.Now()
+	after := snapshot(before)
+	after.Email, after.EmailVerifiedAt = userToken.Payload, &revertedAt
+	after.PendingEmail, after.PendingEmailExpiresAt = "", nil
+	audit, err := us.auditEntry(ctx, models.AuditActionUpdate, before, after)
+	if err != nil {
+		return nil, err
+	}
+	if err := us.storage.RevertEmail(userToken.UserID, userToken.Payload, revertedAt, audit); err != nil {
+		return nil, err
+	}
+	if err := us.storage.InvalidateUserTokens(userToken.UserID, models.TokenPurposeEmailChange); err != nil {
+		return nil, err
+	}
+	if err := us.storage.AppendUserHistory(models.NewUserHistoryEntry(userToken.UserID, models.HistoryEventEmailChangeReverted, "email change reverted from previous address")); err != nil {
+		return nil, err
+	}
+
+	user, err := us.storage.RetrieveUser(userToken.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	us.logEmailChangeReverted(userToken.UserID)
+	return user, nil
+}
+
+// requestEmailChange stores the new address as pending on the user, the caller
+// persists the user and then calls sendEmailChange
+func (us *userService) requestEmailChange(user *models.User, email string) error {
+	if err := validation.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	existing, err := us.storage.RetrieveUserByEmail(email)
+	if err == nil && existing.ID != user.ID {
+		return models.NewInternalError(models.ContextConflictValue, fmt.Sprintf("email '%s' is already in use", email))
+	}
+	if err != nil && models.ErrorContext(err) != models.ContextNotFound {
+		return err
+	}
+
+	expiresAt := time.Now().Add(us.cfg.EmailChangeTTL)
+	user.PendingEmail = email
+	user.PendingEmailExpiresAt = &expiresAt
+	return nil
+}
+
+// sendEmailChange asks the new address for confirmation and gives the current
+// one a way to undo the change. Links of earlier changes stop working
+func (us *userService) sendEmailChange(ctx context.Context, user *models.User) error {
+	if err := us.storage.InvalidateUserTokens(user.ID, models.TokenPurposeEmailChange); err != nil {
+		return err
+	}
+	if err := us.storage.InvalidateUserTokens(user.ID, models.TokenPurposeEmailRevert); err != nil {
+		return err
+	}
+
+	confirmToken, err := issueToken(us.storage, user.ID, models.TokenPurposeEmailChange, user.PendingEmail, us.cfg.EmailChangeTTL)
+	if err != nil {
+		return err
+	}
+	revertToken, err := issueToken(us.storage, user.ID, models.TokenPurposeEmailRevert, user.Email, us.cfg.EmailRevertTTL)
+	if err != nil {
+		return err
+	}
+
+	confirmLink := fmt.Sprintf("%s/confirm-email-change?token=%s", us.cfg.AppBaseURL, url.QueryEscape(confirmToken))
+	if err := us.mailer.Send(ctx, mailer.Message{
+		To:      user.PendingEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hello %s,\n\nplease confirm this is your new email address by opening %s\n\nUntil then your account keeps using the previous address.\n",
+			user.Name, confirmLink),
+	}); err != nil {
+		return err
+	}
+
+	revertLink := fmt.Sprintf("%s/revert-email-change?token=%s", us.cfg.AppBaseURL, url.QueryEscape(revertToken))
+	if err := us.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your email address is being changed",
+		Body: fmt.Sprintf("Hello %s,\n\na change of the email address of your account was requested.\nIf this was not you, keep this address by opening %s\n",
+			user.Name, revertLink),
+	}); err != nil {
+		return err
+	}
+
+	return us.storage.AppendUserHistory(models.NewUserHistoryEntry(user.ID, models.HistoryEventEmailChangeStarted, "confirmation sent to new email address"))
+}
+
+// cancelEmailChange drops a pending change, the caller persists the user
+func (us *userService) cancelEmailChange(user *models.User) error {
+	user.PendingEmail = ""
+	user.PendingEmailExpiresAt = nil
+	return us.storage.InvalidateUserTokens(user.ID, models.TokenPurposeEmailChange)
+}
+
+// withdrawEmailChange drops a pending change whose confirmation could not be
+// sent, so asking for the address again sends it anew
+func (us *userService) withdrawEmailChange(ctx context.Context, user *models.User) error {
+	before := snapshot(user)
+	if err := us.cancelEmailChange(user); err != nil {
+		return err
+	}
+	audit, err := us.auditEntry(ctx, models.AuditActionUpdate, before, user)
+	if err != nil {
+		return err
+	}
+	return us.storage.UpdateUser(user, nil, audit)
+}
+
+func (us *userService) logEmailChanged(id uuid.UUID) {
+	log.Printf("User %s changed email at %v", id, time.Now())
+}
+
+func (us *userService) logEmailChangeReverted(id uuid.UUID) {
+	log.Printf("User %s reverted email change at %v", id, time.Now())
+}
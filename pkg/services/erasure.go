@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// erasedData names what an erasure anonymizes or deletes, as recorded on
+// certificates. The audit log is kept for its chain, its personal data is
+// only ever hashed with the salt that is deleted
+var erasedData = []string{
+	"profile.name",
+	"profile.email",
+	"profile.date_of_birth",
+	"profile.phone_number",
+	"profile.pending_email",
+	"profile.region",
+	"status_history.reasons",
+	"audit_log.salt",
+	"credentials",
+	"sessions",
+	"passkeys",
+	"linked_identities",
+	"tokens",
+	"personal_data_exports",
+}
+
+// erasedEvent is the data of the event telling downstream systems to erase
+// the user too
+type erasedEvent struct {
+	UserID        uuid.UUID `json:"user_id"`
+	CertificateID uuid.UUID `json:"certificate_id"`
+	ErasedAt      time.Time `json:"erased_at"`
+}
+
+func (ps *privacyService) ErasePersonalData(ctx context.Context, id uuid.UUID) (*models.ErasureCertificate, error) {
+	user, err := ps.authorizePersonalData(ctx, actionUsersErase, id)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := ps.storage.RetrieveErasureCertificate(id)
+	if err == nil {
+		return certificate, nil
+	}
+	if models.ErrorContext(err) != models.ContextNotFound {
+		return nil, err
+	}
+	// erasing cannot be undone, the user has to be taken out of use first
+	if user.Status != models.UserStatusDeactivated && user.Status != models.UserStatusDeleted {
+		return nil, models.NewInternalError(models.ContextConflictValue, fmt.Sprintf("cannot erase user in '%s' status, deactivate it first", user.Status))
+	}
+
+	// the UUID stays so whatever refers to the user still does
+	erased := anonymize(user)
+	entry := models.NewUserHistoryEntry(id, models.HistoryEventPersonalDataErased, "")
+	entry.FromStatus, entry.ToStatus = user.Status, erased.Status
+	certificate = models.NewErasureCertificate(id, models.AuditActor(ctx), models.RequestIDFromContext(ctx), erasedData)
+	event, err := models.NewEvent(models.EventPersonalDataErased, id, erasedEvent{UserID: id, CertificateID: certificate.ID, ErasedAt: certificate.ErasedAt})
+	if err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to encode erasure event")
+	}
+	// the entry hashes the old values with the salt the erasure deletes
+	audit, err := ps.auditEntry(ctx, models.AuditActionErase, user, erased)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ps.storage.ErasePersonalData(erased, entry, certificate, event, audit); err != nil {
+		return nil, err
+	}
+	log.Printf("User %s erased by %s at %v", id, certificate.ErasedBy, certificate.ErasedAt)
+	return certificate, nil
+}
+
+func (ps *privacyService) GetErasureCertificate(ctx context.Context, id uuid.UUID) (*models.ErasureCertificate, error) {
+	if err := ps.authorizeUser(ctx, actionUsersErasureCertificate, id); err != nil {
+		return nil, err
+	}
+	if _, err := ps.storage.RetrieveUser(id); err != nil {
+		return nil, err
+	}
+	return ps.storage.RetrieveErasureCertificate(id)
+}
+
+// anonymize returns a copy of the user without its personal data, deleted
+func anonymize(user *models.User) *models.User {
+	erased := snapshot(user)
+	erased.Name = models.ErasedName
+	erased.Email = models.ErasedEmail(user.ID)
+	erased.DateOfBirth = time.Time{}
+	erased.Status = models.UserStatusDeleted
+	erased.EmailVerifiedAt = nil
+	erased.PendingEmail = ""
+	erased.PendingEmailExpiresAt = nil
+	erased.PhoneNumber = ""
+	erased.PhoneVerifiedAt = nil
+	erased.Region = ""
+	return erased
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"time"
+	"users-microservice/pkg/export"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// Sections of personal data exports, each service registers those of the
+// data it owns. Secrets such as password hashes, TOTP secrets and token
+// hashes are left out, the archive tells the user they exist
+
+type exportedProfile struct {
+	ID                    uuid.UUID  `json:"id"`
+	Name                  string     `json:"name"`
+	Email                 string     `json:"email"`
+	DateOfBirth           string     `json:"date_of_birth"`
+	Status                string     `json:"status"`
+	EmailVerifiedAt       *time.Time `json:"email_verified_at,omitempty"`
+	PendingEmail          string     `json:"pending_email,omitempty"`
+	PendingEmailExpiresAt *time.Time `json:"pending_email_expires_at,omitempty"`
+	PhoneNumber           string     `json:"phone_number,omitempty"`
+	PhoneVerifiedAt       *time.Time `json:"phone_verified_at,omitempty"`
+	Region                string     `json:"region,omitempty"`
+	CreatedAt             time.Time  `json:"created_at"`
+}
+
+type exportedHistoryEntry struct {
+	Event      string    `json:"event"`
+	FromStatus string    `json:"from_status,omitempty"`
+	ToStatus   string    `json:"to_status,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type exportedAuditEntry struct {
+	Sequence  int64                `json:"sequence"`
+	Action    string               `json:"action"`
+	Actor     string               `json:"actor"`
+	RequestID string               `json:"request_id,omitempty"`
+	Changes   []models.AuditChange `json:"changes"`
+	CreatedAt time.Time            `json:"created_at"`
+	Hash      string               `json:"hash"`
+}
+
+type exportedAccessRecord struct {
+	Accessor   string    `json:"accessor"`
+	Fields     []string  `json:"fields"`
+	Purpose    string    `json:"purpose,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	AccessedAt time.Time `json:"accessed_at"`
+}
+
+type exportedIdentity struct {
+	Provider   string     `json:"provider"`
+	Issuer     string     `json:"issuer"`
+	Subject    string     `json:"subject"`
+	Email      string     `json:"email,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+type exportedSession struct {
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type exportedPasskey struct {
+	Name         string     `json:"name"`
+	CredentialID string     `json:"credential_id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+}
+
+type exportedCredentials struct {
+	PasswordSetAt       *time.Time `json:"password_set_at,omitempty"`
+	MFAConfirmedAt      *time.Time `json:"mfa_confirmed_at,omitempty"`
+	UnusedRecoveryCodes int64      `json:"unused_recovery_codes"`
+}
+
+func (us *userService) registerExportCollectors(exporter *export.Exporter) {
+	exporter.Register("profile", export.CollectorFunc(us.collectProfile))
+	exporter.Register("status_history", export.CollectorFunc(us.collectHistory))
+	exporter.Register("audit_log", export.CollectorFunc(us.collectAuditLog))
+	exporter.Register("access_log", export.CollectorFunc(us.collectAccessLog))
+}
+
+func (as *authService) registerExportCollectors(exporter *export.Exporter) {
+	exporter.Register("credentials", export.CollectorFunc(as.collectCredentials))
+	exporter.Register("sessions", export.CollectorFunc(as.collectSessions))
+	exporter.Register("passkeys", export.CollectorFunc(as.collectPasskeys))
+	exporter.Register("linked_identities", export.CollectorFunc(as.collectIdentities))
+}
+
+func (us *userService) collectProfile(ctx context.Context, userID uuid.UUID) (any, error) {
+	user, err := us.storage.RetrieveUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return exportedProfile{
+		ID:                    user.ID,
+		Name:                  user.Name,
+		Email:                 user.Email,
+		DateOfBirth:           user.DateOfBirth.Format(time.DateOnly),
+		Status:                string(user.Status),
+		EmailVerifiedAt:       user.EmailVerifiedAt,
+		PendingEmail:          user.PendingEmail,
+		PendingEmailExpiresAt: user.PendingEmailExpiresAt,
+		PhoneNumber:           user.PhoneNumber,
+		PhoneVerifiedAt:       user.PhoneVerifiedAt,
+		Region:                user.Region,
+		CreatedAt:             user.CreatedAt,
+	}, nil
+}
+
+func (us *userService) collectHistory(ctx context.Context, userID uuid.UUID) (any, error) {
+	entries, err := us.storage.RetrieveUserHistory(userID)
+	if err != nil {
+		return nil, err
+	}
+	exported := make([]exportedHistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		exported = append(exported, exportedHistoryEntry{
+			Event:      entry.Event,
+			FromStatus: string(entry.FromStatus),
+			ToStatus:   string(entry.ToStatus),
+			Reason:     entry.Reason,
+			CreatedAt:  entry.CreatedAt,
+		})
+	}
+	return exported, nil
+}
+
+// collectAuditLog exports the entries as kept, personal data stays hashed
+func (us *userService) collectAuditLog(ctx context.Context, userID uuid.UUID) (any, error) {
+	entries, err := us.storage.RetrieveAuditEntries(userID)
+	if err != nil {
+		return nil, err
+	}
+	exported := make([]exportedAuditEntry, 0, len(entries))
+	for _, entry := range entries {
+		exported = append(exported, exportedAuditEntry{
+			Sequence:  entry.Sequence,
+			Action:    entry.Action,
+			Actor:     entry.Actor,
+			RequestID: entry.RequestID,
+			Changes:   entry.Changes,
+			CreatedAt: entry.CreatedAt,
+			Hash:      entry.Hash,
+		})
+	}
+	return exported, nil
+}
+
+// collectAccessLog exports every record still kept
+func (us *userService) collectAccessLog(ctx context.Context, userID uuid.UUID) (any, error) {
+	us.accessLog.Flush()
+	records, err := us.storage.RetrieveAccessRecords(userID, time.Time{}, -1)
+	if err != nil {
+		return nil, err
+	}
+	exported := make([]exportedAccessRecord, 0, len(records))
+	for _, record := range records {
+		exported = append(exported, exportedAccessRecord{
+			Accessor:   record.Accessor,
+			Fields:     record.Fields,
+			Purpose:    record.Purpose,
+			RequestID:  record.RequestID,
+			AccessedAt: record.AccessedAt,
+		})
+	}
+	return exported, nil
+}
+
+func (as *authService) collectCredentials(ctx context.Context, userID uuid.UUID) (any, error) {
+	var exported exportedCredentials
+	credential, err := as.storage.RetrievePasswordCredential(userID)
+	if err != nil && models.ErrorContext(err) != models.ContextNotFound {
+		return nil, err
+	}
+	if credential != nil {
+		exported.PasswordSetAt = &credential.UpdatedAt
+	}
+
+	totp, err := as.storage.RetrieveTOTPCredential(userID)
+	if err != nil && models.ErrorContext(err) != models.ContextNotFound {
+		return nil, err
+	}
+	if totp != nil && totp.ConfirmedAt != nil {
+		exported.MFAConfirmedAt = totp.ConfirmedAt
+		if exported.UnusedRecoveryCodes, err = as.storage.CountUnusedRecoveryCodes(userID); err != nil {
+			return nil, err
+		}
+	}
+	return exported, nil
+}
+
+func (as *authService) collectSessions(ctx context.Context, userID uuid.UUID) (any, error) {
+	sessions, err := as.storage.RetrieveActiveSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	exported := make([]exportedSession, 0, len(sessions))
+	for _, session := range sessions {
+		exported = append(exported, exportedSession{
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IPAddress,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+		})
+	}
+	return exported, nil
+}
+
+func (as *authService) collectPasskeys(ctx context.Context, userID uuid.UUID) (any, error) {
+	passkeys, err := as.storage.RetrievePasskeys(userID)
+	if err != nil {
+		return nil, err
+	}
+	exported := make([]exportedPasskey, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		exported = append(exported, exportedPasskey{
+			Name:         passkey.Name,
+			CredentialID: base64.RawURLEncoding.EncodeToString(passkey.CredentialID),
+			CreatedAt:    passkey.CreatedAt,
+			LastUsedAt:   passkey.LastUsedAt,
+		})
+	}
+	return exported, nil
+}
+
+func (as *authService) collectIdentities(ctx context.Context, userID uuid.UUID) (any, error) {
+	identities, err := as.storage.RetrieveFederatedIdentities(userID)
+	if err != nil {
+		return nil, err
+	}
+	exported := make([]exportedIdentity, 0, len(identities))
+	for _, identity := range identities {
+		exported = append(exported, exportedIdentity{
+			Provider:   identity.Provider,
+			Issuer:     identity.Issuer,
+			Subject:    identity.Subject,
+			Email:      identity.Email,
+			CreatedAt:  identity.CreatedAt,
+			LastUsedAt: identity.LastUsedAt,
+		})
+	}
+	return exported, nil
+}
@@ -0,0 +1,452 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/federation"
+	"users-microservice/pkg/lockout"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/oidc"
+	"users-microservice/pkg/tokens"
+	"users-microservice/pkg/validation"
+
+	"github.com/google/uuid"
+)
+
+// FederatedLoginStart sends the browser to the provider, the nonce has to
+// stay on the device like the one of a magic link
+type FederatedLoginStart struct {
+	AuthorizationURL string
+	Nonce            string
+}
+
+// FederatedCallbackRequest carries what the provider sent back to the
+// redirect page
+type FederatedCallbackRequest struct {
+	Provider string
+	Code     string
+	State    string
+	Nonce    string
+	Metadata SessionMetadata
+}
+
+// FederatedSignup is handed out when nobody is linked to the identity, the
+// token creates the account once the user adds what the provider did not tell
+type FederatedSignup struct {
+	Token     string
+	ExpiresAt time.Time
+	Provider  string
+	Email     string
+	Name      string
+}
+
+type FederatedSignupRequest struct {
+	Token string
+	// the name asserted by the provider when empty
+	Name        string
+	DateOfBirth time.Time
+	Metadata    SessionMetadata
+}
+
+// federationState is kept with the state token until the provider redirects
+// back, the device nonce is only bound for sign-ins
+type federationState struct {
+	// whom the token was issued to, nobody for sign-ins
+	UserID       uuid.UUID `json:"-"`
+	Provider     string    `json:"provider"`
+	NonceHash    string    `json:"nonce_hash,omitempty"`
+	IDTokenNonce string    `json:"id_token_nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+}
+
+// federatedIdentity is kept with the signup token
+type federatedIdentity struct {
+	Provider string `json:"provider"`
+	Issuer   string `json:"issuer"`
+	Subject  string `json:"subject"`
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+}
+
+func (as *authService) FederationProviders() []string {
+	return as.federation.Names()
+}
+
+func (as *authService) BeginFederatedLogin(ctx context.Context, providerName string) (*FederatedLoginStart, error) {
+	nonce, nonceHash, err := tokens.New()
+	if err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to generate device nonce")
+	}
+	authorizationURL, err := as.beginFederation(ctx, uuid.Nil, models.TokenPurposeFederatedLogin, providerName, nonceHash)
+	if err != nil {
+		return nil, err
+	}
+	return &FederatedLoginStart{AuthorizationURL: authorizationURL, Nonce: nonce}, nil
+}
+
+// FederatedLogin signs in the user linked to the identity, identities that
+// are not linked yet are linked by email when the provider allows it or lead
+// to a signup
+func (as *authService) FederatedLogin(ctx context.Context, req FederatedCallbackRequest) (*LoginResult, error) {
+	state, provider, identity, err := as.completeFederation(ctx, models.TokenPurposeFederatedLogin, req)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(state.NonceHash), []byte(tokens.Hash(req.Nonce))) != 1 {
+		log.Printf("Sign-in with %s opened on another device at %v", provider.Name, time.Now())
+		return nil, models.NewInternalError(models.ContextUnauthorized, "sign-in was started on another device")
+	}
+
+	var user *models.User
+	linked, err := as.storage.RetrieveFederatedIdentity(identity.Issuer, identity.Subject)
+	switch {
+	case err == nil:
+		if user, err = as.storage.RetrieveUser(linked.UserID); err != nil {
+			return nil, err
+		}
+		if err := as.checkCanSignInNow(user); err != nil {
+			return nil, err
+		}
+	case models.ErrorContext(err) == models.ContextNotFound:
+		user, linked, err = as.linkByEmail(provider, identity)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return as.startFederatedSignup(provider, identity)
+		}
+	default:
+		return nil, err
+	}
+
+	if err := as.storage.UseFederatedIdentity(linked.ID, identity.Email); err != nil {
+		return nil, err
+	}
+
+	// the provider replaces the password, not the second factor
+	mfaEnabled, err := as.isMFAEnabled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if mfaEnabled {
+		return as.issueMFAChallenge(user)
+	}
+
+	sessionTokens, err := as.createSession(user, req.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %s logged in with %s at %v", user.ID, provider.Name, time.Now())
+	return &LoginResult{User: user, Tokens: sessionTokens}, nil
+}
+
+// CompleteFederatedSignup creates the account of an identity nobody is
+// linked to, the email counts as verified as the provider verified it
+func (as *authService) CompleteFederatedSignup(ctx context.Context, req FederatedSignupRequest) (*LoginResult, error) {
+	userToken, err := consumeToken(as.storage, models.TokenPurposeFederatedSignup, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	var identity federatedIdentity
+	if err := json.Unmarshal([]byte(userToken.Payload), &identity); err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "signup token payload is malformed")
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = identity.Name
+	}
+	if err := validation.ValidateUser(name, identity.Email, req.DateOfBirth); err != nil {
+		return nil, err
+	}
+	if !isEligibleForRegistration(req.DateOfBirth) {
+		return nil, models.NewInternalError(models.ContextBadRequest, "user must have atleast 13 years to register")
+	}
+
+	// either may have happened since the token was issued
+	if _, err := as.storage.RetrieveUserByEmail(identity.Email); err == nil {
+		return nil, models.NewInternalError(models.ContextConflictValue, "email '"+identity.Email+"' is already in use")
+	} else if models.ErrorContext(err) != models.ContextNotFound {
+		return nil, err
+	}
+	if _, err := as.storage.RetrieveFederatedIdentity(identity.Issuer, identity.Subject); err == nil {
+		return nil, models.NewInternalError(models.ContextConflictValue, identity.Provider+" account is already linked")
+	} else if models.ErrorContext(err) != models.ContextNotFound {
+		return nil, err
+	}
+
+	verifiedAt := time.Now()
+	user := models.NewUser(uuid.New(), name, identity.Email, req.DateOfBirth)
+	user.Status = models.UserStatusActive
+	user.EmailVerifiedAt = &verifiedAt
+	audit, err := as.auditEntry(ctx, models.AuditActionCreate, nil, user)
+	if err != nil {
+		return nil, err
+	}
+	if err := as.storage.CreateUser(user, audit); err != nil {
+		return nil, err
+	}
+	log.Printf("User %s signed up with %s at %v", user.ID, identity.Provider, time.Now())
+
+	linked := models.NewFederatedIdentity(user.ID, identity.Provider, identity.Issuer, identity.Subject, identity.Email)
+	if err := as.linkIdentity(linked); err != nil {
+		return nil, err
+	}
+
+	sessionTokens, err := as.createSession(user, req.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{User: user, Tokens: sessionTokens}, nil
+}
+
+// BeginIdentityLink sends a signed-in user to the provider to link another
+// account, the state only completes a link for the same user
+func (as *authService) BeginIdentityLink(ctx context.Context, userID uuid.UUID, providerName string) (string, error) {
+	if err := as.authorizeUser(ctx, actionIdentitiesLink, userID); err != nil {
+		return "", err
+	}
+	user, err := as.storage.RetrieveUser(userID)
+	if err != nil {
+		return "", err
+	}
+	if err := checkCanSignIn(user); err != nil {
+		return "", err
+	}
+	return as.beginFederation(ctx, userID, models.TokenPurposeFederatedLink, providerName, "")
+}
+
+func (as *authService) LinkIdentity(ctx context.Context, userID uuid.UUID, req FederatedCallbackRequest) (*models.FederatedIdentity, error) {
+	if err := as.authorizeUser(ctx, actionIdentitiesLink, userID); err != nil {
+		return nil, err
+	}
+	state, provider, identity, err := as.completeFederation(ctx, models.TokenPurposeFederatedLink, req)
+	if err != nil {
+		return nil, err
+	}
+	if state.UserID != userID {
+		return nil, models.NewInternalError(models.ContextBadRequest, "link was started for another user")
+	}
+
+	linked, err := as.storage.RetrieveFederatedIdentity(identity.Issuer, identity.Subject)
+	if err == nil {
+		if linked.UserID == userID {
+			return nil, models.NewInternalError(models.ContextConflictValue, provider.Name+" account is already linked to this user")
+		}
+		return nil, models.NewInternalError(models.ContextConflictValue, provider.Name+" account is linked to another user")
+	}
+	if models.ErrorContext(err) != models.ContextNotFound {
+		return nil, err
+	}
+
+	linked = models.NewFederatedIdentity(userID, provider.Name, identity.Issuer, identity.Subject, identity.Email)
+	if err := as.linkIdentity(linked); err != nil {
+		return nil, err
+	}
+	return linked, nil
+}
+
+func (as *authService) ListIdentities(ctx context.Context, userID uuid.UUID) ([]models.FederatedIdentity, error) {
+	if err := as.authorizeUser(ctx, actionIdentitiesList, userID); err != nil {
+		return nil, err
+	}
+	if _, err := as.storage.RetrieveUser(userID); err != nil {
+		return nil, err
+	}
+	return as.storage.RetrieveFederatedIdentities(userID)
+}
+
+func (as *authService) UnlinkIdentity(ctx context.Context, userID uuid.UUID, identityID uuid.UUID) error {
+	if err := as.authorizeUser(ctx, actionIdentitiesUnlink, userID); err != nil {
+		return err
+	}
+	identity, err := as.storage.DeleteFederatedIdentity(userID, identityID)
+	if err != nil {
+		return err
+	}
+	if err := as.storage.AppendUserHistory(models.NewUserHistoryEntry(userID, models.HistoryEventIdentityUnlinked, identity.Provider)); err != nil {
+		return err
+	}
+
+	log.Printf("User %s unlinked %s account %s at %v", userID, identity.Provider, identity.ID, time.Now())
+	return nil
+}
+
+// beginFederation issues the state the provider echoes back and builds the
+// authorization URL, the code verifier and nonce never leave the service
+func (as *authService) beginFederation(ctx context.Context, userID uuid.UUID, purpose models.TokenPurpose, providerName string, nonceHash string) (string, error) {
+	provider, err := as.provider(providerName)
+	if err != nil {
+		return "", err
+	}
+
+	codeVerifier, _, err := tokens.New()
+	if err != nil {
+		return "", models.NewWrappedError(err, models.ContextInternalServer, "failed to generate code verifier")
+	}
+	idTokenNonce, _, err := tokens.New()
+	if err != nil {
+		return "", models.NewWrappedError(err, models.ContextInternalServer, "failed to generate nonce")
+	}
+	payload, err := json.Marshal(federationState{
+		Provider:     provider.Name,
+		NonceHash:    nonceHash,
+		IDTokenNonce: idTokenNonce,
+		CodeVerifier: codeVerifier,
+	})
+	if err != nil {
+		return "", models.NewWrappedError(err, models.ContextInternalServer, "failed to encode state")
+	}
+
+	state, err := issueToken(as.storage, userID, purpose, string(payload), as.cfg.FederationStateTTL)
+	if err != nil {
+		return "", err
+	}
+	authorizationURL, err := provider.AuthorizationURL(ctx, state, idTokenNonce, oidc.CodeChallenge(codeVerifier))
+	if err != nil {
+		return "", models.NewWrappedError(err, models.ContextInternalServer, "failed to reach "+provider.Name)
+	}
+	return authorizationURL, nil
+}
+
+// completeFederation uses up the state and redeems the code for the verified
+// identity
+func (as *authService) completeFederation(ctx context.Context, purpose models.TokenPurpose, req FederatedCallbackRequest) (*federationState, *federation.Provider, *federation.Identity, error) {
+	userToken, err := consumeToken(as.storage, purpose, req.State)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var state federationState
+	if err := json.Unmarshal([]byte(userToken.Payload), &state); err != nil {
+		return nil, nil, nil, models.NewWrappedError(err, models.ContextInternalServer, "state payload is malformed")
+	}
+	if state.Provider != req.Provider {
+		return nil, nil, nil, models.NewInternalError(models.ContextBadRequest, "state was issued for another provider")
+	}
+	provider, err := as.provider(state.Provider)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if strings.TrimSpace(req.Code) == "" {
+		return nil, nil, nil, models.NewInternalError(models.ContextBadRequest, "code is required and cannot be empty")
+	}
+	identity, err := provider.Exchange(ctx, req.Code, state.CodeVerifier, state.IDTokenNonce)
+	if err != nil {
+		log.Printf("Sign-in with %s rejected: %v", provider.Name, err)
+		return nil, nil, nil, models.NewWrappedError(err, models.ContextUnauthorized, "sign-in with "+provider.Name+" failed")
+	}
+	state.UserID = userToken.UserID
+	return &state, provider, identity, nil
+}
+
+// linkByEmail links the identity to the account with the same email if the
+// policy of the provider allows it and the account may sign in, a nil user
+// means there is no such account
+func (as *authService) linkByEmail(provider *federation.Provider, identity *federation.Identity) (*models.User, *models.FederatedIdentity, error) {
+	if identity.Email == "" {
+		return nil, nil, nil
+	}
+	user, err := as.storage.RetrieveUserByEmail(identity.Email)
+	if err != nil {
+		if models.ErrorContext(err) == models.ContextNotFound {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	// both sides have to have proven they own the address, otherwise an
+	// account at the provider could take over the one here or the other way round
+	if provider.LinkPolicy != config.LinkPolicyVerifiedEmail || !identity.EmailVerified || user.EmailVerifiedAt == nil {
+		return nil, nil, models.NewInternalError(models.ContextConflictValue, "an account with this email exists, sign in and link "+provider.Name+" to it")
+	}
+	// links are for good, blocked and locked accounts do not get one
+	if err := as.checkCanSignInNow(user); err != nil {
+		return nil, nil, err
+	}
+
+	linked := models.NewFederatedIdentity(user.ID, provider.Name, identity.Issuer, identity.Subject, identity.Email)
+	if err := as.linkIdentity(linked); err != nil {
+		return nil, nil, err
+	}
+	return user, linked, nil
+}
+
+func (as *authService) startFederatedSignup(provider *federation.Provider, identity *federation.Identity) (*LoginResult, error) {
+	if !as.cfg.FederationSignup {
+		return nil, models.NewInternalError(models.ContextForbidden, "no account is linked to this "+provider.Name+" account")
+	}
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, models.NewInternalError(models.ContextForbidden, provider.Name+" did not share a verified email address")
+	}
+
+	payload, err := json.Marshal(federatedIdentity{
+		Provider: provider.Name,
+		Issuer:   identity.Issuer,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		Name:     identity.Name,
+	})
+	if err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to encode identity")
+	}
+	token, err := issueToken(as.storage, uuid.Nil, models.TokenPurposeFederatedSignup, string(payload), as.cfg.FederationStateTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{Signup: &FederatedSignup{
+		Token:     token,
+		ExpiresAt: time.Now().Add(as.cfg.FederationStateTTL),
+		Provider:  provider.Name,
+		Email:     identity.Email,
+		Name:      identity.Name,
+	}}, nil
+}
+
+// linkIdentity refuses a second account of the same provider up front, the
+// unique index only catches it on Postgres with a useful message
+func (as *authService) linkIdentity(identity *models.FederatedIdentity) error {
+	existing, err := as.storage.RetrieveFederatedIdentities(identity.UserID)
+	if err != nil {
+		return err
+	}
+	for _, other := range existing {
+		if other.Provider == identity.Provider {
+			return models.NewInternalError(models.ContextConflictValue, "user already has a linked "+identity.Provider+" account")
+		}
+	}
+
+	if err := as.storage.CreateFederatedIdentity(identity); err != nil {
+		return err
+	}
+	if err := as.storage.AppendUserHistory(models.NewUserHistoryEntry(identity.UserID, models.HistoryEventIdentityLinked, identity.Provider)); err != nil {
+		return err
+	}
+
+	log.Printf("User %s linked %s account %s at %v", identity.UserID, identity.Provider, identity.ID, time.Now())
+	return nil
+}
+
+func (as *authService) provider(name string) (*federation.Provider, error) {
+	provider, ok := as.federation.Provider(name)
+	if !ok {
+		return nil, models.NewInternalError(models.ContextNotFound, "provider '"+name+"' is not configured")
+	}
+	return provider, nil
+}
+
+// checkCanSignInNow refuses users that may not sign in at all and accounts
+// that are locked out for now
+func (as *authService) checkCanSignInNow(user *models.User) error {
+	if err := checkCanSignIn(user); err != nil {
+		return err
+	}
+	return as.guard.Check(lockout.AccountKey(user.ID))
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"strings"
+	"time"
+	"users-microservice/pkg/lockout"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/tokens"
+)
+
+// MagicLinkLoginRequest carries the emailed token together with the nonce
+// the requesting device was given
+type MagicLinkLoginRequest struct {
+	Token    string
+	Nonce    string
+	Metadata SessionMetadata
+}
+
+// RequestMagicLink emails a sign-in link bound to the returned nonce, which
+// has to stay on the requesting device. The nonce the device already holds
+// is kept, so asking again does not unbind a link that is already sent. Like
+// the password reset it never tells whether anything was sent, a nonce is
+// returned in every case and the link is sent in the background
+func (as *authService) RequestMagicLink(ctx context.Context, email string, nonce string) (string, error) {
+	if !tokens.WellFormed(nonce) {
+		var err error
+		if nonce, _, err = tokens.New(); err != nil {
+			return "", models.NewWrappedError(err, models.ContextInternalServer, "failed to generate device nonce")
+		}
+	}
+
+	user, err := as.storage.RetrieveUserByEmail(strings.TrimSpace(email))
+	if err != nil {
+		if models.ErrorContext(err) == models.ContextNotFound {
+			return nonce, nil
+		}
+		return "", err
+	}
+	if checkCanSignIn(user) != nil {
+		return nonce, nil
+	}
+
+	as.notifyInBackground(ctx, user.ID, "magic link", func(ctx context.Context) error {
+		return as.sendMagicLink(ctx, user, tokens.Hash(nonce))
+	})
+	return nonce, nil
+}
+
+func (as *authService) sendMagicLink(ctx context.Context, user *models.User, nonceHash string) error {
+	issued, err := as.storage.RetrieveUserTokensSince(user.ID, models.TokenPurposeMagicLink, time.Now().Add(-as.cfg.VerificationResendDelay))
+	if err != nil {
+		return err
+	}
+	if len(issued) > 0 {
+		log.Printf("Magic link for user %s throttled at %v", user.ID, time.Now())
+		return nil
+	}
+
+	// only the latest link works, it belongs to the latest device
+	if err := as.storage.InvalidateUserTokens(user.ID, models.TokenPurposeMagicLink); err != nil {
+		return err
+	}
+	token, err := issueToken(as.storage, user.ID, models.TokenPurposeMagicLink, nonceHash, as.cfg.MagicLinkTTL)
+	if err != nil {
+		return err
+	}
+	if err := as.notifier.NotifyMagicLink(ctx, user, token, time.Now().Add(as.cfg.MagicLinkTTL)); err != nil {
+		return err
+	}
+
+	log.Printf("Magic link for user %s requested at %v", user.ID, time.Now())
+	return nil
+}
+
+// ConsumeMagicLink signs the user in, the link is used up even when it is
+// opened on another device so a leaked link cannot be tried twice
+func (as *authService) ConsumeMagicLink(ctx context.Context, req MagicLinkLoginRequest) (*LoginResult, error) {
+	userToken, err := consumeToken(as.storage, models.TokenPurposeMagicLink, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(userToken.Payload), []byte(tokens.Hash(req.Nonce))) != 1 {
+		log.Printf("Magic link of user %s opened on another device at %v", userToken.UserID, time.Now())
+		return nil, models.NewInternalError(models.ContextUnauthorized, "sign-in link was requested on another device")
+	}
+
+	user, err := as.storage.RetrieveUser(userToken.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCanSignIn(user); err != nil {
+		return nil, err
+	}
+	if err := as.guard.Check(lockout.AccountKey(user.ID)); err != nil {
+		return nil, err
+	}
+
+	// the link replaces the password, not the second factor
+	mfaEnabled, err := as.isMFAEnabled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if mfaEnabled {
+		return as.issueMFAChallenge(user)
+	}
+
+	sessionTokens, err := as.createSession(user, req.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %s logged in with a magic link at %v", user.ID, time.Now())
+	return &LoginResult{User: user, Tokens: sessionTokens}, nil
+}
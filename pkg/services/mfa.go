@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"log"
+	"strings"
+	"time"
+	"users-microservice/pkg/lockout"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/tokens"
+	"users-microservice/pkg/totp"
+
+	"github.com/google/uuid"
+)
+
+const (
+	recoveryCodeCount = 10
+	// steps accepted on either side of the current one
+	totpSkew = 1
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPEnrollment is shown to the user once to set up the authenticator app
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+type MFAStatus struct {
+	Enabled                bool
+	RecoveryCodesRemaining int64
+}
+
+// MFALoginRequest completes a login that was answered with an MFA challenge,
+// either the code or a recovery code has to be set
+type MFALoginRequest struct {
+	MFAToken     string
+	Code         string
+	RecoveryCode string
+	Metadata     SessionMetadata
+}
+
+func (as *authService) EnrollTOTP(ctx context.Context, userID uuid.UUID) (*TOTPEnrollment, error) {
+	if err := as.authorizeUser(ctx, actionMFAEnroll, userID); err != nil {
+		return nil, err
+	}
+	user, err := as.storage.RetrieveUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCanSignIn(user); err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to generate authenticator secret")
+	}
+	encrypted, err := as.cipher.Encrypt([]byte(secret), userID[:])
+	if err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to encrypt authenticator secret")
+	}
+	if err := as.storage.SaveUnconfirmedTOTPCredential(models.NewTOTPCredential(userID, encrypted)); err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(as.cfg.MFAIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables the factor once the user proves the app is set up and
+// returns the recovery codes, they are never retrievable again
+func (as *authService) ConfirmTOTP(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	if err := as.authorizeUser(ctx, actionMFAConfirm, userID); err != nil {
+		return nil, err
+	}
+	credential, err := as.storage.RetrieveTOTPCredential(userID)
+	if err != nil {
+		return nil, err
+	}
+	if credential.IsConfirmed() {
+		return nil, models.NewInternalError(models.ContextConflictValue, "authenticator is already confirmed")
+	}
+
+	step, err := as.validateTOTP(credential, code)
+	if err != nil {
+		return nil, err
+	}
+
+	plainCodes, recoveryCodes, err := generateRecoveryCodes(userID)
+	if err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to generate recovery codes")
+	}
+	if err := as.storage.ConfirmTOTPCredential(userID, step, recoveryCodes); err != nil {
+		return nil, err
+	}
+	if err := as.storage.AppendUserHistory(models.NewUserHistoryEntry(userID, models.HistoryEventMFAEnabled, "")); err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %s enabled MFA at %v", userID, time.Now())
+	return plainCodes, nil
+}
+
+func (as *authService) GetMFAStatus(ctx context.Context, userID uuid.UUID) (*MFAStatus, error) {
+	if err := as.authorizeUser(ctx, actionMFAStatus, userID); err != nil {
+		return nil, err
+	}
+	if _, err := as.storage.RetrieveUser(userID); err != nil {
+		return nil, err
+	}
+	enabled, err := as.isMFAEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return &MFAStatus{}, nil
+	}
+
+	remaining, err := as.storage.CountUnusedRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &MFAStatus{Enabled: true, RecoveryCodesRemaining: remaining}, nil
+}
+
+// ResetMFA is the administrative way out for users who lost both their
+// authenticator and recovery codes
+func (as *authService) ResetMFA(ctx context.Context, userID uuid.UUID, reason string) error {
+	if err := as.authorizeUser(ctx, actionMFAReset, userID); err != nil {
+		return err
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return models.NewInternalError(models.ContextBadRequest, "reason is required and cannot be empty")
+	}
+	if _, err := as.storage.RetrieveUser(userID); err != nil {
+		return err
+	}
+
+	if err := as.storage.DeleteMFA(userID); err != nil {
+		return err
+	}
+	if err := as.storage.AppendUserHistory(models.NewUserHistoryEntry(userID, models.HistoryEventMFAReset, reason)); err != nil {
+		return err
+	}
+
+	log.Printf("MFA of user %s reset (%s) at %v", userID, reason, time.Now())
+	return nil
+}
+
+// CompleteMFALogin checks the second factor, the challenge is consumed on the
+// first attempt so a wrong code means starting over with the password
+func (as *authService) CompleteMFALogin(ctx context.Context, req MFALoginRequest) (*LoginResult, error) {
+	challenge, err := consumeToken(as.storage, models.TokenPurposeMFAChallenge, req.MFAToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := as.storage.RetrieveUser(challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCanSignIn(user); err != nil {
+		return nil, err
+	}
+
+	accountKey := lockout.AccountKey(user.ID)
+	if err := as.guard.Check(accountKey); err != nil {
+		return nil, err
+	}
+	if err := as.verifySecondFactor(user.ID, req); err != nil {
+		if models.ErrorContext(err) == models.ContextUnauthorized {
+			return nil, as.recordFailure(user.ID, accountKey, err)
+		}
+		return nil, err
+	}
+
+	sessionTokens, err := as.createSession(user, req.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	as.resetFailures(accountKey)
+
+	as.logUserLoggedIn(user.ID)
+	return &LoginResult{User: user, Tokens: sessionTokens}, nil
+}
+
+func (as *authService) verifySecondFactor(userID uuid.UUID, req MFALoginRequest) error {
+	if req.RecoveryCode != "" {
+		if err := as.storage.ConsumeRecoveryCode(userID, tokens.Hash(normalizeRecoveryCode(req.RecoveryCode))); err != nil {
+			return err
+		}
+		log.Printf("User %s signed in with a recovery code at %v", userID, time.Now())
+		return nil
+	}
+
+	credential, err := as.storage.RetrieveTOTPCredential(userID)
+	if err != nil {
+		return err
+	}
+	step, err := as.validateTOTP(credential, req.Code)
+	if err != nil {
+		return err
+	}
+	return as.storage.UseTOTPStep(userID, step)
+}
+
+func (as *authService) isMFAEnabled(userID uuid.UUID) (bool, error) {
+	credential, err := as.storage.RetrieveTOTPCredential(userID)
+	if err != nil {
+		if models.ErrorContext(err) == models.ContextNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return credential.IsConfirmed(), nil
+}
+
+// issueMFAChallenge is what a login with a correct password gets when the
+// user has a second factor
+func (as *authService) issueMFAChallenge(user *models.User) (*LoginResult, error) {
+	token, err := issueToken(as.storage, user.ID, models.TokenPurposeMFAChallenge, "", as.cfg.MFAChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{MFAToken: token, MFATokenExpiresAt: time.Now().Add(as.cfg.MFAChallengeTTL)}, nil
+}
+
+func (as *authService) validateTOTP(credential *models.TOTPCredential, code string) (int64, error) {
+	secret, err := as.cipher.Decrypt(credential.EncryptedSecret, credential.UserID[:])
+	if err != nil {
+		return 0, models.NewWrappedError(err, models.ContextInternalServer, "failed to decrypt authenticator secret")
+	}
+	step, ok, err := totp.Validate(string(secret), code, time.Now(), totpSkew)
+	if err != nil {
+		return 0, models.NewWrappedError(err, models.ContextInternalServer, "failed to validate code")
+	}
+	if !ok {
+		return 0, models.NewInternalError(models.ContextUnauthorized, "code is invalid")
+	}
+	return step, nil
+}
+
+// generateRecoveryCodes returns codes formatted for the user and their hashes
+func generateRecoveryCodes(userID uuid.UUID) ([]string, []models.RecoveryCode, error) {
+	plainCodes := make([]string, 0, recoveryCodeCount)
+	recoveryCodes := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		encoded := strings.ToLower(recoveryCodeEncoding.EncodeToString(raw))
+		plainCodes = append(plainCodes, encoded[:8]+"-"+encoded[8:])
+		recoveryCodes = append(recoveryCodes, *models.NewRecoveryCode(userID, tokens.Hash(encoded)))
+	}
+	return plainCodes, recoveryCodes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+	"users-microservice/pkg/mailer"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// a notification taking longer is given up
+const notifyTimeout = time.Minute
+
+// AuthNotifier delivers sign-in and reset tokens to the user
+type AuthNotifier interface {
+	NotifyPasswordReset(ctx context.Context, user *models.User, token string, expiresAt time.Time) error
+	NotifyMagicLink(ctx context.Context, user *models.User, token string, expiresAt time.Time) error
+}
+
+type mailAuthNotifier struct {
+	mailer  mailer.Mailer
+	baseURL string
+}
+
+// NewMailAuthNotifier sends the links to the email of the user
+func NewMailAuthNotifier(mailer mailer.Mailer, baseURL string) AuthNotifier {
+	return &mailAuthNotifier{mailer: mailer, baseURL: baseURL}
+}
+
+func (n *mailAuthNotifier) NotifyPasswordReset(ctx context.Context, user *models.User, token string, expiresAt time.Time) error {
+	link := fmt.Sprintf("%s/reset-password?token=%s", n.baseURL, url.QueryEscape(token))
+	return n.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nyou can choose a new password by opening %s\n\nThe link expires at %s. If you did not ask for this, ignore this email.\n",
+			user.Name, link, expiresAt.Format(time.RFC1123)),
+	})
+}
+
+func (n *mailAuthNotifier) NotifyMagicLink(ctx context.Context, user *models.User, token string, expiresAt time.Time) error {
+	link := fmt.Sprintf("%s/magic-link?token=%s", n.baseURL, url.QueryEscape(token))
+	return n.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Hello %s,\n\nyou can sign in by opening %s on the device you requested it from.\n\nThe link works once and expires at %s. If you did not ask for this, ignore this email.\n",
+			user.Name, link, expiresAt.Format(time.RFC1123)),
+	})
+}
+
+// notifyInBackground issues and sends the token after the request returned,
+// so an email with an account answers as fast as one without. Failures are
+// only logged, the caller is never told whether anything was sent
+func (as *authService) notifyInBackground(ctx context.Context, userID uuid.UUID, what string, notify func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), notifyTimeout)
+	go func() {
+		defer cancel()
+		if err := notify(ctx); err != nil {
+			log.Printf("ERROR: failed to send %s to user %s: %v", what, userID, err)
+		}
+	}()
+}
@@ -0,0 +1,464 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"users-microservice/pkg/authz"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/oidc"
+	"users-microservice/pkg/storage"
+	"users-microservice/pkg/tokens"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+type OIDCService interface {
+	RegisterClient(context.Context, OIDCClientRequest) (*OIDCClientRegistration, error)
+	GetClient(context.Context, string) (*models.OIDCClient, error)
+	DeleteClient(context.Context, string) error
+	ValidateAuthorization(context.Context, AuthorizationRequest) error
+	Authorize(context.Context, AuthorizationRequest, *models.Session) (string, error)
+	ExchangeCode(context.Context, TokenRequest) (*OIDCTokens, error)
+	UserInfo(context.Context, string) (*oidc.UserInfo, error)
+	ProviderMetadata() *oidc.ProviderMetadata
+	JWKS(context.Context) (*oidc.JSONWebKeySet, error)
+	RotateSigningKey(context.Context) (string, error)
+}
+
+type OIDCClientRequest struct {
+	Name         string
+	RedirectURIs []string
+	// public clients, e.g. single page or native apps, cannot keep a secret
+	Public bool
+}
+
+// OIDCClientRegistration carries the client secret, it is only available
+// right after registration
+type OIDCClientRegistration struct {
+	Client *models.OIDCClient
+	Secret string
+}
+
+// AuthorizationRequest holds the parameters of the authorization endpoint
+type AuthorizationRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// TokenRequest holds the parameters of the token endpoint, the client
+// credentials come from basic auth or the form
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	ClientID     string
+	ClientSecret string
+}
+
+type OIDCTokens struct {
+	AccessToken string
+	IDToken     string
+	ExpiresIn   time.Duration
+	Scope       string
+}
+
+// authorizationCode is kept as the payload of the code token
+type authorizationCode struct {
+	ClientID      string    `json:"client_id"`
+	RedirectURI   string    `json:"redirect_uri"`
+	Scopes        []string  `json:"scopes"`
+	Nonce         string    `json:"nonce,omitempty"`
+	CodeChallenge string    `json:"code_challenge"`
+	SessionID     uuid.UUID `json:"session_id"`
+	AuthTime      time.Time `json:"auth_time"`
+}
+
+type oidcService struct {
+	authorizer
+	storage storage.Storage
+	keys    *oidc.KeySet
+	cfg     *config.Config
+}
+
+func NewOIDCService(storage storage.Storage, keys *oidc.KeySet, policies *authz.Engine, cfg *config.Config) (OIDCService, error) {
+	return &oidcService{authorizer: newAuthorizer(storage, policies), storage: storage, keys: keys, cfg: cfg}, nil
+}
+
+func (op *oidcService) RegisterClient(ctx context.Context, req OIDCClientRequest) (*OIDCClientRegistration, error) {
+	if err := op.authorizeService(ctx, actionClientsRegister, nil); err != nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, models.NewInternalError(models.ContextBadRequest, "client name is required and cannot be empty")
+	}
+	if len(req.RedirectURIs) == 0 {
+		return nil, models.NewInternalError(models.ContextBadRequest, "at least one redirect URI is required")
+	}
+	for _, uri := range req.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return nil, err
+		}
+	}
+
+	registration := &OIDCClientRegistration{}
+	secretHash := ""
+	if !req.Public {
+		secret, hash, err := tokens.New()
+		if err != nil {
+			return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to generate client secret")
+		}
+		registration.Secret = secret
+		secretHash = hash
+	}
+	registration.Client = models.NewOIDCClient(uuid.NewString(), name, secretHash, req.RedirectURIs)
+	if err := op.storage.CreateOIDCClient(registration.Client); err != nil {
+		return nil, err
+	}
+
+	op.logClientRegistered(registration.Client.ID)
+	return registration, nil
+}
+
+func (op *oidcService) GetClient(ctx context.Context, id string) (*models.OIDCClient, error) {
+	if err := op.authorizeService(ctx, actionClientsGet, map[string]any{"id": id}); err != nil {
+		return nil, err
+	}
+	return op.storage.RetrieveOIDCClient(id)
+}
+
+func (op *oidcService) DeleteClient(ctx context.Context, id string) error {
+	if err := op.authorizeService(ctx, actionClientsDelete, map[string]any{"id": id}); err != nil {
+		return err
+	}
+	if err := op.storage.DeleteOIDCClient(id); err != nil {
+		return err
+	}
+	op.logClientDeleted(id)
+	return nil
+}
+
+// ValidateAuthorization returns a plain error when the client or redirect URI
+// cannot be trusted, anything else is an oidc.Error meant to be redirected
+// back to the client
+func (op *oidcService) ValidateAuthorization(ctx context.Context, req AuthorizationRequest) error {
+	_, err := op.validateAuthorization(req)
+	return err
+}
+
+func (op *oidcService) validateAuthorization(req AuthorizationRequest) ([]string, error) {
+	if req.ClientID == "" {
+		return nil, models.NewInternalError(models.ContextBadRequest, "client_id is required")
+	}
+	client, err := op.storage.RetrieveOIDCClient(req.ClientID)
+	if err != nil {
+		if models.ErrorContext(err) == models.ContextNotFound {
+			return nil, models.NewInternalError(models.ContextBadRequest, "client is not registered")
+		}
+		return nil, err
+	}
+	if !client.AllowsRedirectURI(req.RedirectURI) {
+		return nil, models.NewInternalError(models.ContextBadRequest, "redirect_uri is not registered for the client")
+	}
+
+	if req.ResponseType != "code" {
+		return nil, authorizationError(oidc.ErrorUnsupportedResponseType, "only the code response type is supported")
+	}
+	scopes := oidc.ParseScope(req.Scope)
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		return nil, authorizationError(oidc.ErrorInvalidScope, "openid scope is required")
+	}
+	if req.CodeChallenge == "" {
+		return nil, authorizationError(oidc.ErrorInvalidRequest, "code_challenge is required")
+	}
+	if req.CodeChallengeMethod != oidc.CodeChallengeMethodS256 {
+		return nil, authorizationError(oidc.ErrorInvalidRequest, "code_challenge_method must be S256")
+	}
+	if !oidc.ValidCodeChallenge(req.CodeChallenge) {
+		return nil, authorizationError(oidc.ErrorInvalidRequest, "code_challenge is malformed")
+	}
+	return scopes, nil
+}
+
+// Authorize issues an authorization code for the signed-in user and returns
+// the URL to send the user back to the client with
+func (op *oidcService) Authorize(ctx context.Context, req AuthorizationRequest, session *models.Session) (string, error) {
+	scopes, err := op.validateAuthorization(req)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := op.storage.RetrieveUser(session.UserID)
+	if err != nil {
+		return "", err
+	}
+	if err := checkCanSignIn(user); err != nil {
+		return "", authorizationError(oidc.ErrorAccessDenied, err.Error())
+	}
+
+	payload, err := json.Marshal(authorizationCode{
+		ClientID:      req.ClientID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        scopes,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		SessionID:     session.ID,
+		AuthTime:      session.CreatedAt,
+	})
+	if err != nil {
+		return "", models.NewWrappedError(err, models.ContextInternalServer, "failed to issue authorization code")
+	}
+	code, err := issueToken(op.storage, user.ID, models.TokenPurposeOIDCCode, string(payload), op.cfg.OIDCCodeTTL)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{"code": {code}, "iss": {op.cfg.OIDCIssuer}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	op.logAuthorized(user.ID, req.ClientID)
+	return oidc.RedirectURL(req.RedirectURI, params), nil
+}
+
+func (op *oidcService) ExchangeCode(ctx context.Context, req TokenRequest) (*OIDCTokens, error) {
+	if req.GrantType != "authorization_code" {
+		return nil, tokenError(oidc.ErrorUnsupportedGrantType, "only the authorization_code grant is supported")
+	}
+	client, err := op.authenticateClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if req.Code == "" || req.CodeVerifier == "" {
+		return nil, tokenError(oidc.ErrorInvalidRequest, "code and code_verifier are required")
+	}
+
+	invalidGrant := tokenError(oidc.ErrorInvalidGrant, "authorization code is invalid or has expired")
+	codeToken, err := consumeToken(op.storage, models.TokenPurposeOIDCCode, req.Code)
+	if err != nil {
+		if models.ErrorContext(err) == models.ContextBadRequest {
+			return nil, invalidGrant
+		}
+		return nil, err
+	}
+	var code authorizationCode
+	if err := json.Unmarshal([]byte(codeToken.Payload), &code); err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to read authorization code")
+	}
+	if code.ClientID != client.ID || code.RedirectURI != req.RedirectURI {
+		return nil, invalidGrant
+	}
+	if !oidc.VerifyCodeChallenge(req.CodeVerifier, code.CodeChallenge) {
+		return nil, tokenError(oidc.ErrorInvalidGrant, "code_verifier does not match the code challenge")
+	}
+
+	// signing out or being suspended in the meantime withdraws the grant
+	session, err := op.storage.RetrieveSession(code.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !session.IsActive() {
+		return nil, invalidGrant
+	}
+	user, err := op.storage.RetrieveUser(codeToken.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCanSignIn(user); err != nil {
+		return nil, invalidGrant
+	}
+
+	issued, err := op.issueTokens(user, client, code)
+	if err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to sign tokens")
+	}
+	op.logTokensIssued(user.ID, client.ID)
+	return issued, nil
+}
+
+func (op *oidcService) UserInfo(ctx context.Context, accessToken string) (*oidc.UserInfo, error) {
+	invalidToken := models.NewWrappedError(oidc.NewError(oidc.ErrorInvalidToken, "access token is invalid or has expired"), models.ContextUnauthorized, "access token is invalid or has expired")
+
+	claims := &oidc.AccessTokenClaims{}
+	token, err := op.keys.Parse(accessToken, claims,
+		jwt.WithIssuer(op.cfg.OIDCIssuer),
+		jwt.WithAudience(op.cfg.OIDCIssuer),
+		jwt.WithExpirationRequired())
+	if err != nil || token.Header["typ"] != oidc.AccessTokenType {
+		return nil, invalidToken
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, invalidToken
+	}
+
+	user, err := op.storage.RetrieveUser(userID)
+	if err != nil {
+		if models.ErrorContext(err) == models.ContextNotFound {
+			return nil, invalidToken
+		}
+		return nil, err
+	}
+	if err := checkCanSignIn(user); err != nil {
+		return nil, invalidToken
+	}
+
+	return &oidc.UserInfo{
+		Subject:    user.ID.String(),
+		UserClaims: oidc.NewUserClaims(user, strings.Fields(claims.Scope)),
+	}, nil
+}
+
+func (op *oidcService) ProviderMetadata() *oidc.ProviderMetadata {
+	return oidc.NewProviderMetadata(op.cfg.OIDCIssuer)
+}
+
+func (op *oidcService) JWKS(ctx context.Context) (*oidc.JSONWebKeySet, error) {
+	set, err := op.keys.JWKS()
+	if err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to load signing keys")
+	}
+	return set, nil
+}
+
+func (op *oidcService) RotateSigningKey(ctx context.Context) (string, error) {
+	if err := op.authorizeService(ctx, actionSigningKeysRotate, nil); err != nil {
+		return "", err
+	}
+	kid, err := op.keys.Rotate()
+	if err != nil {
+		return "", models.NewWrappedError(err, models.ContextInternalServer, "failed to rotate signing key")
+	}
+	log.Printf("OIDC signing key rotated to %s at %v", kid, time.Now())
+	return kid, nil
+}
+
+// authenticateClient checks the secret of confidential clients, public ones
+// are only identified and have to prove the code with PKCE
+func (op *oidcService) authenticateClient(clientID string, secret string) (*models.OIDCClient, error) {
+	invalidClient := tokenError(oidc.ErrorInvalidClient, "client authentication failed")
+	if clientID == "" {
+		return nil, invalidClient
+	}
+	client, err := op.storage.RetrieveOIDCClient(clientID)
+	if err != nil {
+		if models.ErrorContext(err) == models.ContextNotFound {
+			return nil, invalidClient
+		}
+		return nil, err
+	}
+	if client.IsPublic() {
+		if secret != "" {
+			return nil, invalidClient
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(tokens.Hash(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, invalidClient
+	}
+	return client, nil
+}
+
+func (op *oidcService) issueTokens(user *models.User, client *models.OIDCClient, code authorizationCode) (*OIDCTokens, error) {
+	now := time.Now()
+	expiresAt := jwt.NewNumericDate(now.Add(op.cfg.OIDCTokenTTL))
+	scope := strings.Join(code.Scopes, " ")
+
+	idToken, err := op.keys.Sign("", oidc.IDTokenClaims{
+		Nonce:      code.Nonce,
+		AuthTime:   jwt.NewNumericDate(code.AuthTime),
+		UserClaims: oidc.NewUserClaims(user, code.Scopes),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    op.cfg.OIDCIssuer,
+			Subject:   user.ID.String(),
+			Audience:  jwt.ClaimStrings{client.ID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: expiresAt,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := op.keys.Sign(oidc.AccessTokenType, oidc.AccessTokenClaims{
+		ClientID: client.ID,
+		Scope:    scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    op.cfg.OIDCIssuer,
+			Subject:   user.ID.String(),
+			Audience:  jwt.ClaimStrings{op.cfg.OIDCIssuer},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: expiresAt,
+			ID:        uuid.NewString(),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDCTokens{AccessToken: accessToken, IDToken: idToken, ExpiresIn: op.cfg.OIDCTokenTTL, Scope: scope}, nil
+}
+
+// validateRedirectURI accepts absolute https URIs, plain http only for
+// loopback addresses used by native apps and local development
+func validateRedirectURI(uri string) error {
+	invalid := models.NewInternalError(models.ContextBadRequest, fmt.Sprintf("redirect URI '%s' must be an absolute https URI without fragment", uri))
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Host == "" || parsed.Fragment != "" {
+		return invalid
+	}
+	switch parsed.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := parsed.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+	}
+	return invalid
+}
+
+func authorizationError(code string, description string) error {
+	return models.NewWrappedError(oidc.NewError(code, description), models.ContextBadRequest, description)
+}
+
+func tokenError(code string, description string) error {
+	context := models.ContextBadRequest
+	if code == oidc.ErrorInvalidClient {
+		context = models.ContextUnauthorized
+	}
+	return models.NewWrappedError(oidc.NewError(code, description), context, description)
+}
+
+func (op *oidcService) logClientRegistered(id string) {
+	log.Printf("OIDC client %s registered at %v", id, time.Now())
+}
+
+func (op *oidcService) logClientDeleted(id string) {
+	log.Printf("OIDC client %s deleted at %v", id, time.Now())
+}
+
+func (op *oidcService) logAuthorized(userID uuid.UUID, clientID string) {
+	log.Printf("User %s authorized client %s at %v", userID, clientID, time.Now())
+}
+
+func (op *oidcService) logTokensIssued(userID uuid.UUID, clientID string) {
+	log.Printf("ID token for user %s issued to client %s at %v", userID, clientID, time.Now())
+}
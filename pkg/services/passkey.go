@@ -0,0 +1,279 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"log"
+	"strings"
+	"time"
+	"users-microservice/pkg/lockout"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/webauthn"
+
+	"github.com/google/uuid"
+)
+
+const maxPasskeyNameLength = 64
+
+// PasskeyRegistrationOptions are passed to navigator.credentials.create,
+// the challenge is valid for a single registration
+type PasskeyRegistrationOptions struct {
+	Challenge          []byte
+	RPID               string
+	RPName             string
+	UserHandle         []byte
+	UserName           string
+	UserDisplayName    string
+	Algorithms         []int
+	ExcludeCredentials [][]byte
+	Timeout            time.Duration
+}
+
+type PasskeyRegistrationRequest struct {
+	Name              string
+	ClientDataJSON    []byte
+	AttestationObject []byte
+}
+
+// PasskeyLoginOptions are passed to navigator.credentials.get, no
+// credentials are listed so the authenticator offers its discoverable ones
+type PasskeyLoginOptions struct {
+	Challenge []byte
+	RPID      string
+	Timeout   time.Duration
+}
+
+type PasskeyLoginRequest struct {
+	CredentialID      []byte
+	ClientDataJSON    []byte
+	AuthenticatorData []byte
+	Signature         []byte
+	UserHandle        []byte
+	Metadata          SessionMetadata
+}
+
+func (as *authService) BeginPasskeyRegistration(ctx context.Context, userID uuid.UUID) (*PasskeyRegistrationOptions, error) {
+	if err := as.authorizeUser(ctx, actionPasskeysRegister, userID); err != nil {
+		return nil, err
+	}
+	user, err := as.storage.RetrieveUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCanSignIn(user); err != nil {
+		return nil, err
+	}
+
+	passkeys, err := as.storage.RetrievePasskeys(userID)
+	if err != nil {
+		return nil, err
+	}
+	excluded := make([][]byte, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		excluded = append(excluded, passkey.CredentialID)
+	}
+
+	challenge, err := as.issueWebAuthnChallenge(userID, models.TokenPurposePasskeyRegister)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PasskeyRegistrationOptions{
+		Challenge:          challenge,
+		RPID:               as.relyingParty.ID,
+		RPName:             as.relyingParty.Name,
+		UserHandle:         userID[:],
+		UserName:           user.Email,
+		UserDisplayName:    user.Name,
+		Algorithms:         webauthn.SupportedAlgorithms,
+		ExcludeCredentials: excluded,
+		Timeout:            as.cfg.WebAuthnChallengeTTL,
+	}, nil
+}
+
+func (as *authService) FinishPasskeyRegistration(ctx context.Context, userID uuid.UUID, req PasskeyRegistrationRequest) (*models.Passkey, error) {
+	if err := as.authorizeUser(ctx, actionPasskeysRegister, userID); err != nil {
+		return nil, err
+	}
+	name, err := validatePasskeyName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, token, err := as.consumeWebAuthnChallenge(models.TokenPurposePasskeyRegister, req.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	if token.UserID != userID {
+		return nil, models.NewInternalError(models.ContextBadRequest, "challenge was issued for another user")
+	}
+
+	passkeys, err := as.storage.RetrievePasskeys(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, passkey := range passkeys {
+		if passkey.Name == name {
+			return nil, models.NewInternalError(models.ContextConflictValue, "passkey named '"+name+"' already exists")
+		}
+	}
+
+	credential, err := as.relyingParty.VerifyRegistration(challenge, req.ClientDataJSON, req.AttestationObject)
+	if err != nil {
+		return nil, models.NewWrappedError(err, models.ContextBadRequest, "passkey registration is invalid: "+err.Error())
+	}
+
+	passkey := models.NewPasskey(userID, name, credential.ID, credential.PublicKey, credential.SignCount, credential.AAGUID)
+	if err := as.storage.CreatePasskey(passkey); err != nil {
+		return nil, err
+	}
+	if err := as.storage.AppendUserHistory(models.NewUserHistoryEntry(userID, models.HistoryEventPasskeyAdded, name)); err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %s registered passkey %s at %v", userID, passkey.ID, time.Now())
+	return passkey, nil
+}
+
+func (as *authService) ListPasskeys(ctx context.Context, userID uuid.UUID) ([]models.Passkey, error) {
+	if err := as.authorizeUser(ctx, actionPasskeysList, userID); err != nil {
+		return nil, err
+	}
+	if _, err := as.storage.RetrieveUser(userID); err != nil {
+		return nil, err
+	}
+	return as.storage.RetrievePasskeys(userID)
+}
+
+func (as *authService) RenamePasskey(ctx context.Context, userID uuid.UUID, passkeyID uuid.UUID, name string) error {
+	if err := as.authorizeUser(ctx, actionPasskeysRename, userID); err != nil {
+		return err
+	}
+	name, err := validatePasskeyName(name)
+	if err != nil {
+		return err
+	}
+	return as.storage.RenamePasskey(userID, passkeyID, name)
+}
+
+func (as *authService) DeletePasskey(ctx context.Context, userID uuid.UUID, passkeyID uuid.UUID) error {
+	if err := as.authorizeUser(ctx, actionPasskeysDelete, userID); err != nil {
+		return err
+	}
+	if err := as.storage.DeletePasskey(userID, passkeyID); err != nil {
+		return err
+	}
+	if err := as.storage.AppendUserHistory(models.NewUserHistoryEntry(userID, models.HistoryEventPasskeyRemoved, passkeyID.String())); err != nil {
+		return err
+	}
+
+	log.Printf("User %s removed passkey %s at %v", userID, passkeyID, time.Now())
+	return nil
+}
+
+// BeginPasskeyLogin does not need to know the user, the passkey tells who
+// is signing in
+func (as *authService) BeginPasskeyLogin(ctx context.Context) (*PasskeyLoginOptions, error) {
+	challenge, err := as.issueWebAuthnChallenge(uuid.Nil, models.TokenPurposePasskeyLogin)
+	if err != nil {
+		return nil, err
+	}
+	return &PasskeyLoginOptions{
+		Challenge: challenge,
+		RPID:      as.relyingParty.ID,
+		Timeout:   as.cfg.WebAuthnChallengeTTL,
+	}, nil
+}
+
+// PasskeyLogin starts a session without password, passkeys verify the user
+// on the device so no second factor is asked for
+func (as *authService) PasskeyLogin(ctx context.Context, req PasskeyLoginRequest) (*LoginResult, error) {
+	invalidPasskey := models.NewInternalError(models.ContextUnauthorized, "passkey is invalid")
+
+	challenge, _, err := as.consumeWebAuthnChallenge(models.TokenPurposePasskeyLogin, req.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	passkey, err := as.storage.RetrievePasskeyByCredentialID(req.CredentialID)
+	if err != nil {
+		if models.ErrorContext(err) == models.ContextNotFound {
+			return nil, invalidPasskey
+		}
+		return nil, err
+	}
+	if len(req.UserHandle) > 0 && !bytes.Equal(req.UserHandle, passkey.UserID[:]) {
+		return nil, invalidPasskey
+	}
+
+	user, err := as.storage.RetrieveUser(passkey.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCanSignIn(user); err != nil {
+		return nil, err
+	}
+	accountKey := lockout.AccountKey(user.ID)
+	if err := as.guard.Check(accountKey); err != nil {
+		return nil, err
+	}
+
+	credential := &webauthn.Credential{ID: passkey.CredentialID, PublicKey: passkey.PublicKey, SignCount: passkey.SignCount}
+	signCount, err := as.relyingParty.VerifyAssertion(challenge, credential, req.ClientDataJSON, req.AuthenticatorData, req.Signature)
+	if err != nil {
+		log.Printf("Passkey %s of user %s rejected: %v", passkey.ID, user.ID, err)
+		return nil, as.recordFailure(user.ID, accountKey, invalidPasskey)
+	}
+	if err := as.storage.UsePasskey(passkey.ID, signCount); err != nil {
+		return nil, err
+	}
+
+	sessionTokens, err := as.createSession(user, req.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	as.resetFailures(accountKey)
+
+	log.Printf("User %s logged in with passkey %s at %v", user.ID, passkey.ID, time.Now())
+	return &LoginResult{User: user, Tokens: sessionTokens}, nil
+}
+
+// issueWebAuthnChallenge uses a single-use token as the challenge, the
+// client sends it back base64url encoded which is the token itself
+func (as *authService) issueWebAuthnChallenge(userID uuid.UUID, purpose models.TokenPurpose) ([]byte, error) {
+	token, err := issueToken(as.storage, userID, purpose, "", as.cfg.WebAuthnChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to create challenge")
+	}
+	return challenge, nil
+}
+
+// consumeWebAuthnChallenge uses up the challenge the client data was signed
+// for and returns it with the token it was issued as
+func (as *authService) consumeWebAuthnChallenge(purpose models.TokenPurpose, clientDataJSON []byte) (string, *models.UserToken, error) {
+	challenge, err := webauthn.Challenge(clientDataJSON)
+	if err != nil {
+		return "", nil, models.NewWrappedError(err, models.ContextBadRequest, "client data is malformed")
+	}
+	token, err := consumeToken(as.storage, purpose, challenge)
+	if err != nil {
+		return "", nil, err
+	}
+	return challenge, token, nil
+}
+
+func validatePasskeyName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", models.NewInternalError(models.ContextBadRequest, "passkey name is required and cannot be empty")
+	}
+	if len(name) > maxPasskeyNameLength {
+		return "", models.NewInternalError(models.ContextBadRequest, "passkey name is too long")
+	}
+	return name, nil
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// RequestPasswordReset never tells the caller whether anything was sent,
+// unknown emails, blocked users and throttled requests all end silently. The
+// reset is sent in the background so the answer takes as long in every case
+func (as *authService) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := as.storage.RetrieveUserByEmail(strings.TrimSpace(email))
+	if err != nil {
+		if models.ErrorContext(err) == models.ContextNotFound {
+			return nil
+		}
+		return err
+	}
+	if checkCanSignIn(user) != nil {
+		return nil
+	}
+
+	as.notifyInBackground(ctx, user.ID, "password reset", func(ctx context.Context) error {
+		return as.sendPasswordReset(ctx, user)
+	})
+	return nil
+}
+
+func (as *authService) sendPasswordReset(ctx context.Context, user *models.User) error {
+	issued, err := as.storage.RetrieveUserTokensSince(user.ID, models.TokenPurposePasswordReset, time.Now().Add(-as.cfg.VerificationResendDelay))
+	if err != nil {
+		return err
+	}
+	if len(issued) > 0 {
+		log.Printf("Password reset for user %s throttled at %v", user.ID, time.Now())
+		return nil
+	}
+
+	if err := as.storage.InvalidateUserTokens(user.ID, models.TokenPurposePasswordReset); err != nil {
+		return err
+	}
+	token, err := issueToken(as.storage, user.ID, models.TokenPurposePasswordReset, "", as.cfg.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+	if err := as.notifier.NotifyPasswordReset(ctx, user, token, time.Now().Add(as.cfg.PasswordResetTTL)); err != nil {
+		return err
+	}
+
+	log.Printf("Password reset for user %s requested at %v", user.ID, time.Now())
+	return nil
+}
+
+func (as *authService) ConfirmPasswordReset(ctx context.Context, token string, newPassword string) error {
+	// check the password first so a rejected one does not burn the token
+	if err := as.policy.Validate(newPassword); err != nil {
+		return err
+	}
+
+	userToken, err := consumeToken(as.storage, models.TokenPurposePasswordReset, token)
+	if err != nil {
+		return err
+	}
+
+	user, err := as.storage.RetrieveUser(userToken.UserID)
+	if err != nil {
+		return err
+	}
+	if err := checkCanSignIn(user); err != nil {
+		return err
+	}
+
+	if err := as.storePassword(user.ID, newPassword); err != nil {
+		return err
+	}
+	// whoever knew the old password may still be signed in
+	if err := as.revokeAllSessions(user.ID, "password reset"); err != nil {
+		return err
+	}
+	if err := as.storage.AppendUserHistory(models.NewUserHistoryEntry(user.ID, models.HistoryEventPasswordReset, "password reset through emailed token")); err != nil {
+		return err
+	}
+
+	as.logPasswordReset(user.ID)
+	return nil
+}
+
+func (as *authService) logPasswordReset(id uuid.UUID) {
+	log.Printf("User %s reset password at %v", id, time.Now())
+}
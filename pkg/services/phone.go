@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+	"users-microservice/pkg/lockout"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/sms"
+	"users-microservice/pkg/tokens"
+	"users-microservice/pkg/validation"
+
+	"github.com/google/uuid"
+)
+
+const phoneCodeDigits = 6
+
+// phoneCodePayload is kept on the verification token, the token itself is
+// never handed out, the user proves possession of the phone with the code
+type phoneCodePayload struct {
+	PhoneNumber string `json:"phone_number"`
+	CodeHash    string `json:"code_hash"`
+}
+
+func (us *userService) SendPhoneVerification(ctx context.Context, id uuid.UUID) error {
+	if err := us.authorizeUser(ctx, actionPhoneSendVerification, id); err != nil {
+		return err
+	}
+	user, err := us.storage.RetrieveUser(id)
+	if err != nil {
+		return err
+	}
+	if user.PhoneNumber == "" {
+		return models.NewInternalError(models.ContextBadRequest, "user has no phone number")
+	}
+	if user.PhoneVerifiedAt != nil {
+		return models.NewInternalError(models.ContextConflictValue, "phone number is already verified")
+	}
+	if err := us.checkPhoneCodeThrottle(id, us.cfg.VerificationResendDelay); err != nil {
+		return err
+	}
+
+	if err := us.sendPhoneVerification(ctx, user); err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, "failed to send verification code")
+	}
+	return nil
+}
+
+// VerifyPhone checks the code against the latest one sent, wrong guesses are
+// counted so the few possible codes cannot be tried out
+func (us *userService) VerifyPhone(ctx context.Context, id uuid.UUID, code string) (*models.User, error) {
+	if err := us.authorizeUser(ctx, actionPhoneVerify, id); err != nil {
+		return nil, err
+	}
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, models.NewInternalError(models.ContextBadRequest, "code is required and cannot be empty")
+	}
+
+	user, err := us.storage.RetrieveUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.PhoneNumber == "" {
+		return nil, models.NewInternalError(models.ContextBadRequest, "user has no phone number")
+	}
+	if user.PhoneVerifiedAt != nil {
+		return nil, models.NewInternalError(models.ContextConflictValue, "phone number is already verified")
+	}
+
+	key := lockout.PhoneVerificationKey(id)
+	if err := us.guard.Check(key); err != nil {
+		return nil, err
+	}
+
+	token, payload, err := us.latestPhoneCode(id)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil || subtle.ConstantTimeCompare([]byte(payload.CodeHash), []byte(hashPhoneCode(id, code))) != 1 {
+		lockedOut, err := us.guard.Fail(key)
+		if err != nil {
+			return nil, err
+		}
+		if lockedOut {
+			log.Printf("Phone verification of user %s locked at %v", id, time.Now())
+		}
+		return nil, models.NewInternalError(models.ContextBadRequest, "code is invalid or has expired")
+	}
+
+	if _, err := us.storage.ConsumeUserToken(models.TokenPurposePhoneVerification, token.TokenHash); err != nil {
+		return nil, err
+	}
+	before := snapshot(user)
+	verifiedAt := time.Now()
+	user.PhoneVerifiedAt = &verifiedAt
+	audit, err := us.auditEntry(ctx, models.AuditActionUpdate, before, user)
+	if err != nil {
+		return nil, err
+	}
+	entry := models.NewUserHistoryEntry(id, models.HistoryEventPhoneVerified, "phone number verified")
+	if err := us.storage.MarkPhoneVerified(id, payload.PhoneNumber, verifiedAt, entry, audit); err != nil {
+		return nil, err
+	}
+	// the number is verified, a failed reset only leaves the counter to expire
+	if err := us.guard.Reset(key); err != nil {
+		log.Printf("ERROR: failed to reset phone verification attempts of user %s: %v", id, err)
+	}
+
+	us.logPhoneVerified(id)
+	return user, nil
+}
+
+// setPhoneNumber normalizes the number onto the user, any change drops the
+// verification, the caller persists the user and sends a new code
+func (us *userService) setPhoneNumber(user *models.User, number string, region string) (bool, error) {
+	if strings.TrimSpace(number) == "" {
+		if user.PhoneNumber == "" {
+			return false, nil
+		}
+		user.PhoneNumber = ""
+		user.PhoneVerifiedAt = nil
+		return true, nil
+	}
+
+	if region == "" {
+		region = us.cfg.PhoneDefaultRegion
+	}
+	normalized, err := validation.NormalizePhoneNumber(number, region)
+	if err != nil {
+		return false, err
+	}
+	if normalized == user.PhoneNumber {
+		return false, nil
+	}
+
+	existing, err := us.storage.RetrieveUserByPhoneNumber(normalized)
+	if err == nil && existing.ID != user.ID {
+		return false, models.NewInternalError(models.ContextConflictValue, "phone number is already in use")
+	}
+	if err != nil && models.ErrorContext(err) != models.ContextNotFound {
+		return false, err
+	}
+
+	user.PhoneNumber = normalized
+	user.PhoneVerifiedAt = nil
+	return true, nil
+}
+
+// sendNewPhoneVerification is used when the number was just set, a corrected
+// number gets its code right away but the daily limit still applies, the
+// request has succeeded already so failures are only logged
+func (us *userService) sendNewPhoneVerification(ctx context.Context, user *models.User) {
+	if err := us.checkPhoneCodeThrottle(user.ID, 0); err != nil {
+		log.Printf("WARNING: verification code for user %s not sent: %v", user.ID, err)
+		return
+	}
+	if err := us.sendPhoneVerification(ctx, user); err != nil {
+		log.Printf("ERROR: failed to send verification code to user %s: %v", user.ID, err)
+	}
+}
+
+// checkPhoneCodeThrottle limits the codes sent per day and requires delay to
+// pass since the last one
+func (us *userService) checkPhoneCodeThrottle(id uuid.UUID, delay time.Duration) error {
+	issued, err := us.storage.RetrieveUserTokensSince(id, models.TokenPurposePhoneVerification, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return err
+	}
+	if len(issued) >= us.cfg.VerificationResendPerDay {
+		return models.NewInternalError(models.ContextTooManyRequests, "too many verification codes requested, try again tomorrow")
+	}
+	if len(issued) > 0 && time.Since(issued[0].CreatedAt) < delay {
+		return models.NewInternalError(models.ContextTooManyRequests, "verification code was sent recently, try again later")
+	}
+	return nil
+}
+
+// sendPhoneVerification issues a fresh code, anything sent before is no
+// longer usable
+func (us *userService) sendPhoneVerification(ctx context.Context, user *models.User) error {
+	if err := us.storage.InvalidateUserTokens(user.ID, models.TokenPurposePhoneVerification); err != nil {
+		return err
+	}
+	code, err := newPhoneCode()
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(phoneCodePayload{PhoneNumber: user.PhoneNumber, CodeHash: hashPhoneCode(user.ID, code)})
+	if err != nil {
+		return err
+	}
+	if _, err := issueToken(us.storage, user.ID, models.TokenPurposePhoneVerification, string(payload), us.cfg.PhoneVerificationTTL); err != nil {
+		return err
+	}
+
+	return us.sms.Send(ctx, sms.Message{
+		To:   user.PhoneNumber,
+		Body: fmt.Sprintf("Your verification code is %s, it expires in %d minutes.", code, int(us.cfg.PhoneVerificationTTL.Minutes())),
+	})
+}
+
+// latestPhoneCode returns the outstanding code of the user, nil when there is
+// none
+func (us *userService) latestPhoneCode(id uuid.UUID) (*models.UserToken, *phoneCodePayload, error) {
+	issued, err := us.storage.RetrieveUserTokensSince(id, models.TokenPurposePhoneVerification, time.Now().Add(-us.cfg.PhoneVerificationTTL))
+	if err != nil {
+		return nil, nil, err
+	}
+	// sending a code invalidates the previous ones, so only the newest counts
+	if len(issued) == 0 || issued[0].ConsumedAt != nil || !issued[0].ExpiresAt.After(time.Now()) {
+		return nil, nil, nil
+	}
+
+	payload := &phoneCodePayload{}
+	if err := json.Unmarshal([]byte(issued[0].Payload), payload); err != nil {
+		return nil, nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to read verification code")
+	}
+	return &issued[0], payload, nil
+}
+
+func newPhoneCode() (string, error) {
+	limit := big.NewInt(1)
+	for range phoneCodeDigits {
+		limit.Mul(limit, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", phoneCodeDigits, n), nil
+}
+
+// hashPhoneCode binds the code to the user, the same code may be sent to many
+func hashPhoneCode(userID uuid.UUID, code string) string {
+	return tokens.Hash(userID.String() + ":" + code)
+}
+
+func (us *userService) logPhoneVerified(id uuid.UUID) {
+	log.Printf("User %s verified phone number at %v", id, time.Now())
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"users-microservice/pkg/accesslog"
+	"users-microservice/pkg/authz"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/encryption"
+	"users-microservice/pkg/export"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/storage"
+
+	"github.com/google/uuid"
+)
+
+// PrivacyService serves the requests of data subjects
+type PrivacyService interface {
+	// ExportPersonalData starts gathering everything held about the user in
+	// the background
+	ExportPersonalData(context.Context, uuid.UUID) (*models.PersonalDataExport, error)
+	// GetPersonalDataExport returns the export with its download link once it
+	// is completed
+	GetPersonalDataExport(context.Context, uuid.UUID, uuid.UUID) (*PersonalDataExportStatus, error)
+	// DownloadPersonalDataExport consumes the token of a download link and
+	// returns the archive
+	DownloadPersonalDataExport(context.Context, string) (*models.PersonalDataExport, []byte, error)
+	// ErasePersonalData irreversibly anonymizes a deactivated or deleted user
+	// and returns the certificate of the erasure. Erasing again returns the
+	// same certificate
+	ErasePersonalData(context.Context, uuid.UUID) (*models.ErasureCertificate, error)
+	GetErasureCertificate(context.Context, uuid.UUID) (*models.ErasureCertificate, error)
+}
+
+type PersonalDataExportStatus struct {
+	Export *models.PersonalDataExport
+	// empty until the export is completed
+	DownloadURL       string
+	DownloadExpiresAt time.Time
+}
+
+// an export taking longer is failed
+const exportTimeout = 5 * time.Minute
+
+// staleExportAge is when an unfinished export is known to be lost with the
+// instance that ran it, other instances are done with theirs by then
+const staleExportAge = exportTimeout + time.Minute
+
+const exportInterrupted = "the export was interrupted"
+
+type privacyService struct {
+	authorizer
+	auditor
+	// export jobs are canceled once it is done
+	jobs      context.Context
+	storage   storage.Storage
+	exporter  *export.Exporter
+	cipher    *encryption.Cipher
+	accessLog *accesslog.Writer
+	cfg       *config.Config
+}
+
+// NewPrivacyService runs exports in the background until jobs is done, the
+// exports left unfinished by a previous run are failed
+func NewPrivacyService(jobs context.Context, storage storage.Storage, exporter *export.Exporter, cipher *encryption.Cipher, accessLog *accesslog.Writer, policies *authz.Engine, cfg *config.Config) (PrivacyService, error) {
+	if err := storage.FailStalePersonalDataExports(time.Now().Add(-staleExportAge), exportInterrupted); err != nil {
+		return nil, err
+	}
+	return &privacyService{authorizer: newAuthorizer(storage, policies), auditor: auditor{audit: storage}, jobs: jobs, storage: storage, exporter: exporter, cipher: cipher, accessLog: accessLog, cfg: cfg}, nil
+}
+
+// authorizePersonalData lets callers act on the personal data of the user
+// only when they may see it unmasked, and returns the user
+func (ps *privacyService) authorizePersonalData(ctx context.Context, action string, id uuid.UUID) (*models.User, error) {
+	if err := ps.authorizeUser(ctx, action, id); err != nil {
+		return nil, err
+	}
+	if !models.SeesPersonalData(ctx, id) {
+		return nil, models.NewInternalError(models.ContextForbidden, fmt.Sprintf("caller is not granted the '%s' scope", models.ScopeUsersPII))
+	}
+	if masked := models.MaskedFields(ctx, id); len(masked) > 0 {
+		return nil, models.NewInternalError(models.ContextForbidden, fmt.Sprintf("policy masks %s of the user", strings.Join(masked, ", ")))
+	}
+	return ps.storage.RetrieveUser(id)
+}
+
+func (ps *privacyService) ExportPersonalData(ctx context.Context, id uuid.UUID) (*models.PersonalDataExport, error) {
+	if _, err := ps.authorizePersonalData(ctx, actionUsersExport, id); err != nil {
+		return nil, err
+	}
+	// expired exports are cleaned up whenever a new one is asked for
+	if err := ps.storage.DeleteExpiredPersonalDataExports(time.Now()); err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+
+	job := models.NewPersonalDataExport(id, models.AuditActor(ctx), models.RequestIDFromContext(ctx), ps.cfg.ExportTTL)
+	if err := ps.storage.CreatePersonalDataExport(job); err != nil {
+		return nil, err
+	}
+	// the job outlives the request but keeps who asked and why, it stops
+	// with the service. It works on a copy as the caller goes on reading
+	// the export
+	jobCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), exportTimeout)
+	stop := context.AfterFunc(ps.jobs, cancel)
+	running := *job
+	go func() {
+		defer cancel()
+		defer stop()
+		ps.runExport(jobCtx, &running)
+	}()
+	return job, nil
+}
+
+// runExport builds the archive and stores it encrypted, a failure is kept
+// on the export for the caller to see
+func (ps *privacyService) runExport(ctx context.Context, job *models.PersonalDataExport) {
+	job.Status = models.ExportStatusRunning
+	if err := ps.storage.UpdatePersonalDataExport(job); err != nil {
+		log.Printf("ERROR: failed to start export %s: %v", job.ID, err)
+		return
+	}
+
+	archive, err := ps.buildArchive(ctx, job)
+	completed := err == nil
+	now := time.Now()
+	job.CompletedAt = &now
+	if completed {
+		job.Status = models.ExportStatusCompleted
+		job.EncryptedArchive = archive
+	} else {
+		log.Printf("ERROR: export %s of user %s failed: %v", job.ID, job.UserID, err)
+		job.Status = models.ExportStatusFailed
+		job.Error = "the personal data could not be gathered"
+	}
+	if err := ps.storage.UpdatePersonalDataExport(job); err != nil {
+		log.Printf("ERROR: failed to complete export %s: %v", job.ID, err)
+		return
+	}
+	if !completed {
+		return
+	}
+
+	// handing out the archive is an access to all of it
+	ps.accessLog.Record(models.AccessRecord{
+		ID:         uuid.New(),
+		UserID:     job.UserID,
+		Accessor:   job.RequestedBy,
+		Fields:     ps.exporter.Sections(),
+		Purpose:    models.AccessPurposeFromContext(ctx),
+		RequestID:  job.RequestID,
+		AccessedAt: now.UTC(),
+	})
+}
+
+func (ps *privacyService) buildArchive(ctx context.Context, job *models.PersonalDataExport) (string, error) {
+	archive, err := ps.exporter.Build(ctx, job.ID, job.UserID)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(archive)
+	if err != nil {
+		return "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return ps.cipher.Encrypt(payload, job.ID[:])
+}
+
+func (ps *privacyService) GetPersonalDataExport(ctx context.Context, id uuid.UUID, exportID uuid.UUID) (*PersonalDataExportStatus, error) {
+	if _, err := ps.authorizePersonalData(ctx, actionUsersExport, id); err != nil {
+		return nil, err
+	}
+	job, err := ps.storage.RetrievePersonalDataExport(exportID)
+	if err != nil {
+		return nil, err
+	}
+	if job.UserID != id {
+		return nil, models.NewInternalError(models.ContextNotFound, fmt.Sprintf("export with '%s' ID does not exist", exportID))
+	}
+
+	unfinished := job.Status == models.ExportStatusPending || job.Status == models.ExportStatusRunning
+	if unfinished && time.Since(job.CreatedAt) > staleExportAge {
+		// the instance running the export went away before finishing it
+		now := time.Now()
+		job.Status = models.ExportStatusFailed
+		job.Error = exportInterrupted
+		job.CompletedAt = &now
+		if err := ps.storage.UpdatePersonalDataExport(job); err != nil {
+			return nil, err
+		}
+	}
+
+	status := &PersonalDataExportStatus{Export: job}
+	if job.Status != models.ExportStatusCompleted {
+		return status, nil
+	}
+	token, expiresAt, err := ps.downloadLink(job)
+	if err != nil {
+		return nil, err
+	}
+	status.DownloadURL = ps.cfg.ExportDownloadURL + "/" + token
+	status.DownloadExpiresAt = expiresAt
+	return status, nil
+}
+
+// downloadLink hands out the outstanding link of the export, a new one is
+// only issued once it is used or expired
+func (ps *privacyService) downloadLink(job *models.PersonalDataExport) (string, time.Time, error) {
+	if job.EncryptedDownloadToken != "" && job.DownloadExpiresAt != nil && time.Now().Before(*job.DownloadExpiresAt) {
+		token, err := ps.cipher.Decrypt(job.EncryptedDownloadToken, job.ID[:])
+		if err != nil {
+			return "", time.Time{}, models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("download link of export with '%s' ID cannot be decrypted", job.ID))
+		}
+		return string(token), *job.DownloadExpiresAt, nil
+	}
+
+	// links do not outlive the export
+	ttl := min(ps.cfg.ExportLinkTTL, time.Until(job.ExpiresAt))
+	token, err := issueToken(ps.storage, job.UserID, models.TokenPurposeExportDownload, job.ID.String(), ttl)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	encrypted, err := ps.cipher.Encrypt([]byte(token), job.ID[:])
+	if err != nil {
+		return "", time.Time{}, models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("download link of export with '%s' ID cannot be encrypted", job.ID))
+	}
+	expiresAt := time.Now().Add(ttl)
+	job.EncryptedDownloadToken = encrypted
+	job.DownloadExpiresAt = &expiresAt
+	if err := ps.storage.UpdatePersonalDataExport(job); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+func (ps *privacyService) DownloadPersonalDataExport(ctx context.Context, token string) (*models.PersonalDataExport, []byte, error) {
+	userToken, err := consumeToken(ps.storage, models.TokenPurposeExportDownload, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	exportID, err := uuid.Parse(userToken.Payload)
+	if err != nil {
+		return nil, nil, models.NewWrappedError(err, models.ContextInternalServer, "download token does not name an export")
+	}
+	job, err := ps.storage.RetrievePersonalDataExport(exportID)
+	if err != nil {
+		return nil, nil, err
+	}
+	archive, err := ps.cipher.Decrypt(job.EncryptedArchive, job.ID[:])
+	if err != nil {
+		return nil, nil, models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("archive of export with '%s' ID cannot be decrypted", job.ID))
+	}
+
+	// the next poll issues a new link
+	job.EncryptedDownloadToken = ""
+	job.DownloadExpiresAt = nil
+	if err := ps.storage.UpdatePersonalDataExport(job); err != nil {
+		return nil, nil, err
+	}
+	return job, archive, nil
+}
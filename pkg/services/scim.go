@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"users-microservice/pkg/accesslog"
+	"users-microservice/pkg/authz"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/scim"
+	"users-microservice/pkg/storage"
+
+	"github.com/google/uuid"
+)
+
+// SCIMService provisions users for identity providers. Changes go through
+// UserService, so SCIM users are validated and verified like any other and a
+// new userName only becomes the email once the user confirms it
+type SCIMService interface {
+	CreateUser(context.Context, *scim.User) (*models.User, error)
+	GetUser(context.Context, uuid.UUID) (*models.User, error)
+	ReplaceUser(context.Context, uuid.UUID, *scim.User) (*models.User, error)
+	PatchUser(context.Context, uuid.UUID, *scim.PatchRequest) (*models.User, error)
+	DeleteUser(context.Context, uuid.UUID) error
+	ListUsers(context.Context, SCIMListRequest) (*SCIMUserList, error)
+}
+
+type SCIMListRequest struct {
+	Filter string
+	// 1-based index of the first user, smaller values start at the first
+	StartIndex int
+	// nil asks for as many users as allowed
+	Count *int
+}
+
+type SCIMUserList struct {
+	Users        []models.User
+	TotalResults int
+	StartIndex   int
+}
+
+const (
+	scimDeactivateReason = "deactivated through SCIM"
+	scimReactivateReason = "reactivated through SCIM"
+	scimDeleteReason     = "deleted through SCIM"
+)
+
+type scimService struct {
+	authorizer
+	accessRecorder
+	users   UserService
+	storage storage.Storage
+	cfg     *config.Config
+}
+
+func NewSCIMService(users UserService, storage storage.Storage, accessLog *accesslog.Writer, policies *authz.Engine, cfg *config.Config) (SCIMService, error) {
+	return &scimService{authorizer: newAuthorizer(storage, policies), accessRecorder: accessRecorder{accessLog: accessLog}, users: users, storage: storage, cfg: cfg}, nil
+}
+
+func (ss *scimService) CreateUser(ctx context.Context, resource *scim.User) (*models.User, error) {
+	if err := ss.authorizeService(ctx, actionSCIMCreate, nil); err != nil {
+		return nil, err
+	}
+	profile, err := resource.Profile()
+	if err != nil {
+		return nil, err
+	}
+
+	// identity providers rely on the conflict to find out the user exists
+	existing, err := ss.storage.RetrieveUserByEmail(profile.Email)
+	if err == nil && existing != nil {
+		return nil, models.NewInternalError(models.ContextConflictValue, fmt.Sprintf("email '%s' is already in use", profile.Email))
+	}
+	if err != nil && models.ErrorContext(err) != models.ContextNotFound {
+		return nil, err
+	}
+
+	user, err := ss.users.CreateUser(ctx, UserCreationRequest{
+		ID:          uuid.New(),
+		Name:        profile.Name,
+		Email:       profile.Email,
+		DateOfBirth: profile.DateOfBirth,
+		PhoneNumber: profile.PhoneNumber,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ss.logUserProvisioned(user.ID)
+
+	if resource.Active != nil && !*resource.Active {
+		return ss.users.DeactivateUser(ctx, user.ID, scimDeactivateReason)
+	}
+	return user, nil
+}
+
+func (ss *scimService) GetUser(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	if err := ss.authorizeService(ctx, actionSCIMGet, map[string]any{"id": id.String()}); err != nil {
+		return nil, err
+	}
+	user, err := ss.users.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user.Status == models.UserStatusDeleted {
+		return nil, userNotFound(id)
+	}
+	return user, nil
+}
+
+// ReplaceUser sets the user to the resource, active is left alone when the
+// resource does not have it
+func (ss *scimService) ReplaceUser(ctx context.Context, id uuid.UUID, resource *scim.User) (*models.User, error) {
+	if err := ss.authorizeService(ctx, actionSCIMReplace, map[string]any{"id": id.String()}); err != nil {
+		return nil, err
+	}
+	user, err := ss.retrieveUser(id)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := resource.Profile()
+	if err != nil {
+		return nil, err
+	}
+	return ss.applyProfile(ctx, user, profile, resource.Active)
+}
+
+// PatchUser applies the operations to the current representation of the user
+// and saves the result like a replace
+func (ss *scimService) PatchUser(ctx context.Context, id uuid.UUID, patch *scim.PatchRequest) (*models.User, error) {
+	if err := ss.authorizeService(ctx, actionSCIMPatch, map[string]any{"id": id.String()}); err != nil {
+		return nil, err
+	}
+	user, err := ss.retrieveUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	resource := scim.NewUser(user, ss.cfg.SCIMBaseURL)
+	// the status only changes when an operation sets active
+	resource.Active = nil
+	if err := patch.Apply(resource); err != nil {
+		return nil, err
+	}
+	profile, err := resource.Profile()
+	if err != nil {
+		return nil, err
+	}
+	return ss.applyProfile(ctx, user, profile, resource.Active)
+}
+
+// DeleteUser deactivates the user first if needed, deleted users are gone
+// for SCIM clients but their history stays
+func (ss *scimService) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	if err := ss.authorizeService(ctx, actionSCIMDelete, map[string]any{"id": id.String()}); err != nil {
+		return err
+	}
+	user, err := ss.retrieveUser(id)
+	if err != nil {
+		return err
+	}
+	if user.Status != models.UserStatusDeactivated {
+		if _, err := ss.users.DeactivateUser(ctx, id, scimDeactivateReason); err != nil {
+			return err
+		}
+	}
+	_, err = ss.users.DeleteUser(ctx, id, scimDeleteReason)
+	return err
+}
+
+func (ss *scimService) ListUsers(ctx context.Context, req SCIMListRequest) (*SCIMUserList, error) {
+	if err := ss.authorizeService(ctx, actionSCIMList, nil); err != nil {
+		return nil, err
+	}
+	filter := models.NewUserComparison(models.UserAttributeStatus, models.FilterNotEqual, string(models.UserStatusDeleted))
+	if strings.TrimSpace(req.Filter) != "" {
+		parsed, err := scim.ParseFilter(req.Filter)
+		if err != nil {
+			return nil, err
+		}
+		requested, err := parsed.UserFilter()
+		if err != nil {
+			return nil, err
+		}
+		// matching on masked data would reveal it a character at a time
+		masked := models.MaskedFields(ctx, uuid.Nil)
+		for _, field := range requested.PersonalDataFields() {
+			if slices.Contains(masked, field) {
+				return nil, scim.NewError(http.StatusForbidden, scim.ErrorInvalidFilter, fmt.Sprintf("users cannot be filtered by %s, it is masked for the caller", field))
+			}
+		}
+		filter = models.NewUserFilterAnd(filter, *requested)
+	}
+
+	startIndex := max(req.StartIndex, 1)
+	count := ss.cfg.SCIMMaxResults
+	if req.Count != nil {
+		count = min(max(*req.Count, 0), ss.cfg.SCIMMaxResults)
+	}
+
+	users, total, err := ss.storage.RetrieveUsers(&filter, startIndex-1, count)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		ss.recordAccess(ctx, &users[i])
+	}
+	return &SCIMUserList{Users: users, TotalResults: int(total), StartIndex: startIndex}, nil
+}
+
+// applyProfile saves what changed, the email is only passed on when it
+// differs as asking for the current one would withdraw a pending change
+func (ss *scimService) applyProfile(ctx context.Context, user *models.User, profile *scim.Profile, active *bool) (*models.User, error) {
+	update := UserUpdateRequest{
+		Name:        &profile.Name,
+		DateOfBirth: &profile.DateOfBirth,
+		PhoneNumber: &profile.PhoneNumber,
+	}
+	if !strings.EqualFold(profile.Email, user.Email) {
+		update.Email = &profile.Email
+	}
+	user, err := ss.users.UpdateUser(ctx, user.ID, update)
+	if err != nil {
+		return nil, err
+	}
+	if active == nil {
+		return user, nil
+	}
+
+	// suspensions are lifted by administrators of this service, not by the
+	// identity provider asserting the user is active
+	switch {
+	case !*active && user.Status != models.UserStatusDeactivated:
+		return ss.users.DeactivateUser(ctx, user.ID, scimDeactivateReason)
+	case *active && user.Status == models.UserStatusDeactivated:
+		return ss.users.ReactivateUser(ctx, user.ID, scimReactivateReason)
+	}
+	return user, nil
+}
+
+// retrieveUser treats deleted users as missing
+func (ss *scimService) retrieveUser(id uuid.UUID) (*models.User, error) {
+	user, err := ss.storage.RetrieveUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.Status == models.UserStatusDeleted {
+		return nil, userNotFound(id)
+	}
+	return user, nil
+}
+
+func (ss *scimService) logUserProvisioned(id uuid.UUID) {
+	log.Printf("User %s provisioned through SCIM at %v", id, time.Now())
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/tokens"
+
+	"github.com/google/uuid"
+)
+
+// describes the device a session is created or refreshed from
+type SessionMetadata struct {
+	UserAgent string
+	IPAddress string
+}
+
+// SessionTokens are handed out on login and on every refresh
+type SessionTokens struct {
+	Session               *models.Session
+	AccessToken           string
+	AccessTokenExpiresAt  time.Time
+	RefreshToken          string
+	RefreshTokenExpiresAt time.Time
+}
+
+func (as *authService) RefreshSession(ctx context.Context, refreshToken string, meta SessionMetadata) (*SessionTokens, error) {
+	invalidToken := models.NewInternalError(models.ContextUnauthorized, "refresh token is invalid or has expired")
+
+	refreshToken = strings.TrimSpace(refreshToken)
+	if refreshToken == "" {
+		return nil, models.NewInternalError(models.ContextBadRequest, "refresh token is required and cannot be empty")
+	}
+
+	presented, err := as.storage.RetrieveRefreshToken(tokens.Hash(refreshToken))
+	if err != nil {
+		if models.ErrorContext(err) == models.ContextNotFound {
+			return nil, invalidToken
+		}
+		return nil, err
+	}
+
+	session, err := as.storage.RetrieveSession(presented.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !session.IsActive() {
+		return nil, invalidToken
+	}
+
+	// a rotated token showing up again means it was copied, whoever holds the
+	// family cannot be trusted anymore
+	if presented.UsedAt != nil {
+		return nil, as.revokeOnReuse(session)
+	}
+	if presented.ExpiresAt.Before(time.Now()) {
+		return nil, invalidToken
+	}
+
+	user, err := as.storage.RetrieveUser(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCanSignIn(user); err != nil {
+		return nil, err
+	}
+
+	next, nextHash, err := tokens.New()
+	if err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to generate refresh token")
+	}
+	nextToken := models.NewRefreshToken(session, nextHash, as.cfg.RefreshTokenTTL)
+	// sessions show the device they were used from last
+	session.LastUsedAt = nextToken.CreatedAt
+	if meta.UserAgent != "" {
+		session.UserAgent = meta.UserAgent
+	}
+	if meta.IPAddress != "" {
+		session.IPAddress = meta.IPAddress
+	}
+	if err := as.storage.RotateRefreshToken(presented.ID, nextToken, session); err != nil {
+		// lost the race against another use of the same token
+		if models.ErrorContext(err) == models.ContextConflictValue {
+			return nil, as.revokeOnReuse(session)
+		}
+		return nil, err
+	}
+
+	return as.sessionTokens(session, next, nextToken)
+}
+
+func (as *authService) ListSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	if err := as.authorizeUser(ctx, actionSessionsList, userID); err != nil {
+		return nil, err
+	}
+	if _, err := as.storage.RetrieveUser(userID); err != nil {
+		return nil, err
+	}
+	return as.storage.RetrieveActiveSessions(userID)
+}
+
+func (as *authService) RevokeSession(ctx context.Context, userID uuid.UUID, sessionID uuid.UUID) error {
+	if err := as.authorizeUser(ctx, actionSessionsRevoke, userID); err != nil {
+		return err
+	}
+	session, err := as.storage.RetrieveSession(sessionID)
+	if err != nil {
+		return err
+	}
+	// sessions of other users are reported the same way as missing ones
+	if session.UserID != userID {
+		return models.NewInternalError(models.ContextNotFound, fmt.Sprintf("session with '%s' ID does not exist", sessionID))
+	}
+
+	if err := as.storage.RevokeSession(sessionID, "revoked by request"); err != nil {
+		return err
+	}
+	as.logSessionsRevoked(userID, "revoked by request")
+	return nil
+}
+
+func (as *authService) RevokeAllSessions(ctx context.Context, userID uuid.UUID) error {
+	if err := as.authorizeUser(ctx, actionSessionsRevokeAll, userID); err != nil {
+		return err
+	}
+	if _, err := as.storage.RetrieveUser(userID); err != nil {
+		return err
+	}
+	return as.revokeAllSessions(userID, "all sessions revoked by request")
+}
+
+// VerifyAccessToken checks the signature of a session access token and that
+// the session has not been revoked since it was issued
+func (as *authService) VerifyAccessToken(ctx context.Context, accessToken string) (*models.Session, error) {
+	invalidToken := models.NewInternalError(models.ContextUnauthorized, "access token is invalid or has expired")
+
+	claims, err := as.signer.Parse(accessToken)
+	if err != nil {
+		return nil, invalidToken
+	}
+	session, err := as.storage.RetrieveSession(claims.SessionID)
+	if err != nil {
+		if models.ErrorContext(err) == models.ContextNotFound {
+			return nil, invalidToken
+		}
+		return nil, err
+	}
+	if !session.IsActive() {
+		return nil, invalidToken
+	}
+	return session, nil
+}
+
+// createSession starts a new session for a user who just proved their identity
+func (as *authService) createSession(user *models.User, meta SessionMetadata) (*SessionTokens, error) {
+	session := models.NewSession(user.ID, meta.UserAgent, meta.IPAddress, as.cfg.SessionMaxAge)
+
+	refresh, refreshHash, err := tokens.New()
+	if err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to generate refresh token")
+	}
+	refreshToken := models.NewRefreshToken(session, refreshHash, as.cfg.RefreshTokenTTL)
+	if err := as.storage.CreateSession(session, refreshToken); err != nil {
+		return nil, err
+	}
+
+	return as.sessionTokens(session, refresh, refreshToken)
+}
+
+func (as *authService) sessionTokens(session *models.Session, refresh string, refreshToken *models.RefreshToken) (*SessionTokens, error) {
+	accessToken, accessExpiresAt, err := as.signer.Sign(session.UserID, session.ID)
+	if err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to sign access token")
+	}
+	return &SessionTokens{
+		Session:               session,
+		AccessToken:           accessToken,
+		AccessTokenExpiresAt:  accessExpiresAt,
+		RefreshToken:          refresh,
+		RefreshTokenExpiresAt: refreshToken.ExpiresAt,
+	}, nil
+}
+
+func (as *authService) revokeOnReuse(session *models.Session) error {
+	if err := as.storage.RevokeSession(session.ID, "refresh token reuse detected"); err != nil {
+		return err
+	}
+	log.Printf("WARNING: refresh token reuse detected for session %s of user %s, session revoked", session.ID, session.UserID)
+	return models.NewInternalError(models.ContextUnauthorized, "refresh token is invalid or has expired")
+}
+
+func (as *authService) revokeAllSessions(userID uuid.UUID, reason string) error {
+	if err := as.storage.RevokeUserSessions(userID, reason); err != nil {
+		return err
+	}
+	as.logSessionsRevoked(userID, reason)
+	return nil
+}
+
+func (as *authService) logSessionsRevoked(id uuid.UUID, reason string) {
+	log.Printf("Sessions of user %s revoked (%s) at %v", id, reason, time.Now())
+}
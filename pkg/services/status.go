@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+type statusAction string
+
+const (
+	statusActionActivate   statusAction = "activate"
+	statusActionSuspend    statusAction = "suspend"
+	statusActionReactivate statusAction = "reactivate"
+	statusActionDeactivate statusAction = "deactivate"
+	statusActionDelete     statusAction = "delete"
+)
+
+type statusTransition struct {
+	from []models.UserStatus
+	to   models.UserStatus
+}
+
+// userStatusTransitions is the lifecycle of a user, every status change has to
+// go through one of these actions, anything not listed here is refused
+var userStatusTransitions = map[statusAction]statusTransition{
+	statusActionActivate: {
+		from: []models.UserStatus{models.UserStatusPending},
+		to:   models.UserStatusActive,
+	},
+	statusActionSuspend: {
+		from: []models.UserStatus{models.UserStatusActive},
+		to:   models.UserStatusSuspended,
+	},
+	statusActionReactivate: {
+		from: []models.UserStatus{models.UserStatusSuspended, models.UserStatusDeactivated},
+		to:   models.UserStatusActive,
+	},
+	statusActionDeactivate: {
+		from: []models.UserStatus{models.UserStatusPending, models.UserStatusActive, models.UserStatusSuspended},
+		to:   models.UserStatusDeactivated,
+	},
+	statusActionDelete: {
+		from: []models.UserStatus{models.UserStatusDeactivated},
+		to:   models.UserStatusDeleted,
+	},
+}
+
+const maxStatusReasonLength = 500
+
+func (us *userService) SuspendUser(ctx context.Context, id uuid.UUID, reason string) (*models.User, error) {
+	return us.changeStatus(ctx, id, statusActionSuspend, reason)
+}
+
+func (us *userService) ReactivateUser(ctx context.Context, id uuid.UUID, reason string) (*models.User, error) {
+	return us.changeStatus(ctx, id, statusActionReactivate, reason)
+}
+
+func (us *userService) DeactivateUser(ctx context.Context, id uuid.UUID, reason string) (*models.User, error) {
+	return us.changeStatus(ctx, id, statusActionDeactivate, reason)
+}
+
+func (us *userService) DeleteUser(ctx context.Context, id uuid.UUID, reason string) (*models.User, error) {
+	return us.changeStatus(ctx, id, statusActionDelete, reason)
+}
+
+func (us *userService) GetUserHistory(ctx context.Context, id uuid.UUID) ([]models.UserHistoryEntry, error) {
+	if err := us.authorizeUser(ctx, actionUsersHistory, id); err != nil {
+		return nil, err
+	}
+	// make sure the user exists so unknown IDs end up as 404 instead of empty history
+	if _, err := us.storage.RetrieveUser(id); err != nil {
+		return nil, err
+	}
+	return us.storage.RetrieveUserHistory(id)
+}
+
+func (us *userService) changeStatus(ctx context.Context, id uuid.UUID, action statusAction, reason string) (*models.User, error) {
+	if err := us.authorizeUser(ctx, statusActionName(action), id); err != nil {
+		return nil, err
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, models.NewInternalError(models.ContextBadRequest, "reason is required and cannot be empty")
+	}
+	if len(reason) > maxStatusReasonLength {
+		return nil, models.NewInternalError(models.ContextBadRequest, fmt.Sprintf("reason cannot be longer than %d characters", maxStatusReasonLength))
+	}
+
+	user, err := us.storage.RetrieveUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := us.applyTransition(ctx, user, action, reason); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// applyTransition persists the status change and updates the passed user
+func (us *userService) applyTransition(ctx context.Context, user *models.User, action statusAction, reason string) error {
+	transition := userStatusTransitions[action]
+	if !slices.Contains(transition.from, user.Status) {
+		return models.NewInternalError(models.ContextConflictValue, fmt.Sprintf("cannot %s user in '%s' status", action, user.Status))
+	}
+
+	entry := models.NewStatusChangeEntry(user.ID, user.Status, transition.to, reason)
+	after := snapshot(user)
+	after.Status = transition.to
+	auditAction := models.AuditActionStatusChange
+	if action == statusActionDelete {
+		auditAction = models.AuditActionDelete
+	}
+	audit, err := us.auditEntry(ctx, auditAction, user, after)
+	if err != nil {
+		return err
+	}
+	if err := us.storage.UpdateUserStatus(user.ID, user.Status, entry, audit); err != nil {
+		return err
+	}
+
+	user.Status = transition.to
+	us.logStatusChanged(user.ID, entry.FromStatus, entry.ToStatus)
+	return nil
+}
+
+func (us *userService) logStatusChanged(id uuid.UUID, from models.UserStatus, to models.UserStatus) {
+	log.Printf("User %s changed status from %s to %s at %v", id, from, to, time.Now())
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/storage"
+	"users-microservice/pkg/tokens"
+
+	"github.com/google/uuid"
+)
+
+// issueToken stores the hash of a new single-use token and returns the token
+// itself, which has to be handed to the user right away
+func issueToken(store storage.TokenStorage, userID uuid.UUID, purpose models.TokenPurpose, payload string, ttl time.Duration) (string, error) {
+	token, hash, err := tokens.New()
+	if err != nil {
+		return "", err
+	}
+	userToken := models.NewUserToken(userID, purpose, hash, ttl)
+	userToken.Payload = payload
+	if err := store.CreateUserToken(userToken); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func consumeToken(store storage.TokenStorage, purpose models.TokenPurpose, token string) (*models.UserToken, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, models.NewInternalError(models.ContextBadRequest, "token is required and cannot be empty")
+	}
+	return store.ConsumeUserToken(purpose, tokens.Hash(token))
+}
@@ -3,8 +3,16 @@ package services
 import (
 	"context"
 	"log"
+	"strings"
 	"time"
+	"users-microservice/pkg/accesslog"
+	"users-microservice/pkg/authz"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/export"
+	"users-microservice/pkg/lockout"
+	"users-microservice/pkg/mailer"
 	"users-microservice/pkg/models"
+	"users-microservice/pkg/sms"
 	"users-microservice/pkg/storage"
 	"users-microservice/pkg/validation"
 
@@ -14,6 +22,20 @@ import (
 type UserService interface {
 	GetUser(context.Context, uuid.UUID) (*models.User, error)
 	CreateUser(context.Context, UserCreationRequest) (*models.User, error)
+	SuspendUser(context.Context, uuid.UUID, string) (*models.User, error)
+	ReactivateUser(context.Context, uuid.UUID, string) (*models.User, error)
+	DeactivateUser(context.Context, uuid.UUID, string) (*models.User, error)
+	DeleteUser(context.Context, uuid.UUID, string) (*models.User, error)
+	GetUserHistory(context.Context, uuid.UUID) ([]models.UserHistoryEntry, error)
+	GetUserAudit(context.Context, uuid.UUID) ([]models.AuditEntry, error)
+	GetUserAccessLog(context.Context, uuid.UUID, time.Time, int) ([]models.AccessRecord, error)
+	VerifyEmail(context.Context, string) (*models.User, error)
+	ResendEmailVerification(context.Context, uuid.UUID) error
+	UpdateUser(context.Context, uuid.UUID, UserUpdateRequest) (*models.User, error)
+	ConfirmEmailChange(context.Context, string) (*models.User, error)
+	RevertEmailChange(context.Context, string) (*models.User, error)
+	SendPhoneVerification(context.Context, uuid.UUID) error
+	VerifyPhone(context.Context, uuid.UUID, string) (*models.User, error)
 }
 
 type UserCreationRequest struct {
@@ -21,56 +43,203 @@ type UserCreationRequest struct {
 	Name        string
 	Email       string
 	DateOfBirth time.Time
+	// optional, national numbers are parsed for PhoneRegion or the default one
+	PhoneNumber string
+	PhoneRegion string
+	Region      string
+}
+
+// fields left nil are not changed
+type UserUpdateRequest struct {
+	Name        *string
+	Email       *string
+	DateOfBirth *time.Time
+	// an empty number removes the phone
+	PhoneNumber *string
+	PhoneRegion string
+	// an empty region removes it
+	Region *string
 }
 
 type userService struct {
+	authorizer
+	auditor
+	accessRecorder
 	storage storage.Storage
+	mailer  mailer.Mailer
+	sms     sms.SMSSender
+	guard   *lockout.Guard
+	cfg     *config.Config
 }
 
-func NewUserService(storage storage.Storage) (UserService, error) {
-	return &userService{storage: storage}, nil
+func NewUserService(storage storage.Storage, mailer mailer.Mailer, sms sms.SMSSender, guard *lockout.Guard, accessLog *accesslog.Writer, exporter *export.Exporter, policies *authz.Engine, cfg *config.Config) (UserService, error) {
+	us := &userService{authorizer: newAuthorizer(storage, policies), auditor: auditor{audit: storage}, accessRecorder: accessRecorder{accessLog: accessLog}, storage: storage, mailer: mailer, sms: sms, guard: guard, cfg: cfg}
+	us.registerExportCollectors(exporter)
+	return us, nil
 }
 
 func (us *userService) GetUser(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	if err := us.authorizeUser(ctx, actionUsersGet, id); err != nil {
+		return nil, err
+	}
 	user, err := us.storage.RetrieveUser(id)
 	if err != nil {
 		return nil, err
 	}
 
-	us.logUserAccess(id)
+	us.recordAccess(ctx, user)
 	return user, nil
 }
 
 func (us *userService) CreateUser(ctx context.Context, req UserCreationRequest) (*models.User, error) {
+	if err := us.authorizeCreation(ctx, models.NewUser(req.ID, req.Name, req.Email, req.DateOfBirth)); err != nil {
+		return nil, err
+	}
 	if err := validation.ValidateUser(req.Name, req.Email, req.DateOfBirth); err != nil {
 		return nil, err
 	}
 
 	//some business
-	if !us.isEligibleForRegistration(req.DateOfBirth) {
+	if !isEligibleForRegistration(req.DateOfBirth) {
 		return nil, models.NewInternalError(models.ContextBadRequest, "user must have atleast 13 years to register")
 	}
 
 	newUser := models.NewUser(req.ID, req.Name, req.Email, req.DateOfBirth)
+	if _, err := us.setPhoneNumber(newUser, req.PhoneNumber, req.PhoneRegion); err != nil {
+		return nil, err
+	}
+	region, err := validation.NormalizeRegion(req.Region)
+	if err != nil {
+		return nil, err
+	}
+	newUser.Region = region
 
 	//store
-	if err := us.storage.CreateUser(newUser); err != nil {
+	audit, err := us.auditEntry(ctx, models.AuditActionCreate, nil, newUser)
+	if err != nil {
+		return nil, err
+	}
+	if err := us.storage.CreateUser(newUser, audit); err != nil {
 		return nil, err
 	}
 
 	us.logUserCreated(newUser.ID)
+
+	// the user can ask for another email, so failing here does not fail the registration
+	if err := us.sendEmailVerification(ctx, newUser); err != nil {
+		log.Printf("ERROR: failed to send verification email to user %s: %v", newUser.ID, err)
+	}
+	if newUser.PhoneNumber != "" {
+		us.sendNewPhoneVerification(ctx, newUser)
+	}
 	return newUser, nil
 }
 
-func (us *userService) logUserAccess(id uuid.UUID) {
-	log.Printf("User %s accessed at %v", id, time.Now())
+// UpdateUser applies profile changes right away, a new email only becomes
+// pending until the new address is confirmed
+func (us *userService) UpdateUser(ctx context.Context, id uuid.UUID, req UserUpdateRequest) (*models.User, error) {
+	if err := us.authorizeUser(ctx, actionUsersUpdate, id); err != nil {
+		return nil, err
+	}
+	user, err := us.storage.RetrieveUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.Status == models.UserStatusDeleted {
+		return nil, models.NewInternalError(models.ContextConflictValue, "deleted user cannot be updated")
+	}
+	before := snapshot(user)
+
+	if req.Name != nil {
+		if err := validation.ValidateName(*req.Name); err != nil {
+			return nil, err
+		}
+		user.Name = *req.Name
+	}
+	if req.DateOfBirth != nil {
+		if err := validation.ValidateDateOfBirth(*req.DateOfBirth); err != nil {
+			return nil, err
+		}
+		if !isEligibleForRegistration(*req.DateOfBirth) {
+			return nil, models.NewInternalError(models.ContextBadRequest, "user must have atleast 13 years to register")
+		}
+		user.DateOfBirth = *req.DateOfBirth
+	}
+	if req.Region != nil {
+		region, err := validation.NormalizeRegion(*req.Region)
+		if err != nil {
+			return nil, err
+		}
+		user.Region = region
+	}
+
+	emailChanged := false
+	if req.Email != nil {
+		if strings.EqualFold(*req.Email, user.Email) {
+			// asking for the current address withdraws a pending change
+			if user.PendingEmail != "" {
+				if err := us.cancelEmailChange(user); err != nil {
+					return nil, err
+				}
+			}
+		} else if !user.HasPendingEmail() || !strings.EqualFold(*req.Email, user.PendingEmail) {
+			if err := us.requestEmailChange(user, *req.Email); err != nil {
+				return nil, err
+			}
+			emailChanged = true
+		}
+	}
+
+	phoneChanged := false
+	if req.PhoneNumber != nil {
+		if phoneChanged, err = us.setPhoneNumber(user, *req.PhoneNumber, req.PhoneRegion); err != nil {
+			return nil, err
+		}
+	}
+
+	var entry *models.UserHistoryEntry
+	if phoneChanged {
+		reason := "phone number changed"
+		if user.PhoneNumber == "" {
+			reason = "phone number removed"
+		}
+		entry = models.NewUserHistoryEntry(user.ID, models.HistoryEventPhoneChanged, reason)
+	}
+	audit, err := us.auditEntry(ctx, models.AuditActionUpdate, before, user)
+	if err != nil {
+		return nil, err
+	}
+	if err := us.storage.UpdateUser(user, entry, audit); err != nil {
+		return nil, err
+	}
+
+	if phoneChanged && user.PhoneNumber != "" {
+		us.sendNewPhoneVerification(ctx, user)
+	}
+
+	if emailChanged {
+		if err := us.sendEmailChange(ctx, user); err != nil {
+			// a change nobody was asked to confirm would only block retries
+			if withdrawErr := us.withdrawEmailChange(ctx, user); withdrawErr != nil {
+				log.Printf("ERROR: failed to withdraw email change of user %s: %v", user.ID, withdrawErr)
+			}
+			return nil, models.NewWrappedError(err, models.ContextInternalServer, "failed to send email change confirmation")
+		}
+	}
+
+	us.logUserUpdated(user.ID)
+	return user, nil
+}
+
+func (us *userService) logUserUpdated(id uuid.UUID) {
+	log.Printf("User %s updated at %v", id, time.Now())
 }
 
 func (us *userService) logUserCreated(id uuid.UUID) {
 	log.Printf("User %s created at %v", id, time.Now())
 }
 
-func (us *userService) isEligibleForRegistration(dateOfBirth time.Time) bool {
+func isEligibleForRegistration(dateOfBirth time.Time) bool {
 	age := time.Since(dateOfBirth).Hours() / 24 / 365.25
 	return age >= 13
 }
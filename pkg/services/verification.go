@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+	"users-microservice/pkg/mailer"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+func (us *userService) VerifyEmail(ctx context.Context, token string) (*models.User, error) {
+	userToken, err := consumeToken(us.storage, models.TokenPurposeEmailVerification, token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := us.storage.RetrieveUser(userToken.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.EmailVerifiedAt == nil {
+		before := snapshot(user)
+		verifiedAt := time.Now()
+		user.EmailVerifiedAt = &verifiedAt
+		audit, err := us.auditEntry(ctx, models.AuditActionUpdate, before, user)
+		if err != nil {
+			return nil, err
+		}
+		if err := us.storage.MarkEmailVerified(user.ID, verifiedAt, audit); err != nil {
+			return nil, err
+		}
+	}
+
+	if user.Status == models.UserStatusPending {
+		if err := us.applyTransition(ctx, user, statusActionActivate, "email address verified"); err != nil {
+			return nil, err
+		}
+	}
+
+	us.logEmailVerified(user.ID)
+	return user, nil
+}
+
+func (us *userService) ResendEmailVerification(ctx context.Context, id uuid.UUID) error {
+	if err := us.authorizeUser(ctx, actionEmailResendVerification, id); err != nil {
+		return err
+	}
+	user, err := us.storage.RetrieveUser(id)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return models.NewInternalError(models.ContextConflictValue, "email address is already verified")
+	}
+
+	issued, err := us.storage.RetrieveUserTokensSince(id, models.TokenPurposeEmailVerification, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return err
+	}
+	if len(issued) >= us.cfg.VerificationResendPerDay {
+		return models.NewInternalError(models.ContextTooManyRequests, "too many verification emails requested, try again tomorrow")
+	}
+	if len(issued) > 0 && time.Since(issued[0].CreatedAt) < us.cfg.VerificationResendDelay {
+		return models.NewInternalError(models.ContextTooManyRequests, "verification email was sent recently, try again later")
+	}
+
+	if err := us.sendEmailVerification(ctx, user); err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, "failed to send verification email")
+	}
+	return nil
+}
+
+// sendEmailVerification issues a fresh token, anything issued before is no
+// longer usable
+func (us *userService) sendEmailVerification(ctx context.Context, user *models.User) error {
+	if err := us.storage.InvalidateUserTokens(user.ID, models.TokenPurposeEmailVerification); err != nil {
+		return err
+	}
+	token, err := issueToken(us.storage, user.ID, models.TokenPurposeEmailVerification, "", us.cfg.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(us.cfg.EmailVerificationTTL)
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", us.cfg.AppBaseURL, url.QueryEscape(token))
+	return us.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nplease confirm your email address by opening %s\n\nThe link expires at %s.\n",
+			user.Name, link, expiresAt.Format(time.RFC1123)),
+	})
+}
+
+func (us *userService) logEmailVerified(id uuid.UUID) {
+	log.Printf("User %s verified email at %v", id, time.Now())
+}
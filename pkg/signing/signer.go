@@ -0,0 +1,81 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Signer signs requests with the secret shared under the key ID
+type Signer struct {
+	keyID  string
+	secret []byte
+	// lower case, host is always signed and content-type whenever set
+	headers []string
+	now     func() time.Time
+}
+
+// NewSigner signs the method, path, query, body, host and content type of
+// requests, along with any further headers named
+func NewSigner(keyID string, secret []byte, headers ...string) *Signer {
+	signed := []string{"host"}
+	for _, name := range headers {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !slices.Contains(signed, name) {
+			signed = append(signed, name)
+		}
+	}
+	return &Signer{keyID: keyID, secret: secret, headers: signed, now: time.Now}
+}
+
+// Sign sets the authorization header of the request. The body is read and
+// replaced, so it can still be sent
+func (s *Signer) Sign(r *http.Request) error {
+	digest := sha256.New()
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read request body: %w", err)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+		digest.Write(body)
+	}
+
+	headers := s.headers
+	if r.Header.Get("Content-Type") != "" && !slices.Contains(headers, "content-type") {
+		headers = append(slices.Clone(headers), "content-type")
+	}
+	p := &params{keyID: s.keyID, timestamp: s.now(), nonce: rand.Text(), headers: headers}
+	p.signature = sign(s.secret, r, digest.Sum(nil), p)
+	r.Header.Set("Authorization", p.String())
+	return nil
+}
+
+// Transport signs every request before handing it to Base, or
+// http.DefaultTransport when Base is nil. Requests are signed again on
+// redirects and retries, so each carries a fresh nonce
+type Transport struct {
+	Signer *Signer
+	Base   http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// a round tripper must not modify the request it is given
+	signed := r.Clone(r.Context())
+	if err := t.Signer.Sign(signed); err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
@@ -0,0 +1,164 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/models"
+)
+
+const (
+	// bodies are read to be verified before the handler limits them
+	maxBodySize = 1 << 20
+	// shorter secrets are refused, HMAC-SHA256 keys should not be weaker
+	// than the hash
+	minSecretSize = 32
+)
+
+// Client is a caller sharing a secret with the service, it acts as a service
+// principal with the scopes given
+type Client struct {
+	KeyID  string         `json:"key_id"`
+	Secret string         `json:"secret"`
+	Scopes []models.Scope `json:"scopes"`
+}
+
+// Nonces remembers nonces until they expire and reports whether a nonce was
+// new, see storage.NonceStorage
+type Nonces interface {
+	RecordNonce(key string, now time.Time, expiresAt time.Time) (bool, error)
+	DeleteExpiredNonces(now time.Time) error
+}
+
+// Verifier checks signatures of the configured clients and rejects requests
+// outside the timestamp window and replayed nonces
+type Verifier struct {
+	clients         map[string]Client
+	nonces          Nonces
+	maxSkew         time.Duration
+	requiredHeaders []string
+	now             func() time.Time
+
+	mu sync.Mutex
+	// expired nonces are deleted at most once per skew
+	swept time.Time
+}
+
+func Load(cfg *config.Signing, nonces Nonces) (*Verifier, error) {
+	clients, err := readClients(cfg.ClientsFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Verifier{clients: clients, nonces: nonces, maxSkew: cfg.MaxSkew, requiredHeaders: cfg.RequiredHeaders, now: time.Now}, nil
+}
+
+func readClients(path string) (map[string]Client, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing clients: %w", err)
+	}
+	var list []Client
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse signing clients: %w", err)
+	}
+
+	clients := make(map[string]Client, len(list))
+	var errs error
+	for i, client := range list {
+		if client.KeyID == "" {
+			errs = errors.Join(errs, fmt.Errorf("signing client %d has no key ID", i+1))
+		} else if _, ok := clients[client.KeyID]; ok {
+			errs = errors.Join(errs, fmt.Errorf("key ID '%s' is used by more than one signing client", client.KeyID))
+		}
+		if len(client.Secret) < minSecretSize {
+			errs = errors.Join(errs, fmt.Errorf("secret of signing client %d is shorter than %d bytes", i+1, minSecretSize))
+		}
+		for _, scope := range client.Scopes {
+			if !models.IsKnownScope(scope) {
+				errs = errors.Join(errs, fmt.Errorf("signing client %d has unknown scope '%s'", i+1, scope))
+			}
+		}
+		clients[client.KeyID] = client
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	return clients, nil
+}
+
+// Verify checks the signature of the request and returns the client that
+// made it. The body is read and replaced, so handlers can still decode it
+func (v *Verifier) Verify(r *http.Request) (*models.Principal, error) {
+	p, err := parseParams(r.Header.Get("Authorization"))
+	if err != nil {
+		return nil, models.NewWrappedError(err, models.ContextUnauthorized, "request signature is malformed")
+	}
+	for _, name := range v.requiredHeaders {
+		if !slices.Contains(p.headers, name) {
+			return nil, models.NewInternalError(models.ContextUnauthorized, fmt.Sprintf("request signature has to cover the '%s' header", name))
+		}
+	}
+	client, ok := v.clients[p.keyID]
+	if !ok {
+		return nil, models.NewInternalError(models.ContextUnauthorized, "request signature is invalid")
+	}
+	now := v.now()
+	if p.timestamp.Before(now.Add(-v.maxSkew)) || p.timestamp.After(now.Add(v.maxSkew)) {
+		return nil, models.NewInternalError(models.ContextUnauthorized, "request signature has expired")
+	}
+
+	digest := sha256.New()
+	if r.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+		if err != nil {
+			return nil, models.NewWrappedError(err, models.ContextBadRequest, "failed to read request body")
+		}
+		if len(body) > maxBodySize {
+			return nil, models.NewInternalError(models.ContextBadRequest, "body of a signed request is too large")
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		digest.Write(body)
+	}
+	if !hmac.Equal(sign([]byte(client.Secret), r, digest.Sum(nil), p), p.signature) {
+		return nil, models.NewInternalError(models.ContextUnauthorized, "request signature is invalid")
+	}
+
+	// the nonce is only recorded for valid signatures, so nobody can use up
+	// the nonces of a client. It is kept until the timestamp would be
+	// rejected anyway
+	fresh, err := v.nonces.RecordNonce(p.keyID+":"+p.nonce, now, p.timestamp.Add(v.maxSkew))
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, models.NewInternalError(models.ContextUnauthorized, "request has already been made")
+	}
+	v.sweep(now)
+	return &models.Principal{Kind: models.PrincipalService, Subject: client.KeyID, Scopes: client.Scopes}, nil
+}
+
+func (v *Verifier) sweep(now time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if now.Sub(v.swept) < v.maxSkew {
+		return
+	}
+	v.swept = now
+	go func() {
+		if err := v.nonces.DeleteExpiredNonces(now); err != nil {
+			log.Printf("ERROR: failed to delete expired nonces: %v", err)
+		}
+	}()
+}
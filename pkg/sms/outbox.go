@@ -0,0 +1,90 @@
+package sms
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogSender writes messages to the service log instead of delivering them
+type LogSender struct{}
+
+func NewLogSender() *LogSender {
+	return &LogSender{}
+}
+
+func (s *LogSender) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	log.Printf("SMS to %s: %s", msg.To, msg.Body)
+	return nil
+}
+
+// OutboxSender appends every message as a JSON line to the underlying writer,
+// meant for local runs and tests
+type OutboxSender struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type outboxEntry struct {
+	Message
+	SentAt time.Time `json:"sent_at"`
+}
+
+func NewOutboxSender(w io.Writer) *OutboxSender {
+	return &OutboxSender{w: w}
+}
+
+func NewFileOutbox(path string) (*OutboxSender, error) {
+	if path == "" {
+		return nil, fmt.Errorf("sms outbox path is not set")
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sms outbox %s: %w", path, err)
+	}
+	return NewOutboxSender(file), nil
+}
+
+func (s *OutboxSender) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(outboxEntry{Message: msg, SentAt: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+// ReadOutbox returns all messages written to an outbox file, oldest first
+func ReadOutbox(path string) ([]Message, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var messages []Message
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry outboxEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		messages = append(messages, entry.Message)
+	}
+	return messages, scanner.Err()
+}
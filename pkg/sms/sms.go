@@ -0,0 +1,30 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"users-microservice/pkg/config"
+)
+
+type Message struct {
+	// E.164 formatted recipient
+	To   string `json:"to"`
+	Body string `json:"body"`
+}
+
+type SMSSender interface {
+	Send(context.Context, Message) error
+}
+
+// New builds the sender selected in the configuration, only local senders
+// exist so far, a gateway is plugged in by implementing SMSSender
+func New(cfg *config.Config) (SMSSender, error) {
+	switch cfg.SMSSender {
+	case "file":
+		return NewFileOutbox(cfg.SMSOutboxPath)
+	case "log", "":
+		return NewLogSender(), nil
+	default:
+		return nil, fmt.Errorf("unknown sms sender '%s'", cfg.SMSSender)
+	}
+}
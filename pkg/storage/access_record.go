@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+type AccessRecordEntity struct {
+	ID         uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID     uuid.UUID `gorm:"type:uuid;not null;index:idx_access_records_user"`
+	Accessor   string    `gorm:"not null"`
+	Fields     []string  `gorm:"serializer:json;type:jsonb;not null"`
+	Purpose    string
+	RequestID  string    `gorm:"type:varchar(128)"`
+	AccessedAt time.Time `gorm:"not null;index:idx_access_records_user;index"`
+}
+
+func (AccessRecordEntity) TableName() string {
+	return "access_records"
+}
+
+func (dto *AccessRecordEntity) ToModel() *models.AccessRecord {
+	return &models.AccessRecord{
+		ID:         dto.ID,
+		UserID:     dto.UserID,
+		Accessor:   dto.Accessor,
+		Fields:     dto.Fields,
+		Purpose:    dto.Purpose,
+		RequestID:  dto.RequestID,
+		AccessedAt: dto.AccessedAt,
+	}
+}
+
+func (dto *AccessRecordEntity) FromModel(record *models.AccessRecord) {
+	dto.ID = record.ID
+	dto.UserID = record.UserID
+	dto.Accessor = record.Accessor
+	dto.Fields = record.Fields
+	dto.Purpose = record.Purpose
+	dto.RequestID = record.RequestID
+	dto.AccessedAt = record.AccessedAt
+}
+
+func (ps *PostgresStorage) AppendAccessRecords(records []models.AccessRecord) error {
+	dtos := make([]AccessRecordEntity, len(records))
+	for i := range records {
+		dtos[i].FromModel(&records[i])
+	}
+	if err := ps.db.Create(&dtos).Error; err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while recording %d accesses", len(records)))
+	}
+	return nil
+}
+
+// RetrieveAccessRecords returns the newest records of the user since the
+// given time
+func (ps *PostgresStorage) RetrieveAccessRecords(userID uuid.UUID, since time.Time, limit int) ([]models.AccessRecord, error) {
+	var dtos []AccessRecordEntity
+	if err := ps.db.Where("user_id = ? AND accessed_at >= ?", userID, since).Order("accessed_at DESC").Limit(limit).Find(&dtos).Error; err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while retrieving accesses of user with '%s' ID", userID))
+	}
+
+	records := make([]models.AccessRecord, 0, len(dtos))
+	for _, dto := range dtos {
+		records = append(records, *dto.ToModel())
+	}
+	return records, nil
+}
+
+func (ps *PostgresStorage) DeleteAccessRecordsBefore(before time.Time) (int64, error) {
+	tx := ps.db.Delete(&AccessRecordEntity{}, "accessed_at < ?", before)
+	if tx.Error != nil {
+		return 0, models.NewWrappedError(tx.Error, models.ContextInternalServer, "unexpected error while deleting expired access records")
+	}
+	return tx.RowsAffected, nil
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+type APIKeyEntity struct {
+	ID         uuid.UUID      `gorm:"type:uuid;primaryKey"`
+	Name       string         `gorm:"not null"`
+	Prefix     string         `gorm:"not null;uniqueIndex:idx_api_keys_prefix"`
+	Hash       string         `gorm:"not null"`
+	Scopes     []models.Scope `gorm:"serializer:json;type:jsonb;not null"`
+	CreatedAt  time.Time      `gorm:"not null"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+func (APIKeyEntity) TableName() string {
+	return "api_keys"
+}
+
+func (dto *APIKeyEntity) ToModel() *models.APIKey {
+	return &models.APIKey{
+		ID:         dto.ID,
+		Name:       dto.Name,
+		Prefix:     dto.Prefix,
+		Hash:       dto.Hash,
+		Scopes:     dto.Scopes,
+		CreatedAt:  dto.CreatedAt,
+		ExpiresAt:  dto.ExpiresAt,
+		LastUsedAt: dto.LastUsedAt,
+	}
+}
+
+func (dto *APIKeyEntity) FromModel(key *models.APIKey) {
+	dto.ID = key.ID
+	dto.Name = key.Name
+	dto.Prefix = key.Prefix
+	dto.Hash = key.Hash
+	dto.Scopes = key.Scopes
+	dto.CreatedAt = key.CreatedAt
+	dto.ExpiresAt = key.ExpiresAt
+	dto.LastUsedAt = key.LastUsedAt
+}
+
+func (ps *PostgresStorage) CreateAPIKey(key *models.APIKey) error {
+	dto := &APIKeyEntity{}
+	dto.FromModel(key)
+
+	tx := ps.db.Create(dto)
+	if tx.Error != nil {
+		errMsg := tx.Error.Error()
+		if strings.Contains(errMsg, "duplicate key value violates unique constraint") && strings.Contains(errMsg, "idx_api_keys_prefix") {
+			return models.NewWrappedError(tx.Error, models.ContextConflictValue, fmt.Sprintf("API key with '%s' prefix already exists", key.Prefix))
+		}
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, "unexpected error while storing API key")
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) RetrieveAPIKeyByPrefix(prefix string) (*models.APIKey, error) {
+	dto := &APIKeyEntity{}
+	tx := ps.db.First(dto, "prefix = ?", prefix)
+	if tx.Error != nil {
+		errMsg := tx.Error.Error()
+		if strings.Contains(errMsg, "record not found") {
+			return nil, models.NewWrappedError(tx.Error, models.ContextNotFound, fmt.Sprintf("API key with '%s' prefix does not exist", prefix))
+		} else {
+			return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while searching API key with '%s' prefix", prefix))
+		}
+	}
+	return dto.ToModel(), nil
+}
+
+// RetrieveAPIKeys lists every key, oldest first
+func (ps *PostgresStorage) RetrieveAPIKeys() ([]models.APIKey, error) {
+	var dtos []APIKeyEntity
+	tx := ps.db.Order("created_at").Find(&dtos)
+	if tx.Error != nil {
+		return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, "unexpected error while listing API keys")
+	}
+
+	keys := make([]models.APIKey, 0, len(dtos))
+	for _, dto := range dtos {
+		keys = append(keys, *dto.ToModel())
+	}
+	return keys, nil
+}
+
+func (ps *PostgresStorage) UseAPIKey(id uuid.UUID, at time.Time) error {
+	tx := ps.db.Model(&APIKeyEntity{}).Where("id = ?", id).Update("last_used_at", at)
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while using API key with '%s' ID", id))
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) DeleteAPIKey(id uuid.UUID) error {
+	tx := ps.db.Delete(&APIKeyEntity{}, "id = ?", id)
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while revoking API key with '%s' ID", id))
+	}
+	if tx.RowsAffected == 0 {
+		return models.NewInternalError(models.ContextNotFound, fmt.Sprintf("API key with '%s' ID does not exist", id))
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// a concurrent append to the same chain takes the sequence, the append is
+// tried again on top of it
+const auditAppendAttempts = 3
+
+var errAuditSequenceTaken = errors.New("audit sequence was taken by a concurrent append")
+
+type AuditEntryEntity struct {
+	ID        uuid.UUID            `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID            `gorm:"type:uuid;not null;uniqueIndex:idx_audit_entries_chain"`
+	Sequence  int64                `gorm:"not null;uniqueIndex:idx_audit_entries_chain"`
+	Action    string               `gorm:"type:varchar(20);not null"`
+	Actor     string               `gorm:"not null"`
+	RequestID string               `gorm:"type:varchar(128)"`
+	Changes   []models.AuditChange `gorm:"serializer:json;type:jsonb;not null"`
+	CreatedAt time.Time            `gorm:"not null"`
+	PrevHash  string               `gorm:"type:varchar(64)"`
+	Hash      string               `gorm:"type:varchar(64);not null"`
+}
+
+func (AuditEntryEntity) TableName() string {
+	return "audit_entries"
+}
+
+func (dto *AuditEntryEntity) ToModel() *models.AuditEntry {
+	return &models.AuditEntry{
+		ID:        dto.ID,
+		UserID:    dto.UserID,
+		Sequence:  dto.Sequence,
+		Action:    dto.Action,
+		Actor:     dto.Actor,
+		RequestID: dto.RequestID,
+		Changes:   dto.Changes,
+		CreatedAt: dto.CreatedAt,
+		PrevHash:  dto.PrevHash,
+		Hash:      dto.Hash,
+	}
+}
+
+func (dto *AuditEntryEntity) FromModel(entry *models.AuditEntry) {
+	dto.ID = entry.ID
+	dto.UserID = entry.UserID
+	dto.Sequence = entry.Sequence
+	dto.Action = entry.Action
+	dto.Actor = entry.Actor
+	dto.RequestID = entry.RequestID
+	dto.Changes = entry.Changes
+	dto.CreatedAt = entry.CreatedAt
+	dto.PrevHash = entry.PrevHash
+	dto.Hash = entry.Hash
+}
+
+// AuditHeadEntity records the last entry of a user's chain, it only ever
+// moves on to the next entry
+type AuditHeadEntity struct {
+	UserID   uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Sequence int64     `gorm:"not null"`
+	Hash     string    `gorm:"type:varchar(64);not null"`
+}
+
+func (AuditHeadEntity) TableName() string {
+	return "audit_heads"
+}
+
+func (dto *AuditHeadEntity) ToModel() *models.AuditHead {
+	return &models.AuditHead{
+		UserID:   dto.UserID,
+		Sequence: dto.Sequence,
+		Hash:     dto.Hash,
+	}
+}
+
+// AuditSaltEntity holds the key personal data in the audit entries of a user
+// is hashed with
+type AuditSaltEntity struct {
+	UserID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Salt   []byte    `gorm:"not null"`
+}
+
+func (AuditSaltEntity) TableName() string {
+	return "audit_salts"
+}
+
+// rejectAuditRewrite keeps the audit log append-only in the database itself,
+// entries are never updated or deleted and heads only move on to the entry
+// just appended
+const rejectAuditRewrite = `
+CREATE OR REPLACE FUNCTION reject_audit_rewrite() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'UPDATE' AND TG_TABLE_NAME = 'audit_heads'
+		AND NEW.user_id = OLD.user_id AND NEW.sequence = OLD.sequence + 1
+		AND EXISTS (SELECT 1 FROM audit_entries WHERE user_id = NEW.user_id AND sequence = NEW.sequence AND hash = NEW.hash) THEN
+		RETURN NEW;
+	END IF;
+	RAISE EXCEPTION '% on % is not allowed, the audit log is append-only', TG_OP, TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql`
+
+// protectAuditLog installs the triggers rejecting rewrites of the audit log
+// unless they exist, so a role that does not own the tables can run the
+// service once they were installed. Chains from before heads were recorded
+// get the head they end at
+func protectAuditLog(db *gorm.DB) error {
+	for _, table := range []string{"audit_entries", "audit_heads"} {
+		trigger := table + "_append_only"
+		var installed int64
+		if err := db.Raw("SELECT COUNT(*) FROM pg_trigger WHERE tgname = ?", trigger).Scan(&installed).Error; err != nil {
+			return err
+		}
+		if installed > 0 {
+			continue
+		}
+		if err := db.Exec(rejectAuditRewrite).Error; err != nil {
+			return err
+		}
+		if err := db.Exec(fmt.Sprintf("CREATE TRIGGER %s BEFORE UPDATE OR DELETE ON %s FOR EACH ROW EXECUTE FUNCTION reject_audit_rewrite()", trigger, table)).Error; err != nil {
+			return err
+		}
+	}
+
+	return db.Exec(`INSERT INTO audit_heads (user_id, sequence, hash)
+		SELECT user_id, sequence, hash FROM audit_entries e
+		WHERE sequence = (SELECT MAX(sequence) FROM audit_entries WHERE user_id = e.user_id)
+		AND NOT EXISTS (SELECT 1 FROM audit_heads WHERE user_id = e.user_id)`).Error
+}
+
+// auditedTransaction runs write and appends the entry to the end of the
+// user's chain in one transaction, a nil entry is not appended. The whole
+// transaction is tried again when a concurrent append took the sequence
+func (ps *PostgresStorage) auditedTransaction(entry *models.AuditEntry, write func(tx *gorm.DB) error) error {
+	var err error
+	for range auditAppendAttempts {
+		err = ps.db.Transaction(func(tx *gorm.DB) error {
+			if err := write(tx); err != nil {
+				return err
+			}
+			if entry == nil {
+				return nil
+			}
+			return appendAuditEntry(tx, entry)
+		})
+		if !errors.Is(err, errAuditSequenceTaken) {
+			return err
+		}
+	}
+	return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while auditing user with '%s' ID", entry.UserID))
+}
+
+// appendAuditEntry links the entry to the end of the user's chain, setting
+// its sequence and hashes, and moves the head of the chain onto it
+func appendAuditEntry(tx *gorm.DB, entry *models.AuditEntry) error {
+	last := &AuditEntryEntity{}
+	res := tx.Where("user_id = ?", entry.UserID).Order("sequence DESC").Limit(1).Find(last)
+	if res.Error != nil {
+		return models.NewWrappedError(res.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while auditing user with '%s' ID", entry.UserID))
+	}
+	entry.Sequence, entry.PrevHash = 1, ""
+	if res.RowsAffected > 0 {
+		entry.Sequence, entry.PrevHash = last.Sequence+1, last.Hash
+	}
+	entry.Hash = entry.ComputeHash()
+
+	dto := &AuditEntryEntity{}
+	dto.FromModel(entry)
+	if err := tx.Create(dto).Error; err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") || strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return fmt.Errorf("%w: %v", errAuditSequenceTaken, err)
+		}
+		return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while auditing user with '%s' ID", entry.UserID))
+	}
+
+	head := &AuditHeadEntity{UserID: entry.UserID, Sequence: entry.Sequence, Hash: entry.Hash}
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"sequence", "hash"}),
+	}).Create(head).Error
+	if err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while auditing user with '%s' ID", entry.UserID))
+	}
+	return nil
+}
+
+// RetrieveAuditEntries returns the chain of the user in sequence order
+func (ps *PostgresStorage) RetrieveAuditEntries(userID uuid.UUID) ([]models.AuditEntry, error) {
+	var dtos []AuditEntryEntity
+	if err := ps.db.Where("user_id = ?", userID).Order("sequence").Find(&dtos).Error; err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while retrieving audit log of user with '%s' ID", userID))
+	}
+
+	entries := make([]models.AuditEntry, 0, len(dtos))
+	for _, dto := range dtos {
+		entries = append(entries, *dto.ToModel())
+	}
+	return entries, nil
+}
+
+// RetrieveAuditHead returns the recorded last entry of the user's chain
+func (ps *PostgresStorage) RetrieveAuditHead(userID uuid.UUID) (*models.AuditHead, error) {
+	dto := &AuditHeadEntity{}
+	tx := ps.db.First(dto, "user_id = ?", userID)
+	if tx.Error != nil {
+		if strings.Contains(tx.Error.Error(), "record not found") {
+			return nil, models.NewWrappedError(tx.Error, models.ContextNotFound, fmt.Sprintf("audit log of user with '%s' ID has no head", userID))
+		}
+		return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while retrieving audit head of user with '%s' ID", userID))
+	}
+	return dto.ToModel(), nil
+}
+
+// RetrieveAuditedUserIDs returns every user with a chain or a head, so all of
+// them can be verified, including chains whose entries were all removed
+func (ps *PostgresStorage) RetrieveAuditedUserIDs() ([]uuid.UUID, error) {
+	var withEntries, withHeads []uuid.UUID
+	if err := ps.db.Model(&AuditEntryEntity{}).Distinct().Pluck("user_id", &withEntries).Error; err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "unexpected error while retrieving audited users")
+	}
+	if err := ps.db.Model(&AuditHeadEntity{}).Pluck("user_id", &withHeads).Error; err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "unexpected error while retrieving audited users")
+	}
+
+	ids := append(withEntries, withHeads...)
+	slices.SortFunc(ids, func(a, b uuid.UUID) int { return bytes.Compare(a[:], b[:]) })
+	return slices.Compact(ids), nil
+}
+
+// AuditSalt returns the salt of the user, creating it on first use
+func (ps *PostgresStorage) AuditSalt(userID uuid.UUID) ([]byte, error) {
+	dto := &AuditSaltEntity{}
+	res := ps.db.Where("user_id = ?", userID).Limit(1).Find(dto)
+	if res.Error != nil {
+		return nil, models.NewWrappedError(res.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while retrieving audit salt of user with '%s' ID", userID))
+	}
+	if res.RowsAffected > 0 {
+		return dto.Salt, nil
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while generating audit salt of user with '%s' ID", userID))
+	}
+	// whoever creates the salt first wins, everyone reads it back
+	if err := ps.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&AuditSaltEntity{UserID: userID, Salt: salt}).Error; err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while creating audit salt of user with '%s' ID", userID))
+	}
+	if err := ps.db.First(dto, "user_id = ?", userID).Error; err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while retrieving audit salt of user with '%s' ID", userID))
+	}
+	return dto.Salt, nil
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+)
+
+type PasswordCredentialEntity struct {
+	UserID    uuid.UUID `gorm:"primaryKey"`
+	Hash      string    `gorm:"not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+func (PasswordCredentialEntity) TableName() string {
+	return "user_passwords"
+}
+
+func (dto *PasswordCredentialEntity) ToModel() *models.PasswordCredential {
+	return &models.PasswordCredential{
+		UserID:    dto.UserID,
+		Hash:      dto.Hash,
+		UpdatedAt: dto.UpdatedAt,
+	}
+}
+
+func (dto *PasswordCredentialEntity) FromModel(credential *models.PasswordCredential) {
+	dto.UserID = credential.UserID
+	dto.Hash = credential.Hash
+	dto.UpdatedAt = credential.UpdatedAt
+}
+
+func (ps *PostgresStorage) RetrievePasswordCredential(userID uuid.UUID) (*models.PasswordCredential, error) {
+	dto := &PasswordCredentialEntity{}
+	tx := ps.db.First(dto, "user_id = ?", userID)
+	if tx.Error != nil {
+		errMsg := tx.Error.Error()
+		if strings.Contains(errMsg, "record not found") {
+			return nil, models.NewWrappedError(tx.Error, models.ContextNotFound, fmt.Sprintf("user with '%s' ID has no password", userID))
+		} else {
+			return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while retrieving password of user with '%s' ID", userID))
+		}
+	}
+	return dto.ToModel(), nil
+}
+
+// SavePasswordCredential creates or replaces the password of the user
+func (ps *PostgresStorage) SavePasswordCredential(credential *models.PasswordCredential) error {
+	dto := &PasswordCredentialEntity{}
+	dto.FromModel(credential)
+
+	tx := ps.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"hash", "updated_at"}),
+	}).Create(dto)
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while storing password of user with '%s' ID", credential.UserID))
+	}
+	return nil
+}
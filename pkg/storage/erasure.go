@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ErasureCertificateEntity struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex"`
+	ErasedBy  string    `gorm:"not null"`
+	RequestID string    `gorm:"type:varchar(128)"`
+	Erased    []string  `gorm:"serializer:json;type:jsonb;not null"`
+	ErasedAt  time.Time `gorm:"not null"`
+	Hash      string    `gorm:"type:varchar(64);not null"`
+}
+
+func (ErasureCertificateEntity) TableName() string {
+	return "erasure_certificates"
+}
+
+func (dto *ErasureCertificateEntity) ToModel() *models.ErasureCertificate {
+	return &models.ErasureCertificate{
+		ID:        dto.ID,
+		UserID:    dto.UserID,
+		ErasedBy:  dto.ErasedBy,
+		RequestID: dto.RequestID,
+		Erased:    dto.Erased,
+		ErasedAt:  dto.ErasedAt,
+		Hash:      dto.Hash,
+	}
+}
+
+func (dto *ErasureCertificateEntity) FromModel(certificate *models.ErasureCertificate) {
+	dto.ID = certificate.ID
+	dto.UserID = certificate.UserID
+	dto.ErasedBy = certificate.ErasedBy
+	dto.RequestID = certificate.RequestID
+	dto.Erased = certificate.Erased
+	dto.ErasedAt = certificate.ErasedAt
+	dto.Hash = certificate.Hash
+}
+
+// data of the user that is deleted outright on erasure
+var erasedEntities = []any{
+	&AuditSaltEntity{},
+	&UserTokenEntity{},
+	&PasswordCredentialEntity{},
+	&SessionEntity{},
+	&TOTPCredentialEntity{},
+	&RecoveryCodeEntity{},
+	&PasskeyEntity{},
+	&FederatedIdentityEntity{},
+	&PersonalDataExportEntity{},
+}
+
+// ErasePersonalData saves the anonymized user, clears the reasons in its
+// history and deletes everything else identifying the person, including the
+// salt of its audit log. The history entry, certificate and event are
+// recorded with it, along with the audit entry, all or nothing. Only
+// deactivated and deleted users are erased
+func (ps *PostgresStorage) ErasePersonalData(user *models.User, entry *models.UserHistoryEntry, certificate *models.ErasureCertificate, event *models.Event, audit *models.AuditEntry) error {
+	err := ps.auditedTransaction(audit, func(tx *gorm.DB) error {
+		dto := &UserEntity{}
+		dto.FromModel(user)
+		res := tx.Model(dto).
+			Where("status IN ?", []string{string(models.UserStatusDeactivated), string(models.UserStatusDeleted)}).
+			Select("name", "email", "date_of_birth", "status", "email_verified_at", "pending_email", "pending_email_expires_at", "phone_number", "phone_verified_at", "region").
+			Updates(dto)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return models.NewInternalError(models.ContextConflictValue, fmt.Sprintf("user with '%s' ID is no longer deactivated or deleted", user.ID))
+		}
+
+		if err := tx.Model(&UserHistoryEntity{}).Where("user_id = ?", user.ID).Update("reason", "").Error; err != nil {
+			return err
+		}
+		history := &UserHistoryEntity{}
+		history.FromModel(entry)
+		if err := tx.Create(history).Error; err != nil {
+			return err
+		}
+
+		sessions := tx.Model(&SessionEntity{}).Select("id").Where("user_id = ?", user.ID)
+		if err := tx.Where("session_id IN (?)", sessions).Delete(&RefreshTokenEntity{}).Error; err != nil {
+			return err
+		}
+		for _, entity := range erasedEntities {
+			if err := tx.Where("user_id = ?", user.ID).Delete(entity).Error; err != nil {
+				return err
+			}
+		}
+
+		erasure := &ErasureCertificateEntity{}
+		erasure.FromModel(certificate)
+		if err := tx.Create(erasure).Error; err != nil {
+			return err
+		}
+		published := &EventEntity{}
+		published.FromModel(event)
+		return tx.Create(published).Error
+	})
+	if err == nil || models.ErrorContext(err) == models.ContextConflictValue {
+		return err
+	}
+	if strings.Contains(err.Error(), "duplicate key value violates unique constraint") || strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return models.NewWrappedError(err, models.ContextConflictValue, fmt.Sprintf("user with '%s' ID is already erased", user.ID))
+	}
+	return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while erasing user with '%s' ID", user.ID))
+}
+
+func (ps *PostgresStorage) RetrieveErasureCertificate(userID uuid.UUID) (*models.ErasureCertificate, error) {
+	dto := &ErasureCertificateEntity{}
+	tx := ps.db.First(dto, "user_id = ?", userID)
+	if tx.Error != nil {
+		if strings.Contains(tx.Error.Error(), "record not found") {
+			return nil, models.NewWrappedError(tx.Error, models.ContextNotFound, fmt.Sprintf("user with '%s' ID has not been erased", userID))
+		}
+		return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while searching erasure of user with '%s' ID", userID))
+	}
+	return dto.ToModel(), nil
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// EventEntity is the outbox of events, rows are written with the change
+// they tell about and marked once published
+type EventEntity struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	Type        string     `gorm:"type:varchar(64);not null"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null;index"`
+	Data        string     `gorm:"type:jsonb;not null"`
+	CreatedAt   time.Time  `gorm:"not null;index"`
+	PublishedAt *time.Time `gorm:"index"`
+}
+
+func (EventEntity) TableName() string {
+	return "events"
+}
+
+func (dto *EventEntity) ToModel() *models.Event {
+	return &models.Event{
+		ID:          dto.ID,
+		Type:        dto.Type,
+		UserID:      dto.UserID,
+		Data:        json.RawMessage(dto.Data),
+		CreatedAt:   dto.CreatedAt,
+		PublishedAt: copyTime(dto.PublishedAt),
+	}
+}
+
+func (dto *EventEntity) FromModel(event *models.Event) {
+	dto.ID = event.ID
+	dto.Type = event.Type
+	dto.UserID = event.UserID
+	dto.Data = string(event.Data)
+	dto.CreatedAt = event.CreatedAt
+	dto.PublishedAt = copyTime(event.PublishedAt)
+}
+
+// RetrieveUnpublishedEvents returns the oldest events not published yet
+func (ps *PostgresStorage) RetrieveUnpublishedEvents(limit int) ([]models.Event, error) {
+	var dtos []EventEntity
+	if err := ps.db.Where("published_at IS NULL").Order("created_at, id").Limit(limit).Find(&dtos).Error; err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, "unexpected error while retrieving unpublished events")
+	}
+
+	events := make([]models.Event, 0, len(dtos))
+	for _, dto := range dtos {
+		events = append(events, *dto.ToModel())
+	}
+	return events, nil
+}
+
+func (ps *PostgresStorage) MarkEventPublished(id uuid.UUID, at time.Time) error {
+	if err := ps.db.Model(&EventEntity{}).Where("id = ?", id).Update("published_at", at).Error; err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while marking event with '%s' ID published", id))
+	}
+	return nil
+}
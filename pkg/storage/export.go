@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+type PersonalDataExportEntity struct {
+	ID                     uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID                 uuid.UUID `gorm:"type:uuid;not null;index"`
+	Status                 string    `gorm:"type:varchar(20);not null"`
+	RequestedBy            string    `gorm:"not null"`
+	RequestID              string    `gorm:"type:varchar(128)"`
+	EncryptedArchive       string    `gorm:"type:text"`
+	EncryptedDownloadToken string    `gorm:"type:text"`
+	DownloadExpiresAt      *time.Time
+	Error                  string
+	CreatedAt              time.Time `gorm:"not null"`
+	CompletedAt            *time.Time
+	ExpiresAt              time.Time `gorm:"not null;index"`
+}
+
+func (PersonalDataExportEntity) TableName() string {
+	return "personal_data_exports"
+}
+
+func (dto *PersonalDataExportEntity) ToModel() *models.PersonalDataExport {
+	return &models.PersonalDataExport{
+		ID:                     dto.ID,
+		UserID:                 dto.UserID,
+		Status:                 models.ExportStatus(dto.Status),
+		RequestedBy:            dto.RequestedBy,
+		RequestID:              dto.RequestID,
+		EncryptedArchive:       dto.EncryptedArchive,
+		EncryptedDownloadToken: dto.EncryptedDownloadToken,
+		DownloadExpiresAt:      copyTime(dto.DownloadExpiresAt),
+		Error:                  dto.Error,
+		CreatedAt:              dto.CreatedAt,
+		CompletedAt:            copyTime(dto.CompletedAt),
+		ExpiresAt:              dto.ExpiresAt,
+	}
+}
+
+func (dto *PersonalDataExportEntity) FromModel(export *models.PersonalDataExport) {
+	dto.ID = export.ID
+	dto.UserID = export.UserID
+	dto.Status = string(export.Status)
+	dto.RequestedBy = export.RequestedBy
+	dto.RequestID = export.RequestID
+	dto.EncryptedArchive = export.EncryptedArchive
+	dto.EncryptedDownloadToken = export.EncryptedDownloadToken
+	dto.DownloadExpiresAt = copyTime(export.DownloadExpiresAt)
+	dto.Error = export.Error
+	dto.CreatedAt = export.CreatedAt
+	dto.CompletedAt = copyTime(export.CompletedAt)
+	dto.ExpiresAt = export.ExpiresAt
+}
+
+func (ps *PostgresStorage) CreatePersonalDataExport(export *models.PersonalDataExport) error {
+	dto := &PersonalDataExportEntity{}
+	dto.FromModel(export)
+	if err := ps.db.Create(dto).Error; err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while creating export of user with '%s' ID", export.UserID))
+	}
+	return nil
+}
+
+// RetrievePersonalDataExport finds exports that have not expired yet
+func (ps *PostgresStorage) RetrievePersonalDataExport(id uuid.UUID) (*models.PersonalDataExport, error) {
+	dto := &PersonalDataExportEntity{}
+	tx := ps.db.First(dto, "id = ? AND expires_at > ?", id, time.Now())
+	if tx.Error != nil {
+		if strings.Contains(tx.Error.Error(), "record not found") {
+			return nil, models.NewWrappedError(tx.Error, models.ContextNotFound, fmt.Sprintf("export with '%s' ID does not exist", id))
+		}
+		return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while searching export with '%s' ID", id))
+	}
+	return dto.ToModel(), nil
+}
+
+func (ps *PostgresStorage) UpdatePersonalDataExport(export *models.PersonalDataExport) error {
+	dto := &PersonalDataExportEntity{}
+	dto.FromModel(export)
+	if err := ps.db.Save(dto).Error; err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while updating export with '%s' ID", export.ID))
+	}
+	return nil
+}
+
+// FailStalePersonalDataExports fails the exports still pending or running
+// that were started before the given time, their jobs are gone
+func (ps *PostgresStorage) FailStalePersonalDataExports(before time.Time, reason string) error {
+	tx := ps.db.Model(&PersonalDataExportEntity{}).
+		Where("status IN ? AND created_at < ?", []string{string(models.ExportStatusPending), string(models.ExportStatusRunning)}, before).
+		Updates(map[string]any{"status": string(models.ExportStatusFailed), "error": reason, "completed_at": time.Now()})
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, "unexpected error while failing stale exports")
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) DeleteExpiredPersonalDataExports(now time.Time) error {
+	if err := ps.db.Delete(&PersonalDataExportEntity{}, "expires_at <= ?", now).Error; err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, "unexpected error while deleting expired exports")
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"users-microservice/pkg/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type FailureCounterEntity struct {
+	Key           string    `gorm:"primaryKey"`
+	Failures      int       `gorm:"not null"`
+	LastFailureAt time.Time `gorm:"not null"`
+	LockedUntil   *time.Time
+}
+
+func (FailureCounterEntity) TableName() string {
+	return "failure_counters"
+}
+
+func (dto *FailureCounterEntity) ToModel() *models.FailureCounter {
+	return &models.FailureCounter{
+		Key:           dto.Key,
+		Failures:      dto.Failures,
+		LastFailureAt: dto.LastFailureAt,
+		LockedUntil:   copyTime(dto.LockedUntil),
+	}
+}
+
+func (ps *PostgresStorage) RetrieveFailureCounter(key string) (*models.FailureCounter, error) {
+	dto := &FailureCounterEntity{}
+	tx := ps.db.First(dto, "key = ?", key)
+	if tx.Error != nil {
+		errMsg := tx.Error.Error()
+		if strings.Contains(errMsg, "record not found") {
+			return nil, models.NewWrappedError(tx.Error, models.ContextNotFound, fmt.Sprintf("no failures recorded for '%s'", key))
+		} else {
+			return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while retrieving failures of '%s'", key))
+		}
+	}
+	return dto.ToModel(), nil
+}
+
+// RecordFailure increments the counter in a single statement so replicas
+// never lose a failure, it starts over when the last failure happened before
+// resetBefore
+func (ps *PostgresStorage) RecordFailure(key string, now time.Time, resetBefore time.Time) (*models.FailureCounter, error) {
+	dto := &FailureCounterEntity{Key: key, Failures: 1, LastFailureAt: now}
+	tx := ps.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"failures":        gorm.Expr("CASE WHEN failure_counters.last_failure_at < ? THEN 1 ELSE failure_counters.failures + 1 END", resetBefore),
+			"last_failure_at": now,
+		}),
+	}, clause.Returning{}).Create(dto)
+	if tx.Error != nil {
+		return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while recording failure of '%s'", key))
+	}
+	return dto.ToModel(), nil
+}
+
+func (ps *PostgresStorage) LockFailureCounter(key string, until time.Time) error {
+	tx := ps.db.Model(&FailureCounterEntity{}).Where("key = ?", key).Update("locked_until", until)
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while locking '%s'", key))
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) DeleteFailureCounter(key string) error {
+	tx := ps.db.Delete(&FailureCounterEntity{}, "key = ?", key)
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while clearing failures of '%s'", key))
+	}
+	return nil
+}
+
+// counters forgotten in memory are swept once there are this many
+const memoryFailureCounterSweepSize = 10000
+
+// MemoryFailureCounterStorage keeps the counters in the process, each replica
+// counts on its own
+type MemoryFailureCounterStorage struct {
+	mu       sync.Mutex
+	counters map[string]*models.FailureCounter
+}
+
+func NewMemoryFailureCounterStorage() *MemoryFailureCounterStorage {
+	return &MemoryFailureCounterStorage{counters: make(map[string]*models.FailureCounter)}
+}
+
+func (ms *MemoryFailureCounterStorage) RetrieveFailureCounter(key string) (*models.FailureCounter, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	counter, ok := ms.counters[key]
+	if !ok {
+		return nil, models.NewInternalError(models.ContextNotFound, fmt.Sprintf("no failures recorded for '%s'", key))
+	}
+	copied := *counter
+	copied.LockedUntil = copyTime(counter.LockedUntil)
+	return &copied, nil
+}
+
+func (ms *MemoryFailureCounterStorage) RecordFailure(key string, now time.Time, resetBefore time.Time) (*models.FailureCounter, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	counter, ok := ms.counters[key]
+	if !ok {
+		if len(ms.counters) >= memoryFailureCounterSweepSize {
+			ms.sweep(now, resetBefore)
+		}
+		counter = &models.FailureCounter{Key: key}
+		ms.counters[key] = counter
+	}
+	if counter.LastFailureAt.Before(resetBefore) {
+		counter.Failures = 0
+	}
+	counter.Failures++
+	counter.LastFailureAt = now
+
+	copied := *counter
+	copied.LockedUntil = copyTime(counter.LockedUntil)
+	return &copied, nil
+}
+
+func (ms *MemoryFailureCounterStorage) LockFailureCounter(key string, until time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if counter, ok := ms.counters[key]; ok {
+		counter.LockedUntil = &until
+	}
+	return nil
+}
+
+func (ms *MemoryFailureCounterStorage) DeleteFailureCounter(key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.counters, key)
+	return nil
+}
+
+// sweep drops counters that would start over anyway and hold no lock
+func (ms *MemoryFailureCounterStorage) sweep(now time.Time, resetBefore time.Time) {
+	for key, counter := range ms.counters {
+		if counter.LastFailureAt.Before(resetBefore) && !counter.IsLocked(now) {
+			delete(ms.counters, key)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+)
+
+type FederatedIdentityEntity struct {
+	ID         uuid.UUID `gorm:"primaryKey"`
+	UserID     uuid.UUID `gorm:"not null;uniqueIndex:idx_federated_identities_user_provider"`
+	Provider   string    `gorm:"not null;uniqueIndex:idx_federated_identities_user_provider"`
+	Issuer     string    `gorm:"not null;uniqueIndex:idx_federated_identities_subject"`
+	Subject    string    `gorm:"not null;uniqueIndex:idx_federated_identities_subject"`
+	Email      string
+	CreatedAt  time.Time `gorm:"not null"`
+	LastUsedAt *time.Time
+}
+
+func (FederatedIdentityEntity) TableName() string {
+	return "federated_identities"
+}
+
+func (dto *FederatedIdentityEntity) ToModel() *models.FederatedIdentity {
+	return &models.FederatedIdentity{
+		ID:         dto.ID,
+		UserID:     dto.UserID,
+		Provider:   dto.Provider,
+		Issuer:     dto.Issuer,
+		Subject:    dto.Subject,
+		Email:      dto.Email,
+		CreatedAt:  dto.CreatedAt,
+		LastUsedAt: copyTime(dto.LastUsedAt),
+	}
+}
+
+func (dto *FederatedIdentityEntity) FromModel(identity *models.FederatedIdentity) {
+	dto.ID = identity.ID
+	dto.UserID = identity.UserID
+	dto.Provider = identity.Provider
+	dto.Issuer = identity.Issuer
+	dto.Subject = identity.Subject
+	dto.Email = identity.Email
+	dto.CreatedAt = identity.CreatedAt
+	dto.LastUsedAt = copyTime(identity.LastUsedAt)
+}
+
+func (ps *PostgresStorage) CreateFederatedIdentity(identity *models.FederatedIdentity) error {
+	dto := &FederatedIdentityEntity{}
+	dto.FromModel(identity)
+
+	tx := ps.db.Create(dto)
+	if tx.Error != nil {
+		errMsg := tx.Error.Error()
+		if strings.Contains(errMsg, "duplicate key value violates unique constraint") {
+			if strings.Contains(errMsg, "idx_federated_identities_user_provider") {
+				return models.NewWrappedError(tx.Error, models.ContextConflictValue, fmt.Sprintf("user already has a linked %s account", identity.Provider))
+			}
+			return models.NewWrappedError(tx.Error, models.ContextConflictValue, fmt.Sprintf("%s account is already linked", identity.Provider))
+		}
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, "unexpected error while linking identity")
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) RetrieveFederatedIdentity(issuer string, subject string) (*models.FederatedIdentity, error) {
+	dto := &FederatedIdentityEntity{}
+	tx := ps.db.First(dto, "issuer = ? AND subject = ?", issuer, subject)
+	if tx.Error != nil {
+		errMsg := tx.Error.Error()
+		if strings.Contains(errMsg, "record not found") {
+			return nil, models.NewWrappedError(tx.Error, models.ContextNotFound, "identity is not linked")
+		} else {
+			return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, "unexpected error while retrieving identity")
+		}
+	}
+	return dto.ToModel(), nil
+}
+
+func (ps *PostgresStorage) RetrieveFederatedIdentities(userID uuid.UUID) ([]models.FederatedIdentity, error) {
+	var dtos []FederatedIdentityEntity
+	tx := ps.db.Where("user_id = ?", userID).Order("created_at").Find(&dtos)
+	if tx.Error != nil {
+		return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while retrieving identities of user with '%s' ID", userID))
+	}
+
+	identities := make([]models.FederatedIdentity, 0, len(dtos))
+	for _, dto := range dtos {
+		identities = append(identities, *dto.ToModel())
+	}
+	return identities, nil
+}
+
+// UseFederatedIdentity records a sign-in and the email the provider reported
+// with it
+func (ps *PostgresStorage) UseFederatedIdentity(id uuid.UUID, email string) error {
+	tx := ps.db.Model(&FederatedIdentityEntity{}).Where("id = ?", id).
+		Updates(map[string]any{"email": email, "last_used_at": time.Now()})
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while using identity with '%s' ID", id))
+	}
+	return nil
+}
+
+// DeleteFederatedIdentity unlinks the identity and returns what was removed
+func (ps *PostgresStorage) DeleteFederatedIdentity(userID uuid.UUID, id uuid.UUID) (*models.FederatedIdentity, error) {
+	var dtos []FederatedIdentityEntity
+	tx := ps.db.Clauses(clause.Returning{}).Where("id = ? AND user_id = ?", id, userID).Delete(&dtos)
+	if tx.Error != nil {
+		return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while unlinking identity with '%s' ID", id))
+	}
+	if tx.RowsAffected == 0 || len(dtos) == 0 {
+		return nil, models.NewInternalError(models.ContextNotFound, fmt.Sprintf("identity with '%s' ID does not exist", id))
+	}
+	return dtos[0].ToModel(), nil
+}
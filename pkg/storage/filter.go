@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+var userFilterColumns = map[models.UserAttribute]string{
+	models.UserAttributeID:          "id",
+	models.UserAttributeName:        "name",
+	models.UserAttributeEmail:       "email",
+	models.UserAttributePhoneNumber: "phone_number",
+	models.UserAttributeDateOfBirth: "date_of_birth",
+	models.UserAttributeStatus:      "status",
+	models.UserAttributeCreatedAt:   "created_at",
+}
+
+// empty text counts as absent
+var textAttributes = map[models.UserAttribute]bool{
+	models.UserAttributeName:        true,
+	models.UserAttributeEmail:       true,
+	models.UserAttributePhoneNumber: true,
+	models.UserAttributeStatus:      true,
+}
+
+var comparisonOperators = map[models.FilterOperator]string{
+	models.FilterEqual:          "=",
+	models.FilterNotEqual:       "<>",
+	models.FilterGreater:        ">",
+	models.FilterGreaterOrEqual: ">=",
+	models.FilterLess:           "<",
+	models.FilterLessOrEqual:    "<=",
+}
+
+// likeEscaper keeps wildcards in the value from matching anything
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// userFilterCondition translates the filter into a WHERE condition, column
+// names only ever come from userFilterColumns and values are bound
+func userFilterCondition(filter *models.UserFilter) (string, []any, error) {
+	switch filter.Operator {
+	case models.FilterAnd, models.FilterOr:
+		if len(filter.Operands) == 0 {
+			return "", nil, fmt.Errorf("%s filter has no operands", filter.Operator)
+		}
+		conditions := make([]string, 0, len(filter.Operands))
+		var args []any
+		for _, operand := range filter.Operands {
+			condition, operandArgs, err := userFilterCondition(&operand)
+			if err != nil {
+				return "", nil, err
+			}
+			conditions = append(conditions, "("+condition+")")
+			args = append(args, operandArgs...)
+		}
+		return strings.Join(conditions, " "+strings.ToUpper(string(filter.Operator))+" "), args, nil
+	case models.FilterNot:
+		if len(filter.Operands) != 1 {
+			return "", nil, fmt.Errorf("not filter needs exactly one operand")
+		}
+		condition, args, err := userFilterCondition(&filter.Operands[0])
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT (" + condition + ")", args, nil
+	}
+
+	column, ok := userFilterColumns[filter.Attribute]
+	if !ok {
+		return "", nil, fmt.Errorf("users cannot be filtered by %q", filter.Attribute)
+	}
+
+	if filter.Operator == models.FilterPresent {
+		if textAttributes[filter.Attribute] {
+			return fmt.Sprintf("%s IS NOT NULL AND %s <> ''", column, column), nil, nil
+		}
+		return column + " IS NOT NULL", nil, nil
+	}
+
+	switch value := filter.Value.(type) {
+	case string:
+		value = strings.ToLower(value)
+		switch filter.Operator {
+		case models.FilterContains:
+			return fmt.Sprintf(`LOWER(%s) LIKE ? ESCAPE '\'`, column), []any{"%" + likeEscaper.Replace(value) + "%"}, nil
+		case models.FilterStartsWith:
+			return fmt.Sprintf(`LOWER(%s) LIKE ? ESCAPE '\'`, column), []any{likeEscaper.Replace(value) + "%"}, nil
+		case models.FilterEndsWith:
+			return fmt.Sprintf(`LOWER(%s) LIKE ? ESCAPE '\'`, column), []any{"%" + likeEscaper.Replace(value)}, nil
+		}
+		if operator, ok := comparisonOperators[filter.Operator]; ok {
+			return fmt.Sprintf("LOWER(%s) %s ?", column, operator), []any{value}, nil
+		}
+	case time.Time, uuid.UUID:
+		if operator, ok := comparisonOperators[filter.Operator]; ok {
+			return fmt.Sprintf("%s %s ?", column, operator), []any{value}, nil
+		}
+	}
+	return "", nil, fmt.Errorf("users cannot be filtered with %s %s %v", filter.Attribute, filter.Operator, filter.Value)
+}
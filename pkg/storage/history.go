@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type UserHistoryEntity struct {
+	ID         uuid.UUID `gorm:"primaryKey"`
+	UserID     uuid.UUID `gorm:"index;not null"`
+	Event      string    `gorm:"type:varchar(50);not null"`
+	FromStatus string    `gorm:"type:varchar(20)"`
+	ToStatus   string    `gorm:"type:varchar(20)"`
+	Reason     string
+	CreatedAt  time.Time `gorm:"not null"`
+}
+
+func (UserHistoryEntity) TableName() string {
+	return "user_history"
+}
+
+func (dto *UserHistoryEntity) ToModel() *models.UserHistoryEntry {
+	return &models.UserHistoryEntry{
+		ID:         dto.ID,
+		UserID:     dto.UserID,
+		Event:      dto.Event,
+		FromStatus: models.UserStatus(dto.FromStatus),
+		ToStatus:   models.UserStatus(dto.ToStatus),
+		Reason:     dto.Reason,
+		CreatedAt:  dto.CreatedAt,
+	}
+}
+
+func (dto *UserHistoryEntity) FromModel(entry *models.UserHistoryEntry) {
+	dto.ID = entry.ID
+	dto.UserID = entry.UserID
+	dto.Event = entry.Event
+	dto.FromStatus = string(entry.FromStatus)
+	dto.ToStatus = string(entry.ToStatus)
+	dto.Reason = entry.Reason
+	dto.CreatedAt = entry.CreatedAt
+}
+
+func (ps *PostgresStorage) AppendUserHistory(entry *models.UserHistoryEntry) error {
+	dto := &UserHistoryEntity{}
+	dto.FromModel(entry)
+
+	if err := ps.db.Create(dto).Error; err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while recording history of user with '%s' ID", entry.UserID))
+	}
+	return nil
+}
+
+// appendUserHistory records the entry in the transaction of the change it
+// describes
+func appendUserHistory(tx *gorm.DB, entry *models.UserHistoryEntry) error {
+	dto := &UserHistoryEntity{}
+	dto.FromModel(entry)
+	if err := tx.Create(dto).Error; err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while recording history of user with '%s' ID", entry.UserID))
+	}
+	return nil
+}
+
+// AppendUserHistoryAndEvent records the entry with the event telling
+// downstream systems about it, all or nothing
+func (ps *PostgresStorage) AppendUserHistoryAndEvent(entry *models.UserHistoryEntry, event *models.Event) error {
+	err := ps.db.Transaction(func(tx *gorm.DB) error {
+		dto := &UserHistoryEntity{}
+		dto.FromModel(entry)
+		if err := tx.Create(dto).Error; err != nil {
+			return err
+		}
+		published := &EventEntity{}
+		published.FromModel(event)
+		return tx.Create(published).Error
+	})
+	if err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while recording history of user with '%s' ID", entry.UserID))
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) RetrieveUserHistory(userID uuid.UUID) ([]models.UserHistoryEntry, error) {
+	var dtos []UserHistoryEntity
+	if err := ps.db.Where("user_id = ?", userID).Order("created_at").Find(&dtos).Error; err != nil {
+		return nil, models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while retrieving history of user with '%s' ID", userID))
+	}
+
+	history := make([]models.UserHistoryEntry, 0, len(dtos))
+	for _, dto := range dtos {
+		history = append(history, *dto.ToModel())
+	}
+	return history, nil
+}
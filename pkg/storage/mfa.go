@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TOTPCredentialEntity struct {
+	UserID          uuid.UUID `gorm:"primaryKey"`
+	EncryptedSecret string    `gorm:"not null"`
+	ConfirmedAt     *time.Time
+	LastUsedStep    int64     `gorm:"not null;default:0"`
+	CreatedAt       time.Time `gorm:"not null"`
+}
+
+func (TOTPCredentialEntity) TableName() string {
+	return "user_totp"
+}
+
+func (dto *TOTPCredentialEntity) ToModel() *models.TOTPCredential {
+	return &models.TOTPCredential{
+		UserID:          dto.UserID,
+		EncryptedSecret: dto.EncryptedSecret,
+		ConfirmedAt:     copyTime(dto.ConfirmedAt),
+		LastUsedStep:    dto.LastUsedStep,
+		CreatedAt:       dto.CreatedAt,
+	}
+}
+
+func (dto *TOTPCredentialEntity) FromModel(credential *models.TOTPCredential) {
+	dto.UserID = credential.UserID
+	dto.EncryptedSecret = credential.EncryptedSecret
+	dto.ConfirmedAt = copyTime(credential.ConfirmedAt)
+	dto.LastUsedStep = credential.LastUsedStep
+	dto.CreatedAt = credential.CreatedAt
+}
+
+type RecoveryCodeEntity struct {
+	ID        uuid.UUID `gorm:"primaryKey"`
+	UserID    uuid.UUID `gorm:"index;not null"`
+	CodeHash  string    `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+func (RecoveryCodeEntity) TableName() string {
+	return "mfa_recovery_codes"
+}
+
+func (dto *RecoveryCodeEntity) FromModel(code *models.RecoveryCode) {
+	dto.ID = code.ID
+	dto.UserID = code.UserID
+	dto.CodeHash = code.CodeHash
+	dto.UsedAt = copyTime(code.UsedAt)
+	dto.CreatedAt = code.CreatedAt
+}
+
+func (ps *PostgresStorage) RetrieveTOTPCredential(userID uuid.UUID) (*models.TOTPCredential, error) {
+	dto := &TOTPCredentialEntity{}
+	tx := ps.db.First(dto, "user_id = ?", userID)
+	if tx.Error != nil {
+		errMsg := tx.Error.Error()
+		if strings.Contains(errMsg, "record not found") {
+			return nil, models.NewWrappedError(tx.Error, models.ContextNotFound, fmt.Sprintf("user with '%s' ID has no authenticator enrolled", userID))
+		} else {
+			return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while retrieving authenticator of user with '%s' ID", userID))
+		}
+	}
+	return dto.ToModel(), nil
+}
+
+// SaveUnconfirmedTOTPCredential starts a new enrollment, replacing one that
+// was never confirmed but never a confirmed one
+func (ps *PostgresStorage) SaveUnconfirmedTOTPCredential(credential *models.TOTPCredential) error {
+	dto := &TOTPCredentialEntity{}
+	dto.FromModel(credential)
+
+	tx := ps.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"encrypted_secret", "last_used_step", "created_at"}),
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "user_totp.confirmed_at IS NULL"}}},
+	}).Create(dto)
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while enrolling authenticator of user with '%s' ID", credential.UserID))
+	}
+	if tx.RowsAffected == 0 {
+		return models.NewInternalError(models.ContextConflictValue, "authenticator is already enrolled")
+	}
+	return nil
+}
+
+// ConfirmTOTPCredential enables the factor and replaces the recovery codes
+func (ps *PostgresStorage) ConfirmTOTPCredential(userID uuid.UUID, step int64, codes []models.RecoveryCode) error {
+	return ps.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&TOTPCredentialEntity{}).
+			Where("user_id = ? AND confirmed_at IS NULL", userID).
+			Updates(map[string]any{"confirmed_at": time.Now(), "last_used_step": step})
+		if res.Error != nil {
+			return models.NewWrappedError(res.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while confirming authenticator of user with '%s' ID", userID))
+		}
+		if res.RowsAffected == 0 {
+			return models.NewInternalError(models.ContextConflictValue, "authenticator is already confirmed")
+		}
+		return replaceRecoveryCodes(tx, userID, codes)
+	})
+}
+
+// UseTOTPStep records the step of an accepted code, fails when a code of the
+// same or a later step was already accepted
+func (ps *PostgresStorage) UseTOTPStep(userID uuid.UUID, step int64) error {
+	tx := ps.db.Model(&TOTPCredentialEntity{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while using authenticator of user with '%s' ID", userID))
+	}
+	if tx.RowsAffected == 0 {
+		return models.NewInternalError(models.ContextUnauthorized, "code was already used")
+	}
+	return nil
+}
+
+// ConsumeRecoveryCode marks the matching unused code as used
+func (ps *PostgresStorage) ConsumeRecoveryCode(userID uuid.UUID, codeHash string) error {
+	tx := ps.db.Model(&RecoveryCodeEntity{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while using recovery code of user with '%s' ID", userID))
+	}
+	if tx.RowsAffected == 0 {
+		return models.NewInternalError(models.ContextUnauthorized, "recovery code is invalid or was already used")
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) CountUnusedRecoveryCodes(userID uuid.UUID) (int64, error) {
+	var count int64
+	tx := ps.db.Model(&RecoveryCodeEntity{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count)
+	if tx.Error != nil {
+		return 0, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while counting recovery codes of user with '%s' ID", userID))
+	}
+	return count, nil
+}
+
+// DeleteMFA removes the authenticator and all recovery codes of the user
+func (ps *PostgresStorage) DeleteMFA(userID uuid.UUID) error {
+	return ps.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&TOTPCredentialEntity{}).Error; err != nil {
+			return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while resetting MFA of user with '%s' ID", userID))
+		}
+		return replaceRecoveryCodes(tx, userID, nil)
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID, codes []models.RecoveryCode) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCodeEntity{}).Error; err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while replacing recovery codes of user with '%s' ID", userID))
+	}
+	if len(codes) == 0 {
+		return nil
+	}
+
+	dtos := make([]RecoveryCodeEntity, len(codes))
+	for i := range codes {
+		dtos[i].FromModel(&codes[i])
+	}
+	if err := tx.Create(&dtos).Error; err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while replacing recovery codes of user with '%s' ID", userID))
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"users-microservice/pkg/models"
+
+	"gorm.io/gorm/clause"
+)
+
+type NonceEntity struct {
+	Key       string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+func (NonceEntity) TableName() string {
+	return "request_nonces"
+}
+
+// RecordNonce remembers the nonce until it expires and reports whether it was
+// new, a nonce whose previous use has expired counts as new again
+func (ps *PostgresStorage) RecordNonce(key string, now time.Time, expiresAt time.Time) (bool, error) {
+	tx := ps.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]any{"expires_at": expiresAt}),
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Lt{Column: clause.Column{Table: "request_nonces", Name: "expires_at"}, Value: now}}},
+	}).Create(&NonceEntity{Key: key, ExpiresAt: expiresAt})
+	if tx.Error != nil {
+		return false, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while recording nonce '%s'", key))
+	}
+	return tx.RowsAffected > 0, nil
+}
+
+func (ps *PostgresStorage) DeleteExpiredNonces(now time.Time) error {
+	tx := ps.db.Delete(&NonceEntity{}, "expires_at < ?", now)
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, "unexpected error while deleting expired nonces")
+	}
+	return nil
+}
+
+// MemoryNonceStorage keeps the nonces in the process, a request replayed
+// against another replica is not caught
+type MemoryNonceStorage struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func NewMemoryNonceStorage() *MemoryNonceStorage {
+	return &MemoryNonceStorage{nonces: make(map[string]time.Time)}
+}
+
+func (ms *MemoryNonceStorage) RecordNonce(key string, now time.Time, expiresAt time.Time) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if expires, ok := ms.nonces[key]; ok && !expires.Before(now) {
+		return false, nil
+	}
+	ms.nonces[key] = expiresAt
+	return true, nil
+}
+
+func (ms *MemoryNonceStorage) DeleteExpiredNonces(now time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for key, expires := range ms.nonces {
+		if expires.Before(now) {
+			delete(ms.nonces, key)
+		}
+	}
+	return nil
+}
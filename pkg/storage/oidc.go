@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+)
+
+type OIDCClientEntity struct {
+	ID           string `gorm:"primaryKey"`
+	Name         string `gorm:"not null"`
+	SecretHash   string
+	RedirectURIs []string  `gorm:"serializer:json;type:jsonb;not null"`
+	CreatedAt    time.Time `gorm:"not null"`
+}
+
+func (OIDCClientEntity) TableName() string {
+	return "oidc_clients"
+}
+
+func (dto *OIDCClientEntity) ToModel() *models.OIDCClient {
+	return &models.OIDCClient{
+		ID:           dto.ID,
+		Name:         dto.Name,
+		SecretHash:   dto.SecretHash,
+		RedirectURIs: dto.RedirectURIs,
+		CreatedAt:    dto.CreatedAt,
+	}
+}
+
+func (dto *OIDCClientEntity) FromModel(client *models.OIDCClient) {
+	dto.ID = client.ID
+	dto.Name = client.Name
+	dto.SecretHash = client.SecretHash
+	dto.RedirectURIs = client.RedirectURIs
+	dto.CreatedAt = client.CreatedAt
+}
+
+type OIDCSigningKeyEntity struct {
+	ID           string    `gorm:"primaryKey"`
+	Algorithm    string    `gorm:"not null"`
+	EncryptedKey string    `gorm:"not null"`
+	CreatedAt    time.Time `gorm:"not null;index"`
+}
+
+func (OIDCSigningKeyEntity) TableName() string {
+	return "oidc_signing_keys"
+}
+
+func (dto *OIDCSigningKeyEntity) ToModel() *models.OIDCSigningKey {
+	return &models.OIDCSigningKey{
+		ID:           dto.ID,
+		Algorithm:    dto.Algorithm,
+		EncryptedKey: dto.EncryptedKey,
+		CreatedAt:    dto.CreatedAt,
+	}
+}
+
+func (dto *OIDCSigningKeyEntity) FromModel(key *models.OIDCSigningKey) {
+	dto.ID = key.ID
+	dto.Algorithm = key.Algorithm
+	dto.EncryptedKey = key.EncryptedKey
+	dto.CreatedAt = key.CreatedAt
+}
+
+func (ps *PostgresStorage) CreateOIDCClient(client *models.OIDCClient) error {
+	dto := &OIDCClientEntity{}
+	dto.FromModel(client)
+
+	tx := ps.db.Create(dto)
+	if tx.Error != nil {
+		if strings.Contains(tx.Error.Error(), "duplicate key value violates unique constraint") {
+			return models.NewWrappedError(tx.Error, models.ContextConflictValue, fmt.Sprintf("client with '%s' ID already exists", client.ID))
+		}
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, "unexpected error while registering client")
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) RetrieveOIDCClient(id string) (*models.OIDCClient, error) {
+	dto := &OIDCClientEntity{}
+	tx := ps.db.First(dto, "id = ?", id)
+	if tx.Error != nil {
+		errMsg := tx.Error.Error()
+		if strings.Contains(errMsg, "record not found") {
+			return nil, models.NewWrappedError(tx.Error, models.ContextNotFound, fmt.Sprintf("client with '%s' ID does not exist", id))
+		} else {
+			return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while searching client with '%s' ID", id))
+		}
+	}
+	return dto.ToModel(), nil
+}
+
+func (ps *PostgresStorage) DeleteOIDCClient(id string) error {
+	tx := ps.db.Delete(&OIDCClientEntity{}, "id = ?", id)
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while deleting client with '%s' ID", id))
+	}
+	if tx.RowsAffected == 0 {
+		return models.NewInternalError(models.ContextNotFound, fmt.Sprintf("client with '%s' ID does not exist", id))
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) CreateOIDCSigningKey(key *models.OIDCSigningKey) error {
+	dto := &OIDCSigningKeyEntity{}
+	dto.FromModel(key)
+
+	if err := ps.db.Create(dto).Error; err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, "unexpected error while storing signing key")
+	}
+	return nil
+}
+
+// RetrieveOIDCSigningKeys lists keys created after given time, newest first
+func (ps *PostgresStorage) RetrieveOIDCSigningKeys(since time.Time) ([]models.OIDCSigningKey, error) {
+	var dtos []OIDCSigningKeyEntity
+	tx := ps.db.Where("created_at > ?", since).Order("created_at DESC").Find(&dtos)
+	if tx.Error != nil {
+		return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, "unexpected error while retrieving signing keys")
+	}
+
+	keys := make([]models.OIDCSigningKey, 0, len(dtos))
+	for _, dto := range dtos {
+		keys = append(keys, *dto.ToModel())
+	}
+	return keys, nil
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+type PasskeyEntity struct {
+	ID           uuid.UUID `gorm:"primaryKey"`
+	UserID       uuid.UUID `gorm:"not null;uniqueIndex:idx_passkeys_user_name"`
+	Name         string    `gorm:"not null;uniqueIndex:idx_passkeys_user_name"`
+	CredentialID []byte    `gorm:"not null;uniqueIndex"`
+	PublicKey    []byte    `gorm:"not null"`
+	SignCount    int64     `gorm:"not null;default:0"`
+	AAGUID       []byte
+	CreatedAt    time.Time `gorm:"not null"`
+	LastUsedAt   *time.Time
+}
+
+func (PasskeyEntity) TableName() string {
+	return "passkeys"
+}
+
+func (dto *PasskeyEntity) ToModel() *models.Passkey {
+	return &models.Passkey{
+		ID:           dto.ID,
+		UserID:       dto.UserID,
+		Name:         dto.Name,
+		CredentialID: dto.CredentialID,
+		PublicKey:    dto.PublicKey,
+		SignCount:    uint32(dto.SignCount),
+		AAGUID:       dto.AAGUID,
+		CreatedAt:    dto.CreatedAt,
+		LastUsedAt:   copyTime(dto.LastUsedAt),
+	}
+}
+
+func (dto *PasskeyEntity) FromModel(passkey *models.Passkey) {
+	dto.ID = passkey.ID
+	dto.UserID = passkey.UserID
+	dto.Name = passkey.Name
+	dto.CredentialID = passkey.CredentialID
+	dto.PublicKey = passkey.PublicKey
+	dto.SignCount = int64(passkey.SignCount)
+	dto.AAGUID = passkey.AAGUID
+	dto.CreatedAt = passkey.CreatedAt
+	dto.LastUsedAt = copyTime(passkey.LastUsedAt)
+}
+
+func (ps *PostgresStorage) CreatePasskey(passkey *models.Passkey) error {
+	dto := &PasskeyEntity{}
+	dto.FromModel(passkey)
+
+	tx := ps.db.Create(dto)
+	if tx.Error != nil {
+		return translatePasskeyWriteError(tx.Error, passkey.Name, "unexpected error while registering passkey")
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) RetrievePasskeys(userID uuid.UUID) ([]models.Passkey, error) {
+	var dtos []PasskeyEntity
+	tx := ps.db.Where("user_id = ?", userID).Order("created_at").Find(&dtos)
+	if tx.Error != nil {
+		return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while retrieving passkeys of user with '%s' ID", userID))
+	}
+
+	passkeys := make([]models.Passkey, 0, len(dtos))
+	for _, dto := range dtos {
+		passkeys = append(passkeys, *dto.ToModel())
+	}
+	return passkeys, nil
+}
+
+func (ps *PostgresStorage) RetrievePasskeyByCredentialID(credentialID []byte) (*models.Passkey, error) {
+	dto := &PasskeyEntity{}
+	tx := ps.db.First(dto, "credential_id = ?", credentialID)
+	if tx.Error != nil {
+		errMsg := tx.Error.Error()
+		if strings.Contains(errMsg, "record not found") {
+			return nil, models.NewWrappedError(tx.Error, models.ContextNotFound, "passkey is not registered")
+		} else {
+			return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, "unexpected error while retrieving passkey")
+		}
+	}
+	return dto.ToModel(), nil
+}
+
+func (ps *PostgresStorage) RenamePasskey(userID uuid.UUID, id uuid.UUID, name string) error {
+	tx := ps.db.Model(&PasskeyEntity{}).Where("id = ? AND user_id = ?", id, userID).Update("name", name)
+	if tx.Error != nil {
+		return translatePasskeyWriteError(tx.Error, name, fmt.Sprintf("unexpected error while renaming passkey with '%s' ID", id))
+	}
+	if tx.RowsAffected == 0 {
+		return models.NewInternalError(models.ContextNotFound, fmt.Sprintf("passkey with '%s' ID does not exist", id))
+	}
+	return nil
+}
+
+// UsePasskey records a sign-in, fails when another sign-in already moved the
+// signature counter past the given one
+func (ps *PostgresStorage) UsePasskey(id uuid.UUID, signCount uint32) error {
+	tx := ps.db.Model(&PasskeyEntity{}).
+		Where("id = ? AND (sign_count < ? OR ? = 0)", id, signCount, signCount).
+		Updates(map[string]any{"sign_count": signCount, "last_used_at": time.Now()})
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while using passkey with '%s' ID", id))
+	}
+	if tx.RowsAffected == 0 {
+		return models.NewInternalError(models.ContextUnauthorized, "passkey signature counter did not increase")
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) DeletePasskey(userID uuid.UUID, id uuid.UUID) error {
+	tx := ps.db.Delete(&PasskeyEntity{}, "id = ? AND user_id = ?", id, userID)
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while deleting passkey with '%s' ID", id))
+	}
+	if tx.RowsAffected == 0 {
+		return models.NewInternalError(models.ContextNotFound, fmt.Sprintf("passkey with '%s' ID does not exist", id))
+	}
+	return nil
+}
+
+func translatePasskeyWriteError(err error, name string, message string) error {
+	errMsg := err.Error()
+	if strings.Contains(errMsg, "duplicate key value violates unique constraint") {
+		if strings.Contains(errMsg, "idx_passkeys_user_name") {
+			return models.NewWrappedError(err, models.ContextConflictValue, fmt.Sprintf("passkey named '%s' already exists", name))
+		}
+		return models.NewWrappedError(err, models.ContextConflictValue, "passkey is already registered")
+	}
+	return models.NewWrappedError(err, models.ContextInternalServer, message)
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SessionEntity struct {
+	ID            uuid.UUID `gorm:"primaryKey"`
+	UserID        uuid.UUID `gorm:"index;not null"`
+	UserAgent     string
+	IPAddress     string
+	CreatedAt     time.Time `gorm:"not null"`
+	LastUsedAt    time.Time `gorm:"not null"`
+	ExpiresAt     time.Time `gorm:"not null"`
+	RevokedAt     *time.Time
+	RevokedReason string
+}
+
+func (SessionEntity) TableName() string {
+	return "sessions"
+}
+
+func (dto *SessionEntity) ToModel() *models.Session {
+	return &models.Session{
+		ID:            dto.ID,
+		UserID:        dto.UserID,
+		UserAgent:     dto.UserAgent,
+		IPAddress:     dto.IPAddress,
+		CreatedAt:     dto.CreatedAt,
+		LastUsedAt:    dto.LastUsedAt,
+		ExpiresAt:     dto.ExpiresAt,
+		RevokedAt:     copyTime(dto.RevokedAt),
+		RevokedReason: dto.RevokedReason,
+	}
+}
+
+func (dto *SessionEntity) FromModel(session *models.Session) {
+	dto.ID = session.ID
+	dto.UserID = session.UserID
+	dto.UserAgent = session.UserAgent
+	dto.IPAddress = session.IPAddress
+	dto.CreatedAt = session.CreatedAt
+	dto.LastUsedAt = session.LastUsedAt
+	dto.ExpiresAt = session.ExpiresAt
+	dto.RevokedAt = copyTime(session.RevokedAt)
+	dto.RevokedReason = session.RevokedReason
+}
+
+type RefreshTokenEntity struct {
+	ID        uuid.UUID `gorm:"primaryKey"`
+	SessionID uuid.UUID `gorm:"index;not null"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+func (RefreshTokenEntity) TableName() string {
+	return "refresh_tokens"
+}
+
+func (dto *RefreshTokenEntity) ToModel() *models.RefreshToken {
+	return &models.RefreshToken{
+		ID:        dto.ID,
+		SessionID: dto.SessionID,
+		TokenHash: dto.TokenHash,
+		ExpiresAt: dto.ExpiresAt,
+		UsedAt:    copyTime(dto.UsedAt),
+		CreatedAt: dto.CreatedAt,
+	}
+}
+
+func (dto *RefreshTokenEntity) FromModel(token *models.RefreshToken) {
+	dto.ID = token.ID
+	dto.SessionID = token.SessionID
+	dto.TokenHash = token.TokenHash
+	dto.ExpiresAt = token.ExpiresAt
+	dto.UsedAt = copyTime(token.UsedAt)
+	dto.CreatedAt = token.CreatedAt
+}
+
+// CreateSession stores the session together with its first refresh token
+func (ps *PostgresStorage) CreateSession(session *models.Session, token *models.RefreshToken) error {
+	return ps.db.Transaction(func(tx *gorm.DB) error {
+		sessionDTO := &SessionEntity{}
+		sessionDTO.FromModel(session)
+		if err := tx.Create(sessionDTO).Error; err != nil {
+			return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while creating session for user with '%s' ID", session.UserID))
+		}
+
+		tokenDTO := &RefreshTokenEntity{}
+		tokenDTO.FromModel(token)
+		if err := tx.Create(tokenDTO).Error; err != nil {
+			return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while creating session for user with '%s' ID", session.UserID))
+		}
+		return nil
+	})
+}
+
+func (ps *PostgresStorage) RetrieveSession(id uuid.UUID) (*models.Session, error) {
+	dto := &SessionEntity{}
+	tx := ps.db.First(dto, "id = ?", id)
+	if tx.Error != nil {
+		errMsg := tx.Error.Error()
+		if strings.Contains(errMsg, "record not found") {
+			return nil, models.NewWrappedError(tx.Error, models.ContextNotFound, fmt.Sprintf("session with '%s' ID does not exist", id))
+		} else {
+			return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while searching session with '%s' ID", id))
+		}
+	}
+	return dto.ToModel(), nil
+}
+
+func (ps *PostgresStorage) RetrieveActiveSessions(userID uuid.UUID) ([]models.Session, error) {
+	var dtos []SessionEntity
+	tx := ps.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&dtos)
+	if tx.Error != nil {
+		return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while retrieving sessions of user with '%s' ID", userID))
+	}
+
+	sessions := make([]models.Session, 0, len(dtos))
+	for _, dto := range dtos {
+		sessions = append(sessions, *dto.ToModel())
+	}
+	return sessions, nil
+}
+
+func (ps *PostgresStorage) RetrieveRefreshToken(tokenHash string) (*models.RefreshToken, error) {
+	dto := &RefreshTokenEntity{}
+	tx := ps.db.First(dto, "token_hash = ?", tokenHash)
+	if tx.Error != nil {
+		errMsg := tx.Error.Error()
+		if strings.Contains(errMsg, "record not found") {
+			return nil, models.NewWrappedError(tx.Error, models.ContextNotFound, "refresh token does not exist")
+		} else {
+			return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, "unexpected error while searching refresh token")
+		}
+	}
+	return dto.ToModel(), nil
+}
+
+// RotateRefreshToken marks the presented token used and stores its successor
+// together with where the session was used last, fails with a conflict when
+// the token was used in the meantime
+func (ps *PostgresStorage) RotateRefreshToken(usedID uuid.UUID, next *models.RefreshToken, session *models.Session) error {
+	return ps.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&RefreshTokenEntity{}).Where("id = ? AND used_at IS NULL", usedID).Update("used_at", next.CreatedAt)
+		if res.Error != nil {
+			return models.NewWrappedError(res.Error, models.ContextInternalServer, "unexpected error while rotating refresh token")
+		}
+		if res.RowsAffected == 0 {
+			return models.NewInternalError(models.ContextConflictValue, "refresh token was already used")
+		}
+
+		dto := &RefreshTokenEntity{}
+		dto.FromModel(next)
+		if err := tx.Create(dto).Error; err != nil {
+			return models.NewWrappedError(err, models.ContextInternalServer, "unexpected error while rotating refresh token")
+		}
+
+		res = tx.Model(&SessionEntity{}).Where("id = ?", session.ID).Updates(map[string]any{
+			"last_used_at": session.LastUsedAt,
+			"user_agent":   session.UserAgent,
+			"ip_address":   session.IPAddress,
+		})
+		if res.Error != nil {
+			return models.NewWrappedError(res.Error, models.ContextInternalServer, "unexpected error while rotating refresh token")
+		}
+		return nil
+	})
+}
+
+func (ps *PostgresStorage) RevokeSession(id uuid.UUID, reason string) error {
+	tx := ps.db.Model(&SessionEntity{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Updates(map[string]any{"revoked_at": time.Now(), "revoked_reason": reason})
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while revoking session with '%s' ID", id))
+	}
+	return nil
+}
+
+func (ps *PostgresStorage) RevokeUserSessions(userID uuid.UUID, reason string) error {
+	tx := ps.db.Model(&SessionEntity{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Updates(map[string]any{"revoked_at": time.Now(), "revoked_reason": reason})
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while revoking sessions of user with '%s' ID", userID))
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"fmt"
 	"strings"
+	"time"
 	"users-microservice/pkg/config"
 	"users-microservice/pkg/models"
 
@@ -12,11 +13,188 @@ import (
 )
 
 type Storage interface {
-	CreateUser(*models.User) error
-	RetrieveUser(uuid.UUID) (*models.User, error)
+	UserStorage
+	HistoryStorage
+	TokenStorage
+	CredentialStorage
+	SessionStorage
+	MFAStorage
+	FailureCounterStorage
+	NonceStorage
+	PasskeyStorage
+	OIDCStorage
+	FederationStorage
+	APIKeyStorage
+	AuditStorage
+	AccessLogStorage
+	ExportStorage
+	ErasureStorage
+	EventStorage
 	Close() error
 }
 
+type UserStorage interface {
+	CreateUser(*models.User, *models.AuditEntry) error
+	RetrieveUser(uuid.UUID) (*models.User, error)
+	RetrieveUserByEmail(string) (*models.User, error)
+	RetrieveUserByPhoneNumber(string) (*models.User, error)
+	UpdateUser(*models.User, *models.UserHistoryEntry, *models.AuditEntry) error
+	ConfirmPendingEmail(uuid.UUID, string, time.Time, *models.AuditEntry) error
+	RevertEmail(uuid.UUID, string, time.Time, *models.AuditEntry) error
+	UpdateUserStatus(uuid.UUID, models.UserStatus, *models.UserHistoryEntry, *models.AuditEntry) error
+	MarkEmailVerified(uuid.UUID, time.Time, *models.AuditEntry) error
+	MarkPhoneVerified(uuid.UUID, string, time.Time, *models.UserHistoryEntry, *models.AuditEntry) error
+	RetrieveUsers(*models.UserFilter, int, int) ([]models.User, int64, error)
+}
+
+type HistoryStorage interface {
+	AppendUserHistory(*models.UserHistoryEntry) error
+	AppendUserHistoryAndEvent(*models.UserHistoryEntry, *models.Event) error
+	RetrieveUserHistory(uuid.UUID) ([]models.UserHistoryEntry, error)
+}
+
+type TokenStorage interface {
+	CreateUserToken(*models.UserToken) error
+	ConsumeUserToken(models.TokenPurpose, string) (*models.UserToken, error)
+	InvalidateUserTokens(uuid.UUID, models.TokenPurpose) error
+	InvalidateOtherUserTokens(uuid.UUID, models.TokenPurpose, string) error
+	RetrieveUserTokensSince(uuid.UUID, models.TokenPurpose, time.Time) ([]models.UserToken, error)
+}
+
+type CredentialStorage interface {
+	RetrievePasswordCredential(uuid.UUID) (*models.PasswordCredential, error)
+	SavePasswordCredential(*models.PasswordCredential) error
+}
+
+type SessionStorage interface {
+	CreateSession(*models.Session, *models.RefreshToken) error
+	RetrieveSession(uuid.UUID) (*models.Session, error)
+	RetrieveActiveSessions(uuid.UUID) ([]models.Session, error)
+	RetrieveRefreshToken(string) (*models.RefreshToken, error)
+	RotateRefreshToken(uuid.UUID, *models.RefreshToken, *models.Session) error
+	RevokeSession(uuid.UUID, string) error
+	RevokeUserSessions(uuid.UUID, string) error
+}
+
+type MFAStorage interface {
+	RetrieveTOTPCredential(uuid.UUID) (*models.TOTPCredential, error)
+	SaveUnconfirmedTOTPCredential(*models.TOTPCredential) error
+	ConfirmTOTPCredential(uuid.UUID, int64, []models.RecoveryCode) error
+	UseTOTPStep(uuid.UUID, int64) error
+	ConsumeRecoveryCode(uuid.UUID, string) error
+	CountUnusedRecoveryCodes(uuid.UUID) (int64, error)
+	DeleteMFA(uuid.UUID) error
+}
+
+type PasskeyStorage interface {
+	CreatePasskey(*models.Passkey) error
+	RetrievePasskeys(uuid.UUID) ([]models.Passkey, error)
+	RetrievePasskeyByCredentialID([]byte) (*models.Passkey, error)
+	RenamePasskey(uuid.UUID, uuid.UUID, string) error
+	UsePasskey(uuid.UUID, uint32) error
+	DeletePasskey(uuid.UUID, uuid.UUID) error
+}
+
+type OIDCStorage interface {
+	CreateOIDCClient(*models.OIDCClient) error
+	RetrieveOIDCClient(string) (*models.OIDCClient, error)
+	DeleteOIDCClient(string) error
+	CreateOIDCSigningKey(*models.OIDCSigningKey) error
+	RetrieveOIDCSigningKeys(time.Time) ([]models.OIDCSigningKey, error)
+}
+
+type FederationStorage interface {
+	CreateFederatedIdentity(*models.FederatedIdentity) error
+	RetrieveFederatedIdentity(string, string) (*models.FederatedIdentity, error)
+	RetrieveFederatedIdentities(uuid.UUID) ([]models.FederatedIdentity, error)
+	UseFederatedIdentity(uuid.UUID, string) error
+	DeleteFederatedIdentity(uuid.UUID, uuid.UUID) (*models.FederatedIdentity, error)
+}
+
+type APIKeyStorage interface {
+	CreateAPIKey(*models.APIKey) error
+	RetrieveAPIKeyByPrefix(string) (*models.APIKey, error)
+	RetrieveAPIKeys() ([]models.APIKey, error)
+	UseAPIKey(uuid.UUID, time.Time) error
+	DeleteAPIKey(uuid.UUID) error
+}
+
+// FailureCounterStorage is also implemented in memory for single replica
+// deployments, see NewMemoryFailureCounterStorage
+type FailureCounterStorage interface {
+	RetrieveFailureCounter(string) (*models.FailureCounter, error)
+	RecordFailure(string, time.Time, time.Time) (*models.FailureCounter, error)
+	LockFailureCounter(string, time.Time) error
+	DeleteFailureCounter(string) error
+}
+
+// AuditStorage is append-only, entries are never updated or deleted. They are
+// appended by the writes they audit, in the same transaction
+type AuditStorage interface {
+	RetrieveAuditEntries(uuid.UUID) ([]models.AuditEntry, error)
+	RetrieveAuditHead(uuid.UUID) (*models.AuditHead, error)
+	RetrieveAuditedUserIDs() ([]uuid.UUID, error)
+	AuditSalt(uuid.UUID) ([]byte, error)
+}
+
+type AccessLogStorage interface {
+	AppendAccessRecords([]models.AccessRecord) error
+	RetrieveAccessRecords(uuid.UUID, time.Time, int) ([]models.AccessRecord, error)
+	DeleteAccessRecordsBefore(time.Time) (int64, error)
+}
+
+type ExportStorage interface {
+	CreatePersonalDataExport(*models.PersonalDataExport) error
+	RetrievePersonalDataExport(uuid.UUID) (*models.PersonalDataExport, error)
+	UpdatePersonalDataExport(*models.PersonalDataExport) error
+	FailStalePersonalDataExports(time.Time, string) error
+	DeleteExpiredPersonalDataExports(time.Time) error
+}
+
+type ErasureStorage interface {
+	ErasePersonalData(*models.User, *models.UserHistoryEntry, *models.ErasureCertificate, *models.Event, *models.AuditEntry) error
+	RetrieveErasureCertificate(uuid.UUID) (*models.ErasureCertificate, error)
+}
+
+// EventStorage is the outbox events are published from
+type EventStorage interface {
+	RetrieveUnpublishedEvents(int) ([]models.Event, error)
+	MarkEventPublished(uuid.UUID, time.Time) error
+}
+
+// NonceStorage remembers the nonces of signed requests, it is also
+// implemented in memory, see NewMemoryNonceStorage
+type NonceStorage interface {
+	RecordNonce(string, time.Time, time.Time) (bool, error)
+	DeleteExpiredNonces(time.Time) error
+}
+
+// every table owned by the service, used for migrations and cleanup
+var entities = []any{
+	&UserEntity{},
+	&UserHistoryEntity{},
+	&UserTokenEntity{},
+	&PasswordCredentialEntity{},
+	&SessionEntity{},
+	&RefreshTokenEntity{},
+	&TOTPCredentialEntity{},
+	&RecoveryCodeEntity{},
+	&FailureCounterEntity{},
+	&NonceEntity{},
+	&PasskeyEntity{},
+	&OIDCClientEntity{},
+	&OIDCSigningKeyEntity{},
+	&FederatedIdentityEntity{},
+	&APIKeyEntity{},
+	&AuditEntryEntity{},
+	&AuditHeadEntity{},
+	&AuditSaltEntity{},
+	&AccessRecordEntity{},
+	&PersonalDataExportEntity{},
+	&ErasureCertificateEntity{},
+	&EventEntity{},
+}
+
 type PostgresStorage struct {
 	db *gorm.DB
 }
@@ -36,35 +214,52 @@ func NewPostgresStorage(cfg *config.Config) (*PostgresStorage, error) {
 	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 	sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 
-	db.AutoMigrate(&UserEntity{})
+	db.AutoMigrate(entities...)
+	if err := protectAuditLog(db); err != nil {
+		return nil, fmt.Errorf("failed to protect the audit log: %w", err)
+	}
 
 	return &PostgresStorage{db: db}, nil
 }
 
-func (ps *PostgresStorage) CreateUser(user *models.User) error {
+// CreateUser stores the user along with the audit entry of its creation
+func (ps *PostgresStorage) CreateUser(user *models.User, audit *models.AuditEntry) error {
 	dto := &UserEntity{}
 	dto.FromModel(user)
 
-	tx := ps.db.Create(dto)
-	if tx.Error != nil {
-		errMsg := tx.Error.Error()
-		// Check for PostgreSQL duplicate key constraint violations
-		if strings.Contains(errMsg, "duplicate key value violates unique constraint") {
-			if strings.Contains(errMsg, "users_pkey") {
-				return models.NewWrappedError(tx.Error, models.ContextConflictValue, fmt.Sprintf("user with ID '%s' already exists", dto.ID))
-			} else if strings.Contains(errMsg, "idx_users_email") {
-				return models.NewWrappedError(tx.Error, models.ContextConflictValue, fmt.Sprintf("email '%s' is already in use", dto.Email))
-			} else {
-				// fallback
-				return models.NewWrappedError(tx.Error, models.ContextConflictValue, "duplicate value violates unique constraint")
-			}
-		} else {
-			return models.NewWrappedError(tx.Error, models.ContextInternalServer, "unexpected error while creating new user")
+	err := ps.auditedTransaction(audit, func(tx *gorm.DB) error {
+		if err := tx.Create(dto).Error; err != nil {
+			return translateUserWriteError(err, dto.ID, dto.Email, "unexpected error while creating new user")
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
+	user.CreatedAt = dto.CreatedAt
 	return nil
 }
 
+// translateUserWriteError maps unique constraint violations on the users table
+// to conflicts, anything else is reported with given message
+func translateUserWriteError(err error, id uuid.UUID, email string, message string) error {
+	errMsg := err.Error()
+	// Check for PostgreSQL duplicate key constraint violations
+	if strings.Contains(errMsg, "duplicate key value violates unique constraint") {
+		if strings.Contains(errMsg, "users_pkey") {
+			return models.NewWrappedError(err, models.ContextConflictValue, fmt.Sprintf("user with ID '%s' already exists", id))
+		} else if strings.Contains(errMsg, "idx_users_email") {
+			return models.NewWrappedError(err, models.ContextConflictValue, fmt.Sprintf("email '%s' is already in use", email))
+		} else if strings.Contains(errMsg, "idx_users_phone_number") {
+			return models.NewWrappedError(err, models.ContextConflictValue, "phone number is already in use")
+		} else {
+			// fallback
+			return models.NewWrappedError(err, models.ContextConflictValue, "duplicate value violates unique constraint")
+		}
+	}
+	return models.NewWrappedError(err, models.ContextInternalServer, message)
+}
+
 func (ps *PostgresStorage) RetrieveUser(id uuid.UUID) (*models.User, error) {
 	dto := &UserEntity{}
 	tx := ps.db.First(dto, id)
@@ -80,15 +275,202 @@ func (ps *PostgresStorage) RetrieveUser(id uuid.UUID) (*models.User, error) {
 	return dto.ToModel(), nil
 }
 
-func (ps *PostgresStorage) CleanupTable() error {
-	stmt := &gorm.Statement{DB: ps.db}
-	if err := stmt.Parse(UserEntity{}); err != nil {
-		return fmt.Errorf("failed to parse model for table name: %w", err)
+func (ps *PostgresStorage) RetrieveUserByEmail(email string) (*models.User, error) {
+	dto := &UserEntity{}
+	tx := ps.db.Where("email = ?", email).First(dto)
+	if tx.Error != nil {
+		errMsg := tx.Error.Error()
+		if strings.Contains(errMsg, "record not found") {
+			return nil, models.NewWrappedError(tx.Error, models.ContextNotFound, "user with given email does not exist")
+		} else {
+			return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, "unexpected error while searching user by email")
+		}
 	}
-	tableName := stmt.Schema.Table
+	return dto.ToModel(), nil
+}
 
-	if err := ps.db.Exec(fmt.Sprintf("TRUNCATE TABLE %s RESTART IDENTITY CASCADE;", tableName)).Error; err != nil {
-		return fmt.Errorf("failed to cleanup the table %s: %w", tableName, err)
+func (ps *PostgresStorage) RetrieveUserByPhoneNumber(phoneNumber string) (*models.User, error) {
+	dto := &UserEntity{}
+	tx := ps.db.Where("phone_number = ?", phoneNumber).First(dto)
+	if tx.Error != nil {
+		errMsg := tx.Error.Error()
+		if strings.Contains(errMsg, "record not found") {
+			return nil, models.NewWrappedError(tx.Error, models.ContextNotFound, "user with given phone number does not exist")
+		} else {
+			return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, "unexpected error while searching user by phone number")
+		}
+	}
+	return dto.ToModel(), nil
+}
+
+// RetrieveUsers returns a page of the users matching the filter, oldest first,
+// along with the number of all matching users. A nil filter matches everyone
+func (ps *PostgresStorage) RetrieveUsers(filter *models.UserFilter, offset int, limit int) ([]models.User, int64, error) {
+	matching := func(db *gorm.DB) *gorm.DB { return db }
+	if filter != nil {
+		condition, args, err := userFilterCondition(filter)
+		if err != nil {
+			return nil, 0, models.NewWrappedError(err, models.ContextBadRequest, "filter cannot be applied to users")
+		}
+		matching = func(db *gorm.DB) *gorm.DB { return db.Where(condition, args...) }
+	}
+
+	var total int64
+	if err := ps.db.Model(&UserEntity{}).Scopes(matching).Count(&total).Error; err != nil {
+		return nil, 0, models.NewWrappedError(err, models.ContextInternalServer, "unexpected error while counting users")
+	}
+	if limit == 0 {
+		return []models.User{}, total, nil
+	}
+
+	var dtos []UserEntity
+	if err := ps.db.Scopes(matching).Order("created_at, id").Offset(offset).Limit(limit).Find(&dtos).Error; err != nil {
+		return nil, 0, models.NewWrappedError(err, models.ContextInternalServer, "unexpected error while searching users")
+	}
+	users := make([]models.User, 0, len(dtos))
+	for _, dto := range dtos {
+		users = append(users, *dto.ToModel())
+	}
+	return users, total, nil
+}
+
+// UpdateUser persists the editable profile fields, email and status have
+// dedicated flows and are left untouched. Deleted users are not updated, an
+// update read before an erasure must not write the erased data back. The
+// history entry is optional
+func (ps *PostgresStorage) UpdateUser(user *models.User, entry *models.UserHistoryEntry, audit *models.AuditEntry) error {
+	dto := &UserEntity{}
+	dto.FromModel(user)
+
+	return ps.auditedTransaction(audit, func(tx *gorm.DB) error {
+		res := tx.Model(dto).
+			Where("status <> ?", string(models.UserStatusDeleted)).
+			Select("name", "date_of_birth", "pending_email", "pending_email_expires_at", "phone_number", "phone_verified_at", "region").
+			Updates(dto)
+		if res.Error != nil {
+			return translateUserWriteError(res.Error, user.ID, user.Email, fmt.Sprintf("unexpected error while updating user with '%s' ID", user.ID))
+		}
+		if res.RowsAffected == 0 {
+			var count int64
+			if err := tx.Model(&UserEntity{}).Where("id = ?", user.ID).Count(&count).Error; err != nil {
+				return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while updating user with '%s' ID", user.ID))
+			}
+			if count > 0 {
+				return models.NewInternalError(models.ContextConflictValue, "deleted user cannot be updated")
+			}
+			return models.NewInternalError(models.ContextNotFound, fmt.Sprintf("user with '%s' ID does not exist", user.ID))
+		}
+		if entry == nil {
+			return nil
+		}
+		return appendUserHistory(tx, entry)
+	})
+}
+
+// ConfirmPendingEmail swaps the pending address in as the email, only if the
+// same change is still pending, the unique email index applies at this point
+func (ps *PostgresStorage) ConfirmPendingEmail(id uuid.UUID, pendingEmail string, verifiedAt time.Time, audit *models.AuditEntry) error {
+	return ps.auditedTransaction(audit, func(tx *gorm.DB) error {
+		res := tx.Model(&UserEntity{}).
+			Where("id = ? AND pending_email = ? AND pending_email_expires_at > ?", id, pendingEmail, verifiedAt).
+			Updates(map[string]any{
+				"email":                    pendingEmail,
+				"email_verified_at":        verifiedAt,
+				"pending_email":            "",
+				"pending_email_expires_at": nil,
+			})
+		if res.Error != nil {
+			return translateUserWriteError(res.Error, id, pendingEmail, fmt.Sprintf("unexpected error while changing email of user with '%s' ID", id))
+		}
+		if res.RowsAffected == 0 {
+			return models.NewInternalError(models.ContextBadRequest, "email change is no longer pending")
+		}
+		return nil
+	})
+}
+
+// RevertEmail restores the previous address and drops any pending change
+func (ps *PostgresStorage) RevertEmail(id uuid.UUID, email string, verifiedAt time.Time, audit *models.AuditEntry) error {
+	return ps.auditedTransaction(audit, func(tx *gorm.DB) error {
+		res := tx.Model(&UserEntity{}).
+			Where("id = ?", id).
+			Updates(map[string]any{
+				"email":                    email,
+				"email_verified_at":        verifiedAt,
+				"pending_email":            "",
+				"pending_email_expires_at": nil,
+			})
+		if res.Error != nil {
+			return translateUserWriteError(res.Error, id, email, fmt.Sprintf("unexpected error while reverting email of user with '%s' ID", id))
+		}
+		if res.RowsAffected == 0 {
+			return models.NewInternalError(models.ContextNotFound, fmt.Sprintf("user with '%s' ID does not exist", id))
+		}
+		return nil
+	})
+}
+
+// UpdateUserStatus moves the user from the expected status to the one in the
+// history entry and records the entry and the audit, all or nothing
+func (ps *PostgresStorage) UpdateUserStatus(id uuid.UUID, from models.UserStatus, entry *models.UserHistoryEntry, audit *models.AuditEntry) error {
+	return ps.auditedTransaction(audit, func(tx *gorm.DB) error {
+		res := tx.Model(&UserEntity{}).Where("id = ? AND status = ?", id, string(from)).Update("status", string(entry.ToStatus))
+		if res.Error != nil {
+			return models.NewWrappedError(res.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while updating status of user with '%s' ID", id))
+		}
+		// someone else changed the status in the meantime
+		if res.RowsAffected == 0 {
+			return models.NewInternalError(models.ContextConflictValue, fmt.Sprintf("status of user with '%s' ID is no longer '%s'", id, from))
+		}
+
+		dto := &UserHistoryEntity{}
+		dto.FromModel(entry)
+		if err := tx.Create(dto).Error; err != nil {
+			return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while recording history of user with '%s' ID", id))
+		}
+		return nil
+	})
+}
+
+func (ps *PostgresStorage) MarkEmailVerified(id uuid.UUID, at time.Time, audit *models.AuditEntry) error {
+	return ps.auditedTransaction(audit, func(tx *gorm.DB) error {
+		res := tx.Model(&UserEntity{}).Where("id = ?", id).Update("email_verified_at", at)
+		if res.Error != nil {
+			return models.NewWrappedError(res.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while verifying email of user with '%s' ID", id))
+		}
+		if res.RowsAffected == 0 {
+			return models.NewInternalError(models.ContextNotFound, fmt.Sprintf("user with '%s' ID does not exist", id))
+		}
+		return nil
+	})
+}
+
+// MarkPhoneVerified only applies while the user still has the given number,
+// a code sent to a replaced number cannot verify the new one
+func (ps *PostgresStorage) MarkPhoneVerified(id uuid.UUID, phoneNumber string, at time.Time, entry *models.UserHistoryEntry, audit *models.AuditEntry) error {
+	return ps.auditedTransaction(audit, func(tx *gorm.DB) error {
+		res := tx.Model(&UserEntity{}).Where("id = ? AND phone_number = ?", id, phoneNumber).Update("phone_verified_at", at)
+		if res.Error != nil {
+			return models.NewWrappedError(res.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while verifying phone number of user with '%s' ID", id))
+		}
+		if res.RowsAffected == 0 {
+			return models.NewInternalError(models.ContextBadRequest, "phone number has changed since the code was sent")
+		}
+		return appendUserHistory(tx, entry)
+	})
+}
+
+func (ps *PostgresStorage) CleanupTable() error {
+	for _, entity := range entities {
+		stmt := &gorm.Statement{DB: ps.db}
+		if err := stmt.Parse(entity); err != nil {
+			return fmt.Errorf("failed to parse model for table name: %w", err)
+		}
+		tableName := stmt.Schema.Table
+
+		if err := ps.db.Exec(fmt.Sprintf("TRUNCATE TABLE %s RESTART IDENTITY CASCADE;", tableName)).Error; err != nil {
+			return fmt.Errorf("failed to cleanup the table %s: %w", tableName, err)
+		}
 	}
 
 	return nil
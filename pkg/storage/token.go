@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+)
+
+type UserTokenEntity struct {
+	ID         uuid.UUID `gorm:"primaryKey"`
+	UserID     uuid.UUID `gorm:"index;not null"`
+	Purpose    string    `gorm:"type:varchar(50);not null"`
+	TokenHash  string    `gorm:"uniqueIndex;not null"`
+	Payload    string
+	ExpiresAt  time.Time `gorm:"not null"`
+	ConsumedAt *time.Time
+	CreatedAt  time.Time `gorm:"not null"`
+}
+
+func (UserTokenEntity) TableName() string {
+	return "user_tokens"
+}
+
+func (dto *UserTokenEntity) ToModel() *models.UserToken {
+	return &models.UserToken{
+		ID:         dto.ID,
+		UserID:     dto.UserID,
+		Purpose:    models.TokenPurpose(dto.Purpose),
+		TokenHash:  dto.TokenHash,
+		Payload:    dto.Payload,
+		ExpiresAt:  dto.ExpiresAt,
+		ConsumedAt: dto.ConsumedAt,
+		CreatedAt:  dto.CreatedAt,
+	}
+}
+
+func (dto *UserTokenEntity) FromModel(token *models.UserToken) {
+	dto.ID = token.ID
+	dto.UserID = token.UserID
+	dto.Purpose = string(token.Purpose)
+	dto.TokenHash = token.TokenHash
+	dto.Payload = token.Payload
+	dto.ExpiresAt = token.ExpiresAt
+	dto.ConsumedAt = token.ConsumedAt
+	dto.CreatedAt = token.CreatedAt
+}
+
+func (ps *PostgresStorage) CreateUserToken(token *models.UserToken) error {
+	dto := &UserTokenEntity{}
+	dto.FromModel(token)
+
+	if err := ps.db.Create(dto).Error; err != nil {
+		return models.NewWrappedError(err, models.ContextInternalServer, fmt.Sprintf("unexpected error while issuing token for user with '%s' ID", token.UserID))
+	}
+	return nil
+}
+
+// ConsumeUserToken marks the token as used and returns it, the update is
+// conditional so a token can be consumed at most once even under concurrency
+func (ps *PostgresStorage) ConsumeUserToken(purpose models.TokenPurpose, tokenHash string) (*models.UserToken, error) {
+	var dtos []UserTokenEntity
+	now := time.Now()
+	tx := ps.db.Model(&dtos).
+		Clauses(clause.Returning{}).
+		Where("token_hash = ? AND purpose = ? AND consumed_at IS NULL AND expires_at > ?", tokenHash, string(purpose), now).
+		Update("consumed_at", now)
+	if tx.Error != nil {
+		return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, "unexpected error while consuming token")
+	}
+	if tx.RowsAffected == 0 || len(dtos) == 0 {
+		return nil, models.NewInternalError(models.ContextBadRequest, "token is invalid or has expired")
+	}
+	return dtos[0].ToModel(), nil
+}
+
+// InvalidateUserTokens consumes all outstanding tokens of given purpose
+func (ps *PostgresStorage) InvalidateUserTokens(userID uuid.UUID, purpose models.TokenPurpose) error {
+	tx := ps.db.Model(&UserTokenEntity{}).
+		Where("user_id = ? AND purpose = ? AND consumed_at IS NULL", userID, string(purpose)).
+		Update("consumed_at", time.Now())
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while invalidating tokens of user with '%s' ID", userID))
+	}
+	return nil
+}
+
+// InvalidateOtherUserTokens invalidates the tokens of the user for the
+// purpose except those carrying the payload
+func (ps *PostgresStorage) InvalidateOtherUserTokens(userID uuid.UUID, purpose models.TokenPurpose, payload string) error {
+	tx := ps.db.Model(&UserTokenEntity{}).
+		Where("user_id = ? AND purpose = ? AND payload <> ? AND consumed_at IS NULL", userID, string(purpose), payload).
+		Update("consumed_at", time.Now())
+	if tx.Error != nil {
+		return models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while invalidating tokens of user with '%s' ID", userID))
+	}
+	return nil
+}
+
+// RetrieveUserTokensSince lists tokens issued after given time, newest first
+func (ps *PostgresStorage) RetrieveUserTokensSince(userID uuid.UUID, purpose models.TokenPurpose, since time.Time) ([]models.UserToken, error) {
+	var dtos []UserTokenEntity
+	tx := ps.db.Where("user_id = ? AND purpose = ? AND created_at > ?", userID, string(purpose), since).
+		Order("created_at DESC").
+		Find(&dtos)
+	if tx.Error != nil {
+		return nil, models.NewWrappedError(tx.Error, models.ContextInternalServer, fmt.Sprintf("unexpected error while retrieving tokens of user with '%s' ID", userID))
+	}
+
+	tokens := make([]models.UserToken, 0, len(dtos))
+	for _, dto := range dtos {
+		tokens = append(tokens, *dto.ToModel())
+	}
+	return tokens, nil
+}
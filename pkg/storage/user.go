@@ -13,7 +13,16 @@ type UserEntity struct {
 	Name        string    `gorm:"not null"`
 	Email       string    `gorm:"uniqueIndex;not null"`
 	DateOfBirth time.Time `gorm:"type:date"`
-	CreatedAt   time.Time `gorm:"autoCreateTime"`
+	Status      string    `gorm:"type:varchar(20);not null;default:active"`
+	// nil until the user confirms the address
+	EmailVerifiedAt       *time.Time
+	PendingEmail          string
+	PendingEmailExpiresAt *time.Time
+	// NULL rather than empty so users without a phone do not collide
+	PhoneNumber     *string `gorm:"uniqueIndex:idx_users_phone_number"`
+	PhoneVerifiedAt *time.Time
+	Region          string    `gorm:"type:varchar(2)"`
+	CreatedAt       time.Time `gorm:"autoCreateTime"`
 }
 
 func (UserEntity) TableName() string {
@@ -21,12 +30,24 @@ func (UserEntity) TableName() string {
 }
 
 func (dto *UserEntity) ToModel() *models.User {
-	return &models.User{
+	user := &models.User{
 		ID:          dto.ID,
 		Name:        dto.Name,
 		Email:       dto.Email,
 		DateOfBirth: dto.DateOfBirth,
+		Status:      models.UserStatus(dto.Status),
+		// copy so the model does not alias the entity
+		EmailVerifiedAt:       copyTime(dto.EmailVerifiedAt),
+		PendingEmail:          dto.PendingEmail,
+		PendingEmailExpiresAt: copyTime(dto.PendingEmailExpiresAt),
+		PhoneVerifiedAt:       copyTime(dto.PhoneVerifiedAt),
+		Region:                dto.Region,
+		CreatedAt:             dto.CreatedAt,
 	}
+	if dto.PhoneNumber != nil {
+		user.PhoneNumber = *dto.PhoneNumber
+	}
+	return user
 }
 
 func (dto *UserEntity) FromModel(user *models.User) {
@@ -34,4 +55,23 @@ func (dto *UserEntity) FromModel(user *models.User) {
 	dto.Name = user.Name
 	dto.Email = user.Email
 	dto.DateOfBirth = user.DateOfBirth
+	dto.Status = string(user.Status)
+	dto.EmailVerifiedAt = copyTime(user.EmailVerifiedAt)
+	dto.PendingEmail = user.PendingEmail
+	dto.PendingEmailExpiresAt = copyTime(user.PendingEmailExpiresAt)
+	dto.PhoneNumber = nil
+	if user.PhoneNumber != "" {
+		phone := user.PhoneNumber
+		dto.PhoneNumber = &phone
+	}
+	dto.PhoneVerifiedAt = copyTime(user.PhoneVerifiedAt)
+	dto.Region = user.Region
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
 }
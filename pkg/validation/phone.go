@@ -0,0 +1,51 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+	"users-microservice/pkg/models"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+// NormalizePhoneNumber parses the number and returns it in E.164 form, numbers
+// without a leading + are read as national numbers of the region, an ISO 3166
+// country code such as "DE"
+func NormalizePhoneNumber(number string, region string) (string, error) {
+	number = strings.TrimSpace(number)
+	if number == "" {
+		return "", models.NewInternalError(
+			models.ContextBadRequest,
+			"phone number cannot be empty",
+		)
+	}
+
+	region = strings.ToUpper(strings.TrimSpace(region))
+	if region != "" && !phonenumbers.GetSupportedRegions()[region] {
+		return "", models.NewInternalError(
+			models.ContextBadRequest,
+			"phone region is not supported",
+		)
+	}
+
+	parsed, err := phonenumbers.Parse(number, region)
+	if err != nil {
+		if errors.Is(err, phonenumbers.ErrInvalidCountryCode) && !strings.HasPrefix(number, "+") {
+			return "", models.NewWrappedError(err,
+				models.ContextBadRequest,
+				"phone number must start with a country code or come with a region",
+			)
+		}
+		return "", models.NewWrappedError(err,
+			models.ContextBadRequest,
+			"phone number format is invalid",
+		)
+	}
+	if !phonenumbers.IsValidNumber(parsed) {
+		return "", models.NewInternalError(
+			models.ContextBadRequest,
+			"phone number is not valid",
+		)
+	}
+	return phonenumbers.Format(parsed, phonenumbers.E164), nil
+}
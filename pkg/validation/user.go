@@ -5,6 +5,8 @@ import (
 	"strings"
 	"time"
 	"users-microservice/pkg/models"
+
+	"github.com/nyaruka/phonenumbers"
 )
 
 func ValidateUser(name string, email string, birthday time.Time) error {
@@ -64,3 +66,16 @@ func ValidateDateOfBirth(birthday time.Time) error {
 	}
 	return nil
 }
+
+// NormalizeRegion returns the ISO 3166 country code in upper case, an empty
+// region stays empty
+func NormalizeRegion(region string) (string, error) {
+	region = strings.ToUpper(strings.TrimSpace(region))
+	if region != "" && !phonenumbers.GetSupportedRegions()[region] {
+		return "", models.NewInternalError(
+			models.ContextBadRequest,
+			"region must be an ISO 3166 country code",
+		)
+	}
+	return region, nil
+}
@@ -0,0 +1,134 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// COSE algorithm identifiers from the IANA registry
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// SupportedAlgorithms in order of preference
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+const (
+	keyTypeOKP = 1
+	keyTypeEC2 = 2
+	keyTypeRSA = 3
+
+	curveP256    = 1
+	curveEd25519 = 6
+)
+
+// RFC 9053 keys reuse the negative labels with a meaning depending on the
+// key type, so the common header is read first
+type coseKeyHeader struct {
+	KeyType   int `cbor:"1,keyasint"`
+	Algorithm int `cbor:"3,keyasint"`
+}
+
+type curveCOSEKey struct {
+	Curve int    `cbor:"-1,keyasint"`
+	X     []byte `cbor:"-2,keyasint"`
+	Y     []byte `cbor:"-3,keyasint"`
+}
+
+type rsaCOSEKey struct {
+	N []byte `cbor:"-1,keyasint"`
+	E []byte `cbor:"-2,keyasint"`
+}
+
+type publicKey struct {
+	algorithm int
+	key       crypto.PublicKey
+}
+
+func parsePublicKey(raw []byte) (*publicKey, error) {
+	var header coseKeyHeader
+	if err := cbor.Unmarshal(raw, &header); err != nil {
+		return nil, fmt.Errorf("public key is malformed: %w", err)
+	}
+	var key curveCOSEKey
+	if header.KeyType == keyTypeEC2 || header.KeyType == keyTypeOKP {
+		if err := cbor.Unmarshal(raw, &key); err != nil {
+			return nil, fmt.Errorf("public key is malformed: %w", err)
+		}
+	}
+
+	switch {
+	case header.KeyType == keyTypeEC2 && header.Algorithm == AlgES256 && key.Curve == curveP256:
+		point := append([]byte{0x04}, append(leftPad(key.X, 32), leftPad(key.Y, 32)...)...)
+		// rejects points that are not on the curve
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("public key is invalid: %w", err)
+		}
+		return &publicKey{algorithm: AlgES256, key: &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(key.X),
+			Y:     new(big.Int).SetBytes(key.Y),
+		}}, nil
+
+	case header.KeyType == keyTypeOKP && header.Algorithm == AlgEdDSA && key.Curve == curveEd25519:
+		if len(key.X) != ed25519.PublicKeySize {
+			return nil, errors.New("public key is invalid")
+		}
+		return &publicKey{algorithm: AlgEdDSA, key: ed25519.PublicKey(key.X)}, nil
+
+	case header.KeyType == keyTypeRSA && header.Algorithm == AlgRS256:
+		var params rsaCOSEKey
+		if err := cbor.Unmarshal(raw, &params); err != nil {
+			return nil, fmt.Errorf("public key is malformed: %w", err)
+		}
+		exponent := new(big.Int).SetBytes(params.E)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("public key exponent is invalid")
+		}
+		rsaKey := &rsa.PublicKey{N: new(big.Int).SetBytes(params.N), E: int(exponent.Int64())}
+		if rsaKey.N.BitLen() < 2048 {
+			return nil, errors.New("public key is shorter than 2048 bits")
+		}
+		return &publicKey{algorithm: AlgRS256, key: rsaKey}, nil
+
+	default:
+		return nil, fmt.Errorf("key type %d with algorithm %d is not supported", header.KeyType, header.Algorithm)
+	}
+}
+
+func (k *publicKey) verify(signed []byte, signature []byte) error {
+	valid := false
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		valid = ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, signed, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !valid {
+		return errors.New("signature is invalid")
+	}
+	return nil
+}
+
+func leftPad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
@@ -0,0 +1,218 @@
+// Package webauthn verifies the responses of WebAuthn authenticators when
+// passkeys are registered and used to sign in, following the relying party
+// steps of the W3C Web Authentication Level 2 recommendation. Attestation is
+// not evaluated, the service asks for "none" and trusts any authenticator.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagAttestedCredentialData = 0x40
+
+	typeCreate = "webauthn.create"
+	typeGet    = "webauthn.get"
+)
+
+var ErrSignCount = errors.New("signature counter did not increase, the authenticator may be cloned")
+
+// RelyingParty is this service as WebAuthn sees it, ID is the domain
+// passkeys are scoped to and Origins the pages allowed to use them
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+func NewRelyingParty(id string, name string, origins []string) *RelyingParty {
+	return &RelyingParty{ID: id, Name: name, Origins: origins}
+}
+
+// Credential is what has to be stored to verify later sign-ins
+type Credential struct {
+	ID []byte
+	// COSE encoded public key
+	PublicKey []byte
+	SignCount uint32
+	AAGUID    []byte
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+type attestationObject struct {
+	Format    string          `cbor:"fmt"`
+	Statement cbor.RawMessage `cbor:"attStmt"`
+	AuthData  []byte          `cbor:"authData"`
+}
+
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// Challenge returns the challenge the client data was signed for, it is
+// needed to find the ceremony a response belongs to before verifying it
+func Challenge(clientDataJSON []byte) (string, error) {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return "", fmt.Errorf("client data is malformed: %w", err)
+	}
+	return data.Challenge, nil
+}
+
+// VerifyRegistration checks the response of navigator.credentials.create
+// and returns the new credential
+func (rp *RelyingParty) VerifyRegistration(challenge string, clientDataJSON []byte, attestation []byte) (*Credential, error) {
+	if err := rp.verifyClientData(clientDataJSON, typeCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	var object attestationObject
+	if err := cbor.Unmarshal(attestation, &object); err != nil {
+		return nil, fmt.Errorf("attestation object is malformed: %w", err)
+	}
+	if object.Format != "none" {
+		return nil, fmt.Errorf("attestation format %q is not supported", object.Format)
+	}
+
+	authData, err := parseAuthenticatorData(object.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+	if authData.flags&flagAttestedCredentialData == 0 {
+		return nil, errors.New("authenticator data carries no credential")
+	}
+	if _, err := parsePublicKey(authData.publicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		SignCount: authData.signCount,
+		AAGUID:    authData.aaguid,
+	}, nil
+}
+
+// VerifyAssertion checks the response of navigator.credentials.get against
+// the stored credential and returns the new signature counter
+func (rp *RelyingParty) VerifyAssertion(challenge string, credential *Credential, clientDataJSON []byte, rawAuthData []byte, signature []byte) (uint32, error) {
+	if err := rp.verifyClientData(clientDataJSON, typeGet, challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.verifyAuthenticatorData(authData); err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(slices.Clone(rawAuthData), clientDataHash[:]...)
+	if err := key.verify(signed, signature); err != nil {
+		return 0, err
+	}
+
+	// authenticators without a counter always report zero
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return 0, ErrSignCount
+	}
+	return authData.signCount, nil
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge string) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("client data is malformed: %w", err)
+	}
+	if data.Type != ceremony {
+		return fmt.Errorf("client data is of type %q instead of %q", data.Type, ceremony)
+	}
+	if subtle.ConstantTimeCompare([]byte(data.Challenge), []byte(challenge)) != 1 {
+		return errors.New("challenge does not match")
+	}
+	if data.CrossOrigin || !slices.Contains(rp.Origins, data.Origin) {
+		return fmt.Errorf("origin %q is not allowed", data.Origin)
+	}
+	return nil
+}
+
+// passkeys stand in for the password and the second factor, so the user
+// always has to be verified and not only present
+func (rp *RelyingParty) verifyAuthenticatorData(authData *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(authData.rpIDHash, rpIDHash[:]) {
+		return errors.New("credential is scoped to another relying party")
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return errors.New("user was not present")
+	}
+	if authData.flags&flagUserVerified == 0 {
+		return errors.New("user was not verified")
+	}
+	return nil
+}
+
+func parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, errors.New("authenticator data is too short")
+	}
+	authData := &authenticatorData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	if authData.flags&flagAttestedCredentialData == 0 {
+		return authData, nil
+	}
+
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return nil, errors.New("attested credential data is too short")
+	}
+	authData.aaguid = rest[:16]
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLength {
+		return nil, errors.New("credential ID is truncated")
+	}
+	authData.credentialID = rest[:idLength]
+
+	// extensions may follow the key, so only the first item is taken
+	var publicKey cbor.RawMessage
+	if _, err := cbor.UnmarshalFirst(rest[idLength:], &publicKey); err != nil {
+		return nil, fmt.Errorf("credential public key is malformed: %w", err)
+	}
+	authData.publicKey = publicKey
+	return authData, nil
+}
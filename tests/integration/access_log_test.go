@@ -0,0 +1,173 @@
+package integration
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"users-microservice/pkg/accesslog"
+	"users-microservice/pkg/api"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/services"
+
+	"github.com/google/uuid"
+)
+
+func TestAccessLog(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	userID := suite.createActiveTestUser(t, "accessed@test.com")
+	userURL := suite.httpSrv.URL + "/" + userID.String()
+	accessLogURL := suite.httpSrv.URL + "/users/" + userID.String() + "/access-log"
+
+	reader, err := suite.apiKeys.IssueAPIKey(systemContext(), services.APIKeyRequest{Name: "reader", Scopes: []models.Scope{models.ScopeUsersRead}})
+	if err != nil {
+		t.Fatalf("Failed to issue API key: %v", err)
+	}
+	get := func(t *testing.T, target string, key string, purpose string) *http.Response {
+		req, _ := http.NewRequest("GET", target, nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		if purpose != "" {
+			req.Header.Set("X-Access-Purpose", purpose)
+		}
+		resp, err := suite.Client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		return resp
+	}
+	accessLog := func(t *testing.T, query string) []api.AccessRecordAPI {
+		suite.accessLog.Flush()
+		resp := suite.makeJSONRequest(t, "GET", accessLogURL+query, nil)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		var records []api.AccessRecordAPI
+		decodeResponseData(t, resp, &records)
+		return records
+	}
+
+	t.Run("reads are written in the background", func(t *testing.T) {
+		resp := get(t, userURL, "", "support ticket 42")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to get user: Status=%d", resp.StatusCode)
+		}
+
+		records, err := suite.storage.RetrieveAccessRecords(userID, time.Time{}, 10)
+		if err != nil {
+			t.Fatalf("Failed to retrieve access records: %v", err)
+		}
+		if len(records) != 0 {
+			t.Errorf("Expected the read to wait for the flush, got %d records", len(records))
+		}
+
+		logged := accessLog(t, "")
+		if len(logged) != 1 {
+			t.Fatalf("Expected 1 record, got %+v", logged)
+		}
+		record := logged[0]
+		if !strings.HasPrefix(record.Accessor, "service:") || record.Purpose != "support ticket 42" || record.RequestID != resp.Header.Get("X-Request-ID") {
+			t.Errorf("Expected the caller, purpose and request of the read, got %+v", record)
+		}
+		for _, field := range []string{"id", "name", "email", "date_of_birth", "status", "email_verified_at"} {
+			if !slices.Contains(record.Fields, field) {
+				t.Errorf("Expected %s to be recorded as returned, got %v", field, record.Fields)
+			}
+		}
+	})
+
+	t.Run("masked fields are not recorded", func(t *testing.T) {
+		resp := get(t, userURL, reader.Secret, "")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to get user: Status=%d", resp.StatusCode)
+		}
+
+		record := accessLog(t, "?limit=1")[0]
+		if record.Accessor != "service:"+reader.Key.ID.String() {
+			t.Errorf("Expected the reader to be recorded, got %s", record.Accessor)
+		}
+		if record.Purpose != "" {
+			t.Errorf("Expected no purpose, got '%s'", record.Purpose)
+		}
+		if slices.Contains(record.Fields, "email") || slices.Contains(record.Fields, "date_of_birth") || !slices.Contains(record.Fields, "name") {
+			t.Errorf("Expected only unmasked fields, got %v", record.Fields)
+		}
+	})
+
+	t.Run("SCIM lists are recorded", func(t *testing.T) {
+		filter := url.QueryEscape(`userName eq "accessed@test.com"`)
+		resp := suite.makeJSONRequest(t, "GET", suite.httpSrv.URL+"/scim/v2/Users?filter="+filter, nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to list users: Status=%d", resp.StatusCode)
+		}
+
+		records := accessLog(t, "")
+		if len(records) != 3 {
+			t.Fatalf("Expected 3 records, got %d", len(records))
+		}
+	})
+
+	t.Run("since and limit", func(t *testing.T) {
+		records := accessLog(t, "?limit=2")
+		if len(records) != 2 || records[0].AccessedAt.Before(records[1].AccessedAt) {
+			t.Fatalf("Expected the 2 newest records first, got %+v", records)
+		}
+
+		since := url.QueryEscape(time.Now().Add(time.Minute).Format(time.RFC3339))
+		if records := accessLog(t, "?since="+since); len(records) != 0 {
+			t.Errorf("Expected no records in the future, got %d", len(records))
+		}
+	})
+
+	t.Run("access", func(t *testing.T) {
+		cases := []struct {
+			name   string
+			target string
+			key    string
+			status int
+		}{
+			{"reader", accessLogURL, reader.Secret, http.StatusForbidden},
+			{"unknown user", suite.httpSrv.URL + "/users/" + uuid.NewString() + "/access-log", "", http.StatusNotFound},
+			{"malformed since", accessLogURL + "?since=yesterday", "", http.StatusBadRequest},
+			{"malformed limit", accessLogURL + "?limit=-1", "", http.StatusBadRequest},
+		}
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				resp := get(t, tt.target, tt.key, "")
+				resp.Body.Close()
+				if resp.StatusCode != tt.status {
+					t.Errorf("Expected status %d, got %d", tt.status, resp.StatusCode)
+				}
+			})
+		}
+	})
+
+	t.Run("retention", func(t *testing.T) {
+		old := models.AccessRecord{ID: uuid.New(), UserID: userID, Accessor: "service:old", Fields: []string{"id"}, AccessedAt: time.Now().Add(-48 * time.Hour)}
+		if err := suite.storage.AppendAccessRecords([]models.AccessRecord{old}); err != nil {
+			t.Fatalf("Failed to append access record: %v", err)
+		}
+
+		// a writer deletes what is past the retention when it starts
+		writer := accesslog.NewWriter(suite.storage, &config.Config{AccessLogBufferSize: 1, AccessLogFlushInterval: time.Hour, AccessLogRetention: 24 * time.Hour})
+		writer.Close()
+
+		records, err := suite.storage.RetrieveAccessRecords(userID, time.Time{}, 100)
+		if err != nil {
+			t.Fatalf("Failed to retrieve access records: %v", err)
+		}
+		if len(records) != 3 || slices.ContainsFunc(records, func(r models.AccessRecord) bool { return r.ID == old.ID }) {
+			t.Errorf("Expected only the old record to be deleted, got %d records", len(records))
+		}
+	})
+}
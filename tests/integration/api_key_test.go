@@ -0,0 +1,192 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"users-microservice/pkg/api"
+	"users-microservice/pkg/models"
+)
+
+func TestAPIKeys(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	userID := suite.createActiveTestUser(t, "apikeys@test.com")
+	userURL := suite.httpSrv.URL + "/" + userID.String()
+	keysURL := suite.httpSrv.URL + "/api-keys"
+
+	// send a request with the key, an empty key sends none
+	request := func(t *testing.T, key string, method string, url string, payload any) *http.Response {
+		t.Helper()
+		var body bytes.Buffer
+		if payload != nil {
+			if err := json.NewEncoder(&body).Encode(payload); err != nil {
+				t.Fatalf("Failed to encode payload: %v", err)
+			}
+		}
+		req, err := http.NewRequest(method, url, &body)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		return resp
+	}
+	expectError := func(t *testing.T, resp *http.Response, expected int) {
+		t.Helper()
+		defer resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Fatalf("Expected status %d, got %d", expected, resp.StatusCode)
+		}
+		var envelope api.APIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if envelope.Success || envelope.Error == nil || envelope.Error.Description == "" {
+			t.Errorf("Expected an error envelope, got %+v", envelope)
+		}
+	}
+	issue := func(t *testing.T, req api.APIKeyCreateAPI) api.APIKeyAPI {
+		t.Helper()
+		resp := suite.makeJSONRequest(t, "POST", keysURL, req)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+		}
+		var key api.APIKeyAPI
+		decodeResponseData(t, resp, &key)
+		return key
+	}
+
+	reader := issue(t, api.APIKeyCreateAPI{Name: "reporting", Scopes: []models.Scope{models.ScopeUsersRead}})
+	writer := issue(t, api.APIKeyCreateAPI{Name: "signup", Scopes: []models.Scope{models.ScopeUsersWrite}})
+
+	t.Run("issued key", func(t *testing.T) {
+		if reader.Key == "" || reader.Prefix == "" {
+			t.Fatalf("Expected the key and its prefix, got %+v", reader)
+		}
+		if len(reader.Key) <= len(reader.Prefix) || reader.Key[:len(reader.Prefix)] != reader.Prefix {
+			t.Errorf("Expected the key to start with its prefix %q", reader.Prefix)
+		}
+		if reader.ExpiresAt != nil || reader.LastUsedAt != nil {
+			t.Errorf("Expected no expiry nor use, got %+v", reader)
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		resp := request(t, "", "GET", userURL, nil)
+		if resp.Header.Get("WWW-Authenticate") == "" {
+			t.Error("Expected a WWW-Authenticate header")
+		}
+		expectError(t, resp, http.StatusUnauthorized)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		expectError(t, request(t, "uk_unknown.secret", "GET", userURL, nil), http.StatusUnauthorized)
+		expectError(t, request(t, "not-a-key", "GET", userURL, nil), http.StatusUnauthorized)
+		expectError(t, request(t, reader.Prefix+".guessed", "GET", userURL, nil), http.StatusUnauthorized)
+	})
+
+	t.Run("scopes", func(t *testing.T) {
+		resp := request(t, reader.Key, "GET", userURL, nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		expectError(t, request(t, reader.Key, "PATCH", suite.httpSrv.URL+"/users/"+userID.String(), api.UserUpdateAPI{}), http.StatusForbidden)
+
+		// writing includes reading
+		resp = request(t, writer.Key, "GET", userURL, nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		expectError(t, request(t, writer.Key, "POST", suite.httpSrv.URL+"/users/"+userID.String()+"/suspend", api.StatusChangeAPI{Reason: "abuse"}), http.StatusForbidden)
+		expectError(t, request(t, writer.Key, "GET", keysURL, nil), http.StatusForbidden)
+	})
+
+	t.Run("public routes", func(t *testing.T) {
+		resp := request(t, "", "POST", suite.httpSrv.URL+"/auth/login", api.LoginAPI{Email: "apikeys@test.com", Password: "wrong horse battery"})
+		expectError(t, resp, http.StatusUnauthorized)
+		resp = request(t, "", "GET", suite.httpSrv.URL+"/.well-known/openid-configuration", nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+	})
+
+	t.Run("last use", func(t *testing.T) {
+		resp := suite.makeGETRequest(t, keysURL)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		var keys []api.APIKeyAPI
+		decodeResponseData(t, resp, &keys)
+
+		found := false
+		for _, key := range keys {
+			if key.Key != "" {
+				t.Errorf("Expected listed keys without the key, got %q", key.Key)
+			}
+			if key.ID == reader.ID {
+				found = true
+				if key.LastUsedAt == nil {
+					t.Error("Expected the last use to be recorded")
+				}
+			}
+		}
+		if !found {
+			t.Fatalf("Expected key %s to be listed", reader.ID)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		expiresAt := time.Now().Add(200 * time.Millisecond)
+		key := issue(t, api.APIKeyCreateAPI{Name: "temporary", Scopes: []models.Scope{models.ScopeUsersRead}, ExpiresAt: &expiresAt})
+		resp := request(t, key.Key, "GET", userURL, nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+
+		time.Sleep(time.Until(expiresAt))
+		expectError(t, request(t, key.Key, "GET", userURL, nil), http.StatusUnauthorized)
+	})
+
+	t.Run("revocation", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "DELETE", keysURL+"/"+writer.ID.String(), nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		expectError(t, request(t, writer.Key, "GET", userURL, nil), http.StatusUnauthorized)
+		expectError(t, suite.makeJSONRequest(t, "DELETE", keysURL+"/"+writer.ID.String(), nil), http.StatusNotFound)
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		past := time.Now().Add(-time.Hour)
+		cases := []struct {
+			name string
+			req  api.APIKeyCreateAPI
+		}{
+			{"missing name", api.APIKeyCreateAPI{Scopes: []models.Scope{models.ScopeUsersRead}}},
+			{"missing scopes", api.APIKeyCreateAPI{Name: "none"}},
+			{"unknown scope", api.APIKeyCreateAPI{Name: "unknown", Scopes: []models.Scope{"users:delete"}}},
+			{"past expiry", api.APIKeyCreateAPI{Name: "expired", Scopes: []models.Scope{models.ScopeUsersRead}, ExpiresAt: &past}},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				expectError(t, suite.makeJSONRequest(t, "POST", keysURL, tc.req), http.StatusBadRequest)
+			})
+		}
+	})
+}
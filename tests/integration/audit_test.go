@@ -0,0 +1,193 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"users-microservice/pkg/api"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/services"
+)
+
+func TestAuditLog(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	userID := suite.createActiveTestUser(t, "audit@test.com")
+	auditURL := suite.httpSrv.URL + "/users/" + userID.String() + "/audit"
+
+	// the update carries the ID the gateway assigned
+	name := "Audited Name"
+	payload, _ := json.Marshal(api.UserUpdateAPI{Name: &name})
+	req, _ := http.NewRequest("PATCH", suite.httpSrv.URL+"/users/"+userID.String(), bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", "gateway-request-1")
+	resp, err := suite.Client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to update user: Status=%d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-Request-ID"); got != "gateway-request-1" {
+		t.Errorf("Expected the request ID to be echoed, got '%s'", got)
+	}
+
+	resp = suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/users/"+userID.String()+"/suspend", api.StatusChangeAPI{Reason: "audit test"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to suspend user: Status=%d", resp.StatusCode)
+	}
+	suspendRequestID := resp.Header.Get("X-Request-ID")
+	if suspendRequestID == "" {
+		t.Errorf("Expected a request ID to be assigned")
+	}
+
+	resp = suite.makeJSONRequest(t, "DELETE", suite.httpSrv.URL+"/scim/v2/Users/"+userID.String(), nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Failed to delete user: Status=%d", resp.StatusCode)
+	}
+
+	t.Run("mutations are recorded", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "GET", auditURL, nil)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		var entries []api.AuditEntryAPI
+		decodeResponseData(t, resp, &entries)
+
+		// created by the suite's key, verified and activated through the
+		// emailed link, then renamed, suspended, deactivated and deleted
+		wantActions := []string{models.AuditActionCreate, models.AuditActionUpdate, models.AuditActionStatusChange, models.AuditActionUpdate, models.AuditActionStatusChange, models.AuditActionStatusChange, models.AuditActionDelete}
+		if len(entries) != len(wantActions) {
+			t.Fatalf("Expected %d entries, got %d: %+v", len(wantActions), len(entries), entries)
+		}
+		for i, entry := range entries {
+			if entry.Action != wantActions[i] || entry.Sequence != int64(i+1) {
+				t.Errorf("Expected entry %d to be %s, got %d %s", i+1, wantActions[i], entry.Sequence, entry.Action)
+			}
+		}
+
+		if !strings.HasPrefix(entries[0].Actor, "service:") || entries[1].Actor != "public" {
+			t.Errorf("Expected the key to create and the link to verify, got %s and %s", entries[0].Actor, entries[1].Actor)
+		}
+		rename := entries[3]
+		if rename.RequestID != "gateway-request-1" {
+			t.Errorf("Expected the request ID of the update, got '%s'", rename.RequestID)
+		}
+		if len(rename.Changes) != 1 || rename.Changes[0].Field != "name" || !rename.Changes[0].Hashed {
+			t.Fatalf("Expected a hashed name change, got %+v", rename.Changes)
+		}
+		if rename.Changes[0].After == name || rename.Changes[0].Before == "Milan" || len(rename.Changes[0].After) != 64 {
+			t.Errorf("Expected the names to be hashed, got %+v", rename.Changes[0])
+		}
+
+		suspension := entries[4]
+		if suspension.RequestID != suspendRequestID {
+			t.Errorf("Expected the assigned request ID, got '%s'", suspension.RequestID)
+		}
+		want := api.AuditChangeAPI{Field: "status", Before: "active", After: "suspended"}
+		if len(suspension.Changes) != 1 || suspension.Changes[0] != want {
+			t.Errorf("Expected %+v, got %+v", want, suspension.Changes)
+		}
+
+		if entries[6].RequestID == "" {
+			t.Errorf("Expected SCIM requests to be given an ID too")
+		}
+
+		// nothing personal shows in the log
+		raw, _ := json.Marshal(entries)
+		for _, value := range []string{"audit@test.com", "Milan", name} {
+			if bytes.Contains(raw, []byte(value)) {
+				t.Errorf("Expected '%s' not to be in the audit log", value)
+			}
+		}
+	})
+
+	head, err := suite.storage.RetrieveAuditHead(userID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve audit head: %v", err)
+	}
+
+	t.Run("chain is intact", func(t *testing.T) {
+		entries, err := suite.storage.RetrieveAuditEntries(userID)
+		if err != nil {
+			t.Fatalf("Failed to retrieve audit entries: %v", err)
+		}
+		if head.Sequence != int64(len(entries)) {
+			t.Errorf("Expected the head to be entry %d, got %d", len(entries), head.Sequence)
+		}
+		if err := models.VerifyAuditChain(entries, head); err != nil {
+			t.Fatalf("Expected the chain to verify: %v", err)
+		}
+	})
+
+	t.Run("tampering breaks the chain", func(t *testing.T) {
+		tamper := []struct {
+			name   string
+			change func([]models.AuditEntry) []models.AuditEntry
+		}{
+			{"altered change", func(entries []models.AuditEntry) []models.AuditEntry {
+				entries[4].Changes[0].After = "active"
+				return entries
+			}},
+			{"altered actor", func(entries []models.AuditEntry) []models.AuditEntry {
+				entries[0].Actor = "service:someone-else"
+				return entries
+			}},
+			{"removed entry", func(entries []models.AuditEntry) []models.AuditEntry {
+				return append(entries[:2], entries[3:]...)
+			}},
+			{"rehashed entry", func(entries []models.AuditEntry) []models.AuditEntry {
+				entries[2].Action = models.AuditActionDelete
+				entries[2].Hash = entries[2].ComputeHash()
+				return entries
+			}},
+			{"truncated chain", func(entries []models.AuditEntry) []models.AuditEntry {
+				return entries[:len(entries)-1]
+			}},
+			{"removed chain", func(entries []models.AuditEntry) []models.AuditEntry {
+				return nil
+			}},
+		}
+		for _, tt := range tamper {
+			t.Run(tt.name, func(t *testing.T) {
+				entries, err := suite.storage.RetrieveAuditEntries(userID)
+				if err != nil {
+					t.Fatalf("Failed to retrieve audit entries: %v", err)
+				}
+				if err := models.VerifyAuditChain(tt.change(entries), head); err == nil {
+					t.Errorf("Expected the chain to be broken")
+				}
+			})
+		}
+	})
+
+	t.Run("access", func(t *testing.T) {
+		reader, err := suite.apiKeys.IssueAPIKey(systemContext(), services.APIKeyRequest{Name: "reader", Scopes: []models.Scope{models.ScopeUsersRead}})
+		if err != nil {
+			t.Fatalf("Failed to issue API key: %v", err)
+		}
+		req, _ := http.NewRequest("GET", auditURL, nil)
+		req.Header.Set("Authorization", "Bearer "+reader.Secret)
+		resp, err := suite.Client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected status %d for readers, got %d", http.StatusForbidden, resp.StatusCode)
+		}
+
+		resp = suite.makeJSONRequest(t, "GET", suite.httpSrv.URL+"/users/00000000-0000-0000-0000-000000000001/audit", nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status %d for unknown users, got %d", http.StatusNotFound, resp.StatusCode)
+		}
+	})
+}
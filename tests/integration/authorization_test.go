@@ -0,0 +1,201 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"users-microservice/pkg/api"
+	"users-microservice/pkg/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestOwnerAuthorization(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	alice := suite.createActiveTestUser(t, "alice@test.com")
+	bob := suite.createActiveTestUser(t, "bob@test.com")
+
+	// end users get every scope, only ownership keeps them apart
+	userToken := func(t *testing.T, id uuid.UUID) string {
+		t.Helper()
+		token, err := suite.gateway.Sign(jwt.SigningMethodES256, newGatewayClaims(id.String(), "users:admin"))
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		return token
+	}
+	aliceToken := userToken(t, alice)
+
+	// send the request as alice, an empty token uses the administrator key of
+	// the suite
+	request := func(t *testing.T, token string, method string, path string, payload any) int {
+		t.Helper()
+		body, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("Failed to encode payload: %v", err)
+		}
+		req, err := http.NewRequest(method, suite.httpSrv.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := suite.Client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	empty := map[string]any{}
+	subID := uuid.NewString()
+	// {id} is replaced by the user acted on, rejected is the status the owner
+	// and services still get because the request itself is refused, e.g. for
+	// sub-resources that do not exist
+	endpoints := []struct {
+		method   string
+		path     string
+		payload  any
+		rejected int
+	}{
+		{"GET", "/{id}", nil, 0},
+		{"POST", "/users/{id}/suspend", empty, 0},
+		{"POST", "/users/{id}/reactivate", empty, 0},
+		{"POST", "/users/{id}/deactivate", empty, 0},
+		{"GET", "/users/{id}/history", nil, 0},
+		{"POST", "/users/{id}/verify-email/resend", empty, 0},
+		{"PATCH", "/users/{id}", empty, 0},
+		{"POST", "/users/{id}/phone/verification", empty, 0},
+		{"POST", "/users/{id}/phone/verify", empty, 0},
+		{"POST", "/users/{id}/password", empty, 0},
+		{"POST", "/users/{id}/password/change", empty, http.StatusNotFound},
+		{"GET", "/users/{id}/sessions", nil, 0},
+		{"DELETE", "/users/{id}/sessions/" + subID, nil, http.StatusNotFound},
+		{"DELETE", "/users/{id}/sessions", nil, 0},
+		{"POST", "/users/{id}/mfa/totp", empty, 0},
+		{"POST", "/users/{id}/mfa/totp/confirm", empty, http.StatusUnauthorized},
+		{"GET", "/users/{id}/mfa", nil, 0},
+		{"DELETE", "/users/{id}/mfa", empty, 0},
+		{"POST", "/users/{id}/unlock", empty, 0},
+		{"POST", "/users/{id}/passkeys/options", empty, 0},
+		{"POST", "/users/{id}/passkeys", empty, 0},
+		{"GET", "/users/{id}/passkeys", nil, 0},
+		{"PATCH", "/users/{id}/passkeys/" + subID, map[string]string{"name": "laptop"}, http.StatusNotFound},
+		{"DELETE", "/users/{id}/passkeys/" + subID, nil, http.StatusNotFound},
+		{"POST", "/users/{id}/identities/mock", empty, 0},
+		{"POST", "/users/{id}/identities/mock/callback", empty, 0},
+		{"GET", "/users/{id}/identities", nil, 0},
+		{"DELETE", "/users/{id}/identities/" + subID, nil, http.StatusNotFound},
+		{"GET", "/users/{id}/audit", nil, 0},
+		{"GET", "/users/{id}/access-log", nil, 0},
+		{"POST", "/users/{id}:export-personal-data", nil, 0},
+		{"GET", "/users/{id}/personal-data-exports/" + subID, nil, http.StatusNotFound},
+		// active users are not erased, nor do they have a certificate
+		{"POST", "/users/{id}:erase-personal-data", nil, http.StatusConflict},
+		{"GET", "/users/{id}/erasure-certificate", nil, http.StatusNotFound},
+	}
+
+	authorized := func(status int, rejected int) bool {
+		if status == rejected {
+			return true
+		}
+		return status != http.StatusUnauthorized && status != http.StatusForbidden && status != http.StatusNotFound
+	}
+
+	for _, endpoint := range endpoints {
+		name := endpoint.method + " " + endpoint.path
+		t.Run(name, func(t *testing.T) {
+			t.Run("other user", func(t *testing.T) {
+				path := strings.ReplaceAll(endpoint.path, "{id}", bob.String())
+				if status := request(t, aliceToken, endpoint.method, path, endpoint.payload); status != http.StatusNotFound {
+					t.Fatalf("Expected status %d, got %d", http.StatusNotFound, status)
+				}
+			})
+			t.Run("unknown user", func(t *testing.T) {
+				path := strings.ReplaceAll(endpoint.path, "{id}", uuid.NewString())
+				if status := request(t, aliceToken, endpoint.method, path, endpoint.payload); status != http.StatusNotFound {
+					t.Fatalf("Expected status %d, got %d", http.StatusNotFound, status)
+				}
+			})
+			t.Run("owner", func(t *testing.T) {
+				path := strings.ReplaceAll(endpoint.path, "{id}", alice.String())
+				status := request(t, aliceToken, endpoint.method, path, endpoint.payload)
+				if !authorized(status, endpoint.rejected) {
+					t.Fatalf("Expected alice to act on herself, got %d", status)
+				}
+			})
+			t.Run("service", func(t *testing.T) {
+				path := strings.ReplaceAll(endpoint.path, "{id}", bob.String())
+				status := request(t, "", endpoint.method, path, endpoint.payload)
+				if !authorized(status, endpoint.rejected) {
+					t.Fatalf("Expected a service to act on any user, got %d", status)
+				}
+			})
+		})
+	}
+
+	t.Run("service operations", func(t *testing.T) {
+		keyID := uuid.NewString()
+		cases := []struct {
+			method  string
+			path    string
+			payload any
+		}{
+			{"POST", "/api-keys", api.APIKeyCreateAPI{Name: "mine", Scopes: []models.Scope{models.ScopeUsersAdmin}}},
+			{"GET", "/api-keys", nil},
+			{"DELETE", "/api-keys/" + keyID, nil},
+			{"POST", "/oauth2/clients", api.OIDCClientCreateAPI{Name: "mine", RedirectURIs: []string{"https://app.test/callback"}}},
+			{"GET", "/oauth2/clients/" + keyID, nil},
+			{"DELETE", "/oauth2/clients/" + keyID, nil},
+			{"POST", "/oauth2/keys/rotate", empty},
+			{"GET", "/scim/v2/Users", nil},
+			{"POST", "/scim/v2/Users", empty},
+			{"GET", "/scim/v2/Users/" + alice.String(), nil},
+			{"PUT", "/scim/v2/Users/" + alice.String(), empty},
+			{"PATCH", "/scim/v2/Users/" + alice.String(), empty},
+			{"DELETE", "/scim/v2/Users/" + alice.String(), nil},
+		}
+		for _, tc := range cases {
+			t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+				if status := request(t, aliceToken, tc.method, tc.path, tc.payload); status != http.StatusForbidden {
+					t.Fatalf("Expected status %d, got %d", http.StatusForbidden, status)
+				}
+			})
+		}
+	})
+
+	t.Run("no principal", func(t *testing.T) {
+		// a route that forgot to authenticate does not get through
+		_, err := suite.service.GetUser(context.Background(), bob)
+		if models.ErrorContext(err) != models.ContextUnauthorized {
+			t.Fatalf("Expected the call to be refused, got %v", err)
+		}
+		if _, err := suite.apiKeys.ListAPIKeys(context.Background()); models.ErrorContext(err) != models.ContextUnauthorized {
+			t.Fatalf("Expected the call to be refused, got %v", err)
+		}
+	})
+
+	t.Run("sign up", func(t *testing.T) {
+		newcomer := uuid.New()
+		user := func(id uuid.UUID, email string) api.UserAPI {
+			return api.UserAPI{ID: id, Name: "Newcomer", Email: email, DateOfBirth: time.Now().AddDate(-30, 0, 0)}
+		}
+
+		if status := request(t, userToken(t, newcomer), "POST", "/save", user(uuid.New(), "someone@test.com")); status != http.StatusForbidden {
+			t.Fatalf("Expected status %d for another ID, got %d", http.StatusForbidden, status)
+		}
+		if status := request(t, userToken(t, newcomer), "POST", "/save", user(newcomer, "newcomer@test.com")); status != http.StatusCreated {
+			t.Fatalf("Expected status %d for the own ID, got %d", http.StatusCreated, status)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"users-microservice/pkg/api"
+	"users-microservice/pkg/services"
+)
+
+func TestEmailChange(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	oldEmail := "old@test.com"
+	newEmail := "new@test.com"
+	takenEmail := "taken@test.com"
+	userID := suite.createActiveTestUser(t, oldEmail)
+	suite.createTestUser(t, takenEmail)
+	userURL := suite.httpSrv.URL + "/users/" + userID.String()
+
+	getUser := func(t *testing.T) api.UserAPI {
+		resp := suite.makeGETRequest(t, suite.httpSrv.URL+"/"+userID.String())
+		defer resp.Body.Close()
+
+		var user api.UserAPI
+		decodeResponseData(t, resp, &user)
+		return user
+	}
+
+	t.Run("address in use is rejected", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "PATCH", userURL, api.UserUpdateAPI{Email: &takenEmail})
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusConflict {
+			t.Errorf("Expected status %d, got %d", http.StatusConflict, resp.StatusCode)
+		}
+	})
+
+	t.Run("change stays pending until confirmed", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "PATCH", userURL, api.UserUpdateAPI{Email: &newEmail})
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		user := getUser(t)
+		if user.Email != oldEmail || user.PendingEmail != newEmail {
+			t.Errorf("Expected email %s pending %s, got %s pending %s", oldEmail, newEmail, user.Email, user.PendingEmail)
+		}
+	})
+
+	t.Run("confirmation swaps the address", func(t *testing.T) {
+		token := suite.lastMailedToken(t, newEmail)
+		resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/users/confirm-email-change", api.TokenAPI{Token: token})
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		user := getUser(t)
+		if user.Email != newEmail || user.PendingEmail != "" {
+			t.Errorf("Expected email %s without pending change, got %s pending %s", newEmail, user.Email, user.PendingEmail)
+		}
+	})
+
+	t.Run("old address can revert", func(t *testing.T) {
+		token := suite.lastMailedToken(t, oldEmail)
+		resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/users/revert-email-change", api.TokenAPI{Token: token})
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		user := getUser(t)
+		if user.Email != oldEmail {
+			t.Errorf("Expected email %s after revert, got %s", oldEmail, user.Email)
+		}
+	})
+
+	t.Run("only the latest change can be reverted", func(t *testing.T) {
+		change := func(t *testing.T, email string) string {
+			resp := suite.makeJSONRequest(t, "PATCH", userURL, api.UserUpdateAPI{Email: &email})
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+			}
+			return suite.lastMailedToken(t, oldEmail)
+		}
+		revert := func(t *testing.T, token string) int {
+			resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/users/revert-email-change", api.TokenAPI{Token: token})
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		stale := change(t, "first@test.com")
+		latest := change(t, "second@test.com")
+		if status := revert(t, stale); status != http.StatusBadRequest {
+			t.Errorf("Expected status %d for a stale revert link, got %d", http.StatusBadRequest, status)
+		}
+		if status := revert(t, latest); status != http.StatusOK {
+			t.Errorf("Expected status %d for the latest revert link, got %d", http.StatusOK, status)
+		}
+	})
+
+	t.Run("unsent confirmation can be asked for again", func(t *testing.T) {
+		email := "retried@test.com"
+		// the outbox refuses to send once the request is gone
+		ctx, cancel := context.WithCancel(systemContext())
+		cancel()
+		if _, err := suite.service.UpdateUser(ctx, userID, services.UserUpdateRequest{Email: &email}); err == nil {
+			t.Fatal("Expected the update to fail without its confirmation")
+		}
+		if user := getUser(t); user.PendingEmail != "" {
+			t.Fatalf("Expected the change to be withdrawn, got %s pending", user.PendingEmail)
+		}
+
+		resp := suite.makeJSONRequest(t, "PATCH", userURL, api.UserUpdateAPI{Email: &email})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		if len(suite.mailedTo(t, email)) != 1 {
+			t.Errorf("Expected the retry to send the confirmation")
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"users-microservice/pkg/api"
+	"users-microservice/pkg/events"
+	"users-microservice/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+func TestPersonalDataErasure(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	userID := suite.createActiveTestUser(t, "erased@test.com")
+	usersURL := suite.httpSrv.URL + "/users/" + userID.String()
+	erase := func(t *testing.T, id string) *http.Response {
+		return suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/users/"+id+":erase-personal-data", nil)
+	}
+
+	t.Run("active users are not erased", func(t *testing.T) {
+		resp := erase(t, userID.String())
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusConflict {
+			t.Fatalf("Expected status %d, got %d", http.StatusConflict, resp.StatusCode)
+		}
+	})
+
+	resp := suite.makeJSONRequest(t, "POST", usersURL+"/deactivate", api.StatusChangeAPI{Reason: "Milan asked by mail from erased@test.com"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to deactivate user: Status=%d", resp.StatusCode)
+	}
+
+	// an update that read the user before the erasure committed
+	stale, err := suite.storage.RetrieveUser(userID)
+	if err != nil {
+		t.Fatalf("Failed to retrieve user: %v", err)
+	}
+
+	resp = erase(t, userID.String())
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	var certificate api.ErasureCertificateAPI
+	decodeResponseData(t, resp, &certificate)
+	resp.Body.Close()
+
+	t.Run("certificate", func(t *testing.T) {
+		if certificate.UserID != userID || certificate.ErasedBy == "" || !slices.Contains(certificate.Erased, "profile.email") {
+			t.Errorf("Expected the certificate to describe the erasure, got %+v", certificate)
+		}
+		recomputed := models.ErasureCertificate{ID: certificate.ID, UserID: certificate.UserID, ErasedBy: certificate.ErasedBy, RequestID: certificate.RequestID, Erased: certificate.Erased, ErasedAt: certificate.ErasedAt}
+		if recomputed.ComputeHash() != certificate.Hash {
+			t.Errorf("Expected the hash to cover the certificate")
+		}
+
+		resp := suite.makeGETRequest(t, usersURL+"/erasure-certificate")
+		defer resp.Body.Close()
+		var stored api.ErasureCertificateAPI
+		decodeResponseData(t, resp, &stored)
+		if stored.ID != certificate.ID || stored.Hash != certificate.Hash {
+			t.Errorf("Expected the certificate to be kept, got %+v", stored)
+		}
+	})
+
+	t.Run("erasing again returns the certificate", func(t *testing.T) {
+		resp := erase(t, userID.String())
+		defer resp.Body.Close()
+		var again api.ErasureCertificateAPI
+		decodeResponseData(t, resp, &again)
+		if resp.StatusCode != http.StatusOK || again.ID != certificate.ID {
+			t.Errorf("Expected the first certificate, got %d %+v", resp.StatusCode, again)
+		}
+	})
+
+	t.Run("profile is anonymized", func(t *testing.T) {
+		resp := suite.makeGETRequest(t, suite.httpSrv.URL+"/"+userID.String())
+		defer resp.Body.Close()
+		var user api.UserAPI
+		decodeResponseData(t, resp, &user)
+		if user.ID != userID || user.Name != models.ErasedName || user.Email != models.ErasedEmail(userID) || !user.DateOfBirth.IsZero() || user.Status != string(models.UserStatusDeleted) {
+			t.Errorf("Expected the user anonymized under its ID, got %+v", user)
+		}
+	})
+
+	t.Run("stale updates do not restore the profile", func(t *testing.T) {
+		err := suite.storage.UpdateUser(stale, nil, nil)
+		if models.ErrorContext(err) != models.ContextConflictValue {
+			t.Fatalf("Expected the update to conflict, got %v", err)
+		}
+		user, err := suite.storage.RetrieveUser(userID)
+		if err != nil {
+			t.Fatalf("Failed to retrieve user: %v", err)
+		}
+		if user.Name != models.ErasedName || !user.DateOfBirth.IsZero() {
+			t.Errorf("Expected the user to stay anonymized, got %+v", user)
+		}
+	})
+
+	t.Run("history keeps no reasons", func(t *testing.T) {
+		history, err := suite.storage.RetrieveUserHistory(userID)
+		if err != nil {
+			t.Fatalf("Failed to retrieve history: %v", err)
+		}
+		for _, entry := range history {
+			if entry.Reason != "" {
+				t.Errorf("Expected no reasons left, got '%s'", entry.Reason)
+			}
+		}
+		if last := history[len(history)-1]; last.Event != models.HistoryEventPersonalDataErased || last.ToStatus != models.UserStatusDeleted {
+			t.Errorf("Expected the erasure in the history, got %+v", last)
+		}
+	})
+
+	t.Run("audit chain is intact", func(t *testing.T) {
+		entries, err := suite.storage.RetrieveAuditEntries(userID)
+		if err != nil {
+			t.Fatalf("Failed to retrieve audit entries: %v", err)
+		}
+		head, err := suite.storage.RetrieveAuditHead(userID)
+		if err != nil {
+			t.Fatalf("Failed to retrieve audit head: %v", err)
+		}
+		if err := models.VerifyAuditChain(entries, head); err != nil {
+			t.Fatalf("Expected the chain to verify: %v", err)
+		}
+		if last := entries[len(entries)-1]; last.Action != models.AuditActionErase {
+			t.Errorf("Expected the erasure to be audited, got %s", last.Action)
+		}
+	})
+
+	t.Run("email is free again", func(t *testing.T) {
+		suite.createTestUser(t, "erased@test.com")
+	})
+
+	t.Run("event is published", func(t *testing.T) {
+		if err := suite.events.PublishPending(t.Context()); err != nil {
+			t.Fatalf("Failed to publish events: %v", err)
+		}
+		// nothing is published twice
+		if err := suite.events.PublishPending(t.Context()); err != nil {
+			t.Fatalf("Failed to publish events: %v", err)
+		}
+		messages, err := events.ReadOutbox(suite.eventsOutbox)
+		if err != nil {
+			t.Fatalf("Failed to read events outbox: %v", err)
+		}
+		if len(messages) != 1 || messages[0].Type != models.EventPersonalDataErased || messages[0].UserID != userID {
+			t.Fatalf("Expected one erasure event, got %+v", messages)
+		}
+		var data struct {
+			CertificateID uuid.UUID `json:"certificate_id"`
+		}
+		if err := json.Unmarshal(messages[0].Data, &data); err != nil || data.CertificateID != certificate.ID {
+			t.Errorf("Expected the event to name the certificate, got %s", messages[0].Data)
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		resp := erase(t, uuid.NewString())
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,255 @@
+package integration
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"users-microservice/pkg/api"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/export"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/services"
+
+	"github.com/google/uuid"
+)
+
+func TestPersonalDataExport(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	userID := suite.createActiveTestUser(t, "exported@test.com")
+	other := suite.createTestUser(t, "other@test.com")
+	exportURL := suite.httpSrv.URL + "/users/" + userID.String() + ":export-personal-data"
+
+	// subsystems outside of the services plug their data in the same way
+	suite.exporter.Register("consents", export.CollectorFunc(func(ctx context.Context, id uuid.UUID) (any, error) {
+		return []map[string]string{{"purpose": "newsletter", "user": id.String()}}, nil
+	}))
+
+	start := func(t *testing.T, target string, key string) *http.Response {
+		req, _ := http.NewRequest("POST", target, nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		resp, err := suite.Client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		return resp
+	}
+	// waitForExport polls the export until it is done
+	waitForExport := func(t *testing.T, location string) api.PersonalDataExportAPI {
+		var status api.PersonalDataExportAPI
+		for range 50 {
+			resp := suite.makeJSONRequest(t, "GET", suite.httpSrv.URL+location, nil)
+			if resp.StatusCode != http.StatusOK {
+				resp.Body.Close()
+				t.Fatalf("Failed to get export: Status=%d", resp.StatusCode)
+			}
+			decodeResponseData(t, resp, &status)
+			if status.Status == string(models.ExportStatusCompleted) || status.Status == string(models.ExportStatusFailed) {
+				return status
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		t.Fatalf("Export did not finish, last status %s", status.Status)
+		return status
+	}
+	download := func(t *testing.T, status api.PersonalDataExportAPI) *http.Response {
+		resp, err := http.Get(suite.httpSrv.URL + "/personal-data-exports/" + path.Base(status.DownloadURL))
+		if err != nil {
+			t.Fatalf("Failed to download export: %v", err)
+		}
+		return resp
+	}
+
+	resp := start(t, exportURL, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d", http.StatusAccepted, resp.StatusCode)
+	}
+	location := resp.Header.Get("Location")
+	if !strings.HasPrefix(location, "/users/"+userID.String()+"/personal-data-exports/") {
+		t.Fatalf("Expected the export to be located, got '%s'", location)
+	}
+	status := waitForExport(t, location)
+	if status.Status != string(models.ExportStatusCompleted) {
+		t.Fatalf("Expected the export to complete, got %+v", status)
+	}
+
+	t.Run("archive", func(t *testing.T) {
+		status := waitForExport(t, location)
+		if !strings.HasPrefix(status.DownloadURL, "http://localhost:8081/personal-data-exports/") || time.Until(status.DownloadExpiresAt) > time.Minute {
+			t.Fatalf("Expected a short-lived download link, got %s until %s", status.DownloadURL, status.DownloadExpiresAt)
+		}
+
+		resp := download(t, status)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		if disposition := resp.Header.Get("Content-Disposition"); !strings.Contains(disposition, "personal-data-"+userID.String()) {
+			t.Errorf("Expected the archive to be an attachment, got '%s'", disposition)
+		}
+		var archive export.Archive
+		if err := json.NewDecoder(resp.Body).Decode(&archive); err != nil {
+			t.Fatalf("Failed to decode archive: %v", err)
+		}
+
+		manifest := archive.Manifest
+		if manifest.Format != export.Format || manifest.Version != export.Version || manifest.UserID != userID || manifest.ExportID != status.ID {
+			t.Errorf("Expected the manifest to describe the export, got %+v", manifest)
+		}
+		var names []string
+		for _, section := range manifest.Sections {
+			names = append(names, section.Name)
+			sum := sha256.Sum256(archive.Data[section.Name])
+			if hex.EncodeToString(sum[:]) != section.SHA256 {
+				t.Errorf("Expected the hash of %s to match its data", section.Name)
+			}
+		}
+		want := []string{"profile", "status_history", "audit_log", "access_log", "credentials", "sessions", "passkeys", "linked_identities", "consents"}
+		if !slices.Equal(names, want) {
+			t.Errorf("Expected sections %v, got %v", want, names)
+		}
+
+		var profile struct {
+			ID    uuid.UUID `json:"id"`
+			Email string    `json:"email"`
+			Name  string    `json:"name"`
+		}
+		if err := json.Unmarshal(archive.Data["profile"], &profile); err != nil {
+			t.Fatalf("Failed to decode profile: %v", err)
+		}
+		if profile.ID != userID || profile.Email != "exported@test.com" || profile.Name != "Milan" {
+			t.Errorf("Expected the profile unmasked, got %+v", profile)
+		}
+		for _, section := range manifest.Sections {
+			if section.Name == "audit_log" && section.Records == 0 || section.Name == "consents" && section.Records != 1 {
+				t.Errorf("Expected records in %s, got %d", section.Name, section.Records)
+			}
+		}
+	})
+
+	t.Run("links are single-use", func(t *testing.T) {
+		status := waitForExport(t, location)
+		if again := waitForExport(t, location); again.DownloadURL != status.DownloadURL || !again.DownloadExpiresAt.Equal(status.DownloadExpiresAt) {
+			t.Errorf("Expected polls to share the outstanding link, got %s and %s", status.DownloadURL, again.DownloadURL)
+		}
+		resp := download(t, status)
+		resp.Body.Close()
+		resp = download(t, status)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+
+		next := waitForExport(t, location)
+		if next.DownloadURL == status.DownloadURL {
+			t.Fatal("Expected a new link once the last one is used")
+		}
+		resp = download(t, next)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+	})
+
+	t.Run("interrupted exports fail", func(t *testing.T) {
+		// exports whose instance went away before finishing them
+		stale := func(status models.ExportStatus) *models.PersonalDataExport {
+			job := models.NewPersonalDataExport(userID, "test", "", time.Hour)
+			job.Status = status
+			job.CreatedAt = time.Now().Add(-time.Hour)
+			if err := suite.storage.CreatePersonalDataExport(job); err != nil {
+				t.Fatalf("Failed to create export: %v", err)
+			}
+			return job
+		}
+		polled := stale(models.ExportStatusRunning)
+		pending := stale(models.ExportStatusPending)
+		fresh := models.NewPersonalDataExport(userID, "test", "", time.Hour)
+		if err := suite.storage.CreatePersonalDataExport(fresh); err != nil {
+			t.Fatalf("Failed to create export: %v", err)
+		}
+
+		status := waitForExport(t, "/users/"+userID.String()+"/personal-data-exports/"+polled.ID.String())
+		if status.Status != string(models.ExportStatusFailed) || status.Error == "" || status.DownloadURL != "" {
+			t.Errorf("Expected the polled export to fail, got %+v", status)
+		}
+
+		if _, err := services.NewPrivacyService(context.Background(), suite.storage, suite.exporter, nil, suite.accessLog, suite.policies, &config.Config{}); err != nil {
+			t.Fatalf("Failed to start privacy service: %v", err)
+		}
+		for _, tt := range []struct {
+			job  *models.PersonalDataExport
+			want models.ExportStatus
+		}{{pending, models.ExportStatusFailed}, {fresh, models.ExportStatusPending}} {
+			job, err := suite.storage.RetrievePersonalDataExport(tt.job.ID)
+			if err != nil {
+				t.Fatalf("Failed to retrieve export: %v", err)
+			}
+			if job.Status != tt.want {
+				t.Errorf("Expected export created at %s to be %s on start, got %s", tt.job.CreatedAt, tt.want, job.Status)
+			}
+		}
+	})
+
+	t.Run("export is an access", func(t *testing.T) {
+		suite.accessLog.Flush()
+		records, err := suite.storage.RetrieveAccessRecords(userID, time.Time{}, 10)
+		if err != nil {
+			t.Fatalf("Failed to retrieve access records: %v", err)
+		}
+		if !slices.ContainsFunc(records, func(r models.AccessRecord) bool { return slices.Contains(r.Fields, "audit_log") }) {
+			t.Errorf("Expected the export to be recorded, got %+v", records)
+		}
+	})
+
+	t.Run("access", func(t *testing.T) {
+		admin, err := suite.apiKeys.IssueAPIKey(systemContext(), services.APIKeyRequest{Name: "admin", Scopes: []models.Scope{models.ScopeUsersAdmin}})
+		if err != nil {
+			t.Fatalf("Failed to issue API key: %v", err)
+		}
+		reader, err := suite.apiKeys.IssueAPIKey(systemContext(), services.APIKeyRequest{Name: "reader", Scopes: []models.Scope{models.ScopeUsersRead, models.ScopeUsersPII}})
+		if err != nil {
+			t.Fatalf("Failed to issue API key: %v", err)
+		}
+
+		cases := []struct {
+			name   string
+			target string
+			key    string
+			status int
+		}{
+			{"without the PII scope", exportURL, admin.Secret, http.StatusForbidden},
+			{"without the admin scope", exportURL, reader.Secret, http.StatusForbidden},
+			{"unknown user", suite.httpSrv.URL + "/users/" + uuid.NewString() + ":export-personal-data", "", http.StatusNotFound},
+			{"unknown method", suite.httpSrv.URL + "/users/" + userID.String() + ":export-everything", "", http.StatusNotFound},
+			{"no method", suite.httpSrv.URL + "/users/" + userID.String(), "", http.StatusNotFound},
+		}
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				resp := start(t, tt.target, tt.key)
+				resp.Body.Close()
+				if resp.StatusCode != tt.status {
+					t.Errorf("Expected status %d, got %d", tt.status, resp.StatusCode)
+				}
+			})
+		}
+
+		// exports are only found under their user
+		resp := suite.makeJSONRequest(t, "GET", suite.httpSrv.URL+strings.Replace(location, userID.String(), other.String(), 1), nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status %d for another user, got %d", http.StatusNotFound, resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,316 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"testing"
+	"time"
+	"users-microservice/pkg/api"
+
+	"github.com/google/uuid"
+)
+
+func TestFederatedLogin(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	// devices keep the nonce cookie and stop at the redirect back to the
+	// application like a browser handing the parameters to the page would
+	newDevice := func(t *testing.T) *http.Client {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatalf("Failed to create cookie jar: %v", err)
+		}
+		return &http.Client{Jar: jar, Timeout: suite.Client.Timeout, Transport: suite.Client.Transport, CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+	}
+	post := func(t *testing.T, device *http.Client, url string, payload any) *http.Response {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("Failed to encode payload: %v", err)
+		}
+		resp, err := device.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		return resp
+	}
+	// authorize starts at the given endpoint and returns what the issuer
+	// redirected back with
+	authorize := func(t *testing.T, device *http.Client, startURL string, issuer *MockIssuer, identity MockIdentity) api.FederatedCallbackAPI {
+		issuer.SignInAs(identity)
+		resp := post(t, device, startURL, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to start sign-in: Status=%d", resp.StatusCode)
+		}
+		var start api.AuthorizationURLAPI
+		decodeResponseData(t, resp, &start)
+		resp.Body.Close()
+
+		resp, err := device.Get(start.AuthorizationURL)
+		if err != nil {
+			t.Fatalf("Failed to reach issuer: %v", err)
+		}
+		resp.Body.Close()
+		location, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil || resp.StatusCode != http.StatusFound {
+			t.Fatalf("Expected a redirect from the issuer, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+		}
+		return api.FederatedCallbackAPI{Code: location.Query().Get("code"), State: location.Query().Get("state")}
+	}
+	signIn := func(t *testing.T, provider string, issuer *MockIssuer, identity MockIdentity) (int, api.LoginResponseAPI) {
+		device := newDevice(t)
+		loginURL := suite.httpSrv.URL + "/auth/federated/" + provider
+		callback := authorize(t, device, loginURL, issuer, identity)
+
+		resp := post(t, device, loginURL+"/callback", callback)
+		defer resp.Body.Close()
+		var login api.LoginResponseAPI
+		if resp.StatusCode == http.StatusOK {
+			decodeResponseData(t, resp, &login)
+		}
+		return resp.StatusCode, login
+	}
+	listIdentities := func(t *testing.T, userID uuid.UUID) []api.FederatedIdentityAPI {
+		resp := suite.makeGETRequest(t, suite.httpSrv.URL+"/users/"+userID.String()+"/identities")
+		defer resp.Body.Close()
+		var identities []api.FederatedIdentityAPI
+		decodeResponseData(t, resp, &identities)
+		return identities
+	}
+
+	t.Run("providers", func(t *testing.T) {
+		resp := suite.makeGETRequest(t, suite.httpSrv.URL+"/auth/federated")
+		defer resp.Body.Close()
+
+		var providers api.FederationProvidersAPI
+		decodeResponseData(t, resp, &providers)
+		if len(providers.Providers) != 2 || providers.Providers[0] != "mock" || providers.Providers[1] != "strict" {
+			t.Errorf("Expected mock and strict, got %v", providers.Providers)
+		}
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/auth/federated/nope", nil)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, resp.StatusCode)
+		}
+	})
+
+	newcomer := MockIdentity{Subject: "newcomer", Email: "newcomer@test.com", EmailVerified: true, Name: "New Comer"}
+	var newcomerID uuid.UUID
+
+	t.Run("unknown identity signs up", func(t *testing.T) {
+		status, login := signIn(t, "mock", suite.issuer, newcomer)
+		if status != http.StatusOK || login.Signup == nil {
+			t.Fatalf("Expected a signup, got %d %+v", status, login)
+		}
+		if login.Signup.Email != newcomer.Email || login.Signup.Name != newcomer.Name || login.SessionTokensAPI != nil {
+			t.Errorf("Unexpected signup: %+v", login.Signup)
+		}
+
+		signupURL := suite.httpSrv.URL + "/auth/federated/signup"
+		resp := suite.makeJSONRequest(t, "POST", signupURL, api.FederatedSignupAPI{SignupToken: login.Signup.SignupToken, DateOfBirth: time.Now().AddDate(-30, 0, 0)})
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+		}
+		var created api.LoginResponseAPI
+		decodeResponseData(t, resp, &created)
+		resp.Body.Close()
+		if created.AccessToken == "" || created.User.Name != newcomer.Name || created.User.Status != "active" || created.User.EmailVerifiedAt == nil {
+			t.Errorf("Expected an active verified user with a session, got %+v", created.User)
+		}
+		newcomerID = created.User.ID
+
+		resp = suite.makeJSONRequest(t, "POST", signupURL, api.FederatedSignupAPI{SignupToken: login.Signup.SignupToken, DateOfBirth: time.Now().AddDate(-30, 0, 0)})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected used signup token to be rejected with %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+	})
+
+	t.Run("linked identity signs in", func(t *testing.T) {
+		status, login := signIn(t, "mock", suite.issuer, newcomer)
+		if status != http.StatusOK || login.User == nil || login.User.ID != newcomerID {
+			t.Fatalf("Expected a session for %s, got %d %+v", newcomerID, status, login)
+		}
+		if identities := listIdentities(t, newcomerID); len(identities) != 1 || identities[0].LastUsedAt == nil {
+			t.Errorf("Expected one used identity, got %+v", identities)
+		}
+	})
+
+	t.Run("signup checks the age", func(t *testing.T) {
+		_, login := signIn(t, "mock", suite.issuer, MockIdentity{Subject: "young", Email: "young@test.com", EmailVerified: true, Name: "Young"})
+		resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/auth/federated/signup", api.FederatedSignupAPI{SignupToken: login.Signup.SignupToken, DateOfBirth: time.Now().AddDate(-5, 0, 0)})
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+	})
+
+	t.Run("callback on another device", func(t *testing.T) {
+		loginURL := suite.httpSrv.URL + "/auth/federated/mock"
+		callback := authorize(t, newDevice(t), loginURL, suite.issuer, newcomer)
+
+		resp := post(t, newDevice(t), loginURL+"/callback", callback)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	t.Run("state of another provider", func(t *testing.T) {
+		device := newDevice(t)
+		callback := authorize(t, device, suite.httpSrv.URL+"/auth/federated/mock", suite.issuer, newcomer)
+
+		resp := post(t, device, suite.httpSrv.URL+"/auth/federated/strict/callback", callback)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+	})
+
+	t.Run("unverified email cannot sign up", func(t *testing.T) {
+		status, _ := signIn(t, "mock", suite.issuer, MockIdentity{Subject: "unverified", Email: "unverified@test.com", Name: "Unverified"})
+		if status != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, status)
+		}
+	})
+
+	email := "federated@test.com"
+	userID := suite.createActiveTestUser(t, email)
+	mockIdentity := MockIdentity{Subject: "mock-subject", Email: email, EmailVerified: true, Name: "Milan"}
+	strictIdentity := MockIdentity{Subject: "strict-subject", Email: email, EmailVerified: true, Name: "Milan"}
+
+	linkPolicyCases := []struct {
+		name     string
+		provider string
+		issuer   *MockIssuer
+		identity MockIdentity
+		wantCode int
+	}{
+		{name: "unverified email at the provider", provider: "mock", issuer: suite.issuer, identity: MockIdentity{Subject: "other", Email: email, Name: "Milan"}, wantCode: http.StatusConflict},
+		{name: "provider that never links", provider: "strict", issuer: suite.strictIssuer, identity: strictIdentity, wantCode: http.StatusConflict},
+		{name: "verified email links", provider: "mock", issuer: suite.issuer, identity: mockIdentity, wantCode: http.StatusOK},
+	}
+
+	for _, tc := range linkPolicyCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, login := signIn(t, tc.provider, tc.issuer, tc.identity)
+			if status != tc.wantCode {
+				t.Fatalf("Test '%s': Expected status %d, got %d", tc.name, tc.wantCode, status)
+			}
+			if status == http.StatusOK && login.User.ID != userID {
+				t.Errorf("Test '%s': Expected a session for %s, got %s", tc.name, userID, login.User.ID)
+			}
+		})
+	}
+
+	t.Run("unverified account is not linked", func(t *testing.T) {
+		pendingEmail := "pending-federated@test.com"
+		suite.createTestUser(t, pendingEmail)
+
+		status, _ := signIn(t, "mock", suite.issuer, MockIdentity{Subject: "pending", Email: pendingEmail, EmailVerified: true, Name: "Milan"})
+		if status != http.StatusConflict {
+			t.Errorf("Expected status %d, got %d", http.StatusConflict, status)
+		}
+	})
+
+	t.Run("suspended account is not linked", func(t *testing.T) {
+		suspendedEmail := "suspended-federated@test.com"
+		suspendedID := suite.createActiveTestUser(t, suspendedEmail)
+		resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/users/"+suspendedID.String()+"/suspend", api.StatusChangeAPI{Reason: "fraud investigation"})
+		resp.Body.Close()
+
+		status, _ := signIn(t, "mock", suite.issuer, MockIdentity{Subject: "suspended", Email: suspendedEmail, EmailVerified: true, Name: "Milan"})
+		if status != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, status)
+		}
+		if identities := listIdentities(t, suspendedID); len(identities) != 0 {
+			t.Errorf("Expected no identity linked, got %+v", identities)
+		}
+	})
+
+	linkURL := suite.httpSrv.URL + "/users/" + userID.String() + "/identities/strict"
+
+	t.Run("link another provider", func(t *testing.T) {
+		callback := authorize(t, newDevice(t), linkURL, suite.strictIssuer, strictIdentity)
+		resp := suite.makeJSONRequest(t, "POST", linkURL+"/callback", callback)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+		}
+		identities := listIdentities(t, userID)
+		if len(identities) != 2 || identities[0].Provider != "mock" || identities[1].Provider != "strict" {
+			t.Errorf("Expected mock and strict identities, got %+v", identities)
+		}
+
+		status, login := signIn(t, "strict", suite.strictIssuer, strictIdentity)
+		if status != http.StatusOK || login.User.ID != userID {
+			t.Errorf("Expected a session for %s, got %d", userID, status)
+		}
+	})
+
+	newcomerLinkURL := suite.httpSrv.URL + "/users/" + newcomerID.String() + "/identities/strict"
+	linkCases := []struct {
+		name     string
+		linkURL  string
+		identity MockIdentity
+		wantCode int
+	}{
+		{name: "already linked", linkURL: linkURL, identity: strictIdentity, wantCode: http.StatusConflict},
+		{name: "second account of a provider", linkURL: linkURL, identity: MockIdentity{Subject: "strict-second"}, wantCode: http.StatusConflict},
+		{name: "identity of another user", linkURL: newcomerLinkURL, identity: strictIdentity, wantCode: http.StatusConflict},
+		{name: "multiple providers per user", linkURL: newcomerLinkURL, identity: MockIdentity{Subject: "newcomer-strict"}, wantCode: http.StatusCreated},
+	}
+
+	for _, tc := range linkCases {
+		t.Run(tc.name, func(t *testing.T) {
+			callback := authorize(t, newDevice(t), tc.linkURL, suite.strictIssuer, tc.identity)
+			resp := suite.makeJSONRequest(t, "POST", tc.linkURL+"/callback", callback)
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.wantCode {
+				t.Errorf("Test '%s': Expected status %d, got %d", tc.name, tc.wantCode, resp.StatusCode)
+			}
+		})
+	}
+
+	t.Run("link state belongs to the user", func(t *testing.T) {
+		callback := authorize(t, newDevice(t), linkURL, suite.strictIssuer, MockIdentity{Subject: "stolen"})
+		resp := suite.makeJSONRequest(t, "POST", newcomerLinkURL+"/callback", callback)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+	})
+
+	t.Run("unlink", func(t *testing.T) {
+		strict := listIdentities(t, userID)[1]
+		resp := suite.makeJSONRequest(t, "DELETE", suite.httpSrv.URL+"/users/"+userID.String()+"/identities/"+strict.ID.String(), nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+
+		// strict never links by email, so the account is out of reach again
+		if status, _ := signIn(t, "strict", suite.strictIssuer, strictIdentity); status != http.StatusConflict {
+			t.Errorf("Expected status %d, got %d", http.StatusConflict, status)
+		}
+
+		resp = suite.makeJSONRequest(t, "DELETE", suite.httpSrv.URL+"/users/"+userID.String()+"/identities/"+strict.ID.String(), nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,288 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+	"users-microservice/pkg/api"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/jwtauth"
+	"users-microservice/pkg/lockout"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/storage"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// gatewayClaims are the claims of a token the gateway issues
+type gatewayClaims struct {
+	Scope string   `json:"scope,omitempty"`
+	SCP   []string `json:"scp,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func newGatewayClaims(subject string, scope string) gatewayClaims {
+	now := time.Now()
+	return gatewayClaims{
+		Scope: scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    mockGatewayIssuer,
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{mockGatewayAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+	}
+}
+
+func TestBearerTokens(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	userID := suite.createActiveTestUser(t, "bearer@test.com")
+	userPath := "/" + userID.String()
+
+	sign := func(t *testing.T, method jwt.SigningMethod, claims jwt.Claims) string {
+		t.Helper()
+		token, err := suite.gateway.Sign(method, claims)
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		return token
+	}
+	get := func(t *testing.T, baseURL string, token string) int {
+		t.Helper()
+		req, err := http.NewRequest("GET", baseURL+userPath, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("signing algorithms", func(t *testing.T) {
+		for _, method := range []jwt.SigningMethod{jwt.SigningMethodRS256, jwt.SigningMethodES256, jwt.SigningMethodEdDSA} {
+			t.Run(method.Alg(), func(t *testing.T) {
+				token := sign(t, method, newGatewayClaims("reporting-service", "users:read"))
+				if status := get(t, suite.httpSrv.URL, token); status != http.StatusOK {
+					t.Fatalf("Expected status %d, got %d", http.StatusOK, status)
+				}
+			})
+		}
+	})
+
+	t.Run("invalid tokens", func(t *testing.T) {
+		hmacToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, newGatewayClaims("reporting-service", "users:read")).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, newGatewayClaims("reporting-service", "users:read")).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		tampered := sign(t, jwt.SigningMethodES256, newGatewayClaims("reporting-service", "users:read"))
+		tampered = tampered[:len(tampered)-4] + "AAAA"
+
+		cases := []struct {
+			name  string
+			token string
+		}{
+			{"HMAC", hmacToken},
+			{"unsigned", unsigned},
+			{"tampered", tampered},
+			{"malformed", "not.a.token"},
+			{"other issuer", sign(t, jwt.SigningMethodRS256, func() jwt.Claims {
+				claims := newGatewayClaims("reporting-service", "users:read")
+				claims.Issuer = "https://elsewhere.test"
+				return claims
+			}())},
+			{"other audience", sign(t, jwt.SigningMethodRS256, func() jwt.Claims {
+				claims := newGatewayClaims("reporting-service", "users:read")
+				claims.Audience = jwt.ClaimStrings{"billing"}
+				return claims
+			}())},
+			{"expired", sign(t, jwt.SigningMethodRS256, func() jwt.Claims {
+				claims := newGatewayClaims("reporting-service", "users:read")
+				claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+				return claims
+			}())},
+			{"without expiry", sign(t, jwt.SigningMethodRS256, func() jwt.Claims {
+				claims := newGatewayClaims("reporting-service", "users:read")
+				claims.ExpiresAt = nil
+				return claims
+			}())},
+			{"not yet valid", sign(t, jwt.SigningMethodRS256, func() jwt.Claims {
+				claims := newGatewayClaims("reporting-service", "users:read")
+				claims.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Minute))
+				return claims
+			}())},
+			{"without subject", sign(t, jwt.SigningMethodRS256, newGatewayClaims("", "users:read"))},
+			// neither a user ID nor a configured service
+			{"unknown subject", sign(t, jwt.SigningMethodRS256, newGatewayClaims("auth0|123", "users:admin"))},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				if status := get(t, suite.httpSrv.URL, tc.token); status != http.StatusUnauthorized {
+					t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, status)
+				}
+			})
+		}
+	})
+
+	t.Run("clock skew", func(t *testing.T) {
+		claims := newGatewayClaims("reporting-service", "users:read")
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-10 * time.Second))
+		claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(10 * time.Second))
+		if status := get(t, suite.httpSrv.URL, sign(t, jwt.SigningMethodES256, claims)); status != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, status)
+		}
+	})
+
+	t.Run("scopes", func(t *testing.T) {
+		cases := []struct {
+			name     string
+			claims   gatewayClaims
+			expected int
+		}{
+			{"scope claim", newGatewayClaims("reporting-service", "openid users:write"), http.StatusOK},
+			{"scp claim", func() gatewayClaims {
+				claims := newGatewayClaims("reporting-service", "")
+				claims.SCP = []string{"users:read"}
+				return claims
+			}(), http.StatusOK},
+			{"mapped scope", newGatewayClaims("reporting-service", "profiles.read"), http.StatusOK},
+			{"unrelated scope", newGatewayClaims("reporting-service", "billing:read"), http.StatusForbidden},
+			{"no scope", newGatewayClaims("reporting-service", ""), http.StatusForbidden},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				if status := get(t, suite.httpSrv.URL, sign(t, jwt.SigningMethodEdDSA, tc.claims)); status != tc.expected {
+					t.Fatalf("Expected status %d, got %d", tc.expected, status)
+				}
+			})
+		}
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		before := sign(t, jwt.SigningMethodRS256, newGatewayClaims("reporting-service", "users:read"))
+		if err := suite.gateway.Rotate(); err != nil {
+			t.Fatalf("Failed to rotate keys: %v", err)
+		}
+		after := sign(t, jwt.SigningMethodRS256, newGatewayClaims("reporting-service", "users:read"))
+
+		if status := get(t, suite.httpSrv.URL, after); status != http.StatusOK {
+			t.Fatalf("Expected status %d with the new key, got %d", http.StatusOK, status)
+		}
+		if status := get(t, suite.httpSrv.URL, before); status != http.StatusUnauthorized {
+			t.Fatalf("Expected status %d with the retired key, got %d", http.StatusUnauthorized, status)
+		}
+	})
+
+	t.Run("key set file", func(t *testing.T) {
+		keySetPath := filepath.Join(t.TempDir(), "jwks.json")
+		writeKeySet := func(t *testing.T) {
+			t.Helper()
+			payload, err := json.Marshal(suite.gateway.KeySet())
+			if err != nil {
+				t.Fatalf("Failed to encode key set: %v", err)
+			}
+			if err := os.WriteFile(keySetPath, payload, 0o600); err != nil {
+				t.Fatalf("Failed to write key set: %v", err)
+			}
+		}
+		writeKeySet(t)
+
+		validation := config.JWTValidation{JWKSFile: keySetPath, JWKSRefresh: time.Hour, Issuer: mockGatewayIssuer, Audience: mockGatewayAudience, ServiceSubjects: []string{"reporting-service"}}
+		guard := lockout.NewGuard(storage.NewMemoryFailureCounterStorage(), lockout.SourcePolicy(&config.Config{}))
+		server := httptest.NewServer(api.NewAPIServer(":0", api.Dependencies{Users: suite.service, Auth: suite.auth, APIKeys: suite.apiKeys, TokenVerifier: jwtauth.NewVerifier(&validation, nil), SourceGuard: guard}, &config.Config{}).Router())
+		defer server.Close()
+
+		token := sign(t, jwt.SigningMethodEdDSA, newGatewayClaims("reporting-service", "users:read"))
+		if status := get(t, server.URL, token); status != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, status)
+		}
+
+		if err := suite.gateway.Rotate(); err != nil {
+			t.Fatalf("Failed to rotate keys: %v", err)
+		}
+		writeKeySet(t)
+		token = sign(t, jwt.SigningMethodEdDSA, newGatewayClaims("reporting-service", "users:read"))
+		if status := get(t, server.URL, token); status != http.StatusOK {
+			t.Fatalf("Expected status %d after rotation, got %d", http.StatusOK, status)
+		}
+	})
+
+	t.Run("unreachable key set", func(t *testing.T) {
+		validation := config.JWTValidation{JWKSURL: "http://127.0.0.1:1/jwks", JWKSRefresh: time.Hour, Issuer: mockGatewayIssuer, Audience: mockGatewayAudience, ServiceSubjects: []string{"reporting-service"}}
+		guard := lockout.NewGuard(storage.NewMemoryFailureCounterStorage(), lockout.SourcePolicy(&config.Config{}))
+		server := httptest.NewServer(api.NewAPIServer(":0", api.Dependencies{Users: suite.service, Auth: suite.auth, APIKeys: suite.apiKeys, TokenVerifier: jwtauth.NewVerifier(&validation, &http.Client{Timeout: time.Second}), SourceGuard: guard}, &config.Config{}).Router())
+		defer server.Close()
+
+		token := sign(t, jwt.SigningMethodES256, newGatewayClaims("reporting-service", "users:read"))
+		if status := get(t, server.URL, token); status != http.StatusInternalServerError {
+			t.Fatalf("Expected status %d, got %d", http.StatusInternalServerError, status)
+		}
+	})
+
+	t.Run("failed reads are throttled", func(t *testing.T) {
+		var reads atomic.Int32
+		keySet := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reads.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer keySet.Close()
+
+		validation := config.JWTValidation{JWKSURL: keySet.URL, JWKSRefresh: time.Hour, JWKSMinRefresh: time.Hour, Issuer: mockGatewayIssuer, Audience: mockGatewayAudience, ServiceSubjects: []string{"reporting-service"}}
+		guard := lockout.NewGuard(storage.NewMemoryFailureCounterStorage(), lockout.SourcePolicy(&config.Config{}))
+		server := httptest.NewServer(api.NewAPIServer(":0", api.Dependencies{Users: suite.service, Auth: suite.auth, APIKeys: suite.apiKeys, TokenVerifier: jwtauth.NewVerifier(&validation, &http.Client{Timeout: time.Second}), SourceGuard: guard}, &config.Config{}).Router())
+		defer server.Close()
+
+		token := sign(t, jwt.SigningMethodES256, newGatewayClaims("reporting-service", "users:read"))
+		for range 3 {
+			if status := get(t, server.URL, token); status != http.StatusInternalServerError {
+				t.Fatalf("Expected status %d, got %d", http.StatusInternalServerError, status)
+			}
+		}
+		if reads.Load() != 1 {
+			t.Errorf("Expected the key set to be read once, got %d reads", reads.Load())
+		}
+	})
+
+	t.Run("scope mapping is not written to", func(t *testing.T) {
+		keySetPath := filepath.Join(t.TempDir(), "jwks.json")
+		payload, err := json.Marshal(suite.gateway.KeySet())
+		if err != nil {
+			t.Fatalf("Failed to encode key set: %v", err)
+		}
+		if err := os.WriteFile(keySetPath, payload, 0o600); err != nil {
+			t.Fatalf("Failed to write key set: %v", err)
+		}
+		// spare capacity in the mapping is what concurrent requests would
+		// write into
+		mapped := make([]models.Scope, 1, 4)
+		mapped[0] = models.ScopeUsersRead
+		validation := config.JWTValidation{JWKSFile: keySetPath, JWKSRefresh: time.Hour, Issuer: mockGatewayIssuer, Audience: mockGatewayAudience, ServiceSubjects: []string{"reporting-service"}, ScopeMapping: map[string][]models.Scope{"users:write": mapped}}
+
+		identity, err := jwtauth.NewVerifier(&validation, nil).Verify(context.Background(), sign(t, jwt.SigningMethodEdDSA, newGatewayClaims("reporting-service", "users:write")))
+		if err != nil {
+			t.Fatalf("Failed to verify token: %v", err)
+		}
+		if len(identity.Scopes) != 2 {
+			t.Errorf("Expected the mapped and the granted scope, got %v", identity.Scopes)
+		}
+		if spare := mapped[:2][1]; spare != "" {
+			t.Errorf("Expected the mapping to stay as configured, found %s written after it", spare)
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package integration
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+	"users-microservice/pkg/api"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/events"
+	"users-microservice/pkg/lockout"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/storage"
+)
+
+func TestAccountLockout(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	email := "lockout@test.com"
+	userID := suite.createActiveTestUser(t, email)
+	usersURL := suite.httpSrv.URL + "/users/" + userID.String()
+
+	resp := suite.makeJSONRequest(t, "POST", usersURL+"/password", api.PasswordAPI{Password: "lockout horse battery"})
+	resp.Body.Close()
+
+	login := func(t *testing.T, email, password string) *http.Response {
+		resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/auth/login", api.LoginAPI{Email: email, Password: password})
+		resp.Body.Close()
+		return resp
+	}
+	expectStatus := func(t *testing.T, resp *http.Response, expected int) {
+		t.Helper()
+		if resp.StatusCode != expected {
+			t.Fatalf("Expected status %d, got %d", expected, resp.StatusCode)
+		}
+	}
+
+	t.Run("success forgets failures", func(t *testing.T) {
+		expectStatus(t, login(t, email, "wrong horse battery"), http.StatusUnauthorized)
+		expectStatus(t, login(t, email, "wrong horse battery"), http.StatusUnauthorized)
+		expectStatus(t, login(t, email, "lockout horse battery"), http.StatusOK)
+		expectStatus(t, login(t, email, "wrong horse battery"), http.StatusUnauthorized)
+		expectStatus(t, login(t, email, "wrong horse battery"), http.StatusUnauthorized)
+		expectStatus(t, login(t, email, "lockout horse battery"), http.StatusOK)
+	})
+
+	t.Run("too many failures lock the account", func(t *testing.T) {
+		for range 3 {
+			expectStatus(t, login(t, email, "wrong horse battery"), http.StatusUnauthorized)
+		}
+
+		resp := login(t, email, "lockout horse battery")
+		expectStatus(t, resp, http.StatusTooManyRequests)
+		if retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After")); retryAfter <= 0 {
+			t.Errorf("Expected a Retry-After header, got %q", resp.Header.Get("Retry-After"))
+		}
+
+		resp = suite.makeJSONRequest(t, "POST", usersURL+"/password/change", api.PasswordChangeAPI{CurrentPassword: "lockout horse battery", NewPassword: "another horse battery"})
+		resp.Body.Close()
+		expectStatus(t, resp, http.StatusTooManyRequests)
+	})
+
+	t.Run("unknown emails lock the same way", func(t *testing.T) {
+		for range 3 {
+			expectStatus(t, login(t, "nobody@test.com", "wrong horse battery"), http.StatusUnauthorized)
+		}
+		expectStatus(t, login(t, "nobody@test.com", "wrong horse battery"), http.StatusTooManyRequests)
+	})
+
+	t.Run("admin unlock", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "POST", usersURL+"/unlock", api.StatusChangeAPI{})
+		resp.Body.Close()
+		expectStatus(t, resp, http.StatusBadRequest)
+
+		resp = suite.makeJSONRequest(t, "POST", usersURL+"/unlock", api.StatusChangeAPI{Reason: "verified by phone"})
+		resp.Body.Close()
+		expectStatus(t, resp, http.StatusOK)
+
+		expectStatus(t, login(t, email, "lockout horse battery"), http.StatusOK)
+	})
+
+	t.Run("history records lockout and unlock", func(t *testing.T) {
+		resp := suite.makeGETRequest(t, usersURL+"/history")
+		defer resp.Body.Close()
+
+		var history []api.UserHistoryEntryAPI
+		decodeResponseData(t, resp, &history)
+		events := map[string]bool{}
+		for _, entry := range history {
+			events[entry.Event] = true
+		}
+		if !events["account_locked"] || !events["account_unlocked"] {
+			t.Errorf("Expected lockout and unlock in history, got %+v", history)
+		}
+	})
+
+	t.Run("lockout is published", func(t *testing.T) {
+		if err := suite.events.PublishPending(t.Context()); err != nil {
+			t.Fatalf("Failed to publish events: %v", err)
+		}
+		messages, err := events.ReadOutbox(suite.eventsOutbox)
+		if err != nil {
+			t.Fatalf("Failed to read events outbox: %v", err)
+		}
+		// unknown emails are locked out without an event
+		if len(messages) != 1 || messages[0].Type != models.EventAccountLockedOut || messages[0].UserID != userID {
+			t.Fatalf("Expected one lockout event, got %+v", messages)
+		}
+	})
+}
+
+func TestSourceBackoff(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	// the second failure past the free ones would wait two hours
+	policy := lockout.Policy{
+		FreeAttempts:     2,
+		BaseDelay:        time.Hour,
+		MaxDelay:         2 * time.Hour,
+		LockoutThreshold: 10,
+		LockoutDuration:  24 * time.Hour,
+		ResetAfter:       24 * time.Hour,
+	}
+	guard := lockout.NewGuard(storage.NewMemoryFailureCounterStorage(), policy)
+	server := httptest.NewServer(api.NewAPIServer(":0", api.Dependencies{Users: suite.service, Auth: suite.auth, SourceGuard: guard}, &config.Config{}).Router())
+	defer server.Close()
+
+	for range 3 {
+		resp := suite.makeJSONRequest(t, "POST", server.URL+"/users/verify-email", api.TokenAPI{Token: "guessed"})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+
+	resp := suite.makeJSONRequest(t, "POST", server.URL+"/auth/login", api.LoginAPI{Email: "source@test.com", Password: "any horse battery"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected status %d, got %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+	if retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After")); retryAfter <= 0 || retryAfter > 3600 {
+		t.Errorf("Expected to retry within the base delay, got %q", resp.Header.Get("Retry-After"))
+	}
+
+	if policy.Delay(4) != 2*time.Hour || policy.Delay(5) != 2*time.Hour || policy.Delay(10) != 24*time.Hour {
+		t.Errorf("Unexpected backoff %v %v %v", policy.Delay(4), policy.Delay(5), policy.Delay(10))
+	}
+}
@@ -0,0 +1,120 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"testing"
+	"users-microservice/pkg/api"
+)
+
+func TestMagicLink(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	requestURL := suite.httpSrv.URL + "/auth/magic-link"
+	consumeURL := suite.httpSrv.URL + "/auth/magic-link/consume"
+
+	newDevice := func(t *testing.T) *http.Client {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatalf("Failed to create cookie jar: %v", err)
+		}
+		return &http.Client{Jar: jar, Timeout: suite.Client.Timeout, Transport: suite.Client.Transport}
+	}
+	post := func(t *testing.T, device *http.Client, url string, payload any) *http.Response {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("Failed to encode payload: %v", err)
+		}
+		resp, err := device.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		return resp
+	}
+	requestLink := func(t *testing.T, device *http.Client, email string) {
+		resp := post(t, device, requestURL, api.MagicLinkAPI{Email: email})
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("Expected status %d, got %d", http.StatusAccepted, resp.StatusCode)
+		}
+		if len(resp.Cookies()) != 1 || resp.Cookies()[0].Value == "" || !resp.Cookies()[0].HttpOnly {
+			t.Fatalf("Expected an HttpOnly nonce cookie, got %+v", resp.Cookies())
+		}
+	}
+
+	t.Run("unknown email looks the same", func(t *testing.T) {
+		requestLink(t, newDevice(t), "nobody@test.com")
+	})
+
+	t.Run("link signs in once on the requesting device", func(t *testing.T) {
+		email := "magic@test.com"
+		userID := suite.createActiveTestUser(t, email)
+		device := newDevice(t)
+
+		sent := len(suite.mailedTo(t, email))
+		requestLink(t, device, email)
+		token := suite.awaitMailedToken(t, email, sent)
+
+		resp := post(t, device, consumeURL, api.TokenAPI{Token: token})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		var loginResponse api.LoginResponseAPI
+		decodeResponseData(t, resp, &loginResponse)
+		resp.Body.Close()
+		if loginResponse.AccessToken == "" || loginResponse.User.ID != userID {
+			t.Errorf("Expected a session, got %+v", loginResponse)
+		}
+
+		resp = post(t, device, consumeURL, api.TokenAPI{Token: token})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected replay to be rejected with %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+	})
+
+	t.Run("link does not work on another device", func(t *testing.T) {
+		email := "magic-elsewhere@test.com"
+		suite.createActiveTestUser(t, email)
+		device := newDevice(t)
+
+		sent := len(suite.mailedTo(t, email))
+		requestLink(t, device, email)
+		token := suite.awaitMailedToken(t, email, sent)
+
+		resp := post(t, newDevice(t), consumeURL, api.TokenAPI{Token: token})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+		}
+
+		// the attempt used the link up
+		resp = post(t, device, consumeURL, api.TokenAPI{Token: token})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected used link to be rejected with %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+	})
+
+	t.Run("asking again keeps the link working", func(t *testing.T) {
+		email := "magic-twice@test.com"
+		suite.createActiveTestUser(t, email)
+		device := newDevice(t)
+
+		sent := len(suite.mailedTo(t, email))
+		requestLink(t, device, email)
+		token := suite.awaitMailedToken(t, email, sent)
+		// throttled, no new link is sent
+		requestLink(t, device, email)
+
+		resp := post(t, device, consumeURL, api.TokenAPI{Token: token})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+	})
+}
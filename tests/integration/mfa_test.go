@@ -0,0 +1,152 @@
+package integration
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+	"users-microservice/pkg/api"
+	"users-microservice/pkg/totp"
+)
+
+func TestMFA(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	email := "mfa@test.com"
+	userID := suite.createActiveTestUser(t, email)
+	mfaURL := suite.httpSrv.URL + "/users/" + userID.String() + "/mfa"
+
+	resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/users/"+userID.String()+"/password", api.PasswordAPI{Password: "mfa horse battery"})
+	resp.Body.Close()
+
+	login := func(t *testing.T) api.LoginResponseAPI {
+		resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/auth/login", api.LoginAPI{Email: email, Password: "mfa horse battery"})
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to login: Status=%d", resp.StatusCode)
+		}
+		var loginResponse api.LoginResponseAPI
+		decodeResponseData(t, resp, &loginResponse)
+		return loginResponse
+	}
+	completeLogin := func(t *testing.T, request api.MFALoginAPI) (int, api.LoginResponseAPI) {
+		resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/auth/login/mfa", request)
+		defer resp.Body.Close()
+
+		var loginResponse api.LoginResponseAPI
+		if resp.StatusCode == http.StatusOK {
+			decodeResponseData(t, resp, &loginResponse)
+		}
+		return resp.StatusCode, loginResponse
+	}
+
+	resp = suite.makeJSONRequest(t, "POST", mfaURL+"/totp", nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Failed to enroll: Status=%d", resp.StatusCode)
+	}
+	var enrollment api.TOTPEnrollmentAPI
+	decodeResponseData(t, resp, &enrollment)
+	resp.Body.Close()
+
+	uri, err := url.Parse(enrollment.OTPAuthURI)
+	if err != nil || uri.Scheme != "otpauth" || uri.Query().Get("secret") != enrollment.Secret {
+		t.Fatalf("Unexpected otpauth URI %q", enrollment.OTPAuthURI)
+	}
+
+	t.Run("login is unaffected until confirmed", func(t *testing.T) {
+		if response := login(t); response.MFARequired || response.AccessToken == "" {
+			t.Errorf("Expected a session without MFA, got %+v", response)
+		}
+	})
+
+	t.Run("wrong code does not confirm", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "POST", mfaURL+"/totp/confirm", api.TOTPCodeAPI{Code: "000000"})
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	now := time.Now()
+	code, err := totp.Code(enrollment.Secret, totp.Step(now))
+	if err != nil {
+		t.Fatalf("Failed to generate code: %v", err)
+	}
+	resp = suite.makeJSONRequest(t, "POST", mfaURL+"/totp/confirm", api.TOTPCodeAPI{Code: code})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to confirm: Status=%d", resp.StatusCode)
+	}
+	var recovery api.RecoveryCodesAPI
+	decodeResponseData(t, resp, &recovery)
+	resp.Body.Close()
+
+	if len(recovery.RecoveryCodes) != 10 {
+		t.Fatalf("Expected 10 recovery codes, got %d", len(recovery.RecoveryCodes))
+	}
+
+	t.Run("login requires the second factor", func(t *testing.T) {
+		challenge := login(t)
+		if !challenge.MFARequired || challenge.MFAToken == "" || challenge.SessionTokensAPI != nil {
+			t.Fatalf("Expected an MFA challenge, got %+v", challenge)
+		}
+
+		// the confirmation already used the current step
+		if status, _ := completeLogin(t, api.MFALoginAPI{MFAToken: challenge.MFAToken, Code: code}); status != http.StatusUnauthorized {
+			t.Errorf("Expected used code to be rejected with %d, got %d", http.StatusUnauthorized, status)
+		}
+		// and the challenge is gone after a failed attempt
+		nextCode, _ := totp.Code(enrollment.Secret, totp.Step(now)+1)
+		if status, _ := completeLogin(t, api.MFALoginAPI{MFAToken: challenge.MFAToken, Code: nextCode}); status != http.StatusBadRequest {
+			t.Errorf("Expected consumed challenge to be rejected with %d, got %d", http.StatusBadRequest, status)
+		}
+
+		status, response := completeLogin(t, api.MFALoginAPI{MFAToken: login(t).MFAToken, Code: nextCode})
+		if status != http.StatusOK || response.AccessToken == "" || response.User.ID != userID {
+			t.Fatalf("Expected a session, got status %d %+v", status, response)
+		}
+
+		if status, _ := completeLogin(t, api.MFALoginAPI{MFAToken: login(t).MFAToken, Code: nextCode}); status != http.StatusUnauthorized {
+			t.Errorf("Expected replayed code to be rejected with %d, got %d", http.StatusUnauthorized, status)
+		}
+	})
+
+	t.Run("recovery codes work once", func(t *testing.T) {
+		recoveryCode := recovery.RecoveryCodes[0]
+		if status, _ := completeLogin(t, api.MFALoginAPI{MFAToken: login(t).MFAToken, RecoveryCode: recoveryCode}); status != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, status)
+		}
+		if status, _ := completeLogin(t, api.MFALoginAPI{MFAToken: login(t).MFAToken, RecoveryCode: recoveryCode}); status != http.StatusUnauthorized {
+			t.Errorf("Expected used recovery code to be rejected with %d, got %d", http.StatusUnauthorized, status)
+		}
+
+		resp := suite.makeGETRequest(t, mfaURL)
+		defer resp.Body.Close()
+
+		var status api.MFAStatusAPI
+		decodeResponseData(t, resp, &status)
+		if !status.Enabled || status.RecoveryCodesRemaining != 9 {
+			t.Errorf("Unexpected MFA status %+v", status)
+		}
+	})
+
+	t.Run("admin reset", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "DELETE", mfaURL, api.StatusChangeAPI{})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected reset without reason to fail with %d, got %d", http.StatusBadRequest, resp.StatusCode)
+		}
+
+		resp = suite.makeJSONRequest(t, "DELETE", mfaURL, api.StatusChangeAPI{Reason: "lost phone"})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+
+		if response := login(t); response.MFARequired || response.AccessToken == "" {
+			t.Errorf("Expected a session without MFA, got %+v", response)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package integration
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"users-microservice/pkg/oidc"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	mockGatewayIssuer   = "https://gateway.test"
+	mockGatewayAudience = "users-microservice"
+)
+
+type mockGatewayKey struct {
+	kid    string
+	method jwt.SigningMethod
+	key    crypto.Signer
+}
+
+// MockGateway issues bearer tokens like the platform gateway, with an RSA, a
+// P-256 and an Ed25519 key published at /jwks
+type MockGateway struct {
+	server *httptest.Server
+
+	mu         sync.Mutex
+	keys       []mockGatewayKey
+	generation int
+}
+
+func NewMockGateway() (*MockGateway, error) {
+	gateway := &MockGateway{}
+	if err := gateway.Rotate(); err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jwks", gateway.handleJWKS)
+	gateway.server = httptest.NewServer(mux)
+	return gateway, nil
+}
+
+func (mg *MockGateway) JWKSURL() string {
+	return mg.server.URL + "/jwks"
+}
+
+func (mg *MockGateway) Close() {
+	mg.server.Close()
+}
+
+// Rotate replaces every key with a new one under a new key ID
+func (mg *MockGateway) Rotate() error {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+	mg.generation++
+	suffix := "-" + strconv.Itoa(mg.generation)
+	mg.keys = []mockGatewayKey{
+		{kid: "rsa" + suffix, method: jwt.SigningMethodRS256, key: rsaKey},
+		{kid: "ec" + suffix, method: jwt.SigningMethodES256, key: ecKey},
+		{kid: "ed" + suffix, method: jwt.SigningMethodEdDSA, key: edKey},
+	}
+	return nil
+}
+
+// Sign issues a token with the current key of the algorithm
+func (mg *MockGateway) Sign(method jwt.SigningMethod, claims jwt.Claims) (string, error) {
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+	for _, key := range mg.keys {
+		if key.method == method {
+			token := jwt.NewWithClaims(method, claims)
+			token.Header["kid"] = key.kid
+			return token.SignedString(key.key)
+		}
+	}
+	return "", jwt.ErrInvalidKeyType
+}
+
+func (mg *MockGateway) KeySet() oidc.JSONWebKeySet {
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+	set := oidc.JSONWebKeySet{}
+	for _, key := range mg.keys {
+		switch public := key.key.Public().(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, oidc.NewRSAJSONWebKey(key.kid, public))
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, oidc.JSONWebKey{
+				KeyType: "EC", Use: "sig", Algorithm: "ES256", KeyID: key.kid, Curve: "P-256",
+				X: base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, 32))),
+				Y: base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, 32))),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, oidc.JSONWebKey{
+				KeyType: "OKP", Use: "sig", Algorithm: "EdDSA", KeyID: key.kid, Curve: "Ed25519",
+				X: base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return set
+}
+
+func (mg *MockGateway) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(mg.KeySet())
+}
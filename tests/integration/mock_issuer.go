@@ -0,0 +1,177 @@
+package integration
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+	"users-microservice/pkg/oidc"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	mockClientID     = "users-test"
+	mockClientSecret = "users-test-secret"
+	mockKeyID        = "mock-key"
+)
+
+// MockIdentity is the account the mock issuer signs in
+type MockIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type mockAuthorization struct {
+	identity      MockIdentity
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+}
+
+// MockIssuer is an in-process OpenID provider for the federation tests. It
+// has no login page, every authorization request signs in the identity given
+// to SignInAs right away
+type MockIssuer struct {
+	server *httptest.Server
+	key    *ecdsa.PrivateKey
+
+	mu       sync.Mutex
+	identity MockIdentity
+	codes    map[string]mockAuthorization
+}
+
+func NewMockIssuer() (*MockIssuer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	issuer := &MockIssuer{key: key, codes: map[string]mockAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", issuer.handleDiscovery)
+	mux.HandleFunc("GET /jwks", issuer.handleJWKS)
+	mux.HandleFunc("GET /authorize", issuer.handleAuthorize)
+	mux.HandleFunc("POST /token", issuer.handleToken)
+	issuer.server = httptest.NewServer(mux)
+	return issuer, nil
+}
+
+func (mi *MockIssuer) URL() string {
+	return mi.server.URL
+}
+
+func (mi *MockIssuer) Close() {
+	mi.server.Close()
+}
+
+func (mi *MockIssuer) SignInAs(identity MockIdentity) {
+	mi.mu.Lock()
+	defer mi.mu.Unlock()
+	mi.identity = identity
+}
+
+func (mi *MockIssuer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	metadata := oidc.ProviderMetadata{
+		Issuer:                           mi.URL(),
+		AuthorizationEndpoint:            mi.URL() + "/authorize",
+		TokenEndpoint:                    mi.URL() + "/token",
+		JWKSURI:                          mi.URL() + "/jwks",
+		ResponseTypesSupported:           []string{"code"},
+		IDTokenSigningAlgValuesSupported: []string{"ES256"},
+	}
+	json.NewEncoder(w).Encode(metadata)
+}
+
+func (mi *MockIssuer) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	size := (mi.key.Curve.Params().BitSize + 7) / 8
+	set := oidc.JSONWebKeySet{Keys: []oidc.JSONWebKey{{
+		KeyType:   "EC",
+		Use:       "sig",
+		Algorithm: "ES256",
+		KeyID:     mockKeyID,
+		Curve:     "P-256",
+		X:         base64.RawURLEncoding.EncodeToString(mi.key.X.FillBytes(make([]byte, size))),
+		Y:         base64.RawURLEncoding.EncodeToString(mi.key.Y.FillBytes(make([]byte, size))),
+	}}}
+	json.NewEncoder(w).Encode(set)
+}
+
+func (mi *MockIssuer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != mockClientID || query.Get("code_challenge_method") != oidc.CodeChallengeMethodS256 {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	code := rand.Text()
+	mi.mu.Lock()
+	mi.codes[code] = mockAuthorization{
+		identity:      mi.identity,
+		redirectURI:   query.Get("redirect_uri"),
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+	}
+	mi.mu.Unlock()
+
+	http.Redirect(w, r, oidc.RedirectURL(query.Get("redirect_uri"), url.Values{"code": {code}, "state": {query.Get("state")}}), http.StatusFound)
+}
+
+func (mi *MockIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	if clientID != mockClientID || clientSecret != mockClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(oidc.NewError(oidc.ErrorInvalidClient, "client authentication failed"))
+		return
+	}
+
+	mi.mu.Lock()
+	authorization, ok := mi.codes[r.PostFormValue("code")]
+	delete(mi.codes, r.PostFormValue("code"))
+	mi.mu.Unlock()
+	if !ok || authorization.redirectURI != r.PostFormValue("redirect_uri") || !oidc.VerifyCodeChallenge(r.PostFormValue("code_verifier"), authorization.codeChallenge) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(oidc.NewError(oidc.ErrorInvalidGrant, "code is invalid"))
+		return
+	}
+
+	now := time.Now()
+	verified := authorization.identity.EmailVerified
+	claims := oidc.IDTokenClaims{
+		Nonce: authorization.nonce,
+		UserClaims: oidc.UserClaims{
+			Name:          authorization.identity.Name,
+			Email:         authorization.identity.Email,
+			EmailVerified: &verified,
+		},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    mi.URL(),
+			Subject:   authorization.identity.Subject,
+			Audience:  jwt.ClaimStrings{mockClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = mockKeyID
+	idToken, err := token.SignedString(mi.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
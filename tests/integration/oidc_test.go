@@ -0,0 +1,295 @@
+package integration
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"users-microservice/pkg/api"
+	"users-microservice/pkg/oidc"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	oidcRedirectURI  = "https://client.test/callback"
+	oidcCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+func TestOIDCProvider(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	email := "oidc@test.com"
+	userID := suite.createActiveTestUser(t, email)
+	resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/users/"+userID.String()+"/password", api.PasswordAPI{Password: "oidc horse battery"})
+	resp.Body.Close()
+	resp = suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/auth/login", api.LoginAPI{Email: email, Password: "oidc horse battery"})
+	var login api.LoginResponseAPI
+	decodeResponseData(t, resp, &login)
+	resp.Body.Close()
+
+	resp = suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/oauth2/clients", api.OIDCClientCreateAPI{Name: "Client", RedirectURIs: []string{oidcRedirectURI}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Failed to register client: Status=%d", resp.StatusCode)
+	}
+	var client api.OIDCClientAPI
+	decodeResponseData(t, resp, &client)
+	resp.Body.Close()
+	if client.Secret == "" || client.Public {
+		t.Fatalf("Expected a confidential client with a secret, got %+v", client)
+	}
+
+	challengeSum := sha256.Sum256([]byte(oidcCodeVerifier))
+	authQuery := url.Values{
+		"client_id":             {client.ID},
+		"redirect_uri":          {oidcRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid profile email"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6_WzA2Mj"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challengeSum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	noRedirects := &http.Client{Transport: suite.Client.Transport, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	t.Run("discovery", func(t *testing.T) {
+		resp := suite.makeGETRequest(t, suite.httpSrv.URL+"/.well-known/openid-configuration")
+		defer resp.Body.Close()
+
+		var metadata oidc.ProviderMetadata
+		if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+			t.Fatalf("Failed to decode discovery document: %v", err)
+		}
+		if metadata.Issuer != "http://localhost:8081" || metadata.JWKSURI != "http://localhost:8081/.well-known/jwks.json" {
+			t.Errorf("Unexpected discovery document: %+v", metadata)
+		}
+	})
+
+	authorizeCases := []struct {
+		name       string
+		change     url.Values
+		wantCode   int
+		wantPrefix string
+	}{
+		{name: "valid request goes to login", wantCode: http.StatusFound, wantPrefix: "http://localhost:8081/login?"},
+		{name: "unknown redirect uri", change: url.Values{"redirect_uri": {"https://evil.test/"}}, wantCode: http.StatusBadRequest},
+		{name: "unknown client", change: url.Values{"client_id": {"nope"}}, wantCode: http.StatusBadRequest},
+		{name: "missing pkce", change: url.Values{"code_challenge": {""}}, wantCode: http.StatusFound, wantPrefix: oidcRedirectURI + "?error=invalid_request"},
+		{name: "missing openid scope", change: url.Values{"scope": {"profile"}}, wantCode: http.StatusFound, wantPrefix: oidcRedirectURI + "?error=invalid_scope"},
+	}
+
+	for _, tc := range authorizeCases {
+		t.Run(tc.name, func(t *testing.T) {
+			query := url.Values{}
+			for key, value := range authQuery {
+				query[key] = value
+			}
+			for key, value := range tc.change {
+				query[key] = value
+			}
+			resp, err := noRedirects.Get(suite.httpSrv.URL + "/oauth2/authorize?" + query.Encode())
+			if err != nil {
+				t.Fatalf("Failed to make request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.wantCode {
+				t.Fatalf("Test '%s': Expected status %d, got %d", tc.name, tc.wantCode, resp.StatusCode)
+			}
+			if location := resp.Header.Get("Location"); !strings.HasPrefix(location, tc.wantPrefix) {
+				t.Errorf("Test '%s': Expected redirect to %s, got %s", tc.name, tc.wantPrefix, location)
+			}
+		})
+	}
+
+	authorize := func(t *testing.T, accessToken string) (int, string) {
+		body := api.AuthorizationRequestAPI{
+			ClientID:            client.ID,
+			RedirectURI:         oidcRedirectURI,
+			ResponseType:        "code",
+			Scope:               authQuery.Get("scope"),
+			State:               authQuery.Get("state"),
+			Nonce:               authQuery.Get("nonce"),
+			CodeChallenge:       authQuery.Get("code_challenge"),
+			CodeChallengeMethod: "S256",
+		}
+		payload, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", suite.httpSrv.URL+"/oauth2/authorize", strings.NewReader(string(payload)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		resp, err := suite.Client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, ""
+		}
+		var redirect api.AuthorizationRedirectAPI
+		decodeResponseData(t, resp, &redirect)
+		location, err := url.Parse(redirect.RedirectTo)
+		if err != nil {
+			t.Fatalf("Failed to parse redirect: %v", err)
+		}
+		if location.Query().Get("state") != "xyz" {
+			t.Errorf("Expected state to be echoed, got %s", redirect.RedirectTo)
+		}
+		return resp.StatusCode, location.Query().Get("code")
+	}
+	exchange := func(t *testing.T, code string, verifier string) (int, api.TokenResponseAPI) {
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {oidcRedirectURI},
+			"code_verifier": {verifier},
+		}
+		req, _ := http.NewRequest("POST", suite.httpSrv.URL+"/oauth2/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(url.QueryEscape(client.ID), url.QueryEscape(client.Secret))
+		resp, err := suite.Client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var tokens api.TokenResponseAPI
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+				t.Fatalf("Failed to decode token response: %v", err)
+			}
+		}
+		return resp.StatusCode, tokens
+	}
+
+	t.Run("authorize requires a session", func(t *testing.T) {
+		if code, _ := authorize(t, "not-a-token"); code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, code)
+		}
+	})
+
+	status, code := authorize(t, login.AccessToken)
+	if status != http.StatusOK || code == "" {
+		t.Fatalf("Failed to authorize: Status=%d", status)
+	}
+
+	t.Run("wrong code verifier", func(t *testing.T) {
+		status, wrongCode := authorize(t, login.AccessToken)
+		if status != http.StatusOK {
+			t.Fatalf("Failed to authorize: Status=%d", status)
+		}
+		if status, _ := exchange(t, wrongCode, strings.Repeat("a", 43)); status != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, status)
+		}
+	})
+
+	status, tokens := exchange(t, code, oidcCodeVerifier)
+	if status != http.StatusOK {
+		t.Fatalf("Failed to exchange code: Status=%d", status)
+	}
+
+	t.Run("code is single use", func(t *testing.T) {
+		if status, _ := exchange(t, code, oidcCodeVerifier); status != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, status)
+		}
+	})
+
+	t.Run("id token claims", func(t *testing.T) {
+		claims := &oidc.IDTokenClaims{}
+		suite.parseOIDCToken(t, tokens.IDToken, claims)
+
+		if claims.Subject != userID.String() || claims.Nonce != "n-0S6_WzA2Mj" || len(claims.Audience) != 1 || claims.Audience[0] != client.ID {
+			t.Errorf("Unexpected registered claims: %+v", claims)
+		}
+		if claims.Name != "Milan" || claims.Email != email || claims.EmailVerified == nil || !*claims.EmailVerified || claims.Birthdate == "" {
+			t.Errorf("Unexpected user claims: %+v", claims.UserClaims)
+		}
+	})
+
+	t.Run("userinfo", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", suite.httpSrv.URL+"/userinfo", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		resp, err := suite.Client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var info oidc.UserInfo
+		if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+			t.Fatalf("Failed to decode userinfo: %v", err)
+		}
+		if info.Subject != userID.String() || info.Email != email || info.Name != "Milan" {
+			t.Errorf("Unexpected userinfo: %+v", info)
+		}
+	})
+
+	t.Run("id token is no access token", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", suite.httpSrv.URL+"/userinfo", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.IDToken)
+		resp, err := suite.Client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	t.Run("rotated keys stay published", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/oauth2/keys/rotate", nil)
+		var rotated api.SigningKeyAPI
+		decodeResponseData(t, resp, &rotated)
+		resp.Body.Close()
+
+		// the token signed with the previous key still verifies
+		suite.parseOIDCToken(t, tokens.IDToken, &oidc.IDTokenClaims{})
+
+		_, nextCode := authorize(t, login.AccessToken)
+		status, next := exchange(t, nextCode, oidcCodeVerifier)
+		if status != http.StatusOK {
+			t.Fatalf("Failed to exchange code: Status=%d", status)
+		}
+		token := suite.parseOIDCToken(t, next.IDToken, &oidc.IDTokenClaims{})
+		if token.Header["kid"] != rotated.KeyID {
+			t.Errorf("Expected new tokens to be signed with %s, got %v", rotated.KeyID, token.Header["kid"])
+		}
+	})
+}
+
+// parseOIDCToken verifies the token against the published JWKS
+func (ts *TestSuite) parseOIDCToken(t *testing.T, token string, claims jwt.Claims) *jwt.Token {
+	resp := ts.makeGETRequest(t, ts.httpSrv.URL+"/.well-known/jwks.json")
+	defer resp.Body.Close()
+
+	var set oidc.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		t.Fatalf("Failed to decode JWKS: %v", err)
+	}
+
+	parsed, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (any, error) {
+		for _, key := range set.Keys {
+			if key.KeyID != token.Header["kid"] {
+				continue
+			}
+			n, _ := base64.RawURLEncoding.DecodeString(key.N)
+			e, _ := base64.RawURLEncoding.DecodeString(key.E)
+			return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+		}
+		return nil, jwt.ErrTokenUnverifiable
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer("http://localhost:8081"))
+	if err != nil {
+		t.Fatalf("Failed to verify token: %v", err)
+	}
+	return parsed
+}
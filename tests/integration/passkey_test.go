@@ -0,0 +1,238 @@
+package integration
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"users-microservice/pkg/api"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// softAuthenticator plays a platform authenticator keeping its passkeys in
+// memory, it always verifies the user and uses ES256
+type softAuthenticator struct {
+	origin string
+}
+
+type softCredential struct {
+	id         []byte
+	key        *ecdsa.PrivateKey
+	userHandle []byte
+	signCount  uint32
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony string, challenge []byte) []byte {
+	clientData, err := json.Marshal(map[string]any{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.origin,
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode client data: %v", err)
+	}
+	return clientData
+}
+
+func (a *softAuthenticator) authenticatorData(rpID string, flags byte, signCount uint32) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	authData := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(authData, signCount)
+}
+
+func (a *softAuthenticator) create(t *testing.T, options api.PasskeyCreationOptionsAPI) (*softCredential, api.RegistrationCredentialAPI) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	credential := &softCredential{id: make([]byte, 16), key: key, userHandle: options.User.ID}
+	rand.Read(credential.id)
+
+	publicKey, err := cbor.Marshal(map[int]any{1: 2, 3: -7, -1: 1, -2: key.X.FillBytes(make([]byte, 32)), -3: key.Y.FillBytes(make([]byte, 32))})
+	if err != nil {
+		t.Fatalf("Failed to encode public key: %v", err)
+	}
+	// user present, user verified and attested credential data
+	authData := a.authenticatorData(options.RP.ID, 0x45, 0)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(credential.id)))
+	authData = append(authData, credential.id...)
+	authData = append(authData, publicKey...)
+
+	attestation, err := cbor.Marshal(map[string]any{"fmt": "none", "attStmt": map[string]any{}, "authData": authData})
+	if err != nil {
+		t.Fatalf("Failed to encode attestation: %v", err)
+	}
+
+	var response api.RegistrationCredentialAPI
+	response.ID = base64.RawURLEncoding.EncodeToString(credential.id)
+	response.RawID = credential.id
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = a.clientData(t, "webauthn.create", options.Challenge)
+	response.Response.AttestationObject = attestation
+	return credential, response
+}
+
+func (a *softAuthenticator) get(t *testing.T, credential *softCredential, options api.PasskeyRequestOptionsAPI) api.AuthenticationCredentialAPI {
+	credential.signCount++
+	authData := a.authenticatorData(options.RPID, 0x05, credential.signCount)
+	clientData := a.clientData(t, "webauthn.get", options.Challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, credential.key, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign assertion: %v", err)
+	}
+
+	var response api.AuthenticationCredentialAPI
+	response.ID = base64.RawURLEncoding.EncodeToString(credential.id)
+	response.RawID = credential.id
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = clientData
+	response.Response.AuthenticatorData = authData
+	response.Response.Signature = signature
+	response.Response.UserHandle = credential.userHandle
+	return response
+}
+
+func TestPasskeys(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	userID := suite.createActiveTestUser(t, "passkey@test.com")
+	passkeysURL := suite.httpSrv.URL + "/users/" + userID.String() + "/passkeys"
+	authenticator := &softAuthenticator{origin: "http://localhost:8081"}
+
+	creationOptions := func(t *testing.T) api.PasskeyCreationOptionsAPI {
+		resp := suite.makeJSONRequest(t, "POST", passkeysURL+"/options", nil)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to get creation options: Status=%d", resp.StatusCode)
+		}
+		var options api.PasskeyCreationOptionsAPI
+		decodeResponseData(t, resp, &options)
+		return options
+	}
+	register := func(t *testing.T, name string) (*softCredential, int) {
+		credential, response := authenticator.create(t, creationOptions(t))
+		resp := suite.makeJSONRequest(t, "POST", passkeysURL, api.PasskeyRegistrationAPI{Name: name, Credential: response})
+		resp.Body.Close()
+		return credential, resp.StatusCode
+	}
+	requestOptions := func(t *testing.T) api.PasskeyRequestOptionsAPI {
+		resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/auth/passkey/options", nil)
+		defer resp.Body.Close()
+
+		var options api.PasskeyRequestOptionsAPI
+		decodeResponseData(t, resp, &options)
+		return options
+	}
+	login := func(t *testing.T, assertion api.AuthenticationCredentialAPI) (int, api.LoginResponseAPI) {
+		resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/auth/passkey/login", assertion)
+		defer resp.Body.Close()
+
+		var loginResponse api.LoginResponseAPI
+		if resp.StatusCode == http.StatusOK {
+			decodeResponseData(t, resp, &loginResponse)
+		}
+		return resp.StatusCode, loginResponse
+	}
+	listPasskeys := func(t *testing.T) []api.PasskeyAPI {
+		resp := suite.makeGETRequest(t, passkeysURL)
+		defer resp.Body.Close()
+
+		var passkeys []api.PasskeyAPI
+		decodeResponseData(t, resp, &passkeys)
+		return passkeys
+	}
+
+	options := creationOptions(t)
+	if options.RP.ID != "localhost" || string(options.User.ID) != string(userID[:]) || len(options.Challenge) != 32 {
+		t.Fatalf("Unexpected creation options %+v", options)
+	}
+
+	laptop, status := register(t, "Laptop")
+	if status != http.StatusCreated {
+		t.Fatalf("Failed to register passkey: Status=%d", status)
+	}
+	phone, status := register(t, "Phone")
+	if status != http.StatusCreated {
+		t.Fatalf("Failed to register second passkey: Status=%d", status)
+	}
+
+	t.Run("names are unique per user", func(t *testing.T) {
+		if _, status := register(t, "Phone"); status != http.StatusConflict {
+			t.Errorf("Expected status %d, got %d", http.StatusConflict, status)
+		}
+		if excluded := creationOptions(t).ExcludeCredentials; len(excluded) != 2 {
+			t.Errorf("Expected registered passkeys to be excluded, got %d", len(excluded))
+		}
+	})
+
+	t.Run("sign in with a passkey", func(t *testing.T) {
+		assertion := authenticator.get(t, laptop, requestOptions(t))
+		status, response := login(t, assertion)
+		if status != http.StatusOK || response.AccessToken == "" || response.User.ID != userID {
+			t.Fatalf("Expected a session, got status %d %+v", status, response)
+		}
+
+		if status, _ := login(t, assertion); status != http.StatusBadRequest {
+			t.Errorf("Expected replay to be rejected with %d, got %d", http.StatusBadRequest, status)
+		}
+	})
+
+	t.Run("counter must increase", func(t *testing.T) {
+		phone.signCount = 5
+		if status, _ := login(t, authenticator.get(t, phone, requestOptions(t))); status != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, status)
+		}
+		phone.signCount = 2
+		if status, _ := login(t, authenticator.get(t, phone, requestOptions(t))); status != http.StatusUnauthorized {
+			t.Errorf("Expected cloned authenticator to be rejected with %d, got %d", http.StatusUnauthorized, status)
+		}
+		phone.signCount = 10
+	})
+
+	t.Run("other origins are refused", func(t *testing.T) {
+		phishing := &softAuthenticator{origin: "https://users.example.net"}
+		if status, _ := login(t, phishing.get(t, laptop, requestOptions(t))); status != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, status)
+		}
+	})
+
+	t.Run("rename and delete", func(t *testing.T) {
+		passkeys := listPasskeys(t)
+		if len(passkeys) != 2 || passkeys[0].Name != "Laptop" || passkeys[0].LastUsedAt == nil {
+			t.Fatalf("Unexpected passkeys %+v", passkeys)
+		}
+
+		resp := suite.makeJSONRequest(t, "PATCH", passkeysURL+"/"+passkeys[1].ID.String(), api.PasskeyNameAPI{Name: "Work phone"})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		resp = suite.makeJSONRequest(t, "DELETE", passkeysURL+"/"+passkeys[0].ID.String(), nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+
+		if passkeys := listPasskeys(t); len(passkeys) != 1 || passkeys[0].Name != "Work phone" {
+			t.Errorf("Unexpected passkeys %+v", passkeys)
+		}
+		if status, _ := login(t, authenticator.get(t, laptop, requestOptions(t))); status != http.StatusUnauthorized {
+			t.Errorf("Expected removed passkey to be rejected with %d, got %d", http.StatusUnauthorized, status)
+		}
+		if status, _ := login(t, authenticator.get(t, phone, requestOptions(t))); status != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, status)
+		}
+	})
+}
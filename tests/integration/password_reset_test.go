@@ -0,0 +1,72 @@
+package integration
+
+import (
+	"net/http"
+	"testing"
+	"users-microservice/pkg/api"
+)
+
+func TestPasswordReset(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	email := "reset@test.com"
+	userID := suite.createActiveTestUser(t, email)
+	resetURL := suite.httpSrv.URL + "/auth/password-reset"
+	sent := len(suite.mailedTo(t, email))
+
+	for _, requested := range []string{email, "nobody@test.com"} {
+		t.Run("request for "+requested, func(t *testing.T) {
+			resp := suite.makeJSONRequest(t, "POST", resetURL, api.PasswordResetAPI{Email: requested})
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusAccepted {
+				t.Errorf("Expected status %d, got %d", http.StatusAccepted, resp.StatusCode)
+			}
+		})
+	}
+
+	token := suite.awaitMailedToken(t, email, sent)
+
+	testCases := []struct {
+		name     string
+		payload  api.PasswordResetConfirmAPI
+		wantCode int
+	}{
+		{name: "unknown token", payload: api.PasswordResetConfirmAPI{Token: "not-a-token", NewPassword: "reset horse battery"}, wantCode: 400},
+		{name: "weak password keeps token", payload: api.PasswordResetConfirmAPI{Token: token, NewPassword: "short"}, wantCode: 400},
+		{name: "valid reset", payload: api.PasswordResetConfirmAPI{Token: token, NewPassword: "reset horse battery"}, wantCode: 200},
+		{name: "reused token", payload: api.PasswordResetConfirmAPI{Token: token, NewPassword: "other horse battery"}, wantCode: 400},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := suite.makeJSONRequest(t, "POST", resetURL+"/confirm", tc.payload)
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.wantCode {
+				t.Errorf("Test '%s': Expected status %d, got %d", tc.name, tc.wantCode, resp.StatusCode)
+			}
+		})
+	}
+
+	t.Run("login with new password", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/auth/login", api.LoginAPI{Email: email, Password: "reset horse battery"})
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+	})
+
+	t.Run("reset is recorded in history", func(t *testing.T) {
+		resp := suite.makeGETRequest(t, suite.httpSrv.URL+"/users/"+userID.String()+"/history")
+		defer resp.Body.Close()
+
+		var history []api.UserHistoryEntryAPI
+		decodeResponseData(t, resp, &history)
+		if len(history) == 0 || history[len(history)-1].Event != "password_reset" {
+			t.Errorf("Expected password reset as the last history event, got %+v", history)
+		}
+	})
+}
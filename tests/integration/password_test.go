@@ -0,0 +1,95 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"users-microservice/pkg/api"
+	"users-microservice/pkg/auth"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/lockout"
+	"users-microservice/pkg/password"
+	"users-microservice/pkg/services"
+	"users-microservice/pkg/storage"
+)
+
+func TestPasswordCredentials(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	email := "password@test.com"
+	userID := suite.createActiveTestUser(t, email)
+	passwordURL := suite.httpSrv.URL + "/users/" + userID.String() + "/password"
+	loginURL := suite.httpSrv.URL + "/auth/login"
+
+	testCases := []struct {
+		name     string
+		url      string
+		payload  interface{}
+		wantCode int
+	}{
+		{name: "login without password", url: loginURL, payload: api.LoginAPI{Email: email, Password: "correct horse battery"}, wantCode: 401},
+		{name: "too short password", url: passwordURL, payload: api.PasswordAPI{Password: "short"}, wantCode: 400},
+		{name: "breached password", url: passwordURL, payload: api.PasswordAPI{Password: "breachedpassword1"}, wantCode: 400},
+		{name: "set password", url: passwordURL, payload: api.PasswordAPI{Password: "correct horse battery"}, wantCode: 200},
+		{name: "set password twice", url: passwordURL, payload: api.PasswordAPI{Password: "another horse battery"}, wantCode: 409},
+		{name: "login with wrong password", url: loginURL, payload: api.LoginAPI{Email: email, Password: "wrong horse battery"}, wantCode: 401},
+		{name: "login with unknown email", url: loginURL, payload: api.LoginAPI{Email: "nobody@test.com", Password: "correct horse battery"}, wantCode: 401},
+		{name: "login", url: loginURL, payload: api.LoginAPI{Email: email, Password: "correct horse battery"}, wantCode: 200},
+		{name: "change with wrong current password", url: passwordURL + "/change", payload: api.PasswordChangeAPI{CurrentPassword: "wrong horse battery", NewPassword: "staple horse battery"}, wantCode: 401},
+		{name: "change password", url: passwordURL + "/change", payload: api.PasswordChangeAPI{CurrentPassword: "correct horse battery", NewPassword: "staple horse battery"}, wantCode: 200},
+		{name: "login with old password", url: loginURL, payload: api.LoginAPI{Email: email, Password: "correct horse battery"}, wantCode: 401},
+		{name: "login with new password", url: loginURL, payload: api.LoginAPI{Email: email, Password: "staple horse battery"}, wantCode: 200},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := suite.makeJSONRequest(t, "POST", tc.url, tc.payload)
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.wantCode {
+				t.Errorf("Test '%s': Expected status %d, got %d", tc.name, tc.wantCode, resp.StatusCode)
+			}
+		})
+	}
+
+	t.Run("login rehashes with new parameters", func(t *testing.T) {
+		stronger := password.NewHasher(password.Params{Memory: 2048, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+		policy := &password.Policy{MinLength: 12, MaxLength: 128}
+		authService, err := services.NewAuthService(services.AuthDependencies{
+			Storage: suite.storage,
+			Hasher:  stronger,
+			Policy:  policy,
+			Signer:  auth.NewAccessTokenSigner([]byte("key"), "issuer", time.Minute),
+			Guard:   lockout.NewGuard(storage.NewMemoryFailureCounterStorage(), lockout.Policy{}),
+		}, &config.Config{RefreshTokenTTL: time.Hour, SessionMaxAge: time.Hour})
+		if err != nil {
+			t.Fatalf("Failed to create auth service: %v", err)
+		}
+
+		if _, err := authService.Login(context.Background(), services.LoginRequest{Email: email, Password: "staple horse battery"}); err != nil {
+			t.Fatalf("Failed to login: %v", err)
+		}
+		credential, err := suite.storage.RetrievePasswordCredential(userID)
+		if err != nil {
+			t.Fatalf("Failed to retrieve password: %v", err)
+		}
+		if !strings.Contains(credential.Hash, "m=2048,t=2,p=1") {
+			t.Errorf("Expected password to be rehashed, got %s", credential.Hash)
+		}
+	})
+
+	t.Run("suspended user cannot login", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/users/"+userID.String()+"/suspend", api.StatusChangeAPI{Reason: "abuse"})
+		resp.Body.Close()
+
+		resp = suite.makeJSONRequest(t, "POST", loginURL, api.LoginAPI{Email: email, Password: "staple horse battery"})
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, resp.StatusCode)
+		}
+	})
+}
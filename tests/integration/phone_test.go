@@ -0,0 +1,195 @@
+package integration
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+	"users-microservice/pkg/api"
+	"users-microservice/pkg/sms"
+
+	"github.com/google/uuid"
+)
+
+func TestPhoneNumber(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	userID := uuid.New()
+	userURL := suite.httpSrv.URL + "/users/" + userID.String()
+	verifyURL := userURL + "/phone/verify"
+
+	t.Run("create with national number", func(t *testing.T) {
+		createReq := api.UserAPI{
+			ID:          userID,
+			Name:        "Milan",
+			Email:       "phone@test.com",
+			DateOfBirth: time.Now().AddDate(-25, 0, 0),
+			PhoneNumber: "(201) 555-0123",
+		}
+		resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/save", createReq)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+		}
+		var user api.UserAPI
+		decodeResponseData(t, resp, &user)
+		if user.PhoneNumber != "+12015550123" || user.PhoneVerifiedAt != nil {
+			t.Errorf("Expected unverified +12015550123, got %s verified at %v", user.PhoneNumber, user.PhoneVerifiedAt)
+		}
+	})
+
+	invalidCases := []struct {
+		name     string
+		payload  map[string]string
+		wantCode int
+	}{
+		{name: "too short", payload: map[string]string{"phone_number": "12"}, wantCode: 400},
+		{name: "not a number", payload: map[string]string{"phone_number": "call me"}, wantCode: 400},
+		{name: "unknown region", payload: map[string]string{"phone_number": "07400 123456", "phone_region": "XX"}, wantCode: 400},
+	}
+
+	for _, tc := range invalidCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := suite.makeJSONRequest(t, "PATCH", userURL, tc.payload)
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.wantCode {
+				t.Errorf("Test '%s': Expected status %d, got %d", tc.name, tc.wantCode, resp.StatusCode)
+			}
+		})
+	}
+
+	t.Run("number is unique", func(t *testing.T) {
+		otherID := suite.createTestUser(t, "other-phone@test.com")
+		resp := suite.makeJSONRequest(t, "PATCH", suite.httpSrv.URL+"/users/"+otherID.String(), map[string]string{"phone_number": "+1 201-555-0123"})
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusConflict {
+			t.Errorf("Expected status %d, got %d", http.StatusConflict, resp.StatusCode)
+		}
+	})
+
+	t.Run("resend is throttled", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "POST", userURL+"/phone/verification", nil)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, resp.StatusCode)
+		}
+	})
+
+	code := suite.lastTextedCode(t, "+12015550123")
+
+	verifyCases := []struct {
+		name     string
+		code     string
+		wantCode int
+	}{
+		{name: "empty code", code: "", wantCode: 400},
+		{name: "valid code", code: code, wantCode: 200},
+		{name: "already verified", code: code, wantCode: 409},
+	}
+
+	for _, tc := range verifyCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := suite.makeJSONRequest(t, "POST", verifyURL, api.PhoneCodeAPI{Code: tc.code})
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.wantCode {
+				t.Fatalf("Test '%s': Expected status %d, got %d", tc.name, tc.wantCode, resp.StatusCode)
+			}
+		})
+	}
+
+	t.Run("changing the number drops verification", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "PATCH", userURL, map[string]string{"phone_number": "07400 123456", "phone_region": "gb"})
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		var user api.UserAPI
+		decodeResponseData(t, resp, &user)
+		if user.PhoneNumber != "+447400123456" || user.PhoneVerifiedAt != nil {
+			t.Errorf("Expected unverified +447400123456, got %s verified at %v", user.PhoneNumber, user.PhoneVerifiedAt)
+		}
+	})
+
+	t.Run("code for the new number", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "POST", verifyURL, api.PhoneCodeAPI{Code: suite.lastTextedCode(t, "+447400123456")})
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		var user api.UserAPI
+		decodeResponseData(t, resp, &user)
+		if user.PhoneVerifiedAt == nil {
+			t.Error("Expected phone number to be verified")
+		}
+	})
+
+	t.Run("remove number", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "PATCH", userURL, map[string]string{"phone_number": ""})
+		defer resp.Body.Close()
+
+		var user api.UserAPI
+		decodeResponseData(t, resp, &user)
+		if user.PhoneNumber != "" || user.PhoneVerifiedAt != nil {
+			t.Errorf("Expected no phone number, got %s verified at %v", user.PhoneNumber, user.PhoneVerifiedAt)
+		}
+	})
+}
+
+func TestPhoneVerificationLockout(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	userID := suite.createTestUser(t, "phone-lockout@test.com")
+	userURL := suite.httpSrv.URL + "/users/" + userID.String()
+
+	resp := suite.makeJSONRequest(t, "PATCH", userURL, map[string]string{"phone_number": "+12015550199"})
+	resp.Body.Close()
+	code := suite.lastTextedCode(t, "+12015550199")
+	wrongCode := "000000"
+	if code == wrongCode {
+		wrongCode = "111111"
+	}
+
+	// the account policy of the suite locks on the third failure
+	for i := range 3 {
+		resp := suite.makeJSONRequest(t, "POST", userURL+"/phone/verify", api.PhoneCodeAPI{Code: wrongCode})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Attempt %d: Expected status %d, got %d", i+1, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+
+	resp = suite.makeJSONRequest(t, "POST", userURL+"/phone/verify", api.PhoneCodeAPI{Code: code})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+}
+
+var phoneCodePattern = regexp.MustCompile(`\b(\d{6})\b`)
+
+// find the code in the last text message sent to the number
+func (ts *TestSuite) lastTextedCode(t *testing.T, to string) string {
+	messages, err := sms.ReadOutbox(ts.smsOutbox)
+	if err != nil {
+		t.Fatalf("Failed to read sms outbox: %v", err)
+	}
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].To != to {
+			continue
+		}
+		if match := phoneCodePattern.FindStringSubmatch(messages[i].Body); match != nil {
+			return match[1]
+		}
+	}
+	t.Fatalf("No code was texted to %s", to)
+	return ""
+}
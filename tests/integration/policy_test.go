@@ -0,0 +1,198 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"users-microservice/pkg/api"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// supportClaims are gateway claims carrying the role policies decide on
+type supportClaims struct {
+	gatewayClaims
+	Role   string `json:"role,omitempty"`
+	Region string `json:"region,omitempty"`
+}
+
+const supportPolicy = `{
+	"mode": "%s",
+	"rules": [
+		{"name": "support-active-only", "effect": "deny", "actions": ["users.get", "users.history"],
+		 "condition": "principal.claims.?role.orValue('') == 'support' && resource.status != 'active'"},
+		{"name": "no-self-suspension", "effect": "deny", "actions": ["users.suspend"],
+		 "condition": "principal.kind == 'user'"},
+		{"name": "region", "effect": "deny", "actions": ["users.sessions.*"],
+		 "condition": "principal.claims.region != 'eu'"}
+	]
+}`
+
+func TestPolicies(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	active := suite.createActiveTestUser(t, "active@test.com")
+	suspended := suite.createActiveTestUser(t, "suspended@test.com")
+	resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/users/"+suspended.String()+"/suspend", api.StatusChangeAPI{Reason: "abuse"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to suspend user: Status=%d", resp.StatusCode)
+	}
+
+	usePolicy := func(t *testing.T, policy string) {
+		t.Helper()
+		if err := os.WriteFile(suite.policyFile, []byte(policy), 0o600); err != nil {
+			t.Fatalf("Failed to write policy file: %v", err)
+		}
+		if err := suite.policies.Reload(); err != nil {
+			t.Fatalf("Failed to reload policies: %v", err)
+		}
+	}
+	sign := func(t *testing.T, subject string, role string) string {
+		t.Helper()
+		token, err := suite.gateway.Sign(jwt.SigningMethodES256, supportClaims{gatewayClaims: newGatewayClaims(subject, "users:admin"), Role: role})
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		return token
+	}
+	// an empty token uses the administrator key of the suite
+	request := func(t *testing.T, token string, method string, path string, payload any) int {
+		t.Helper()
+		body, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("Failed to encode payload: %v", err)
+		}
+		req, err := http.NewRequest(method, suite.httpSrv.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := suite.Client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	support := sign(t, "support-desk", "support")
+
+	t.Run("enforced", func(t *testing.T) {
+		usePolicy(t, strings.Replace(supportPolicy, "%s", "enforce", 1))
+
+		tests := []struct {
+			name     string
+			token    string
+			method   string
+			path     string
+			payload  any
+			wantCode int
+		}{
+			{"support reads active user", support, "GET", "/" + active.String(), nil, http.StatusOK},
+			{"support reads suspended user", support, "GET", "/" + suspended.String(), nil, http.StatusForbidden},
+			{"support reads history of suspended user", support, "GET", "/users/" + suspended.String() + "/history", nil, http.StatusForbidden},
+			{"API key reads suspended user", "", "GET", "/" + suspended.String(), nil, http.StatusOK},
+			{"user suspends themselves", sign(t, active.String(), ""), "POST", "/users/" + active.String() + "/suspend", api.StatusChangeAPI{Reason: "leaving"}, http.StatusForbidden},
+			// the API key has no region claim, conditions that fail deny
+			{"failing condition", "", "GET", "/users/" + active.String() + "/sessions", nil, http.StatusForbidden},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if status := request(t, tt.token, tt.method, tt.path, tt.payload); status != tt.wantCode {
+					t.Errorf("Expected status %d, got %d", tt.wantCode, status)
+				}
+			})
+		}
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		if status := request(t, support, "GET", "/"+uuid.NewString(), nil); status != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, status)
+		}
+	})
+
+	t.Run("invalid policy is not loaded", func(t *testing.T) {
+		if err := os.WriteFile(suite.policyFile, []byte(`{"rules": [{"effect": "deny", "actions": ["*"], "condition": "resource.status"}]}`), 0o600); err != nil {
+			t.Fatalf("Failed to write policy file: %v", err)
+		}
+		if err := suite.policies.Reload(); err == nil {
+			t.Fatal("Expected a condition that is not bool to be rejected")
+		}
+		if status := request(t, support, "GET", "/"+suspended.String(), nil); status != http.StatusForbidden {
+			t.Errorf("Expected the previous policy to stay, got %d", status)
+		}
+	})
+
+	t.Run("audit", func(t *testing.T) {
+		usePolicy(t, strings.Replace(supportPolicy, "%s", "audit", 1))
+
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+		defer log.SetOutput(os.Stderr)
+
+		if status := request(t, support, "GET", "/"+suspended.String(), nil); status != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, status)
+		}
+		if !strings.Contains(logs.String(), "Policy would deny users.get to service support-desk by support-active-only") {
+			t.Errorf("Expected the decision to be logged, got %q", logs.String())
+		}
+	})
+
+	t.Run("support reads users of their region without date of birth", func(t *testing.T) {
+		usePolicy(t, `{"rules": [
+			{"name": "support-own-region", "effect": "deny", "actions": ["users.get"],
+			 "condition": "principal.claims.?role.orValue('') == 'support' && resource.region != principal.claims.?region.orValue('')"},
+			{"name": "support-no-birthdays", "effect": "allow", "actions": ["users.get"],
+			 "condition": "principal.claims.?role.orValue('') == 'support'", "mask": ["date_of_birth"]}
+		]}`)
+
+		region := "de"
+		resp := suite.makeJSONRequest(t, "PATCH", suite.httpSrv.URL+"/users/"+active.String(), api.UserUpdateAPI{Region: &region})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to set region: Status=%d", resp.StatusCode)
+		}
+		read := func(t *testing.T, region string) *http.Response {
+			t.Helper()
+			token, err := suite.gateway.Sign(jwt.SigningMethodES256, supportClaims{gatewayClaims: newGatewayClaims("support-desk", "users:admin users:pii"), Role: "support", Region: region})
+			if err != nil {
+				t.Fatalf("Failed to sign token: %v", err)
+			}
+			req, _ := http.NewRequest("GET", suite.httpSrv.URL+"/"+active.String(), nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp, err := suite.Client.Do(req)
+			if err != nil {
+				t.Fatalf("Failed to make request: %v", err)
+			}
+			return resp
+		}
+
+		resp = read(t, "FR")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected status %d for another region, got %d", http.StatusForbidden, resp.StatusCode)
+		}
+
+		resp = read(t, "DE")
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		if got := resp.Header.Get("X-Redacted-Fields"); got != "date_of_birth" {
+			t.Errorf("Expected only the date of birth to be redacted, got %q", got)
+		}
+		var user api.UserAPI
+		decodeResponseData(t, resp, &user)
+		if !user.DateOfBirth.IsZero() || user.BirthYear == 0 || user.Email != "active@test.com" || user.Region != "DE" {
+			t.Errorf("Expected the user without date of birth, got %+v", user)
+		}
+	})
+}
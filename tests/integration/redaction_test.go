@@ -0,0 +1,196 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+	"users-microservice/pkg/api"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/scim"
+	"users-microservice/pkg/services"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestPersonalDataRedaction(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	createReq := api.UserAPI{
+		ID:          uuid.New(),
+		Name:        "Jana",
+		Email:       "jana@example.com",
+		DateOfBirth: time.Date(1990, time.May, 17, 0, 0, 0, 0, time.UTC),
+		PhoneNumber: "+14155552671",
+	}
+	resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/save", createReq)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Failed to create user: Status=%d", resp.StatusCode)
+	}
+	userPath := "/" + createReq.ID.String()
+
+	issue := func(t *testing.T, scopes ...models.Scope) string {
+		t.Helper()
+		issued, err := suite.apiKeys.IssueAPIKey(systemContext(), services.APIKeyRequest{Name: "redaction", Scopes: scopes})
+		if err != nil {
+			t.Fatalf("Failed to issue API key: %v", err)
+		}
+		return issued.Secret
+	}
+	userToken := func(t *testing.T, id uuid.UUID) string {
+		t.Helper()
+		token, err := suite.gateway.Sign(jwt.SigningMethodES256, newGatewayClaims(id.String(), "users:read"))
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		return token
+	}
+	get := func(t *testing.T, token string, path string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest("GET", suite.httpSrv.URL+path, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := suite.Client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		return resp
+	}
+
+	reader := issue(t, models.ScopeUsersAdmin)
+	full := api.UserAPI{Email: "jana@example.com", DateOfBirth: createReq.DateOfBirth, PhoneNumber: "+14155552671"}
+	redacted := api.UserAPI{Email: "j***@example.com", BirthYear: 1990, PhoneNumber: "+*********71"}
+
+	tests := []struct {
+		name       string
+		token      string
+		want       api.UserAPI
+		wantHeader string
+	}{
+		{"service without PII scope", reader, redacted, "email, date_of_birth, phone_number"},
+		{"service with PII scope", issue(t, models.ScopeUsersRead, models.ScopeUsersPII), full, ""},
+		{"user reading themselves", userToken(t, createReq.ID), full, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := get(t, tt.token, userPath)
+			defer resp.Body.Close()
+
+			if header := resp.Header.Get("X-Redacted-Fields"); header != tt.wantHeader {
+				t.Errorf("Expected redacted fields %q, got %q", tt.wantHeader, header)
+			}
+			var user api.UserAPI
+			decodeResponseData(t, resp, &user)
+			if user.Email != tt.want.Email || !user.DateOfBirth.Equal(tt.want.DateOfBirth) || user.BirthYear != tt.want.BirthYear || user.PhoneNumber != tt.want.PhoneNumber {
+				t.Errorf("Expected %+v, got %+v", tt.want, user)
+			}
+			if user.Name != "Jana" {
+				t.Errorf("Expected name to be kept, got %q", user.Name)
+			}
+		})
+	}
+
+	t.Run("date of birth is left out", func(t *testing.T) {
+		resp := get(t, reader, userPath)
+		defer resp.Body.Close()
+
+		var envelope struct {
+			Data map[string]any `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if _, ok := envelope.Data["date_of_birth"]; ok {
+			t.Errorf("Expected no date_of_birth, got %v", envelope.Data["date_of_birth"])
+		}
+	})
+
+	t.Run("SCIM", func(t *testing.T) {
+		check := func(t *testing.T, resp *http.Response, resource scim.User) {
+			t.Helper()
+			if header := resp.Header.Get("X-Redacted-Fields"); header != "userName, emails, phoneNumbers, dateOfBirth" {
+				t.Errorf("Expected redacted attributes, got %q", header)
+			}
+			if resource.UserName != "j***@example.com" || resource.Emails[0].Value != "j***@example.com" {
+				t.Errorf("Expected masked emails, got %q and %q", resource.UserName, resource.Emails[0].Value)
+			}
+			if resource.Extension.DateOfBirth != "1990" {
+				t.Errorf("Expected birth year only, got %q", resource.Extension.DateOfBirth)
+			}
+		}
+
+		resp := get(t, reader, "/scim/v2/Users/"+createReq.ID.String())
+		defer resp.Body.Close()
+		var resource scim.User
+		if err := json.NewDecoder(resp.Body).Decode(&resource); err != nil {
+			t.Fatalf("Failed to decode resource: %v", err)
+		}
+		check(t, resp, resource)
+
+		listResp := get(t, reader, "/scim/v2/Users?filter="+url.QueryEscape(`displayName eq "Jana"`))
+		defer listResp.Body.Close()
+		var list struct {
+			Resources []scim.User `json:"Resources"`
+		}
+		if err := json.NewDecoder(listResp.Body).Decode(&list); err != nil {
+			t.Fatalf("Failed to decode list: %v", err)
+		}
+		if len(list.Resources) != 1 {
+			t.Fatalf("Expected 1 resource, got %d", len(list.Resources))
+		}
+		check(t, listResp, list.Resources[0])
+	})
+
+	t.Run("SCIM filters do not reveal masked data", func(t *testing.T) {
+		list := func(t *testing.T, token string, filter string) *http.Response {
+			t.Helper()
+			req, err := http.NewRequest("GET", suite.httpSrv.URL+"/scim/v2/Users?filter="+url.QueryEscape(filter), nil)
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp, err := suite.Client.Do(req)
+			if err != nil {
+				t.Fatalf("Failed to make request: %v", err)
+			}
+			return resp
+		}
+		filters := []string{
+			`userName sw "j"`,
+			`emails.value co "@example"`,
+			`phoneNumbers.value sw "+1415"`,
+			`phoneNumbers pr`,
+			`dateOfBirth gt "1990-01-01"`,
+			`displayName eq "Jana" and not (userName ew ".com")`,
+		}
+		for _, filter := range filters {
+			resp := list(t, reader, filter)
+			var scimErr struct {
+				ScimType string `json:"scimType"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&scimErr); err != nil {
+				t.Fatalf("Failed to decode error: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusForbidden || scimErr.ScimType != scim.ErrorInvalidFilter {
+				t.Errorf("Expected %s to be refused, got %d %q", filter, resp.StatusCode, scimErr.ScimType)
+			}
+		}
+
+		// callers seeing personal data still filter by it
+		resp := list(t, issue(t, models.ScopeUsersRead, models.ScopeUsersPII), filters[0])
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,405 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/scim"
+
+	"github.com/google/uuid"
+)
+
+func TestSCIMProvisioning(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	baseURL := suite.httpSrv.URL + "/scim/v2"
+	request := func(t *testing.T, method string, url string, body any) *http.Response {
+		var payload []byte
+		switch b := body.(type) {
+		case nil:
+		case string:
+			payload = []byte(b)
+		default:
+			var err error
+			if payload, err = json.Marshal(b); err != nil {
+				t.Fatalf("Failed to encode payload: %v", err)
+			}
+		}
+		req, err := http.NewRequest(method, url, bytes.NewReader(payload))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set("Content-Type", scim.ContentType)
+		resp, err := suite.Client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		return resp
+	}
+	decode := func(t *testing.T, resp *http.Response, target any) {
+		defer resp.Body.Close()
+		if contentType := resp.Header.Get("Content-Type"); contentType != scim.ContentType {
+			t.Errorf("Expected content type %s, got %q", scim.ContentType, contentType)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+	// expectError checks the status and SCIM error type of a failed request
+	expectError := func(t *testing.T, resp *http.Response, status int, errorType string) {
+		t.Helper()
+		var scimErr scim.Error
+		decode(t, resp, &scimErr)
+		if resp.StatusCode != status || scimErr.Type != errorType || len(scimErr.Schemas) != 1 || scimErr.Schemas[0] != scim.SchemaError {
+			t.Errorf("Expected %d %q, got %d %+v", status, errorType, resp.StatusCode, scimErr)
+		}
+	}
+	newResource := func(email string, name string) scim.User {
+		return scim.User{
+			Schemas:   []string{scim.SchemaUser, scim.SchemaUserExtension},
+			UserName:  email,
+			Name:      &scim.Name{GivenName: strings.Split(name, " ")[0], FamilyName: strings.Split(name, " ")[1]},
+			Extension: &scim.UserExtension{DateOfBirth: time.Now().AddDate(-30, 0, 0).Format("2006-01-02")},
+		}
+	}
+	create := func(t *testing.T, resource scim.User) scim.User {
+		resp := request(t, "POST", baseURL+"/Users", resource)
+		if resp.StatusCode != http.StatusCreated {
+			resp.Body.Close()
+			t.Fatalf("Failed to create user: Status=%d", resp.StatusCode)
+		}
+		var created scim.User
+		decode(t, resp, &created)
+		return created
+	}
+	patch := func(t *testing.T, id string, operations ...scim.PatchOperation) *http.Response {
+		return request(t, "PATCH", baseURL+"/Users/"+id, scim.PatchRequest{Schemas: []string{scim.SchemaPatchOp}, Operations: operations})
+	}
+	status := func(t *testing.T, id string) models.UserStatus {
+		user, err := suite.storage.RetrieveUser(uuid.MustParse(id))
+		if err != nil {
+			t.Fatalf("Failed to retrieve user: %v", err)
+		}
+		return user.Status
+	}
+
+	t.Run("service provider config", func(t *testing.T) {
+		var config scim.ServiceProviderConfig
+		decode(t, suite.makeGETRequest(t, baseURL+"/ServiceProviderConfig"), &config)
+		if !config.Patch.Supported || !config.Filter.Supported || config.Filter.MaxResults != 50 || config.Bulk.Supported || config.Sort.Supported {
+			t.Errorf("Unexpected service provider config: %+v", config)
+		}
+	})
+
+	t.Run("schemas", func(t *testing.T) {
+		var list struct {
+			TotalResults int           `json:"totalResults"`
+			Resources    []scim.Schema `json:"Resources"`
+		}
+		decode(t, suite.makeGETRequest(t, baseURL+"/Schemas"), &list)
+		if list.TotalResults != 2 || list.Resources[0].ID != scim.SchemaUser || list.Resources[1].ID != scim.SchemaUserExtension {
+			t.Fatalf("Expected the user schema and its extension, got %+v", list)
+		}
+
+		var schema scim.Schema
+		decode(t, suite.makeGETRequest(t, baseURL+"/Schemas/"+scim.SchemaUserExtension), &schema)
+		if len(schema.Attributes) != 1 || schema.Attributes[0].Name != "dateOfBirth" || !schema.Attributes[0].Required {
+			t.Errorf("Expected a required dateOfBirth, got %+v", schema.Attributes)
+		}
+
+		expectError(t, suite.makeGETRequest(t, baseURL+"/Schemas/urn:nope"), http.StatusNotFound, "")
+	})
+
+	t.Run("resource types", func(t *testing.T) {
+		var list struct {
+			Resources []scim.ResourceType `json:"Resources"`
+		}
+		decode(t, suite.makeGETRequest(t, baseURL+"/ResourceTypes"), &list)
+		if len(list.Resources) != 1 || list.Resources[0].Endpoint != "/Users" || list.Resources[0].SchemaExtensions[0].Schema != scim.SchemaUserExtension {
+			t.Fatalf("Expected the user resource type, got %+v", list.Resources)
+		}
+
+		var resourceType scim.ResourceType
+		decode(t, suite.makeGETRequest(t, baseURL+"/ResourceTypes/User"), &resourceType)
+		if resourceType.Schema != scim.SchemaUser {
+			t.Errorf("Expected the core user schema, got %s", resourceType.Schema)
+		}
+	})
+
+	var userID string
+
+	t.Run("create", func(t *testing.T) {
+		resource := newResource("ada@scim.test", "Ada Lovelace")
+		resource.PhoneNumbers = []scim.MultiValue{{Value: "+14155550101", Type: "mobile"}}
+		resp := request(t, "POST", baseURL+"/Users", resource)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+		}
+		var created scim.User
+		decode(t, resp, &created)
+		userID = created.ID
+
+		if created.UserName != "ada@scim.test" || created.DisplayName != "Ada Lovelace" || created.Name.Formatted != "Ada Lovelace" {
+			t.Errorf("Unexpected user: %+v", created)
+		}
+		if created.Active == nil || !*created.Active || created.Extension.DateOfBirth != resource.Extension.DateOfBirth {
+			t.Errorf("Expected an active user with the date of birth, got %+v", created)
+		}
+		if len(created.Emails) != 1 || created.Emails[0].Value != "ada@scim.test" || len(created.PhoneNumbers) != 1 || created.PhoneNumbers[0].Value != "+14155550101" {
+			t.Errorf("Unexpected emails or phone numbers: %+v %+v", created.Emails, created.PhoneNumbers)
+		}
+		// resources are located under the configured base URL
+		location := "http://localhost:8081/scim/v2/Users/" + created.ID
+		if created.Meta == nil || created.Meta.Location != location || resp.Header.Get("Location") != location || created.Meta.Created == nil {
+			t.Errorf("Expected meta located at %s, got %+v", location, created.Meta)
+		}
+		// provisioned users verify their address like anyone else
+		if got := status(t, created.ID); got != models.UserStatusPending {
+			t.Errorf("Expected status %s, got %s", models.UserStatusPending, got)
+		}
+
+		var fetched scim.User
+		decode(t, suite.makeGETRequest(t, baseURL+"/Users/"+created.ID), &fetched)
+		if fetched.ID != created.ID || fetched.UserName != created.UserName {
+			t.Errorf("Expected to get the created user, got %+v", fetched)
+		}
+	})
+
+	t.Run("create inactive", func(t *testing.T) {
+		resource := newResource("inactive@scim.test", "Ina Active")
+		inactive := false
+		resource.Active = &inactive
+		created := create(t, resource)
+		if created.Active == nil || *created.Active || status(t, created.ID) != models.UserStatusDeactivated {
+			t.Errorf("Expected a deactivated user, got %+v", created)
+		}
+	})
+
+	invalidCreations := []struct {
+		name      string
+		body      any
+		wantCode  int
+		wantError string
+	}{
+		{name: "malformed body", body: `{"userName":`, wantCode: http.StatusBadRequest, wantError: scim.ErrorInvalidSyntax},
+		{name: "missing userName", body: newResource("", "No Name"), wantCode: http.StatusBadRequest, wantError: scim.ErrorInvalidValue},
+		{name: "invalid email", body: newResource("not-an-email", "Bad Email"), wantCode: http.StatusBadRequest, wantError: scim.ErrorInvalidValue},
+		{name: "missing date of birth", body: func() scim.User {
+			resource := newResource("nodob@scim.test", "No Birth")
+			resource.Extension = nil
+			return resource
+		}(), wantCode: http.StatusBadRequest, wantError: scim.ErrorInvalidValue},
+		{name: "too young", body: func() scim.User {
+			resource := newResource("young@scim.test", "Too Young")
+			resource.Extension.DateOfBirth = time.Now().AddDate(-5, 0, 0).Format("2006-01-02")
+			return resource
+		}(), wantCode: http.StatusBadRequest, wantError: scim.ErrorInvalidValue},
+		{name: "existing userName", body: newResource("ada@scim.test", "Ada Again"), wantCode: http.StatusConflict, wantError: scim.ErrorUniqueness},
+	}
+	for _, tc := range invalidCreations {
+		t.Run("create with "+tc.name, func(t *testing.T) {
+			expectError(t, request(t, "POST", baseURL+"/Users", tc.body), tc.wantCode, tc.wantError)
+		})
+	}
+
+	t.Run("get unknown user", func(t *testing.T) {
+		expectError(t, suite.makeGETRequest(t, baseURL+"/Users/"+uuid.NewString()), http.StatusNotFound, "")
+		expectError(t, suite.makeGETRequest(t, baseURL+"/Users/not-an-id"), http.StatusNotFound, "")
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		resource := newResource("ada@scim.test", "Augusta King")
+		inactive := false
+		resource.Active = &inactive
+		resp := request(t, "PUT", baseURL+"/Users/"+userID, resource)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		var replaced scim.User
+		decode(t, resp, &replaced)
+		// the phone number was left out so it is gone
+		if replaced.DisplayName != "Augusta King" || len(replaced.PhoneNumbers) != 0 || *replaced.Active {
+			t.Errorf("Unexpected replaced user: %+v", replaced)
+		}
+		if got := status(t, userID); got != models.UserStatusDeactivated {
+			t.Errorf("Expected status %s, got %s", models.UserStatusDeactivated, got)
+		}
+
+		active := true
+		resource.Active = &active
+		resp = request(t, "PUT", baseURL+"/Users/"+userID, resource)
+		resp.Body.Close()
+		if got := status(t, userID); got != models.UserStatusActive {
+			t.Errorf("Expected status %s, got %s", models.UserStatusActive, got)
+		}
+	})
+
+	t.Run("patch", func(t *testing.T) {
+		// operations the way Azure AD and Okta send them
+		resp := patch(t, userID,
+			scim.PatchOperation{Op: "Replace", Path: "displayName", Value: json.RawMessage(`"Ada King"`)},
+			scim.PatchOperation{Op: "Replace", Path: `emails[type eq "work"].value`, Value: json.RawMessage(`"ignored@scim.test"`)},
+			scim.PatchOperation{Op: "Add", Path: `phoneNumbers[type eq "mobile"].value`, Value: json.RawMessage(`"+14155550102"`)},
+			scim.PatchOperation{Op: "Replace", Path: "active", Value: json.RawMessage(`"False"`)},
+		)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		var patched scim.User
+		decode(t, resp, &patched)
+		if patched.DisplayName != "Ada King" || patched.UserName != "ada@scim.test" || len(patched.PhoneNumbers) != 1 || patched.PhoneNumbers[0].Value != "+14155550102" || *patched.Active {
+			t.Errorf("Unexpected patched user: %+v", patched)
+		}
+
+		resp = patch(t, userID, scim.PatchOperation{Op: "replace", Value: json.RawMessage(`{"active": true, "name": {"givenName": "Ada", "familyName": "Byron"}}`)})
+		decode(t, resp, &patched)
+		if patched.DisplayName != "Ada Byron" || !*patched.Active {
+			t.Errorf("Unexpected patched user: %+v", patched)
+		}
+
+		var removed scim.User
+		decode(t, patch(t, userID, scim.PatchOperation{Op: "remove", Path: "phoneNumbers"}), &removed)
+		if len(removed.PhoneNumbers) != 0 {
+			t.Errorf("Expected the phone number to be removed, got %+v", removed.PhoneNumbers)
+		}
+	})
+
+	t.Run("patch userName", func(t *testing.T) {
+		resp := patch(t, userID, scim.PatchOperation{Op: "replace", Path: "userName", Value: json.RawMessage(`"ada.byron@scim.test"`)})
+		var patched scim.User
+		decode(t, resp, &patched)
+
+		// the new address has to be confirmed first
+		user, err := suite.storage.RetrieveUser(uuid.MustParse(userID))
+		if err != nil {
+			t.Fatalf("Failed to retrieve user: %v", err)
+		}
+		if patched.UserName != "ada@scim.test" || user.PendingEmail != "ada.byron@scim.test" {
+			t.Errorf("Expected ada.byron@scim.test to be pending, got %s and %q", patched.UserName, user.PendingEmail)
+		}
+		if token := suite.lastMailedToken(t, "ada.byron@scim.test"); token == "" {
+			t.Error("Expected a confirmation mail to the new address")
+		}
+	})
+
+	invalidPatches := []struct {
+		name      string
+		body      any
+		wantError string
+	}{
+		{name: "missing schema", body: scim.PatchRequest{Operations: []scim.PatchOperation{{Op: "replace", Path: "displayName", Value: json.RawMessage(`"X"`)}}}, wantError: scim.ErrorInvalidSyntax},
+		{name: "unknown operation", body: scim.PatchRequest{Schemas: []string{scim.SchemaPatchOp}, Operations: []scim.PatchOperation{{Op: "move", Path: "displayName"}}}, wantError: scim.ErrorInvalidSyntax},
+		{name: "unknown attribute", body: scim.PatchRequest{Schemas: []string{scim.SchemaPatchOp}, Operations: []scim.PatchOperation{{Op: "replace", Path: "nickName", Value: json.RawMessage(`"X"`)}}}, wantError: scim.ErrorInvalidPath},
+		{name: "removed userName", body: scim.PatchRequest{Schemas: []string{scim.SchemaPatchOp}, Operations: []scim.PatchOperation{{Op: "remove", Path: "userName"}}}, wantError: scim.ErrorMutability},
+		{name: "remove without path", body: scim.PatchRequest{Schemas: []string{scim.SchemaPatchOp}, Operations: []scim.PatchOperation{{Op: "remove"}}}, wantError: scim.ErrorNoTarget},
+		{name: "wrong value type", body: scim.PatchRequest{Schemas: []string{scim.SchemaPatchOp}, Operations: []scim.PatchOperation{{Op: "replace", Path: "active", Value: json.RawMessage(`"maybe"`)}}}, wantError: scim.ErrorInvalidValue},
+	}
+	for _, tc := range invalidPatches {
+		t.Run("patch with "+tc.name, func(t *testing.T) {
+			expectError(t, request(t, "PATCH", baseURL+"/Users/"+userID, tc.body), http.StatusBadRequest, tc.wantError)
+		})
+	}
+
+	grace := create(t, newResource("grace@navy.test", "Grace Hopper"))
+	create(t, newResource("alan@bletchley.test", "Alan Turing"))
+	create(t, newResource("katherine@nasa.test", "Katherine Johnson"))
+
+	filterCases := []struct {
+		filter    string
+		wantUsers []string
+	}{
+		{filter: `userName eq "grace@navy.test"`, wantUsers: []string{"grace@navy.test"}},
+		{filter: `USERNAME EQ "GRACE@NAVY.TEST"`, wantUsers: []string{"grace@navy.test"}},
+		{filter: `emails.value co ".test"`, wantUsers: []string{"ada@scim.test", "inactive@scim.test", "grace@navy.test", "alan@bletchley.test", "katherine@nasa.test"}},
+		{filter: `emails[value ew "nasa.test"]`, wantUsers: []string{"katherine@nasa.test"}},
+		{filter: `userName sw "grace" or userName sw "alan"`, wantUsers: []string{"grace@navy.test", "alan@bletchley.test"}},
+		{filter: `userName co "test" and not (userName co "scim") and displayName co "o"`, wantUsers: []string{"grace@navy.test", "katherine@nasa.test"}},
+		{filter: `(userName sw "ada" or userName sw "alan") and name.formatted co "turing"`, wantUsers: []string{"alan@bletchley.test"}},
+		{filter: `active eq false`, wantUsers: []string{"inactive@scim.test"}},
+		{filter: `id eq "` + grace.ID + `"`, wantUsers: []string{"grace@navy.test"}},
+		{filter: `userName co "%"`, wantUsers: []string{}},
+	}
+	for _, tc := range filterCases {
+		t.Run("filter "+tc.filter, func(t *testing.T) {
+			var list struct {
+				Schemas      []string    `json:"schemas"`
+				TotalResults int         `json:"totalResults"`
+				ItemsPerPage int         `json:"itemsPerPage"`
+				Resources    []scim.User `json:"Resources"`
+			}
+			decode(t, suite.makeGETRequest(t, baseURL+"/Users?filter="+url.QueryEscape(tc.filter)), &list)
+
+			if list.Schemas[0] != scim.SchemaListResponse || list.TotalResults != len(tc.wantUsers) || list.ItemsPerPage != len(tc.wantUsers) {
+				t.Fatalf("Expected %d users, got %+v", len(tc.wantUsers), list)
+			}
+			for i, user := range list.Resources {
+				if user.UserName != tc.wantUsers[i] {
+					t.Errorf("Expected %s at %d, got %s", tc.wantUsers[i], i, user.UserName)
+				}
+			}
+		})
+	}
+
+	invalidFilters := []string{
+		`userName eq`,
+		`userName xx "a"`,
+		`(userName eq "a"`,
+		`nickName eq "a"`,
+		`id co "a"`,
+		`active eq "yes"`,
+	}
+	for _, filter := range invalidFilters {
+		t.Run("invalid filter "+filter, func(t *testing.T) {
+			expectError(t, suite.makeGETRequest(t, baseURL+"/Users?filter="+url.QueryEscape(filter)), http.StatusBadRequest, scim.ErrorInvalidFilter)
+		})
+	}
+
+	t.Run("pagination", func(t *testing.T) {
+		var list struct {
+			TotalResults int         `json:"totalResults"`
+			StartIndex   int         `json:"startIndex"`
+			ItemsPerPage int         `json:"itemsPerPage"`
+			Resources    []scim.User `json:"Resources"`
+		}
+		decode(t, suite.makeGETRequest(t, baseURL+"/Users?startIndex=2&count=2"), &list)
+		if list.TotalResults != 5 || list.StartIndex != 2 || list.ItemsPerPage != 2 {
+			t.Fatalf("Expected users 2 and 3 of 5, got %+v", list)
+		}
+		if list.Resources[0].UserName != "inactive@scim.test" || list.Resources[1].UserName != "grace@navy.test" {
+			t.Errorf("Unexpected page: %s, %s", list.Resources[0].UserName, list.Resources[1].UserName)
+		}
+
+		decode(t, suite.makeGETRequest(t, baseURL+"/Users?count=0"), &list)
+		if list.TotalResults != 5 || list.ItemsPerPage != 0 {
+			t.Errorf("Expected only the total, got %+v", list)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		resp := request(t, "DELETE", baseURL+"/Users/"+grace.ID, nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("Expected status %d, got %d", http.StatusNoContent, resp.StatusCode)
+		}
+		if got := status(t, grace.ID); got != models.UserStatusDeleted {
+			t.Errorf("Expected status %s, got %s", models.UserStatusDeleted, got)
+		}
+
+		expectError(t, suite.makeGETRequest(t, baseURL+"/Users/"+grace.ID), http.StatusNotFound, "")
+		expectError(t, request(t, "DELETE", baseURL+"/Users/"+grace.ID, nil), http.StatusNotFound, "")
+
+		var list struct {
+			TotalResults int `json:"totalResults"`
+		}
+		decode(t, suite.makeGETRequest(t, baseURL+"/Users?filter="+url.QueryEscape(`userName eq "grace@navy.test"`)), &list)
+		if list.TotalResults != 0 {
+			t.Errorf("Expected deleted users to be left out, got %d", list.TotalResults)
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"users-microservice/pkg/api"
+)
+
+func TestSessions(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	email := "session@test.com"
+	userID := suite.createActiveTestUser(t, email)
+	sessionsURL := suite.httpSrv.URL + "/users/" + userID.String() + "/sessions"
+	refreshURL := suite.httpSrv.URL + "/auth/refresh"
+
+	resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/users/"+userID.String()+"/password", api.PasswordAPI{Password: "session horse battery"})
+	resp.Body.Close()
+
+	login := func(t *testing.T) api.LoginResponseAPI {
+		resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/auth/login", api.LoginAPI{Email: email, Password: "session horse battery"})
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to login: Status=%d", resp.StatusCode)
+		}
+		var loginResponse api.LoginResponseAPI
+		decodeResponseData(t, resp, &loginResponse)
+		return loginResponse
+	}
+	refresh := func(t *testing.T, token string) (int, api.SessionTokensAPI) {
+		resp := suite.makeJSONRequest(t, "POST", refreshURL, api.RefreshAPI{RefreshToken: token})
+		defer resp.Body.Close()
+
+		var tokens api.SessionTokensAPI
+		if resp.StatusCode == http.StatusOK {
+			decodeResponseData(t, resp, &tokens)
+		}
+		return resp.StatusCode, tokens
+	}
+	listSessions := func(t *testing.T) []api.SessionAPI {
+		resp := suite.makeGETRequest(t, sessionsURL)
+		defer resp.Body.Close()
+
+		var sessions []api.SessionAPI
+		decodeResponseData(t, resp, &sessions)
+		return sessions
+	}
+
+	first := login(t)
+	if first.AccessToken == "" || first.RefreshToken == "" || first.User.ID != userID {
+		t.Fatalf("Login did not issue tokens: %+v", first)
+	}
+
+	t.Run("refresh rotates the token", func(t *testing.T) {
+		body, _ := json.Marshal(api.RefreshAPI{RefreshToken: first.RefreshToken})
+		req, _ := http.NewRequest("POST", refreshURL, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "refreshing-device/2.0")
+		resp, err := suite.Client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		var rotated api.SessionTokensAPI
+		decodeResponseData(t, resp, &rotated)
+		resp.Body.Close()
+		if rotated.RefreshToken == first.RefreshToken || rotated.SessionID != first.SessionID {
+			t.Fatalf("Expected a new refresh token for the same session, got %+v", rotated)
+		}
+		// the session shows the device it was refreshed from
+		sessions := listSessions(t)
+		if !slices.ContainsFunc(sessions, func(s api.SessionAPI) bool { return s.ID == first.SessionID && s.UserAgent == "refreshing-device/2.0" }) {
+			t.Errorf("Expected the refreshing device on the session, got %+v", sessions)
+		}
+
+		// replaying the rotated token revokes the whole family
+		if code, _ := refresh(t, first.RefreshToken); code != http.StatusUnauthorized {
+			t.Errorf("Expected reuse to be rejected with %d, got %d", http.StatusUnauthorized, code)
+		}
+		if code, _ := refresh(t, rotated.RefreshToken); code != http.StatusUnauthorized {
+			t.Errorf("Expected family to be revoked with %d, got %d", http.StatusUnauthorized, code)
+		}
+	})
+
+	second := login(t)
+	third := login(t)
+
+	t.Run("list active sessions", func(t *testing.T) {
+		if sessions := listSessions(t); len(sessions) != 2 {
+			t.Errorf("Expected 2 active sessions, got %d", len(sessions))
+		}
+	})
+
+	// withAccessToken calls the API as the signed-in user
+	withAccessToken := func(t *testing.T, method string, url string, token string) int {
+		req, _ := http.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("access tokens act for the user", func(t *testing.T) {
+		other := suite.createActiveTestUser(t, "other-session@test.com")
+		cases := []struct {
+			method string
+			url    string
+			status int
+		}{
+			{"GET", sessionsURL, http.StatusOK},
+			{"GET", suite.httpSrv.URL + "/users/" + userID.String() + "/mfa", http.StatusOK},
+			{"GET", suite.httpSrv.URL + "/users/" + other.String() + "/sessions", http.StatusNotFound},
+			// administration is left to services
+			{"POST", suite.httpSrv.URL + "/users/" + userID.String() + "/suspend", http.StatusForbidden},
+		}
+		for _, tc := range cases {
+			if status := withAccessToken(t, tc.method, tc.url, second.AccessToken); status != tc.status {
+				t.Errorf("Expected status %d for %s %s, got %d", tc.status, tc.method, tc.url, status)
+			}
+		}
+	})
+
+	t.Run("revoke one session", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "DELETE", sessionsURL+"/"+second.SessionID.String(), nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+
+		if code, _ := refresh(t, second.RefreshToken); code != http.StatusUnauthorized {
+			t.Errorf("Expected revoked session to be rejected with %d, got %d", http.StatusUnauthorized, code)
+		}
+		if status := withAccessToken(t, "GET", sessionsURL, second.AccessToken); status != http.StatusUnauthorized {
+			t.Errorf("Expected the access token of the revoked session to be rejected with %d, got %d", http.StatusUnauthorized, status)
+		}
+		if sessions := listSessions(t); len(sessions) != 1 || sessions[0].ID != third.SessionID {
+			t.Errorf("Expected only the third session to stay active, got %+v", sessions)
+		}
+	})
+
+	t.Run("password reset revokes all sessions", func(t *testing.T) {
+		sent := len(suite.mailedTo(t, email))
+		resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/auth/password-reset", api.PasswordResetAPI{Email: email})
+		resp.Body.Close()
+
+		confirm := api.PasswordResetConfirmAPI{Token: suite.awaitMailedToken(t, email, sent), NewPassword: "fresh horse battery"}
+		resp = suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/auth/password-reset/confirm", confirm)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+
+		if code, _ := refresh(t, third.RefreshToken); code != http.StatusUnauthorized {
+			t.Errorf("Expected session to be revoked with %d, got %d", http.StatusUnauthorized, code)
+		}
+		if sessions := listSessions(t); len(sessions) != 0 {
+			t.Errorf("Expected no active sessions, got %d", len(sessions))
+		}
+	})
+}
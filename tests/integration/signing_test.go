@@ -0,0 +1,161 @@
+package integration
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"users-microservice/pkg/api"
+	"users-microservice/pkg/signing"
+
+	"github.com/google/uuid"
+)
+
+// signByHand signs the request the way the README describes it, as clients
+// in other languages would
+func signByHand(r *http.Request, body []byte, secret string, timestamp time.Time, nonce string, headers ...string) {
+	digest := sha256.Sum256(body)
+	lines := []string{"HMAC-SHA256", fmt.Sprint(timestamp.Unix()), nonce, r.Method, r.URL.EscapedPath(), r.URL.RawQuery}
+	for _, name := range headers {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.URL.Host
+		}
+		lines = append(lines, name+":"+value)
+	}
+	lines = append(lines, hex.EncodeToString(digest[:]))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join(lines, "\n")))
+
+	r.Header.Set("Authorization", fmt.Sprintf(`HMAC-SHA256 keyId="%s", timestamp="%d", nonce="%s", headers="%s", signature="%s"`,
+		testSigningKeyID, timestamp.Unix(), nonce, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(mac.Sum(nil))))
+}
+
+func TestRequestSigning(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	userID := suite.createActiveTestUser(t, "signing@test.com")
+	userURL := suite.httpSrv.URL + "/" + userID.String()
+	signer := signing.NewSigner(testSigningKeyID, []byte(testSigningSecret))
+	client := &http.Client{Timeout: 5 * time.Second, Transport: &signing.Transport{Signer: signer}}
+
+	expectStatus := func(t *testing.T, resp *http.Response, err error, want int) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Expected status %d, got %d", want, resp.StatusCode)
+		}
+	}
+	newUser := func(t *testing.T) []byte {
+		t.Helper()
+		payload, err := json.Marshal(api.UserAPI{ID: uuid.New(), Name: "Signed", Email: uuid.NewString() + "@test.com", DateOfBirth: time.Now().AddDate(-30, 0, 0)})
+		if err != nil {
+			t.Fatalf("Failed to encode user: %v", err)
+		}
+		return payload
+	}
+
+	t.Run("signed requests", func(t *testing.T) {
+		resp, err := client.Get(userURL)
+		expectStatus(t, resp, err, http.StatusOK)
+
+		resp, err = client.Post(suite.httpSrv.URL+"/save", "application/json", bytes.NewReader(newUser(t)))
+		expectStatus(t, resp, err, http.StatusCreated)
+	})
+
+	t.Run("scopes of the client", func(t *testing.T) {
+		resp, err := client.Get(suite.httpSrv.URL + "/api-keys")
+		expectStatus(t, resp, err, http.StatusForbidden)
+	})
+
+	t.Run("signature by the documented scheme", func(t *testing.T) {
+		body := newUser(t)
+		req, _ := http.NewRequest("POST", suite.httpSrv.URL+"/save?source=billing", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		signByHand(req, body, testSigningSecret, time.Now(), "documented", "host", "content-type")
+		resp, err := http.DefaultClient.Do(req)
+		expectStatus(t, resp, err, http.StatusCreated)
+	})
+
+	// every part of a signed request is changed after signing, on routes that
+	// would answer otherwise if the signature still held
+	tampered := []struct {
+		name   string
+		method string
+		url    string
+		tamper func(r *http.Request)
+	}{
+		{"body", "POST", suite.httpSrv.URL + "/save", func(r *http.Request) {
+			body := newUser(t)
+			r.Body, r.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+		}},
+		{"path", "GET", userURL, func(r *http.Request) { r.URL.Path = "/" + uuid.NewString() }},
+		{"query", "POST", suite.httpSrv.URL + "/save", func(r *http.Request) { r.URL.RawQuery = "admin=true" }},
+		{"method", "POST", suite.httpSrv.URL + "/api-keys", func(r *http.Request) { r.Method = "GET" }},
+		{"signed header", "POST", suite.httpSrv.URL + "/save", func(r *http.Request) { r.Header.Set("Content-Type", "text/plain") }},
+	}
+	for _, tt := range tampered {
+		t.Run("tampered "+tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.url, bytes.NewReader(newUser(t)))
+			req.Header.Set("Content-Type", "application/json")
+			if err := signer.Sign(req); err != nil {
+				t.Fatalf("Failed to sign request: %v", err)
+			}
+			tt.tamper(req)
+			resp, err := http.DefaultClient.Do(req)
+			expectStatus(t, resp, err, http.StatusUnauthorized)
+		})
+	}
+
+	t.Run("replayed request", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", userURL, nil)
+		if err := signer.Sign(req); err != nil {
+			t.Fatalf("Failed to sign request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		expectStatus(t, resp, err, http.StatusOK)
+		resp, err = http.DefaultClient.Do(req)
+		expectStatus(t, resp, err, http.StatusUnauthorized)
+	})
+
+	rejected := []struct {
+		name      string
+		secret    string
+		timestamp time.Time
+		headers   []string
+	}{
+		{"timestamp too old", testSigningSecret, time.Now().Add(-2 * time.Minute), []string{"host"}},
+		{"timestamp in the future", testSigningSecret, time.Now().Add(2 * time.Minute), []string{"host"}},
+		{"required header not signed", testSigningSecret, time.Now(), nil},
+		{"wrong secret", strings.Repeat("x", 32), time.Now(), []string{"host"}},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", userURL, nil)
+			signByHand(req, nil, tt.secret, tt.timestamp, uuid.NewString(), tt.headers...)
+			resp, err := http.DefaultClient.Do(req)
+			expectStatus(t, resp, err, http.StatusUnauthorized)
+		})
+	}
+
+	t.Run("unknown key ID", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", userURL, nil)
+		if err := signing.NewSigner("unknown", []byte(testSigningSecret)).Sign(req); err != nil {
+			t.Fatalf("Failed to sign request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		expectStatus(t, resp, err, http.StatusUnauthorized)
+	})
+}
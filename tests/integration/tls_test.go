@@ -0,0 +1,308 @@
+package integration
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"users-microservice/pkg/api"
+	"users-microservice/pkg/config"
+	"users-microservice/pkg/lockout"
+	"users-microservice/pkg/models"
+	"users-microservice/pkg/servertls"
+	"users-microservice/pkg/services"
+)
+
+// testCA issues certificates for servers and clients
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse CA certificate: %v", err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue signs a certificate for the template and returns it and its key as
+// PEM
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("Failed to generate serial: %v", err)
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) issueServer(t *testing.T) ([]byte, []byte) {
+	return ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+}
+
+func (ca *testCA) issueClient(t *testing.T, subject pkix.Name, spiffeID string) tls.Certificate {
+	t.Helper()
+	template := &x509.Certificate{Subject: subject, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	if spiffeID != "" {
+		uri, err := url.Parse(spiffeID)
+		if err != nil {
+			t.Fatalf("Failed to parse SPIFFE ID: %v", err)
+		}
+		template.URIs = []*url.URL{uri}
+	}
+	certPEM, keyPEM := ca.issue(t, template)
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("Failed to load client certificate: %v", err)
+	}
+	return pair
+}
+
+func TestTLS(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	userID := suite.createActiveTestUser(t, "tls@test.com")
+	issued, err := suite.apiKeys.IssueAPIKey(systemContext(), services.APIKeyRequest{Name: "tls", Scopes: []models.Scope{models.ScopeUsersAdmin}})
+	if err != nil {
+		t.Fatalf("Failed to issue API key: %v", err)
+	}
+
+	dir := t.TempDir()
+	ca := newTestCA(t, "Test CA")
+	write := func(t *testing.T, name string, data []byte) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		return path
+	}
+	certPEM, keyPEM := ca.issueServer(t)
+	baseConfig := config.TLS{
+		CertFile: write(t, "server.pem", certPEM),
+		KeyFile:  write(t, "server-key.pem", keyPEM),
+		// every handshake checks for a renewed certificate
+		ReloadInterval: 0,
+		MinVersion:     tls.VersionTLS12,
+		CipherSuites:   []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		ClientCAFile:   write(t, "client-ca.pem", ca.pem),
+		ClientIdentitiesFile: write(t, "identities.json", []byte(`[
+			{"spiffe_id": "spiffe://example.test/billing", "scopes": ["users:read"]},
+			{"subject": "CN=reporting,O=Example", "scopes": ["users:admin"]}
+		]`)),
+	}
+
+	// serve starts the API the way Run does, on a random port
+	serve := func(t *testing.T, cfg config.TLS) string {
+		t.Helper()
+		settings, err := servertls.Load(&cfg)
+		if err != nil {
+			t.Fatalf("Failed to load TLS settings: %v", err)
+		}
+		apiConfig := &config.Config{LockoutSourceFreeAttempts: 100, LockoutSourceThreshold: 100}
+		guard := lockout.NewGuard(suite.storage, lockout.SourcePolicy(apiConfig))
+		server := api.NewAPIServer(":0", api.Dependencies{Users: suite.service, Auth: suite.auth, APIKeys: suite.apiKeys, TLS: settings, SourceGuard: guard}, apiConfig).NewServer()
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		go server.ServeTLS(listener, "", "")
+		t.Cleanup(func() { server.Close() })
+		return "https://" + listener.Addr().String()
+	}
+	baseURL := serve(t, baseConfig)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := func(certificates []tls.Certificate, configure func(*tls.Config)) *http.Client {
+		tlsConfig := &tls.Config{RootCAs: roots, Certificates: certificates}
+		if configure != nil {
+			configure(tlsConfig)
+		}
+		return &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true}}
+	}
+	do := func(client *http.Client, method string, url string, apiKey string) (*http.Response, error) {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		return client.Do(req)
+	}
+
+	billing := ca.issueClient(t, pkix.Name{CommonName: "billing"}, "spiffe://example.test/billing")
+	reporting := ca.issueClient(t, pkix.Name{CommonName: "reporting", Organization: []string{"Example"}}, "")
+	unknown := ca.issueClient(t, pkix.Name{CommonName: "unknown"}, "spiffe://example.test/unknown")
+	foreign := newTestCA(t, "Other CA").issueClient(t, pkix.Name{CommonName: "reporting", Organization: []string{"Example"}}, "")
+
+	tests := []struct {
+		name     string
+		client   *http.Client
+		method   string
+		path     string
+		apiKey   string
+		wantCode int
+	}{
+		{"API key without certificate", client(nil, nil), "GET", "/" + userID.String(), issued.Secret, http.StatusOK},
+		{"no credentials", client(nil, nil), "GET", "/" + userID.String(), "", http.StatusUnauthorized},
+		{"SPIFFE ID", client([]tls.Certificate{billing}, nil), "GET", "/" + userID.String(), "", http.StatusOK},
+		{"scopes of SPIFFE ID", client([]tls.Certificate{billing}, nil), "GET", "/api-keys", "", http.StatusForbidden},
+		{"subject", client([]tls.Certificate{reporting}, nil), "GET", "/api-keys", "", http.StatusOK},
+		{"unmapped certificate", client([]tls.Certificate{unknown}, nil), "GET", "/" + userID.String(), "", http.StatusUnauthorized},
+		{"API key before certificate", client([]tls.Certificate{billing}, nil), "GET", "/api-keys", issued.Secret, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := do(tt.client, tt.method, baseURL+tt.path, tt.apiKey)
+			if err != nil {
+				t.Fatalf("Failed to make request: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("Expected status %d, got %d", tt.wantCode, resp.StatusCode)
+			}
+		})
+	}
+
+	refused := []struct {
+		name   string
+		client *http.Client
+	}{
+		// Go clients leave out certificates the server does not ask for, so
+		// the foreign one is sent regardless
+		{"certificate of another CA", client(nil, func(c *tls.Config) {
+			c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return &foreign, nil }
+		})},
+		{"cipher suite outside policy", client(nil, func(c *tls.Config) {
+			c.MaxVersion = tls.VersionTLS12
+			c.CipherSuites = []uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305}
+		})},
+	}
+	for _, tt := range refused {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp, err := do(tt.client, "GET", baseURL+"/"+userID.String(), issued.Secret); err == nil {
+				resp.Body.Close()
+				t.Fatalf("Expected the handshake to fail, got status %d", resp.StatusCode)
+			}
+		})
+	}
+
+	t.Run("minimum version", func(t *testing.T) {
+		cfg := baseConfig
+		cfg.MinVersion = tls.VersionTLS13
+		url := serve(t, cfg)
+
+		if resp, err := do(client(nil, func(c *tls.Config) { c.MaxVersion = tls.VersionTLS12 }), "GET", url+"/"+userID.String(), issued.Secret); err == nil {
+			resp.Body.Close()
+			t.Fatalf("Expected TLS 1.2 to be refused, got status %d", resp.StatusCode)
+		}
+		resp, err := do(client(nil, nil), "GET", url+"/"+userID.String(), issued.Secret)
+		if err != nil {
+			t.Fatalf("Expected TLS 1.3 to be accepted: %v", err)
+		}
+		resp.Body.Close()
+	})
+
+	t.Run("required client certificate", func(t *testing.T) {
+		cfg := baseConfig
+		cfg.ClientCertRequired = true
+		url := serve(t, cfg)
+
+		if resp, err := do(client(nil, nil), "GET", url+"/"+userID.String(), issued.Secret); err == nil {
+			resp.Body.Close()
+			t.Fatalf("Expected a connection without certificate to be refused, got status %d", resp.StatusCode)
+		}
+		resp, err := do(client([]tls.Certificate{billing}, nil), "GET", url+"/"+userID.String(), "")
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+	})
+
+	t.Run("certificate reload", func(t *testing.T) {
+		serial := func(t *testing.T) *big.Int {
+			t.Helper()
+			resp, err := do(client(nil, nil), "GET", baseURL+"/"+userID.String(), issued.Secret)
+			if err != nil {
+				t.Fatalf("Failed to make request: %v", err)
+			}
+			resp.Body.Close()
+			return resp.TLS.PeerCertificates[0].SerialNumber
+		}
+		before := serial(t)
+
+		renewedCert, renewedKey := ca.issueServer(t)
+		later := time.Now().Add(time.Minute)
+		// a certificate without its key is not picked up
+		write(t, "server.pem", renewedCert)
+		os.Chtimes(baseConfig.CertFile, later, later)
+		if got := serial(t); got.Cmp(before) != 0 {
+			t.Fatalf("Expected the current certificate to stay until the key is renewed too")
+		}
+
+		write(t, "server-key.pem", renewedKey)
+		os.Chtimes(baseConfig.KeyFile, later, later)
+		if got := serial(t); got.Cmp(before) == 0 {
+			t.Fatalf("Expected the renewed certificate to be served")
+		}
+	})
+}
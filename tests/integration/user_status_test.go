@@ -0,0 +1,82 @@
+package integration
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"testing"
+	"users-microservice/pkg/api"
+)
+
+func TestUserStatusEndpoints(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	userID := suite.createActiveTestUser(t, "status@test.com")
+	usersURL := suite.httpSrv.URL + "/users/" + userID.String()
+
+	testCases := []struct {
+		name       string
+		action     string
+		reason     string
+		wantCode   int
+		wantStatus string
+	}{
+		{name: "suspend without reason", action: "suspend", reason: "  ", wantCode: 400},
+		{name: "suspend active user", action: "suspend", reason: "fraud investigation", wantCode: 200, wantStatus: "suspended"},
+		{name: "suspend suspended user", action: "suspend", reason: "again", wantCode: 409},
+		{name: "reactivate suspended user", action: "reactivate", reason: "investigation closed", wantCode: 200, wantStatus: "active"},
+		{name: "reactivate active user", action: "reactivate", reason: "again", wantCode: 409},
+		{name: "deactivate active user", action: "deactivate", reason: "user request", wantCode: 200, wantStatus: "deactivated"},
+		{name: "suspend deactivated user", action: "suspend", reason: "too late", wantCode: 409},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := suite.makeJSONRequest(t, "POST", usersURL+"/"+tc.action, api.StatusChangeAPI{Reason: tc.reason})
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.wantCode {
+				body, _ := io.ReadAll(resp.Body)
+				log.Printf("Response for '%s': Status=%d, Body=%s", tc.name, resp.StatusCode, string(body))
+				t.Fatalf("Test '%s': Expected status %d, got %d", tc.name, tc.wantCode, resp.StatusCode)
+			}
+			if tc.wantStatus != "" {
+				var user api.UserAPI
+				decodeResponseData(t, resp, &user)
+				if user.Status != tc.wantStatus {
+					t.Errorf("Test '%s': Expected user status %s, got %s", tc.name, tc.wantStatus, user.Status)
+				}
+			}
+		})
+	}
+
+	t.Run("get reflects status", func(t *testing.T) {
+		resp := suite.makeGETRequest(t, suite.httpSrv.URL+"/"+userID.String())
+		defer resp.Body.Close()
+
+		var user api.UserAPI
+		decodeResponseData(t, resp, &user)
+		if user.Status != "deactivated" {
+			t.Errorf("Expected user status deactivated, got %s", user.Status)
+		}
+	})
+
+	t.Run("history contains transitions", func(t *testing.T) {
+		resp := suite.makeGETRequest(t, usersURL+"/history")
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		var history []api.UserHistoryEntryAPI
+		decodeResponseData(t, resp, &history)
+		// activation by email verification comes first
+		if len(history) != 4 {
+			t.Fatalf("Expected 4 history entries, got %d", len(history))
+		}
+		if history[1].ToStatus != "suspended" || history[1].Reason != "fraud investigation" {
+			t.Errorf("Unexpected suspension history entry: %+v", history[1])
+		}
+	})
+}
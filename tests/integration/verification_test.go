@@ -0,0 +1,81 @@
+package integration
+
+import (
+	"net/http"
+	"testing"
+	"users-microservice/pkg/api"
+)
+
+func TestEmailVerification(t *testing.T) {
+	suite := SetupTestSuite(t)
+	defer suite.Teardown(t)
+
+	email := "verify@test.com"
+	userID := suite.createTestUser(t, email)
+	userURL := suite.httpSrv.URL + "/" + userID.String()
+	resendURL := suite.httpSrv.URL + "/users/" + userID.String() + "/verify-email/resend"
+
+	t.Run("new user is pending", func(t *testing.T) {
+		resp := suite.makeGETRequest(t, userURL)
+		defer resp.Body.Close()
+
+		var user api.UserAPI
+		decodeResponseData(t, resp, &user)
+		if user.Status != "pending" || user.EmailVerifiedAt != nil {
+			t.Errorf("Expected unverified pending user, got status %s verified at %v", user.Status, user.EmailVerifiedAt)
+		}
+	})
+
+	t.Run("resend is throttled", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "POST", resendURL, nil)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, resp.StatusCode)
+		}
+	})
+
+	token := suite.lastMailedToken(t, email)
+
+	testCases := []struct {
+		name     string
+		token    string
+		wantCode int
+	}{
+		{name: "empty token", token: "", wantCode: 400},
+		{name: "unknown token", token: "not-a-token", wantCode: 400},
+		{name: "valid token", token: token, wantCode: 200},
+		{name: "reused token", token: token, wantCode: 400},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := suite.makeJSONRequest(t, "POST", suite.httpSrv.URL+"/users/verify-email", api.TokenAPI{Token: tc.token})
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.wantCode {
+				t.Fatalf("Test '%s': Expected status %d, got %d", tc.name, tc.wantCode, resp.StatusCode)
+			}
+		})
+	}
+
+	t.Run("verified user is active", func(t *testing.T) {
+		resp := suite.makeGETRequest(t, userURL)
+		defer resp.Body.Close()
+
+		var user api.UserAPI
+		decodeResponseData(t, resp, &user)
+		if user.Status != "active" || user.EmailVerifiedAt == nil {
+			t.Errorf("Expected verified active user, got status %s verified at %v", user.Status, user.EmailVerifiedAt)
+		}
+	})
+
+	t.Run("resend for verified user", func(t *testing.T) {
+		resp := suite.makeJSONRequest(t, "POST", resendURL, nil)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusConflict {
+			t.Errorf("Expected status %d, got %d", http.StatusConflict, resp.StatusCode)
+		}
+	})
+}